@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Decode splits a bech32 string into its human-readable part and data
+// bytes, verifying the checksum. Cardano addresses exceed BIP-173's 90
+// character limit, so no length cap is applied.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, fmt.Errorf("bech32: mixed case in %q", s)
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, fmt.Errorf("bech32: invalid separator position in %q", s)
+	}
+	hrp := s[:pos]
+	var values []byte
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, fmt.Errorf("bech32: invalid character %q", c)
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, fmt.Errorf("bech32: invalid checksum in %q", s)
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+// bech32Encode encodes data bytes under the given human-readable part.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	poly := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	for i := 0; i < 6; i++ {
+		values = append(values, byte((poly>>uint(5*(5-i)))&31))
+	}
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	return sb.String(), nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	gen := []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for _, c := range hrp {
+		out = append(out, byte(c>>5))
+	}
+	out = append(out, 0)
+	for _, c := range hrp {
+		out = append(out, byte(c&31))
+	}
+	return out
+}
+
+// convertBits regroups a byte slice from fromBits-wide to toBits-wide groups.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	var out []byte
+	maxv := uint(1)<<toBits - 1
+	for _, b := range data {
+		acc = acc<<fromBits | uint(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte((acc>>bits)&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte((acc<<(toBits-bits))&maxv))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxv != 0 {
+		return nil, fmt.Errorf("bech32: invalid padding")
+	}
+	return out, nil
+}
+
+// PaymentCredential returns the payment credential of a Shelley address as
+// bech32: addr_vkh1... for key hashes, script1... for script hashes. Any two
+// addresses sharing a payment key (e.g. with different stake parts) yield
+// the same credential.
+func PaymentCredential(addr string) (string, error) {
+	hrp, data, err := bech32Decode(addr)
+	if err != nil {
+		return "", err
+	}
+	if hrp != "addr" && hrp != "addr_test" {
+		return "", fmt.Errorf("%s is not a Shelley payment address", addr)
+	}
+	if len(data) < 29 {
+		return "", fmt.Errorf("address %s is too short", addr)
+	}
+	// Header high nibble: 0-7 are Shelley payment addresses (base, pointer,
+	// enterprise); odd types carry a script payment credential.
+	addrType := data[0] >> 4
+	if addrType > 7 {
+		return "", fmt.Errorf("address %s (type %d) has no payment credential", addr, addrType)
+	}
+	credHRP := "addr_vkh"
+	if addrType%2 == 1 {
+		credHRP = "script"
+	}
+	return bech32Encode(credHRP, data[1:29])
+}
+
+// isScriptAddress reports whether addr pays to a script credential.
+func isScriptAddress(addr string) bool {
+	cred, err := PaymentCredential(addr)
+	return err == nil && strings.HasPrefix(cred, "script1")
+}
+
+// ValidateAddress checks that addr is a well-formed bech32 Shelley address
+// whose prefix matches the network: addr1/stake1 on mainnet, addr_test1/
+// stake_test1 on preprod and other testnets.
+func ValidateAddress(addr, network string) error {
+	hrp, _, err := bech32Decode(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	mainnet := network == "mainnet" || network == ""
+	switch hrp {
+	case "addr", "stake":
+		if !mainnet {
+			return fmt.Errorf("address %s is a mainnet address but network is %s", addr, network)
+		}
+	case "addr_test", "stake_test":
+		if mainnet {
+			return fmt.Errorf("address %s is a testnet address but network is mainnet", addr)
+		}
+	default:
+		return fmt.Errorf("address %s has unexpected prefix %q (want addr/stake or addr_test/stake_test)", addr, hrp)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testAddress builds a bech32 Shelley address under hrp from a header byte
+// and 28-byte credentials filled with the given bytes: one for an
+// enterprise address, payment then stake for a base address.
+func testAddress(t *testing.T, hrp string, header byte, creds ...byte) string {
+	t.Helper()
+	data := []byte{header}
+	for _, c := range creds {
+		data = append(data, bytes.Repeat([]byte{c}, 28)...)
+	}
+	addr, err := bech32Encode(hrp, data)
+	if err != nil {
+		t.Fatalf("bech32Encode: %v", err)
+	}
+	return addr
+}
+
+func TestPaymentCredentialSharedByBaseAndEnterprise(t *testing.T) {
+	base := testAddress(t, "addr", 0x01, 0xaa, 0xbb)       // key payment, key stake
+	enterprise := testAddress(t, "addr", 0x61, 0xaa)       // same payment key, no stake
+	otherStake := testAddress(t, "addr", 0x01, 0xaa, 0xcc) // same payment key, other stake
+	stranger := testAddress(t, "addr", 0x61, 0xdd)
+
+	cred, err := PaymentCredential(base)
+	if err != nil {
+		t.Fatalf("PaymentCredential(base): %v", err)
+	}
+	for name, addr := range map[string]string{"enterprise": enterprise, "other stake": otherStake} {
+		got, err := PaymentCredential(addr)
+		if err != nil {
+			t.Fatalf("PaymentCredential(%s): %v", name, err)
+		}
+		if got != cred {
+			t.Errorf("PaymentCredential(%s) = %s, want the base address's %s", name, got, cred)
+		}
+	}
+	if got, _ := PaymentCredential(stranger); got == cred {
+		t.Errorf("an unrelated payment key shares the credential %s", cred)
+	}
+
+	e := &Engine{monitorAddr: base, paymentCred: cred}
+	for addr, want := range map[string]bool{base: true, enterprise: true, otherStake: true, stranger: false} {
+		if got := e.isMonitored(addr); got != want {
+			t.Errorf("isMonitored(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	e.paymentCred = ""
+	if e.isMonitored(enterprise) {
+		t.Error("isMonitored matched a sibling address without payment credential matching")
+	}
+}
+
+func TestPaymentCredentialKinds(t *testing.T) {
+	key, err := PaymentCredential(testAddress(t, "addr", 0x61, 0x11))
+	if err != nil || key[:9] != "addr_vkh1" {
+		t.Errorf("key address credential = %q, %v; want addr_vkh1...", key, err)
+	}
+	script, err := PaymentCredential(testAddress(t, "addr", 0x71, 0x11))
+	if err != nil || script[:7] != "script1" {
+		t.Errorf("script address credential = %q, %v; want script1...", script, err)
+	}
+	if _, err := PaymentCredential(testAddress(t, "stake", 0xe1, 0x11)); err == nil {
+		t.Error("a stake address has no payment credential, but one was returned")
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	mainnet := testAddress(t, "addr", 0x61, 0x11)
+	testnet := testAddress(t, "addr_test", 0x60, 0x11)
+	tests := []struct {
+		name, addr, network string
+		wantErr             string
+	}{
+		{name: "mainnet on mainnet", addr: mainnet, network: "mainnet"},
+		{name: "mainnet by default", addr: mainnet, network: ""},
+		{name: "mainnet stake on mainnet", addr: testAddress(t, "stake", 0xe1, 0x11), network: "mainnet"},
+		{name: "testnet on preprod", addr: testnet, network: "preprod"},
+		{name: "testnet stake on preview", addr: testAddress(t, "stake_test", 0xe0, 0x11), network: "preview"},
+		{name: "testnet on mainnet", addr: testnet, network: "mainnet", wantErr: "is a testnet address but network is mainnet"},
+		{name: "testnet by default", addr: testnet, network: "", wantErr: "is a testnet address but network is mainnet"},
+		{name: "mainnet on preprod", addr: mainnet, network: "preprod", wantErr: "is a mainnet address but network is preprod"},
+		{name: "foreign prefix", addr: testAddress(t, "pool", 0x61, 0x11), network: "mainnet", wantErr: `unexpected prefix "pool"`},
+		{name: "bad checksum", addr: mainnet[:len(mainnet)-1] + "q", network: "mainnet", wantErr: "invalid address"},
+		{name: "not bech32", addr: "DdzFFzCqrht", network: "mainnet", wantErr: "invalid address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAddress(tt.addr, tt.network)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateAddress() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateAddress() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Allowlist restricts minting to pre-approved sender addresses, e.g. for a
+// presale. The file lists one bech32 address per line, optionally followed
+// by the most tokens that wallet may mint; blank lines and # comments are
+// ignored:
+/*
+	# presale
+	addr1qx...   2
+	addr1qy...
+*/
+// Reload re-reads the file, so wallets can be added while the engine runs.
+type Allowlist struct {
+	path string
+	mu   sync.RWMutex
+	caps map[string]int // address -> per-wallet cap; 0 means no cap
+}
+
+// LoadAllowlist reads an allowlist file.
+func LoadAllowlist(path string) (*Allowlist, error) {
+	a := &Allowlist{path: path}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload re-reads the allowlist file and swaps it in. On error the current
+// list stays in effect.
+func (a *Allowlist) Reload() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read allowlist: %w", err)
+	}
+	defer f.Close()
+
+	caps := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) > 2 || !strings.HasPrefix(fields[0], "addr") {
+			return fmt.Errorf("allowlist %s line %d: want \"<address> [cap]\"", a.path, line)
+		}
+		limit := 0
+		if len(fields) == 2 {
+			if limit, err = strconv.Atoi(fields[1]); err != nil || limit < 1 {
+				return fmt.Errorf("allowlist %s line %d: invalid cap %q", a.path, line, fields[1])
+			}
+		}
+		caps[fields[0]] = limit
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read allowlist: %w", err)
+	}
+
+	a.mu.Lock()
+	a.caps = caps
+	a.mu.Unlock()
+	return nil
+}
+
+// Allowed reports whether addr is on the list and its per-wallet cap (0
+// for none).
+func (a *Allowlist) Allowed(addr string) (int, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	limit, ok := a.caps[addr]
+	return limit, ok
+}
+
+// Size returns the number of listed addresses.
+func (a *Allowlist) Size() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return len(a.caps)
+}
+
+// depositMints is how many tokens a deposit would mint.
+func (e *Engine) depositMints(dep Deposit) int {
+	if dep.Tier != nil {
+		return dep.Tier.Quantity
+	}
+	if len(e.tiers) > 0 || dep.Amount < e.mintPrice {
+		return 1
+	}
+	return int(dep.Amount / e.mintPrice)
+}
+
+// errWalletCap is returned by the state's reservations when a deposit
+// would take its sender past the per-wallet cap.
+var errWalletCap = errors.New("wallet cap reached")
+
+// walletLimit returns the per-wallet cap for addr (0 for none): its
+// allowlist cap if set, else -max-per-wallet. ok is false when an allowlist
+// is loaded and addr is not on it.
+func (e *Engine) walletLimit(addr string) (limit int, ok bool) {
+	limit = e.maxPerWallet
+	if e.allowlist != nil {
+		walletCap, listed := e.allowlist.Allowed(addr)
+		if !listed {
+			return 0, false
+		}
+		if walletCap > 0 {
+			limit = walletCap
+		}
+	}
+	return limit, true
+}
+
+// capFor returns the per-wallet cap a deposit's reservation is held to.
+// Manual mints are the operator's call and have none.
+func (e *Engine) capFor(dep Deposit) int {
+	if dep.manual {
+		return 0
+	}
+	limit, _ := e.walletLimit(dep.SenderAddr)
+	return limit
+}
+
+// admitSender applies the allowlist and per-wallet cap to a deposit. The cap
+// is the sender's allowlist cap if set, else -max-per-wallet. A sender that
+// is not listed, or whose mints would exceed the cap, is rejected (see
+// rejectDeposit). This is an early check against minted tokens; the cap is
+// enforced when the mint reserves its ids, counting reservations too. If the
+// count cannot be read the deposit waits for the next poll. It reports
+// whether the deposit may mint.
+func (e *Engine) admitSender(dep Deposit) bool {
+	limit, listed := e.walletLimit(dep.SenderAddr)
+	if !listed {
+		e.rejectDeposit(dep, "sender not on allowlist")
+		return false
+	}
+	if limit == 0 {
+		return true
+	}
+	minted, err := e.state.WalletMints(dep.SenderAddr)
+	if err != nil {
+		e.log.Error("failed to count wallet mints; deferring deposit", "deposit_tx", dep.TxHash, "sender", dep.SenderAddr, "error", err)
+		e.failures.Add(1)
+		return false
+	}
+	if minted+e.depositMints(dep) <= limit {
+		return true
+	}
+	e.rejectDeposit(dep, fmt.Sprintf("wallet cap reached (%d of %d minted)", minted, limit))
+	return false
+}
+
+// rejectIfCapped reports whether a mint failed because reserving its ids
+// would take the sender past its cap, rejecting the deposit if so. The
+// reservation was refused, so nothing is held for it.
+func (e *Engine) rejectIfCapped(dep Deposit, err error) bool {
+	if !errors.Is(err, errWalletCap) {
+		return false
+	}
+	e.rejectDeposit(dep, err.Error())
+	return true
+}
+
+// rejectDeposit turns away a deposit the allowlist or wallet cap does not
+// admit. It is refunded when -refund is set; otherwise it is left
+// unprocessed so it mints if the wallet is added (or its cap raised) and
+// the list reloaded.
+func (e *Engine) rejectDeposit(dep Deposit, reason string) {
+	if e.refundUnmatched && len(dep.Assets) == 0 {
+		e.log.Warn("refunding rejected deposit", "deposit_tx", dep.TxHash, "sender", dep.SenderAddr, "reason", reason)
+		if err := e.refundDeposit(dep); err != nil {
+			e.log.Error("failed to refund deposit", "deposit_tx", dep.TxHash, "error", err)
+			e.failures.Add(1)
+			e.auditFailure(dep, err)
+			return
+		}
+		e.markProcessed(dep.TxHash)
+		if err := e.state.Save(); err != nil {
+			e.log.Warn("failed to save state", "error", err)
+		}
+		return
+	}
+	if _, seen := e.rejected.LoadOrStore(dep.TxHash, true); !seen {
+		e.log.Warn("skipping rejected deposit", "deposit_tx", dep.TxHash, "sender", dep.SenderAddr, "reason", reason)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAllowlist writes an allowlist file and loads it.
+func writeAllowlist(t *testing.T, content string) *Allowlist {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "allowlist.txt")
+	writeFile(t, path, content)
+	a, err := LoadAllowlist(path)
+	if err != nil {
+		t.Fatalf("LoadAllowlist: %v", err)
+	}
+	return a
+}
+
+func TestLoadAllowlist(t *testing.T) {
+	alice, bob := testBuyer(t, 1), testBuyer(t, 2)
+	a := writeAllowlist(t, "# presale\n"+alice+"   2\n\n"+bob+" # no cap\n")
+	if a.Size() != 2 {
+		t.Errorf("Size() = %d, want 2", a.Size())
+	}
+	if limit, ok := a.Allowed(alice); !ok || limit != 2 {
+		t.Errorf("Allowed(alice) = %d, %v; want 2, true", limit, ok)
+	}
+	if limit, ok := a.Allowed(bob); !ok || limit != 0 {
+		t.Errorf("Allowed(bob) = %d, %v; want 0, true", limit, ok)
+	}
+	if _, ok := a.Allowed(testBuyer(t, 3)); ok {
+		t.Error("an unlisted address is allowed")
+	}
+
+	for name, tt := range map[string]struct{ content, wantErr string }{
+		"not an address": {content: "stake1u9x 2\n", wantErr: "line 1"},
+		"extra field":    {content: alice + " 2 3\n", wantErr: "line 1"},
+		"zero cap":       {content: "# ok\n" + alice + " 0\n", wantErr: `line 2: invalid cap "0"`},
+		"bad cap":        {content: alice + " two\n", wantErr: `invalid cap "two"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "allowlist.txt")
+			writeFile(t, path, tt.content)
+			if _, err := LoadAllowlist(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadAllowlist() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAllowlistAdmitsListedSenders(t *testing.T) {
+	alice, mallory := testBuyer(t, 1), testBuyer(t, 2)
+	list := writeAllowlist(t, alice+"\n")
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Allowlist = list
+	})
+	allowed, denied := testTxHash(1), testTxHash(2)
+	te.setDeposits(
+		mockDeposit{SenderAddr: alice, Amount: testMintPrice, TxHash: allowed},
+		mockDeposit{SenderAddr: mallory, Amount: testMintPrice, TxHash: denied},
+	)
+
+	te.poll()
+	if !te.state.IsProcessed(allowed) {
+		t.Error("the listed sender's deposit was not minted")
+	}
+	if te.state.IsProcessed(denied) {
+		t.Error("the unlisted sender's deposit was settled without -refund")
+	}
+	if n := len(te.submitted()); n != 1 {
+		t.Errorf("%d transactions, want only the listed sender's mint", n)
+	}
+
+	// Adding the sender and reloading lets the waiting deposit mint.
+	writeFile(t, list.path, alice+"\n"+mallory+"\n")
+	if err := list.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	te.poll()
+	if !te.state.IsProcessed(denied) {
+		t.Error("the deposit did not mint after its sender was listed")
+	}
+}
+
+func TestAllowlistRefundsDeniedSenders(t *testing.T) {
+	mallory := testBuyer(t, 2)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Allowlist = writeAllowlist(t, testBuyer(t, 1)+"\n")
+		cfg.RefundUnmatched = true
+	})
+	denied := testTxHash(2)
+	te.setDeposits(mockDeposit{SenderAddr: mallory, Amount: testMintPrice, TxHash: denied})
+
+	te.poll()
+	refunds := te.submittedKind("refund")
+	if len(refunds) != 1 || refunds[0].Outputs[0] != mallory {
+		t.Fatalf("refunds = %+v, want one to the unlisted sender", refunds)
+	}
+	if len(te.submittedKind("mint")) != 0 || !te.state.IsProcessed(denied) {
+		t.Error("the denied deposit was minted or left unsettled")
+	}
+}
+
+func TestMaxPerWallet(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			requireBackend(t, backend)
+			te := newTestEngine(t, func(cfg *EngineConfig) {
+				cfg.MaxPerWallet = 2
+				cfg.StateBackend = backend
+				cfg.StateFile = filepath.Join(t.TempDir(), "state."+backend)
+			})
+			alice := testBuyer(t, 1)
+			deps := []mockDeposit{
+				{SenderAddr: alice, Amount: testMintPrice, TxHash: testTxHash(1)},
+				{SenderAddr: alice, Amount: testMintPrice, TxHash: testTxHash(2)},
+				{SenderAddr: alice, Amount: testMintPrice, TxHash: testTxHash(3)},
+				{SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: testTxHash(4)},
+			}
+			te.setDeposits(deps...)
+
+			te.poll()
+			for i, dep := range deps {
+				refused := i == 2
+				if got := te.state.IsProcessed(dep.TxHash); got == refused {
+					t.Errorf("deposit %d processed = %v, want %v", i+1, got, !refused)
+				}
+			}
+			if n, _ := te.state.WalletMints(alice); n != 2 {
+				t.Errorf("WalletMints(alice) = %d, want the cap of 2", n)
+			}
+			if n := len(te.submittedKind("mint")); n != 3 {
+				t.Errorf("%d mints, want 3: two for alice and one for the other wallet", n)
+			}
+			if _, ok := te.state.Pending()[testTxHash(3)]; ok {
+				t.Error("an id is reserved for the refused deposit")
+			}
+
+			// The refused deposit stays refused on later polls.
+			te.poll()
+			if te.state.IsProcessed(testTxHash(3)) || len(te.submittedKind("mint")) != 3 {
+				t.Error("the third deposit minted on a later poll")
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// defaultAssetName is the token name pattern when -asset-name is not set.
+const defaultAssetName = "Flowmass%d"
+
+// assetNameLimit is the ledger's maximum asset name length, in bytes.
+const assetNameLimit = 32
+
+// validateAssetName checks an -asset-name pattern: a printf format with
+// exactly one integer verb for the mint id (e.g. "Flowmass %d" or
+// "FM%04d") whose names fit the ledger's 32 bytes.
+func validateAssetName(pattern string) error {
+	if strings.Count(strings.ReplaceAll(pattern, "%%", ""), "%") != 1 {
+		return fmt.Errorf("asset name pattern %q must contain exactly one %%d for the mint id", pattern)
+	}
+	one, two := fmt.Sprintf(pattern, 1), fmt.Sprintf(pattern, 2)
+	if strings.Contains(one, "%!") || one == two {
+		return fmt.Errorf("asset name pattern %q must format the mint id with an integer verb such as %%d", pattern)
+	}
+	if long := fmt.Sprintf(pattern, 9_999_999); len(long) > assetNameLimit {
+		return fmt.Errorf("asset name pattern %q gives names longer than %d bytes (e.g. %q)", pattern, assetNameLimit, long)
+	}
+	return nil
+}
+
+// assetUnit returns the cardano-cli asset id "policyID.hexName" for --mint
+// and --tx-out. The name must already be hex: a raw name with spaces or
+// non-ASCII characters would be read differently by cardano-cli and no
+// longer match the metadata.
+func assetUnit(policyID, hexName string) (string, error) {
+	name, err := hex.DecodeString(hexName)
+	if err != nil || len(name) == 0 {
+		return "", fmt.Errorf("asset name %q is not hex-encoded", hexName)
+	}
+	if len(name) > assetNameLimit {
+		return "", fmt.Errorf("asset name %q is longer than %d bytes", name, assetNameLimit)
+	}
+	return policyID + "." + strings.ToLower(hexName), nil
+}
+
+// tokenName returns the default-tier asset name of mint id.
+func (e *Engine) tokenName(id int) string {
+	return fmt.Sprintf(e.assetName, id)
+}
+
+// checkMetadataNames verifies that rendered CIP-25 metadata describes every
+// token minted under policyID, keyed by its asset name either as UTF-8
+// (CIP-25 v1) or hex (v2), so the metadata and the --mint asset names
+// cannot drift apart. Templates and manifests render the keys themselves.
+// It is the enforcement point for that: every mint path (single, bundle and
+// preview-metadata) runs it on the metadata it is about to use, and a
+// mismatch fails the mint before anything is built.
+func checkMetadataNames(metadata, policyID string, hexNames []string) error {
+	var doc struct {
+		Policies map[string]json.RawMessage `json:"721"`
+	}
+	if err := json.Unmarshal([]byte(metadata), &doc); err != nil {
+		return fmt.Errorf("metadata is not valid JSON: %v", err)
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(doc.Policies[policyID], &keys); err != nil || keys == nil {
+		return fmt.Errorf("metadata has no 721 entry for policy %s", policyID)
+	}
+	for _, hexName := range hexNames {
+		name, err := hex.DecodeString(hexName)
+		if err != nil {
+			return err
+		}
+		if _, ok := keys[string(name)]; ok {
+			continue
+		}
+		if _, ok := keys[hexName]; ok {
+			continue
+		}
+		return fmt.Errorf("metadata has no entry for asset %q (hex %s) under policy %s", name, hexName, policyID)
+	}
+	return nil
+}
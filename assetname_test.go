@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateAssetName(t *testing.T) {
+	tests := []struct {
+		pattern, wantErr string
+	}{
+		{pattern: "Flowmass%d"},
+		{pattern: "Flowmass %d"},
+		{pattern: "FM%04d"},
+		{pattern: "100%% Shark %d"},
+		{pattern: "Flowmass", wantErr: "exactly one %d"},
+		{pattern: "Flowmass %d of %d", wantErr: "exactly one %d"},
+		{pattern: "Flowmass %s", wantErr: "integer verb"},
+		{pattern: "Flowmass Limited Edition Shark %d", wantErr: "longer than 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			err := validateAssetName(tt.pattern)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateAssetName() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateAssetName() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTierNamePattern(t *testing.T) {
+	tests := []struct {
+		name   string
+		tier   Tier
+		want   string
+		wantID string
+	}{
+		{name: "prefix", tier: Tier{AssetPrefix: "FlowmassRare"}, want: "FlowmassRare%d", wantID: "FlowmassRare7"},
+		{name: "percent in prefix", tier: Tier{AssetPrefix: "100%Shark"}, want: "100%%Shark%d", wantID: "100%Shark7"},
+		{name: "no prefix falls back to -asset-name", tier: Tier{}, want: "Shark #%d", wantID: "Shark #7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tier.namePattern("Shark #%d"); got != tt.want {
+				t.Errorf("namePattern() = %q, want %q", got, tt.want)
+			}
+			if got := tt.tier.DisplayName("Shark #%d", 7); got != tt.wantID {
+				t.Errorf("DisplayName(7) = %q, want %q", got, tt.wantID)
+			}
+		})
+	}
+	tiers := []Tier{{AssetPrefix: "FlowmassRare"}, {}, {AssetPrefix: "FlowmassRare"}}
+	if got, want := namePatterns("Shark #%d", tiers), []string{"Shark #%d", "FlowmassRare%d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("namePatterns() = %q, want %q", got, want)
+	}
+}
+
+func TestMintedNameMatchesMetadataKey(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.AssetName = "Flowmass %d"
+	})
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: testTxHash(1)})
+
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 || len(mints[0].Mint) != 1 {
+		t.Fatalf("got mints %+v, want one token", mints)
+	}
+	unit := strings.TrimPrefix(mints[0].Mint[0], "1 "+testPolicyID+".")
+	name, err := hex.DecodeString(unit)
+	if err != nil || string(name) != "Flowmass 1" {
+		t.Fatalf("minted asset %s, want hex of \"Flowmass 1\"", mints[0].Mint[0])
+	}
+	if entry := tokenMetadata(t, string(mints[0].Metadata), testPolicyID, string(name)); entry["name"] == nil {
+		t.Errorf("metadata entry for %q has no name: %v", name, entry)
+	}
+}
+
+func TestCheckMetadataNames(t *testing.T) {
+	spaced := hex.EncodeToString([]byte("Flowmass 1"))
+	tests := []struct {
+		name, metadata, wantErr string
+	}{
+		{name: "utf-8 key", metadata: `{"721": {"` + testPolicyID + `": {"Flowmass 1": {}}}}`},
+		{name: "hex key", metadata: `{"721": {"` + testPolicyID + `": {"` + spaced + `": {}}, "version": 2}}`},
+		{name: "other name", metadata: `{"721": {"` + testPolicyID + `": {"Flowmass1": {}}}}`, wantErr: `no entry for asset "Flowmass 1"`},
+		{name: "other policy", metadata: `{"721": {"` + strings.Repeat("ab", 28) + `": {"Flowmass 1": {}}}}`, wantErr: "no 721 entry for policy"},
+		{name: "not JSON", metadata: `{"721":`, wantErr: "not valid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMetadataNames(tt.metadata, testPolicyID, []string{spaced})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkMetadataNames() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkMetadataNames() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssetUnit(t *testing.T) {
+	tests := []struct {
+		name, hexName, want, wantErr string
+	}{
+		{name: "space", hexName: hex.EncodeToString([]byte("Flowmass 1")), want: testPolicyID + ".466c6f776d6173732031"},
+		{name: "non-ASCII", hexName: hex.EncodeToString([]byte("Flowmass №1")), want: testPolicyID + ".466c6f776d61737320e2849631"},
+		{name: "upper-case hex", hexName: "466C6F776D61737331", want: testPolicyID + ".466c6f776d61737331"},
+		{name: "raw name", hexName: "Flowmass 1", wantErr: "is not hex-encoded"},
+		{name: "empty", hexName: "", wantErr: "is not hex-encoded"},
+		{name: "too long", hexName: strings.Repeat("41", assetNameLimit+1), wantErr: "longer than 32 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := assetUnit(testPolicyID, tt.hexName)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("assetUnit() = %q, %v; want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("assetUnit() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildTransactionMintsHexName(t *testing.T) {
+	node := newFakeNode(t)
+	cli := testCLI(t, buildAuto)
+	hexName := hex.EncodeToString([]byte("Flowmass 1"))
+	metadata, err := MetadataTemplate(testPolicyID, hexName, "A shark.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := testBuyer(t, 1)
+	if _, err := cli.BuildTransactionMultipleMints([]string{testTxHash(1) + "#0"}, testMonitorAddr(t), recipient, []string{hexName}, testPolicyID, "policy.script", metadata, 0, 139493917, Deposit{TxHash: testTxHash(1)}, 1, nil); err != nil {
+		t.Fatalf("BuildTransactionMultipleMints: %v", err)
+	}
+	build, ok := node.call("transaction build")
+	if !ok {
+		t.Fatal("no transaction was built")
+	}
+	spec := "1 " + testPolicyID + "." + hexName
+	if got := flagValue(build, "--mint"); got != spec {
+		t.Errorf("--mint = %q, want %q", got, spec)
+	}
+	if got, want := flagValue(build, "--tx-out"), recipient+"+1138760+"+spec; got != want {
+		t.Errorf("--tx-out = %q, want %q", got, want)
+	}
+	if err := checkMetadataNames(metadata, testPolicyID, []string{hexName}); err != nil {
+		t.Errorf("metadata does not key the minted asset: %v", err)
+	}
+}
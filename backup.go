@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// State backups: every -backup-interval (and on SIGUSR1) each engine's
+// state is copied to a timestamped file in -backup-dir, and all but the
+// newest -backup-keep copies are deleted. A state that goes bad slowly can then be
+// rolled back to a point before the damage. These are the defaults of
+// -backup-interval and -backup-keep; an empty -backup-dir disables backups.
+const (
+	defaultBackupInterval = time.Hour
+	defaultBackupKeep     = 24
+)
+
+// backupTimeFormat names backups so they sort by time.
+const backupTimeFormat = "20060102T150405Z"
+
+// copyFile copies src to dst, syncing dst. A missing src is reported as
+// os.ErrNotExist.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// backupState writes a timestamped copy of the engine's state to its
+// backup directory and rotates old copies out. It returns the backup's path.
+func (e *Engine) backupState() (string, error) {
+	dir := e.settings.backupDir
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	base := filepath.Base(e.stateFile)
+	dest := filepath.Join(dir, base+"."+time.Now().UTC().Format(backupTimeFormat))
+	if err := e.state.Backup(dest); err != nil {
+		return "", fmt.Errorf("failed to back up state: %w", err)
+	}
+	if err := rotateBackups(dir, base, e.settings.backupKeep); err != nil {
+		e.log.Warn("failed to remove old state backups", "error", err)
+	}
+	return dest, nil
+}
+
+// rotateBackups deletes all but the newest keep backups in dir of the state
+// file named base, with their archives.
+func rotateBackups(dir, base string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, base+".*"))
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, m[len(filepath.Join(dir, base))+1:]); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, b := range backups[:len(backups)-keep] {
+		if err := os.Remove(b); err != nil {
+			return err
+		}
+		os.Remove(b + archiveSuffix)
+	}
+	return nil
+}
+
+// backupAll backs up the state of every engine with a backup directory,
+// logging the outcome.
+func backupAll(engines []*Engine) {
+	for _, e := range engines {
+		if e.settings.backupDir == "" {
+			e.log.Warn("no -backup-dir set; not backing up")
+			continue
+		}
+		if path, err := e.backupState(); err != nil {
+			e.log.Error("state backup failed", "error", err)
+		} else {
+			e.log.Info("state backed up", "file", path)
+		}
+	}
+}
+
+// startBackups backs up the engine's state every backupInterval in the
+// background, starting with one right away. It does nothing without a
+// backup directory or interval.
+func (e *Engine) startBackups() {
+	dir, interval := e.settings.backupDir, e.settings.backupInterval
+	if dir == "" || interval <= 0 {
+		return
+	}
+	e.log.Info("state backups enabled", "dir", dir, "interval", interval, "keep", e.settings.backupKeep)
+	go func() {
+		backupAll([]*Engine{e})
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				backupAll([]*Engine{e})
+			case <-e.quit:
+				return
+			}
+		}
+	}()
+}
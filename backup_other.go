@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// backupSignal is nil where there is no SIGUSR1; backups are only timed.
+var backupSignal os.Signal
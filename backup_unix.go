@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// backupSignal triggers an immediate state backup.
+var backupSignal os.Signal = syscall.SIGUSR1
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockfrostBase returns the Blockfrost API root for the network.
+func blockfrostBase(network string) string {
+	if network == "mainnet" {
+		return "https://cardano-mainnet.blockfrost.io/api/v0"
+	}
+	return "https://cardano-preprod.blockfrost.io/api/v0"
+}
+
+// blockfrostPageSize is the most items a Blockfrost list endpoint returns
+// per page.
+const blockfrostPageSize = 100
+
+// blockfrostKeyPrefixes are the network prefixes of Blockfrost project ids.
+var blockfrostKeyPrefixes = []string{"mainnet", "preprod", "preview", "testnet"}
+
+// checkBlockfrostKey fails when the project id belongs to another network
+// than the one blockfrostBase talks to (Blockfrost answers 403 on every
+// call otherwise), then pings /health to confirm the key is accepted.
+func checkBlockfrostKey(bf *blockfrostBudget, key, network string) error {
+	want := "preprod"
+	if network == "mainnet" {
+		want = "mainnet"
+	}
+	if !strings.HasPrefix(key, want) {
+		for _, p := range blockfrostKeyPrefixes {
+			if strings.HasPrefix(key, p) {
+				return fmt.Errorf("blockfrost key is a %s project id but network is %s; use a %s project id from blockfrost.io", p, network, want)
+			}
+		}
+		return fmt.Errorf("blockfrost key does not look like a project id (want one starting with %q)", want)
+	}
+	var health struct {
+		IsHealthy bool `json:"is_healthy"`
+	}
+	if err := blockfrostGet(bf, key, blockfrostBase(network)+"/health", &health); err != nil {
+		return fmt.Errorf("blockfrost health check failed: %v", err)
+	}
+	if !health.IsHealthy {
+		return fmt.Errorf("blockfrost reports it is unhealthy")
+	}
+	return nil
+}
+
+// blockfrostError is an error object returned by Blockfrost.
+type blockfrostError struct {
+	URL        string
+	StatusCode int
+	Message    string
+}
+
+func (e *blockfrostError) Error() string {
+	return fmt.Sprintf("blockfrost %s: %d %s", e.URL, e.StatusCode, e.Message)
+}
+
+// isBlockfrostNotFound reports whether err is Blockfrost's 404, which it
+// returns for an address that has never been used rather than an empty
+// list. Auth (403), rate-limit (429) and server errors are not.
+func isBlockfrostNotFound(err error) bool {
+	var bf *blockfrostError
+	return errors.As(err, &bf) && bf.StatusCode == 404
+}
+
+// blockfrostGet fetches url with curl, throttled by bf, and decodes the JSON
+// body into v. A
+// Blockfrost error object (status_code/message) is returned as an error.
+func blockfrostGet(bf *blockfrostBudget, blockfrostKey, url string, v interface{}) error {
+	bf.throttle()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "curl", "-s",
+		"-H", fmt.Sprintf("project_id:%s", blockfrostKey),
+		url)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("blockfrost curl failed: %v; output: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	var errObj struct {
+		StatusCode int    `json:"status_code"`
+		Message    string `json:"message"`
+	}
+	if json.Unmarshal(out, &errObj) == nil && errObj.StatusCode != 0 {
+		return &blockfrostError{URL: url, StatusCode: errObj.StatusCode, Message: errObj.Message}
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("failed to parse Blockfrost response for %s: %v; raw=%s", url, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// GetCurrentSlotBlockfrost returns the slot of the latest block from
+// Blockfrost's /blocks/latest, for deployments without a local node.
+func GetCurrentSlotBlockfrost(bf *blockfrostBudget, baseURL, key string) (int64, error) {
+	var block struct {
+		Slot *int64 `json:"slot"`
+	}
+	if err := blockfrostGet(bf, key, baseURL+"/blocks/latest", &block); err != nil {
+		return 0, fmt.Errorf("failed to query latest block: %w", err)
+	}
+	if block.Slot == nil {
+		return 0, fmt.Errorf("latest block response has no slot")
+	}
+	return *block.Slot, nil
+}
+
+// GetUTxOsBlockfrost returns the UTxOs at address from Blockfrost's
+// /addresses/{address}/utxos, paged, in the shape of a cardano-cli query:
+// assets are keyed "policyid.assetname". An address with nothing at it
+// fails with errNoUTxOs, as the node query does.
+func GetUTxOsBlockfrost(bf *blockfrostBudget, baseURL, key, address string) ([]UTxO, error) {
+	type addressUTxO struct {
+		TxHash      string `json:"tx_hash"`
+		OutputIndex int    `json:"output_index"`
+		Amount      []struct {
+			Unit     string `json:"unit"`
+			Quantity string `json:"quantity"`
+		} `json:"amount"`
+	}
+	var result []UTxO
+	for page := 1; ; page++ {
+		var batch []addressUTxO
+		url := fmt.Sprintf("%s/addresses/%s/utxos?count=%d&page=%d", baseURL, address, blockfrostPageSize, page)
+		if err := blockfrostGet(bf, key, url, &batch); isBlockfrostNotFound(err) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to query utxos: %w", err)
+		}
+		for _, b := range batch {
+			u := UTxO{ID: fmt.Sprintf("%s#%d", b.TxHash, b.OutputIndex), Assets: make(map[string]uint64)}
+			for _, a := range b.Amount {
+				q, err := strconv.ParseUint(a.Quantity, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("utxo %s: invalid %s quantity %q: %w", u.ID, a.Unit, a.Quantity, err)
+				}
+				switch {
+				case a.Unit == "lovelace":
+					u.Lovelace = q
+				case len(a.Unit) >= policyIDHexLen:
+					u.Assets[a.Unit[:policyIDHexLen]+"."+a.Unit[policyIDHexLen:]] = q
+				default:
+					return nil, fmt.Errorf("utxo %s: invalid asset unit %q", u.ID, a.Unit)
+				}
+			}
+			result = append(result, u)
+		}
+		if len(batch) < blockfrostPageSize {
+			break
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w at address %s", errNoUTxOs, address)
+	}
+	return result, nil
+}
+
+// SubmitTransactionBlockfrost broadcasts a signed transaction through
+// Blockfrost's /tx/submit and returns the transaction hash. signedCborFile may
+// be a cardano-cli text envelope ({"cborHex": ...}) or raw CBOR bytes.
+// Ledger rejections (e.g. ValueNotConservedUTxO) are returned in the error.
+// The CBOR is staged in work.
+func SubmitTransactionBlockfrost(bf *blockfrostBudget, signedCborFile, baseURL, key string, work workDir) (string, error) {
+	data, err := os.ReadFile(signedCborFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signed transaction: %w", err)
+	}
+	var envelope struct {
+		CborHex string `json:"cborHex"`
+	}
+	if json.Unmarshal(data, &envelope) == nil && envelope.CborHex != "" {
+		if data, err = hex.DecodeString(envelope.CborHex); err != nil {
+			return "", fmt.Errorf("invalid cborHex in %s: %v", signedCborFile, err)
+		}
+	}
+
+	cborFile, err := work.tempPath("tx-*.cbor")
+	if err != nil {
+		return "", err
+	}
+	defer work.cleanupTemp(cborFile)
+	if err := os.WriteFile(cborFile, data, 0o600); err != nil {
+		return "", err
+	}
+
+	bf.throttle()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "curl", "-s", "-X", "POST",
+		"-H", fmt.Sprintf("project_id:%s", key),
+		"-H", "Content-Type: application/cbor",
+		"--data-binary", "@"+cborFile,
+		baseURL+"/tx/submit")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("blockfrost submit failed: %v; output: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// Success is the tx hash as a JSON string; failures are an error object
+	// whose message carries the node's rejection reason.
+	var txHash string
+	if err := json.Unmarshal(out, &txHash); err == nil && txHash != "" {
+		return txHash, nil
+	}
+	var errObj struct {
+		StatusCode int    `json:"status_code"`
+		Error      string `json:"error"`
+		Message    string `json:"message"`
+	}
+	if err := json.Unmarshal(out, &errObj); err == nil && errObj.StatusCode != 0 {
+		return "", fmt.Errorf("blockfrost rejected transaction (%d %s): %s", errObj.StatusCode, errObj.Error, errObj.Message)
+	}
+	return "", fmt.Errorf("unexpected Blockfrost submit response: %s", strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBlockfrostKey is the project id test servers expect.
+const testBlockfrostKey = "mainnetTestProjectID"
+
+// newBlockfrostServer serves handler as the Blockfrost API, answering
+// requests without the test project id with Blockfrost's 403 error object.
+// It returns the API root.
+func newBlockfrostServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("project_id") != testBlockfrostKey {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"status_code": 403, "error": "Forbidden", "message": "Invalid project token."}`)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// testBudget is an unthrottled Blockfrost budget.
+func testBudget() *blockfrostBudget {
+	return newBlockfrostBudget(0, 0, 0)
+}
+
+func TestGetCurrentSlotBlockfrost(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       int64
+		wantErr    bool
+	}{
+		{name: "latest block", body: `{"hash": "4ea1", "slot": 139483917, "height": 11023456}`, want: 139483917},
+		{name: "no slot", body: `{"hash": "4ea1", "slot": null}`, wantErr: true},
+		{name: "error object", body: `{"status_code": 500, "error": "Internal Server Error", "message": "An unexpected response was received from the backend."}`, wantErr: true},
+		{name: "not JSON", body: `<html>502 Bad Gateway</html>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/blocks/latest" {
+					t.Errorf("request for %s, want /blocks/latest", r.URL.Path)
+				}
+				fmt.Fprint(w, tt.body)
+			})
+			got, err := GetCurrentSlotBlockfrost(testBudget(), base, testBlockfrostKey)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetCurrentSlotBlockfrost() = %d, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("GetCurrentSlotBlockfrost() = %d, %v; want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockfrostRejectedKey(t *testing.T) {
+	base := newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request with the wrong key reached the handler")
+	})
+	_, err := GetCurrentSlotBlockfrost(testBudget(), base, "preprodWrongKey")
+	var bfErr *blockfrostError
+	if !errors.As(err, &bfErr) || bfErr.StatusCode != 403 {
+		t.Errorf("error = %v, want Blockfrost's 403", err)
+	}
+}
+
+// bfUTxO is a UTxO held at a fakeBlockfrost address.
+type bfUTxO struct {
+	TxHash      string     `json:"tx_hash"`
+	OutputIndex int        `json:"output_index"`
+	Amount      []bfAmount `json:"amount"`
+}
+
+type bfAmount struct {
+	Unit     string `json:"unit"`
+	Quantity string `json:"quantity"`
+}
+
+// lovelaceUTxO returns a pure-ADA UTxO of transaction n.
+func lovelaceUTxO(n int, lovelace int64) bfUTxO {
+	return bfUTxO{TxHash: testTxHash(n), Amount: []bfAmount{{Unit: "lovelace", Quantity: fmt.Sprint(lovelace)}}}
+}
+
+// fakeBlockfrost serves one address's UTxOs blockfrostPageSize at a time,
+// with the transaction lookups a deposit scan makes: every transaction was
+// sent by sender, in the order its UTxO is listed.
+type fakeBlockfrost struct {
+	base   string
+	mu     sync.Mutex
+	utxos  []bfUTxO
+	pages  []int
+	sender string
+}
+
+func newFakeBlockfrost(t *testing.T, address, sender string, utxos []bfUTxO) *fakeBlockfrost {
+	t.Helper()
+	bf := &fakeBlockfrost{utxos: utxos, sender: sender}
+	bf.base = newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+		bf.mu.Lock()
+		defer bf.mu.Unlock()
+		notFound := func() {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"status_code": 404, "error": "Not Found", "message": "The requested component has not been found."}`)
+		}
+		switch path := r.URL.Path; {
+		case path == "/addresses/"+address+"/utxos":
+			page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+			bf.pages = append(bf.pages, page)
+			if len(bf.utxos) == 0 {
+				notFound()
+				return
+			}
+			from := min((page-1)*blockfrostPageSize, len(bf.utxos))
+			json.NewEncoder(w).Encode(bf.utxos[from:min(from+blockfrostPageSize, len(bf.utxos))])
+		case path == "/addresses/"+address+"/transactions":
+			fmt.Fprint(w, "[]")
+		case strings.HasSuffix(path, "/utxos") && strings.HasPrefix(path, "/txs/"):
+			fmt.Fprintf(w, `{"inputs": [{"address": %q}], "outputs": []}`, bf.sender)
+		case strings.HasPrefix(path, "/txs/"):
+			tx := strings.TrimPrefix(path, "/txs/")
+			for i, u := range bf.utxos {
+				if u.TxHash == tx {
+					fmt.Fprintf(w, `{"hash": %q, "block_height": %d, "index": 0}`, tx, 1000+i)
+					return
+				}
+			}
+			notFound()
+		default:
+			t.Errorf("unexpected Blockfrost request %s", r.URL)
+			notFound()
+		}
+	})
+	return bf
+}
+
+// requestedPages returns the UTxO pages requested so far.
+func (bf *fakeBlockfrost) requestedPages() []int {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	return append([]int(nil), bf.pages...)
+}
+
+func TestGetUTxOsBlockfrostPages(t *testing.T) {
+	addr := testMonitorAddr(t)
+	for _, tt := range []struct {
+		utxos int
+		pages []int
+	}{
+		{utxos: 203, pages: []int{1, 2, 3}},
+		{utxos: 200, pages: []int{1, 2, 3}},
+		{utxos: 3, pages: []int{1}},
+	} {
+		t.Run(fmt.Sprint(tt.utxos), func(t *testing.T) {
+			var utxos []bfUTxO
+			for i := 0; i < tt.utxos; i++ {
+				utxos = append(utxos, lovelaceUTxO(i, 5_000_000))
+			}
+			unit := testPolicyID + "466c6f776d61737331"
+			utxos[len(utxos)-1].Amount = append(utxos[len(utxos)-1].Amount, bfAmount{Unit: unit, Quantity: "1"})
+			bf := newFakeBlockfrost(t, addr, "", utxos)
+
+			got, err := GetUTxOsBlockfrost(testBudget(), bf.base, testBlockfrostKey, addr)
+			if err != nil {
+				t.Fatalf("GetUTxOsBlockfrost: %v", err)
+			}
+			if len(got) != tt.utxos {
+				t.Fatalf("got %d UTxOs, want %d", len(got), tt.utxos)
+			}
+			if pages := bf.requestedPages(); !reflect.DeepEqual(pages, tt.pages) {
+				t.Errorf("requested pages %v, want %v", pages, tt.pages)
+			}
+			last := got[len(got)-1]
+			if last.ID != testTxHash(tt.utxos-1)+"#0" || last.Lovelace != 5_000_000 || last.Assets[testPolicyID+".466c6f776d61737331"] != 1 {
+				t.Errorf("last UTxO = %+v, want the token under policy.name", last)
+			}
+		})
+	}
+}
+
+func TestFetchDepositsBeyondFirstPage(t *testing.T) {
+	te := newTestEngine(t, nil)
+	te.useBlockfrost()
+	// 203 UTxOs of change, with deposits on the first and last pages.
+	var utxos []bfUTxO
+	for i := 0; i < 203; i++ {
+		utxos = append(utxos, lovelaceUTxO(i, 5_000_000))
+	}
+	for _, i := range []int{0, 201, 202} {
+		utxos[i] = lovelaceUTxO(i, testMintPrice)
+	}
+	buyer := testBuyer(t, 1)
+	bf := newFakeBlockfrost(t, te.monitorAddr, buyer, utxos)
+
+	deposits, err := te.fetchDepositsBlockfrost(bf.base)
+	if err != nil {
+		t.Fatalf("fetchDepositsBlockfrost: %v", err)
+	}
+	var got []string
+	for _, d := range deposits {
+		got = append(got, d.TxHash)
+		if d.SenderAddr != buyer || d.Amount != testMintPrice {
+			t.Errorf("deposit %s from %s of %d, want %d from the buyer", d.TxHash, d.SenderAddr, d.Amount, testMintPrice)
+		}
+	}
+	if want := []string{testTxHash(0), testTxHash(201), testTxHash(202)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("deposits = %v, want %v", got, want)
+	}
+	if pages := bf.requestedPages(); !reflect.DeepEqual(pages, []int{1, 2, 3}) {
+		t.Errorf("requested pages %v, want [1 2 3]", pages)
+	}
+}
+
+func TestEmptyAddressHasNoDeposits(t *testing.T) {
+	te := newTestEngine(t, nil)
+	te.useBlockfrost()
+	bf := newFakeBlockfrost(t, te.monitorAddr, "", nil)
+
+	deposits, err := te.fetchDepositsBlockfrost(bf.base)
+	if err != nil || len(deposits) != 0 {
+		t.Errorf("fetchDepositsBlockfrost() on a never-used address = %v, %v; want no deposits and no error", deposits, err)
+	}
+	if _, err := GetUTxOsBlockfrost(testBudget(), bf.base, testBlockfrostKey, te.monitorAddr); !errors.Is(err, errNoUTxOs) {
+		t.Errorf("GetUTxOsBlockfrost() on a never-used address error = %v, want errNoUTxOs", err)
+	}
+
+	// Other errors are still errors.
+	te.blockfrostKey = "mainnetWrongKey"
+	if _, err := te.fetchDepositsBlockfrost(bf.base); err == nil {
+		t.Error("fetchDepositsBlockfrost() with a rejected key succeeded")
+	}
+}
+
+func TestIsBlockfrostNotFound(t *testing.T) {
+	for err, want := range map[error]bool{
+		&blockfrostError{StatusCode: 404}:                          true,
+		fmt.Errorf("query: %w", &blockfrostError{StatusCode: 404}): true,
+		&blockfrostError{StatusCode: 403}:                          false,
+		errors.New("404"):                                          false,
+	} {
+		if got := isBlockfrostNotFound(err); got != want {
+			t.Errorf("isBlockfrostNotFound(%v) = %v, want %v", err, got, want)
+		}
+	}
+	if isBlockfrostNotFound(nil) {
+		t.Error("isBlockfrostNotFound(nil) = true")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the engine polls for deposits.
+const pollInterval = 60 * time.Second
+
+// Defaults for -breaker-threshold and -breaker-cooldown.
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Minute
+)
+
+// Breaker states, as reported on /status.
+const (
+	breakerClosed  = "closed"  // polling normally
+	breakerBackoff = "backoff" // recent failures; polls are spaced out
+	breakerOpen    = "open"    // paused until the cooldown ends
+)
+
+// pollBreaker tracks consecutive deposit-fetch failures. threshold and
+// cooldown control how deposit polling reacts to a failing deposit source
+// (Blockfrost down or rate-limiting): after each consecutive failure the
+// next poll waits twice as long, and after threshold failures in a row
+// polling pauses for cooldown before a single trial poll. A threshold of 0
+// never pauses.
+type pollBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	failures  int
+	lastErr   string
+	retryAt   time.Time // polls before this are skipped
+}
+
+// breakerStatus is the breaker's state for /status.
+type breakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+// allow reports whether a poll may run at now.
+func (b *pollBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.retryAt)
+}
+
+// failure records a failed poll and schedules the next one. It reports
+// whether this failure opened the breaker.
+func (b *pollBreaker) failure(now time.Time, err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err.Error()
+	opened := b.threshold > 0 && b.failures >= b.threshold
+	wait := b.cooldown
+	if !opened && b.failures < 32 && pollInterval<<(b.failures-1) < b.cooldown {
+		wait = pollInterval << (b.failures - 1)
+	}
+	// Ticks keep their pace from the start of the failed poll; half an
+	// interval of slack lets the tick due after wait run.
+	b.retryAt = now.Add(wait - pollInterval/2)
+	return opened && b.failures == b.threshold
+}
+
+// success resets the breaker. It reports whether the breaker was open.
+func (b *pollBreaker) success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.threshold > 0 && b.failures >= b.threshold
+	b.failures = 0
+	b.lastErr = ""
+	b.retryAt = time.Time{}
+	return wasOpen
+}
+
+// status returns the breaker's current state.
+func (b *pollBreaker) status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := breakerStatus{State: breakerClosed, ConsecutiveFailures: b.failures, LastError: b.lastErr}
+	switch {
+	case b.failures == 0:
+		return s
+	case b.threshold > 0 && b.failures >= b.threshold:
+		s.State = breakerOpen
+	default:
+		s.State = breakerBackoff
+	}
+	if !b.retryAt.IsZero() {
+		retryAt := b.retryAt
+		s.RetryAt = &retryAt
+	}
+	return s
+}
+
+// fetchFailed feeds a failed deposit fetch to the breaker and announces
+// when polling pauses.
+func (e *Engine) fetchFailed(err error) {
+	if e.breaker.failure(time.Now(), err) {
+		e.log.Error("deposit source keeps failing; pausing polling", "failures", e.breaker.threshold, "cooldown", e.breaker.cooldown, "error", err)
+		Notify(eventPaused, fmt.Sprintf("%s paused polling for %s after %d failed deposit fetches: %v",
+			e.displayName(), e.breaker.cooldown, e.breaker.threshold, err))
+		return
+	}
+	if st := e.breaker.status(); st.RetryAt != nil {
+		e.log.Warn("backing off deposit polling", "failures", st.ConsecutiveFailures, "retry_at", st.RetryAt.Format(time.RFC3339))
+	}
+}
+
+// fetchSucceeded resets the breaker and announces a recovery.
+func (e *Engine) fetchSucceeded() {
+	if e.breaker.success() {
+		e.log.Info("deposit source recovered; polling resumed")
+		Notify(eventResumed, fmt.Sprintf("%s resumed polling: deposit source is responding again", e.displayName()))
+	}
+}
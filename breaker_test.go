@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollBreakerBacksOffThenOpens(t *testing.T) {
+	b := &pollBreaker{threshold: 3, cooldown: 10 * time.Minute}
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	errDown := errors.New("blockfrost: 503")
+
+	// Each failure doubles the wait before the next poll.
+	for i, wait := range []time.Duration{pollInterval, 2 * pollInterval} {
+		if b.failure(now, errDown) {
+			t.Fatalf("failure %d opened the breaker before the threshold", i+1)
+		}
+		if st := b.status(); st.State != breakerBackoff || st.ConsecutiveFailures != i+1 || st.LastError != errDown.Error() {
+			t.Fatalf("after failure %d: status = %+v, want backoff", i+1, st)
+		}
+		if b.allow(now.Add(wait - pollInterval/2 - time.Second)) {
+			t.Errorf("after failure %d: a poll before %s was allowed", i+1, wait)
+		}
+		if !b.allow(now.Add(wait)) {
+			t.Errorf("after failure %d: the poll due after %s was skipped", i+1, wait)
+		}
+	}
+
+	// The threshold opens it, once, for the cooldown.
+	if !b.failure(now, errDown) {
+		t.Fatal("the third failure did not open the breaker")
+	}
+	if st := b.status(); st.State != breakerOpen || st.RetryAt == nil {
+		t.Fatalf("status = %+v, want open with a retry time", st)
+	}
+	if b.allow(now.Add(b.cooldown / 2)) {
+		t.Error("a poll ran halfway through the cooldown")
+	}
+	if !b.allow(now.Add(b.cooldown)) {
+		t.Error("no trial poll after the cooldown")
+	}
+	// A failed trial poll reopens it without announcing it again.
+	if b.failure(now.Add(b.cooldown), errDown) {
+		t.Error("a failed trial poll announced the pause again")
+	}
+	if b.allow(now.Add(b.cooldown + time.Minute)) {
+		t.Error("a failed trial poll did not start a new cooldown")
+	}
+
+	if !b.success() {
+		t.Error("success() did not report the breaker was open")
+	}
+	if st := b.status(); st != (breakerStatus{State: breakerClosed}) {
+		t.Errorf("status after success = %+v, want closed", st)
+	}
+	if !b.allow(now) || b.success() {
+		t.Error("a closed breaker blocked a poll or reported being open")
+	}
+}
+
+func TestPollBreakerWithoutThresholdNeverOpens(t *testing.T) {
+	b := &pollBreaker{cooldown: 10 * time.Minute}
+	now := time.Now()
+	for i := 0; i < 40; i++ {
+		if b.failure(now, errors.New("down")) {
+			t.Fatalf("failure %d opened a breaker with no threshold", i+1)
+		}
+	}
+	if st := b.status(); st.State != breakerBackoff {
+		t.Errorf("status = %+v, want backoff", st)
+	}
+	if !b.allow(now.Add(b.cooldown)) {
+		t.Error("backoff waited longer than the cooldown")
+	}
+}
+
+func TestBreakerPausesAndResumesPolling(t *testing.T) {
+	hook := newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, hook.URL)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.breakerThreshold = 2
+		cfg.Settings.breakerCooldown = 10 * time.Minute
+	})
+	// rewind lets the next poll run as if its wait had passed.
+	rewind := func() {
+		te.breaker.mu.Lock()
+		te.breaker.retryAt = time.Time{}
+		te.breaker.mu.Unlock()
+	}
+	if err := os.Remove(te.deposits); err != nil {
+		t.Fatal(err)
+	}
+
+	te.poll()
+	if st := te.status().Polling; st.State != breakerBackoff || st.ConsecutiveFailures != 1 {
+		t.Fatalf("after a failed fetch: polling = %+v, want backoff", st)
+	}
+	te.poll()
+	if n := te.status().Polling.ConsecutiveFailures; n != 1 {
+		t.Fatalf("a backed-off poll fetched anyway: %d failures", n)
+	}
+
+	rewind()
+	te.poll()
+	if st := te.status().Polling; st.State != breakerOpen {
+		t.Fatalf("after 2 failed fetches: polling = %+v, want open", st)
+	}
+
+	rewind()
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: testTxHash(1)})
+	te.poll()
+	if st := te.status().Polling; st.State != breakerClosed {
+		t.Errorf("after a good fetch: polling = %+v, want closed", st)
+	}
+	if !te.state.IsProcessed(testTxHash(1)) {
+		t.Error("the deposit was not minted once polling resumed")
+	}
+
+	var notices []string
+	for _, p := range hook.received() {
+		if content, _ := p["content"].(string); content != "" {
+			notices = append(notices, content)
+		}
+	}
+	if len(notices) != 2 || !strings.Contains(notices[0], "paused polling") || !strings.Contains(notices[1], "resumed polling") {
+		t.Errorf("webhook notices = %q, want one pause then one resume", notices)
+	}
+}
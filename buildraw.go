@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Build modes for -build-mode.
+const (
+	buildAuto = "auto" // `transaction build`: the node computes fee and change
+	buildRaw  = "raw"  // `transaction build-raw`: flowmass computes them
+)
+
+// rawMinChange is the smallest change output raw mode will produce; below
+// it the ledger would reject the output.
+const rawMinChange = 1_000_000
+
+// checkBuildMode validates a -build-mode value; empty means auto.
+func checkBuildMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return buildAuto, nil
+	case buildAuto, buildRaw:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid -build-mode %q (want auto or raw)", mode)
+}
+
+// buildTx builds a transaction into txFile. body carries the inputs, mint,
+// scripts, outputs, metadata and validity interval; outLovelace is the
+// lovelace its --tx-out entries pay, and the rest of the inputs (less the
+// fee) goes to changeAddr. witnesses is the number of key witnesses the fee
+// must cover.
+func (cli cardanoCLI) buildTx(what string, body []string, ins []string, outLovelace uint64, changeAddr string, witnesses int, txFile string) error {
+	if cli.buildMode == buildRaw {
+		return cli.buildTxRaw(what, body, ins, outLovelace, changeAddr, witnesses, txFile)
+	}
+
+	args := append([]string{cli.era, "transaction", "build"}, body...)
+	args = append(args,
+		"--change-address", changeAddr,
+		"--witness-override", strconv.Itoa(witnesses),
+		"--out-file", txFile,
+	)
+	netArgsWithSocket, err := socketAndNetArgs(cli.network, cli.testnetMagic)
+	if err != nil {
+		return err
+	}
+	args = append(args, netArgsWithSocket...)
+	cardanoLog.Debug("building transaction", "what", what, "args", args)
+
+	if output, err := exec.Command("cardano-cli", args...).CombinedOutput(); err != nil {
+		return buildError(what, err, output)
+	}
+	return nil
+}
+
+// buildError describes a failed cardano-cli build. A rejection of the
+// --metadata-json-file content wraps errMetadataInvalid, since the same
+// metadata fails the same way on every retry.
+func buildError(what string, err error, output []byte) error {
+	if strings.Contains(strings.ToLower(string(output)), "metadata") {
+		return fmt.Errorf("failed to build %s: %w: %v (output: %s)", what, errMetadataInvalid, err, string(output))
+	}
+	return fmt.Errorf("failed to build %s: %w (output: %s)", what, err, string(output))
+}
+
+// buildTxRaw balances a transaction by hand: a draft with a zero fee is
+// sized by calculate-min-fee, then rebuilt with that fee and the change.
+func (cli cardanoCLI) buildTxRaw(what string, body []string, ins []string, outLovelace uint64, changeAddr string, witnesses int, txFile string) error {
+	if err := checkRawScriptArgs(what, body); err != nil {
+		return err
+	}
+	inLovelace, err := cli.inputLovelace(ins)
+	if err != nil {
+		return err
+	}
+	paramsFile, err := cli.protocolParams()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if paramsFile != cli.protocolParamsFile {
+			cli.cleanupTemp(paramsFile)
+		}
+	}()
+
+	draftFile, err := cli.tempPath("draft-*.raw")
+	if err != nil {
+		return err
+	}
+	defer cli.cleanupTemp(draftFile)
+	if err := cli.buildRawBody(what, body, changeAddr, 0, 0, draftFile); err != nil {
+		return err
+	}
+	fee, err := cli.calculateMinFee(draftFile, paramsFile, len(ins), strings.Count(strings.Join(body, " "), "--tx-out")+1, witnesses)
+	if err != nil {
+		return err
+	}
+	if inLovelace < outLovelace+fee+rawMinChange {
+		return fmt.Errorf("failed to build %s: inputs hold %d lovelace, outputs and fee need %d plus %d change", what, inLovelace, outLovelace+fee, rawMinChange)
+	}
+	cardanoLog.Debug("balanced raw transaction", "what", what, "inputs", inLovelace, "outputs", outLovelace, "fee", fee, "witnesses", witnesses)
+	if err := cli.buildRawBody(what, body, changeAddr, inLovelace-outLovelace-fee, fee, txFile); err != nil {
+		return err
+	}
+	cli.budgets.record(txFile, witnesses)
+	return nil
+}
+
+// buildRawBody runs `transaction build-raw` with the change output and fee.
+func (cli cardanoCLI) buildRawBody(what string, body []string, changeAddr string, change, fee uint64, txFile string) error {
+	args := append([]string{cli.era, "transaction", "build-raw"}, body...)
+	args = append(args,
+		"--tx-out", fmt.Sprintf("%s+%d", changeAddr, change),
+		"--fee", strconv.FormatUint(fee, 10),
+		"--out-file", txFile,
+	)
+	cardanoLog.Debug("building raw transaction", "what", what, "args", args)
+	if output, err := exec.Command("cardano-cli", args...).CombinedOutput(); err != nil {
+		return buildError(what, err, output)
+	}
+	return nil
+}
+
+// checkRawScriptArgs refuses a raw body that mints without a native script.
+// `transaction build` reports a missing script itself; build-raw builds the
+// body anyway and the ledger rejects it only at submission.
+func checkRawScriptArgs(what string, body []string) error {
+	var mints, script bool
+	for _, a := range body {
+		switch a {
+		case "--mint":
+			mints = true
+		case "--minting-script-file", "--mint-script-file":
+			script = true
+		}
+	}
+	if mints && !script {
+		return fmt.Errorf("failed to build %s: raw transaction mints without --minting-script-file", what)
+	}
+	return nil
+}
+
+// witnessBudgets holds, per raw transaction file, the key witnesses its fee
+// was sized for.
+type witnessBudgets struct {
+	mu     sync.Mutex
+	byFile map[string]int
+}
+
+// record remembers the witness budget of txFile.
+func (b *witnessBudgets) record(txFile string, witnesses int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.byFile[txFile] = witnesses
+}
+
+// take returns and forgets the witness budget of txFile, or false if it
+// was not built raw here.
+func (b *witnessBudgets) take(txFile string) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.byFile[txFile]
+	delete(b.byFile, txFile)
+	return n, ok
+}
+
+// knownUTxOs holds the latest UTxO query result for each address.
+type knownUTxOs struct {
+	mu     sync.Mutex
+	byAddr map[string][]UTxO
+}
+
+// record remembers the UTxOs a query for address returned, replacing the
+// previous result.
+func (k *knownUTxOs) record(address string, utxos []UTxO) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.byAddr[address] = utxos
+}
+
+// lookup returns the UTxOs with the given ids, or false if any is unknown.
+func (k *knownUTxOs) lookup(ids []string) ([]UTxO, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	byID := make(map[string]UTxO)
+	for _, utxos := range k.byAddr {
+		for _, u := range utxos {
+			byID[u.ID] = u
+		}
+	}
+	utxos := make([]UTxO, 0, len(ids))
+	for _, id := range ids {
+		u, ok := byID[id]
+		if !ok {
+			return nil, false
+		}
+		utxos = append(utxos, u)
+	}
+	return utxos, true
+}
+
+// inputLovelace sums the lovelace of the given UTxOs: from the query the
+// inputs were selected from, or else looked up on the node. Raw mode only
+// balances lovelace, so inputs carrying tokens are refused.
+func (cli cardanoCLI) inputLovelace(ins []string) (uint64, error) {
+	utxos, ok := cli.known.lookup(ins)
+	if !ok {
+		var err error
+		if utxos, err = cli.queryInputs(ins); err != nil {
+			return 0, err
+		}
+	}
+	var sum uint64
+	for _, u := range utxos {
+		if len(u.Assets) > 0 {
+			return 0, fmt.Errorf("input %s carries native assets; -build-mode raw only balances lovelace", u.ID)
+		}
+		sum += u.Lovelace
+	}
+	return sum, nil
+}
+
+// queryInputs looks up the given UTxOs on the node.
+func (cli cardanoCLI) queryInputs(ins []string) ([]UTxO, error) {
+	utxoFile, err := cli.tempPath("inputs-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer cli.cleanupTemp(utxoFile)
+	args := []string{"query", "utxo", "--out-file", utxoFile}
+	for _, in := range ins {
+		args = append(args, "--tx-in", in)
+	}
+	netArgsWithSocket, err := socketAndNetArgs(cli.network, cli.testnetMagic)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := runCardanoQuery("query inputs", append(args, netArgsWithSocket...)); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(utxoFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read utxos file: %w", err)
+	}
+	utxos, err := decodeUTxOs(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(utxos) != len(ins) {
+		return nil, fmt.Errorf("only %d of %d inputs are unspent", len(utxos), len(ins))
+	}
+	return utxos, nil
+}
+
+// protocolParams returns a file holding the protocol parameters: the
+// -protocol-params-file, or else a temp file queried from the node, which
+// the caller removes.
+func (cli cardanoCLI) protocolParams() (string, error) {
+	if cli.protocolParamsFile != "" {
+		return cli.protocolParamsFile, nil
+	}
+	paramsFile, err := cli.tempPath("protocol-params-*.json")
+	if err != nil {
+		return "", err
+	}
+	if err := queryProtocolParamsFile(paramsFile, cli.network, cli.testnetMagic); err != nil {
+		cli.cleanupTemp(paramsFile)
+		return "", err
+	}
+	return paramsFile, nil
+}
+
+// queryProtocolParamsFile writes the node's protocol parameters to file.
+func queryProtocolParamsFile(file, network, testnetMagic string) error {
+	args := []string{"query", "protocol-parameters", "--out-file", file}
+	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
+	if err != nil {
+		return err
+	}
+	_, err = runCardanoQuery("query protocol parameters", append(args, netArgsWithSocket...))
+	return err
+}
+
+// lovelaceRe finds the amount in calculate-min-fee output, which is
+// "180109 Lovelace" or, in newer releases, {"fee": 180109}.
+var lovelaceRe = regexp.MustCompile(`\d+`)
+
+// calculateMinFee runs `transaction calculate-min-fee` on a draft body.
+// Releases before 9.x also want the input and output counts and the
+// network; the call is retried in that form if the short one fails.
+func (cli cardanoCLI) calculateMinFee(draftFile, paramsFile string, txIns, txOuts, witnesses int) (uint64, error) {
+	args := []string{cli.era, "transaction", "calculate-min-fee",
+		"--tx-body-file", draftFile,
+		"--protocol-params-file", paramsFile,
+		"--witness-count", strconv.Itoa(witnesses),
+	}
+	out, err := exec.Command("cardano-cli", args...).CombinedOutput()
+	if err != nil {
+		legacy := append(args,
+			"--tx-in-count", strconv.Itoa(txIns),
+			"--tx-out-count", strconv.Itoa(txOuts),
+			"--byron-witness-count", "0",
+		)
+		legacy = append(legacy, netArgs(cli.network, cli.testnetMagic)...)
+		if out, err = exec.Command("cardano-cli", legacy...).CombinedOutput(); err != nil {
+			return 0, fmt.Errorf("failed to calculate fee: %w (output: %s)", err, strings.TrimSpace(string(out)))
+		}
+	}
+	m := lovelaceRe.FindString(string(out))
+	if m == "" {
+		return 0, fmt.Errorf("unexpected calculate-min-fee output: %s", strings.TrimSpace(string(out)))
+	}
+	return strconv.ParseUint(m, 10, 64)
+}
+
+// checkRawWitnesses checks a signed raw transaction before it is submitted.
+// build-raw is told the witness count rather than working it out, so a
+// transaction carrying more key witnesses than the fee was sized for
+// (keys reported for a native multisig script) would be rejected as
+// FeeTooSmall; and a mint whose script did not make it into the witness set
+// would fail the ledger's script check. budget is the number of key
+// witnesses the build sized the fee for (see Engine.witnessCount); 0 skips
+// that check.
+func checkRawWitnesses(signedFile string, budget int) error {
+	data, err := ioutil.ReadFile(signedFile)
+	if err != nil {
+		return fmt.Errorf("failed to read signed transaction: %w", err)
+	}
+	var envelope struct {
+		CborHex string `json:"cborHex"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.CborHex == "" {
+		return fmt.Errorf("%s is not a transaction envelope", signedFile)
+	}
+	raw, err := hex.DecodeString(envelope.CborHex)
+	if err != nil {
+		return fmt.Errorf("invalid cborHex in %s: %v", signedFile, err)
+	}
+	mints, keys, scripts, err := txWitnessSummary(raw)
+	if err != nil {
+		return fmt.Errorf("cannot read witnesses of %s: %v", signedFile, err)
+	}
+	if budget > 0 && keys > budget {
+		return fmt.Errorf("signed transaction has %d key witnesses but its fee covers %d", keys, budget)
+	}
+	if mints && scripts == 0 {
+		return fmt.Errorf("signed transaction mints but carries no native script witness")
+	}
+	return nil
+}
+
+// txWitnessSummary reads a transaction, [body, witness set, ...], and
+// reports whether the body mints (key 9) and how many key witnesses
+// (witness set key 0) and native scripts (key 1) it carries.
+func txWitnessSummary(tx []byte) (mints bool, keys, scripts int, err error) {
+	r := &cborReader{b: tx}
+	major, n, err := r.head()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if major != 4 || n < 2 {
+		return false, 0, 0, errors.New("not a transaction")
+	}
+	bodyKeys, err := r.mapKeys(nil)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	mints = bodyKeys[9]
+	counts := make(map[uint64]int)
+	if _, err := r.mapKeys(counts); err != nil {
+		return false, 0, 0, err
+	}
+	return mints, counts[0], counts[1], nil
+}
+
+// cborReader walks just enough CBOR to read a transaction's shape.
+type cborReader struct {
+	b   []byte
+	pos int
+}
+
+// cborIndefinite is the argument head returns for indefinite lengths.
+const cborIndefinite = ^uint64(0)
+
+// head reads an item's major type and argument, skipping tags.
+func (r *cborReader) head() (byte, uint64, error) {
+	for {
+		if r.pos >= len(r.b) {
+			return 0, 0, errors.New("truncated cbor")
+		}
+		ib := r.b[r.pos]
+		r.pos++
+		major, info := ib>>5, ib&0x1f
+		var n uint64
+		switch {
+		case info < 24:
+			n = uint64(info)
+		case info <= 27:
+			size := 1 << (info - 24)
+			if r.pos+size > len(r.b) {
+				return 0, 0, errors.New("truncated cbor")
+			}
+			for _, c := range r.b[r.pos : r.pos+size] {
+				n = n<<8 | uint64(c)
+			}
+			r.pos += size
+		case info == 31:
+			n = cborIndefinite
+		default:
+			return 0, 0, fmt.Errorf("invalid cbor byte %#x", ib)
+		}
+		if major == 6 {
+			continue
+		}
+		return major, n, nil
+	}
+}
+
+// skip reads past one item.
+func (r *cborReader) skip() error {
+	major, n, err := r.head()
+	if err != nil {
+		return err
+	}
+	switch major {
+	case 2, 3:
+		if n == cborIndefinite {
+			return r.skipUntilBreak()
+		}
+		if n > uint64(len(r.b)-r.pos) {
+			return errors.New("truncated cbor")
+		}
+		r.pos += int(n)
+	case 4, 5:
+		if n == cborIndefinite {
+			return r.skipUntilBreak()
+		}
+		if major == 5 {
+			n *= 2
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := r.skip(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipUntilBreak skips the items of an indefinite-length item and its break.
+func (r *cborReader) skipUntilBreak() error {
+	for {
+		if r.pos >= len(r.b) {
+			return errors.New("truncated cbor")
+		}
+		if r.b[r.pos] == 0xff {
+			r.pos++
+			return nil
+		}
+		if err := r.skip(); err != nil {
+			return err
+		}
+	}
+}
+
+// mapKeys reads a map with unsigned integer keys and returns them. When
+// counts is given, it also records the length of each value that is an
+// array (a set, in the witness set's encoding).
+func (r *cborReader) mapKeys(counts map[uint64]int) (map[uint64]bool, error) {
+	major, n, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+	if major != 5 || n == cborIndefinite {
+		return nil, errors.New("expected a map")
+	}
+	keys := make(map[uint64]bool, n)
+	for i := uint64(0); i < n; i++ {
+		km, k, err := r.head()
+		if err != nil {
+			return nil, err
+		}
+		if km != 0 {
+			return nil, errors.New("expected an integer map key")
+		}
+		keys[k] = true
+		if counts != nil && r.pos < len(r.b) {
+			save := r.pos
+			if vm, vn, err := r.head(); err == nil && vm == 4 && vn != cborIndefinite {
+				counts[k] = int(vn)
+			}
+			r.pos = save
+		}
+		if err := r.skip(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rawMintInput is the wallet UTxO the build-raw tests spend.
+const rawMintInput = "0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f#0"
+
+// buildRawMint builds a native-script mint of Flowmass1 in raw mode on the
+// fake node, sized for two key witnesses, and returns the tx file.
+func buildRawMint(t *testing.T, node *fakeNode, cli cardanoCLI) string {
+	t.Helper()
+	node.setUTxOs(`{"` + rawMintInput + `": {"address": "` + testMonitorAddr(t) + `", "value": {"lovelace": 10000000}}}`)
+	hexName := hex.EncodeToString([]byte("Flowmass1"))
+	metadata, err := MetadataTemplate(testPolicyID, hexName, "A shark.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txFile, err := cli.BuildTransactionMultipleMints([]string{rawMintInput}, testMonitorAddr(t), testBuyer(t, 1), []string{hexName}, testPolicyID, "policy.script", metadata, 0, 139493917, Deposit{TxHash: testTxHash(1)}, 2, nil)
+	if err != nil {
+		t.Fatalf("BuildTransactionMultipleMints: %v", err)
+	}
+	return txFile
+}
+
+func TestBuildRawNativeMint(t *testing.T) {
+	node := newFakeNode(t)
+	cli := testCLI(t, buildRaw)
+	txFile := buildRawMint(t, node, cli)
+
+	var builds [][]string
+	for _, args := range node.calls() {
+		if len(args) > 2 && args[1] == "transaction" && args[2] == "build-raw" {
+			builds = append(builds, args)
+		}
+	}
+	if len(builds) != 2 {
+		t.Fatalf("got %d build-raw calls, want a draft and the final body", len(builds))
+	}
+	// The temp metadata file is named at random.
+	final := append([]string(nil), builds[1]...)
+	if flagValue(final, "--metadata-json-file") == "" {
+		t.Fatalf("final build has no metadata file: %q", final)
+	}
+	for i := range final {
+		if i > 0 && final[i-1] == "--metadata-json-file" {
+			final[i] = "<metadata>"
+		}
+	}
+	spec := "1 " + testPolicyID + ".466c6f776d61737331"
+	want := []string{
+		defaultEra, "transaction", "build-raw",
+		"--tx-in", rawMintInput,
+		"--mint", spec,
+		"--tx-out", testBuyer(t, 1) + "+1138760+" + spec,
+		"--minting-script-file", "policy.script",
+		"--invalid-hereafter", "139493917",
+		"--metadata-json-file", "<metadata>",
+		"--tx-out", testMonitorAddr(t) + "+8681131",
+		"--fee", "180109",
+		"--out-file", txFile,
+	}
+	if !reflect.DeepEqual(final, want) {
+		t.Errorf("final build-raw args =\n%q\nwant\n%q", final, want)
+	}
+	if got := flagValue(builds[0], "--fee"); got != "0" {
+		t.Errorf("draft --fee = %q, want 0", got)
+	}
+	fee, ok := node.call("transaction calculate-min-fee")
+	if !ok {
+		t.Fatal("the fee was not calculated")
+	}
+	if got := flagValue(fee, "--witness-count"); got != "2" {
+		t.Errorf("calculate-min-fee --witness-count = %q, want 2", got)
+	}
+}
+
+func TestSignRawChecksWitnesses(t *testing.T) {
+	tests := []struct {
+		fixture, wantErr string
+	}{
+		{fixture: "signed-native-mint.json"},
+		{fixture: "signed-over-budget.json", wantErr: "has 3 key witnesses but its fee covers 2"},
+		{fixture: "signed-no-script.json", wantErr: "carries no native script witness"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.respondFixture("signed.json", tt.fixture)
+			cli := testCLI(t, buildRaw)
+			txFile := buildRawMint(t, node, cli)
+
+			signed, err := cli.SignTransaction(txFile, []string{"payment.skey", "policy.skey"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SignTransaction() = %q, %v; want an error containing %q", signed, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignTransaction: %v", err)
+			}
+			sign, _ := node.call("transaction sign")
+			if got := flagValue(sign, "--tx-body-file"); got != txFile {
+				t.Errorf("signed %q, want the raw body %q", got, txFile)
+			}
+			txID, err := cli.TxID(signed)
+			if err != nil || txID != testTxID {
+				t.Errorf("TxID(signed) = %q, %v; want %q", txID, err, testTxID)
+			}
+		})
+	}
+}
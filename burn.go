@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// assetNameHex returns the hex on-chain name for assetName, which may be
+// given either as hex or as the display name (e.g. "Flowmass12").
+func assetNameHex(assetName string) string {
+	if b, err := hex.DecodeString(assetName); err == nil && len(b) > 0 {
+		return strings.ToLower(assetName)
+	}
+	return hex.EncodeToString([]byte(assetName))
+}
+
+// errNotHeld is returned by BurnNFTFrom when the address has no such token.
+var errNotHeld = errors.New("asset not held")
+
+// BurnNFT burns one unit of policyID.assetName held at the enterprise
+// address of signingKeyFile. Use BurnNFTFrom when the token sits at another
+// address controlled by the same key (e.g. a base address).
+func (cli cardanoCLI) BurnNFT(assetName, policyID, scriptFile, signingKeyFile string) (string, error) {
+	addr, err := cli.KeyAddress(signingKeyFile)
+	if err != nil {
+		return "", err
+	}
+	return cli.BurnNFTFrom(addr, assetName, policyID, scriptFile, []string{signingKeyFile})
+}
+
+// BurnNFTFrom builds, signs and submits a transaction that spends the UTxO
+// at address holding policyID.assetName and mints -1 of it. Any other value
+// in that UTxO returns to address as change. It fails before building if
+// the address does not hold the asset. signingKeyFiles must witness both the
+// input and the policy script.
+func (cli cardanoCLI) BurnNFTFrom(address, assetName, policyID, scriptFile string, signingKeyFiles []string) (string, error) {
+	nameHex := assetNameHex(assetName)
+	unit := policyID + "." + nameHex
+
+	utxos, _, err := cli.GetUTxOsWithAsset(address, unit)
+	if err != nil {
+		return "", err
+	}
+	holding := utxos[0].ID
+
+	slot, err := GetCurrentSlotNetwork(cli.network, cli.testnetMagic)
+	if err != nil {
+		return "", err
+	}
+	invalidBefore, invalidHereafter, err := scriptInterval(scriptFile, slot)
+	if err != nil {
+		return "", err
+	}
+
+	txFile, err := cli.tempPath("burn-" + nameHex + "-*.raw")
+	if err != nil {
+		return "", err
+	}
+	var signedFile string
+	defer func() { cli.cleanupTemp(txFile, signedFile) }()
+	args := []string{
+		cli.era, "transaction", "build",
+		"--tx-in", holding,
+		"--mint", fmt.Sprintf("-1 %s", unit),
+		"--minting-script-file", scriptFile,
+		"--change-address", address,
+		"--witness-override", strconv.Itoa(len(signingKeyFiles)),
+		"--out-file", txFile,
+	}
+	args = append(args, validityArgs(invalidBefore, invalidHereafter)...)
+	netArgsWithSocket, err := socketAndNetArgs(cli.network, cli.testnetMagic)
+	if err != nil {
+		return "", err
+	}
+	args = append(args, netArgsWithSocket...)
+	cardanoLog.Debug("building burn transaction", "args", args)
+
+	if output, err := exec.Command("cardano-cli", args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build burn transaction: %w (output: %s)", err, string(output))
+	}
+
+	signedFile, err = cli.SignTransaction(txFile, signingKeyFiles)
+	if err != nil {
+		return "", err
+	}
+	return cli.submit.retrySubmit(func() (string, error) {
+		return cli.SubmitTransaction(signedFile)
+	})
+}
+
+// verificationKeyFile derives the verification key of a signing key into a
+// temp file; the caller removes it with cleanupTemp.
+func (cli cardanoCLI) verificationKeyFile(signingKeyFile string) (string, error) {
+	vkeyFile, err := cli.tempPath("derived-*.vkey")
+	if err != nil {
+		return "", err
+	}
+	if err := cli.signer.VerificationKey(cli, signingKeyFile, vkeyFile); err != nil {
+		cli.cleanupTemp(vkeyFile)
+		return "", err
+	}
+	return vkeyFile, nil
+}
+
+// KeyAddress returns the enterprise address of a payment signing key.
+func (cli cardanoCLI) KeyAddress(signingKeyFile string) (string, error) {
+	vkeyFile, err := cli.verificationKeyFile(signingKeyFile)
+	if err != nil {
+		return "", err
+	}
+	defer cli.cleanupTemp(vkeyFile)
+
+	args := []string{cli.era, "address", "build", "--payment-verification-key-file", vkeyFile}
+	args = append(args, netArgs(cli.network, cli.testnetMagic)...)
+	out, err := exec.Command("cardano-cli", args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to build address: %w (output: %s)", err, string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runBurn implements `flowmass burn [<asset>]`: a one-off burn of a token
+// the wallet still holds. Burns are irreversible, so nothing is submitted
+// without -yes.
+func runBurn(args []string) error {
+	fs := flag.NewFlagSet("burn", flag.ExitOnError)
+	asset := fs.String("asset", "", "Asset name to burn, as display name (Flowmass12) or hex; may also be given as the argument")
+	address := fs.String("address", "", "Address holding the token (default: the signing key's enterprise address)")
+	policyID := fs.String("policy-id", os.Getenv("POLICY_ID"), "NFT minting policy ID")
+	scriptFile := fs.String("script", os.Getenv("SCRIPT_FILE"), "Path to minting script file")
+	var signingKeyFiles stringList
+	fs.Var(&signingKeyFiles, "signing-key", "Path to a signing key witnessing the input and policy; repeat for multisig policies (default: SIGNING_KEY_FILE)")
+	network := fs.String("network", envOr("CARDANO_NETWORK", "mainnet"), "Cardano network: mainnet or preprod")
+	testnetMagic := fs.String("testnet-magic", envOr("TESTNET_MAGIC", "1"), "Testnet magic number for preprod")
+	era := fs.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era: babbage or conway")
+	workDirFlag := fs.String("work-dir", envOr("WORK_DIR", os.TempDir()), "Directory for the burn transaction files")
+	keepTempFlag := fs.Bool("keep-temp", false, "Keep the burn transaction files after submitting")
+	confirm := fs.Bool("yes", false, "Confirm the burn (it cannot be undone)")
+	fs.Parse(args)
+	if *asset == "" {
+		*asset = fs.Arg(0)
+	}
+	work, err := newWorkDir(*workDirFlag, *keepTempFlag)
+	if err != nil {
+		return err
+	}
+	cli, err := newCardanoCLI(*network, *testnetMagic, *era, buildAuto, "", work, fileSigner{}, defaultSubmitRetry)
+	if err != nil {
+		return err
+	}
+	if len(signingKeyFiles) == 0 {
+		signingKeyFiles.Set(os.Getenv("SIGNING_KEY_FILE"))
+	}
+
+	if *asset == "" || *policyID == "" || *scriptFile == "" || len(signingKeyFiles) == 0 {
+		return fmt.Errorf("burn requires -asset, -policy-id, -script and -signing-key")
+	}
+	for _, keyFile := range signingKeyFiles {
+		if _, err := cli.signer.Validate(keyFile); err != nil {
+			return err
+		}
+	}
+	if *address == "" {
+		addr, err := cli.KeyAddress(signingKeyFiles[0])
+		if err != nil {
+			return err
+		}
+		*address = addr
+	}
+	if err := ValidateAddress(*address, *network); err != nil {
+		return err
+	}
+	unit := *policyID + "." + assetNameHex(*asset)
+	if !*confirm {
+		cmdLog.Info("would burn 1 token; re-run with -yes to submit", "unit", unit, "address", *address)
+		return nil
+	}
+	txHash, err := cli.BurnNFTFrom(*address, *asset, *policyID, *scriptFile, signingKeyFiles)
+	if err != nil {
+		return err
+	}
+	cmdLog.Info("burned 1 token", "unit", unit, "tx_hash", txHash)
+	return nil
+}
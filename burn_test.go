@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAssetNameHex(t *testing.T) {
+	for name, want := range map[string]string{
+		"Flowmass12":         "466c6f776d6173733132",
+		"466C6F776D61737331": "466c6f776d61737331",
+		"":                   "",
+	} {
+		if got := assetNameHex(name); got != want {
+			t.Errorf("assetNameHex(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestBurnNFTFrom(t *testing.T) {
+	node := newFakeNode(t)
+	cli := testCLI(t, buildAuto)
+	holder := testBuyer(t, 1)
+	script := filepath.Join(t.TempDir(), "policy.script")
+	writeFile(t, script, `{"type": "sig", "keyHash": "`+testKeyHash+`"}`)
+	keys := []string{filepath.Join("testdata", "keys", "payment.skey")}
+	holding := testTxHash(1) + "#0"
+	node.setUTxOs(fmt.Sprintf(`{
+		%q: {"address": %q, "value": {"lovelace": 1500000, %q: {"466c6f776d6173733132": 1}}},
+		%q: {"address": %q, "value": {"lovelace": 20000000}}
+	}`, holding, holder, testPolicyID, testTxHash(2)+"#1", holder))
+
+	txHash, err := cli.BurnNFTFrom(holder, "Flowmass12", testPolicyID, script, keys)
+	if err != nil {
+		t.Fatalf("BurnNFTFrom: %v", err)
+	}
+	if txHash != testTxID {
+		t.Errorf("tx hash = %s, want %s", txHash, testTxID)
+	}
+	build, ok := node.call("transaction build")
+	if !ok {
+		t.Fatal("no transaction was built")
+	}
+	for flag, want := range map[string]string{
+		"--tx-in":               holding,
+		"--mint":                "-1 " + testPolicyID + ".466c6f776d6173733132",
+		"--minting-script-file": script,
+		"--change-address":      holder,
+		"--witness-override":    "1",
+		"--invalid-hereafter":   fmt.Sprint(139483917 + defaultTTLSlots),
+	} {
+		if got := flagValue(build, flag); got != want {
+			t.Errorf("build %s = %q, want %q", flag, got, want)
+		}
+	}
+	if _, ok := node.call("transaction submit"); !ok {
+		t.Error("the burn was not submitted")
+	}
+	sign, _ := node.call("transaction sign")
+	if !slices.Contains(sign, keys[0]) {
+		t.Errorf("signed with %v, want %s", sign, keys[0])
+	}
+}
+
+func TestBurnNFTFromNotHeld(t *testing.T) {
+	node := newFakeNode(t)
+	cli := testCLI(t, buildAuto)
+	holder := testBuyer(t, 1)
+	node.setUTxOs(fmt.Sprintf(`{%q: {"address": %q, "value": {"lovelace": 20000000}}}`, testTxHash(2)+"#1", holder))
+
+	_, err := cli.BurnNFTFrom(holder, "Flowmass12", testPolicyID, "policy.script", []string{"payment.skey"})
+	if !errors.Is(err, errNotHeld) {
+		t.Fatalf("BurnNFTFrom() error = %v, want errNotHeld", err)
+	}
+	if _, ok := node.call("transaction build"); ok {
+		t.Error("a burn was built for a token the address does not hold")
+	}
+}
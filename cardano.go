@@ -3,12 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -50,114 +50,73 @@ func GetCurrentSlotNetwork(network, testnetMagic string) (int64, error) {
 	}
 	args = append(args, netArgsWithSocket...)
 
-	cmd := exec.Command("cardano-cli", args...)
-	out, err := cmd.CombinedOutput()
+	out, err := runCardanoQuery("query tip", args)
 	if err != nil {
-		return 0, fmt.Errorf("failed to query tip: %w", err)
+		return 0, err
 	}
 
 	var result struct {
-		Slot int64 `json:"slot"`
+		Slot *int64 `json:"slot"`
 	}
 	if err := json.Unmarshal(out, &result); err != nil {
 		return 0, fmt.Errorf("failed to parse slot from response: %w", err)
 	}
-
-	return result.Slot, nil
-}
-
-// BuildTransaction constructs a Cardano transaction with minting.
-func BuildTransaction(utxoIns []string, monitorAddr, recipientAddr, nftName, policyID, scriptFile string, invalidHereafter int64, network, testnetMagic string) (string, error) {
-	txFile := "/var/lib/flowmass/tx.raw"
-
-	// Prepare mint specification
-	mintSpec := fmt.Sprintf("1 %s.%s", policyID, nftName)
-	log.Printf("[cardano][mint-spec]: %s", mintSpec)
-
-	args := []string{
-		"conway", "transaction", "build",
-	}
-
-	// add all inputs
-	for _, in := range utxoIns {
-		args = append(args, "--tx-in", in)
+	if result.Slot == nil {
+		return 0, fmt.Errorf("query tip response has no slot: %s", strings.TrimSpace(string(out)))
 	}
 
-	// --tx-out addr1qyfy6z5q2c370kju53dtjw6qwwmlt7tdjscjj97zval0668ueyljyfjl4lh2pdynrfz4a6mu4xdjyetzmyezugud4epqak50kt+1400000+"1 1d0cf168b30d27c6619e7ca7c18e02c8cebc011bf056216a1ea829ff.466c6f776d6173732039"
-	// Build tx-out with min-ADA and the minted asset.
-	// Use a conservative min-ADA value for NFT outputs (1_400_000 lovelace)
-	minUtxo := uint64(1_400_000)
-	// Format: addr+minUtxo+"1 policyId.tokenName"
-	assetSpec := fmt.Sprintf("1 %s.%s", policyID, nftName)
-	txOut := fmt.Sprintf("%s+%d+%s", recipientAddr, minUtxo, assetSpec)
-	log.Printf("[cardano][tx-out]: %s", txOut)
+	return *result.Slot, nil
+}
 
-	metadata, err := MetadataTemplate(nftName)
+// BuildRefundTransaction builds a transaction that spends a single deposit
+// UTxO and returns its full value, less the fee, to refundAddr.
+func (cli cardanoCLI) BuildRefundTransaction(utxoIn, refundAddr string, invalidHereafter int64, witnesses int) (_ string, err error) {
+	txFile, err := cli.tempPath("refund-*.raw")
 	if err != nil {
-		return "", fmt.Errorf("failed to build metadata template: %w", err)
+		return "", err
 	}
-	metadataFile := fmt.Sprintf("/var/lib/flowmass/%s.json", nftName)
-	SaveMetadataToFile(metadata, metadataFile)
-
-	// Insert the NFT metadata under the correct policy ID and token name
+	defer cli.removeOnError(txFile, &err)
 
-	args = append(args,
-		"--mint", mintSpec,
-		"--minting-script-file", scriptFile,
-		"--tx-out", txOut,
+	args := []string{
+		"--tx-in", utxoIn,
 		"--invalid-hereafter", strconv.FormatInt(invalidHereafter, 10),
-		"--metadata-json-file", metadataFile,
-		"--change-address", monitorAddr,
-		"--witness-override", "1",
-		"--out-file", txFile,
-	)
-
-	// append network args + socket
-	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
-	if err != nil {
-		return "", err
 	}
-	args = append(args, netArgsWithSocket...)
-	log.Printf("[cardano][build][transaction] running cardano-cli with args: %v", args)
-
-	cmd := exec.Command("cardano-cli", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("failed to build transaction: %w (output: %s)", err, string(output))
+	if err := cli.buildTx("refund transaction", args, []string{utxoIn}, 0, refundAddr, witnesses, txFile); err != nil {
+		return "", err
 	}
 
 	return txFile, nil
 }
 
-// SignTransaction signs a transaction.
-func SignTransaction(txFile, signingKeyFile, network, testnetMagic string) (string, error) {
-	signedFile := strings.TrimSuffix(txFile, filepath.Ext(txFile)) + ".signed"
-
-	args := []string{
-		"conway", "transaction", "sign",
-		"--tx-body-file", txFile,
-		"--signing-key-file", signingKeyFile,
-		"--out-file", signedFile,
+// SignTransaction signs a transaction with every key in signingKeyFiles,
+// e.g. the payment key plus the keys a multisig policy requires, using the
+// backend chosen by -signing-backend. In raw build mode the signed
+// transaction's witnesses are checked against the witness count its fee was
+// built for (see checkRawWitnesses), since build-raw does not count them
+// itself.
+func (cli cardanoCLI) SignTransaction(txFile string, signingKeyFiles []string) (string, error) {
+	if len(signingKeyFiles) == 0 {
+		return "", fmt.Errorf("no signing key configured")
 	}
-
-	// append network args + socket
-	netArgs := netArgs(network, testnetMagic)
-	args = append(args, netArgs...)
-
-	cmd := exec.Command("cardano-cli", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("failed to sign transaction: %w (output: %s)", err, string(output))
+	budget, _ := cli.budgets.take(txFile)
+	signedFile, err := cli.signer.Sign(cli, txFile, signingKeyFiles)
+	if err != nil || cli.buildMode != buildRaw {
+		return signedFile, err
+	}
+	if err := checkRawWitnesses(signedFile, budget); err != nil {
+		cli.cleanupTemp(signedFile)
+		return "", err
 	}
-
 	return signedFile, nil
 }
 
 // SubmitTransaction submits a signed transaction to the blockchain.
-func SubmitTransaction(signedFile, network, testnetMagic string) (string, error) {
+func (cli cardanoCLI) SubmitTransaction(signedFile string) (string, error) {
 	args := []string{
-		"conway", "transaction", "submit",
+		cli.era, "transaction", "submit",
 		"--tx-file", signedFile,
 	}
-	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
+	netArgsWithSocket, err := socketAndNetArgs(cli.network, cli.testnetMagic)
 	if err != nil {
 		return "", err
 	}
@@ -169,9 +128,32 @@ func SubmitTransaction(signedFile, network, testnetMagic string) (string, error)
 		return "", fmt.Errorf("failed to submit transaction: %w (output: %s)", err, string(out))
 	}
 
-	// Extract transaction hash from output
-	// Typical output: "Transaction successfully submitted."
+	// Output is only "Transaction successfully submitted."; derive the hash
+	// from the signed file instead.
+	output := strings.TrimSpace(string(out))
+	txID, err := cli.TxID(signedFile)
+	if err != nil {
+		cardanoLog.Warn("submitted but could not compute txid", "file", signedFile, "error", err)
+		return output, nil
+	}
+	return txID, nil
+}
+
+// TxID returns the transaction hash of a built or signed transaction file.
+func (cli cardanoCLI) TxID(txFile string) (string, error) {
+	cmd := exec.Command("cardano-cli", cli.era, "transaction", "txid", "--tx-file", txFile)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to compute txid: %w (output: %s)", err, string(out))
+	}
+	// Newer cardano-cli versions print JSON ({"txhash": "..."}); older print the bare hash.
 	output := strings.TrimSpace(string(out))
+	var parsed struct {
+		TxHash string `json:"txhash"`
+	}
+	if err := json.Unmarshal([]byte(output), &parsed); err == nil && parsed.TxHash != "" {
+		return parsed.TxHash, nil
+	}
 	return output, nil
 }
 
@@ -182,24 +164,34 @@ type UTxO struct {
 	Assets   map[string]uint64 // non-lovelace assets (policyid.assetname -> quantity)
 }
 
+// policyIDHexLen is the length of a policy id in hex (a 28-byte hash).
+const policyIDHexLen = 56
+
+// errNoUTxOs is returned by GetUTxOs when the query succeeded but the
+// address holds nothing.
+var errNoUTxOs = errors.New("no UTxOs found")
+
 // GetUTxOs queries available UTxOs at an address.
-func GetUTxOs(address, network, testnetMagic string) ([]UTxO, error) {
-	utxoFile := "/tmp/utxos.json"
+func (cli cardanoCLI) GetUTxOs(address string) ([]UTxO, error) {
+	utxoFile, err := cli.tempPath("utxos-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer cli.cleanupTemp(utxoFile)
 
 	args := []string{
 		"query", "utxo",
 		"--address", address,
 		"--out-file", utxoFile,
 	}
-	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
+	netArgsWithSocket, err := socketAndNetArgs(cli.network, cli.testnetMagic)
 	if err != nil {
 		return nil, err
 	}
 	args = append(args, netArgsWithSocket...)
 
-	cmd := exec.Command("cardano-cli", args...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return nil, fmt.Errorf("failed to query utxos: %w (output: %s)", err, string(output))
+	if _, err := runCardanoQuery("query utxos", args); err != nil {
+		return nil, err
 	}
 
 	data, err := ioutil.ReadFile(utxoFile)
@@ -207,112 +199,194 @@ func GetUTxOs(address, network, testnetMagic string) ([]UTxO, error) {
 		return nil, fmt.Errorf("failed to read utxos file: %w", err)
 	}
 
+	result, err := decodeUTxOs(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w at address %s", errNoUTxOs, address)
+	}
+	cli.known.record(address, result)
+
+	return result, nil
+}
+
+// GetUTxOsWithAsset returns the UTxOs at address holding unit, given as
+// "policyid.assetname" or "policyidassetname" with the name in hex, and the
+// lovelace they carry between them. It fails with errNotHeld when none do.
+func (cli cardanoCLI) GetUTxOsWithAsset(address, unit string) ([]UTxO, uint64, error) {
+	utxos, err := cli.GetUTxOs(address)
+	return heldUTxOs(address, unit, utxos, err)
+}
+
+// heldUTxOs narrows the result of a UTxO query at address to the UTxOs
+// holding unit, for GetUTxOsWithAsset.
+func heldUTxOs(address, unit string, utxos []UTxO, err error) ([]UTxO, uint64, error) {
+	if err != nil && !errors.Is(err, errNoUTxOs) {
+		return nil, 0, err
+	}
+	matched, lovelace := utxosWithAsset(utxos, unit)
+	if len(matched) == 0 {
+		return nil, 0, fmt.Errorf("%s does not hold %s: %w", address, unit, errNotHeld)
+	}
+	return matched, lovelace, nil
+}
+
+// utxosWithAsset filters utxos to those holding unit, summing their lovelace.
+func utxosWithAsset(utxos []UTxO, unit string) ([]UTxO, uint64) {
+	unit = strings.ToLower(unit)
+	dotted := unit
+	if !strings.Contains(unit, ".") && len(unit) > policyIDHexLen {
+		dotted = unit[:policyIDHexLen] + "." + unit[policyIDHexLen:]
+	}
+	bare := strings.Replace(dotted, ".", "", 1)
+	var matched []UTxO
+	var lovelace uint64
+	for _, u := range utxos {
+		if u.Assets[dotted] > 0 || u.Assets[bare] > 0 {
+			matched = append(matched, u)
+			lovelace += u.Lovelace
+		}
+	}
+	return matched, lovelace
+}
+
+// decodeUTxOs parses `cardano-cli query utxo` JSON in either shape:
+//
+//	older:  {"txid#ix": [{"unit": "lovelace", "quantity": "5000000"}, ...]}
+//	newer:  {"txid#ix": {"address": "...", "value": {"lovelace": 5000000,
+//	         "<policy id>": {"<asset name hex>": 1}}}}
+//
+// Both produce the same UTxOs, with assets keyed "policyid.assetname".
+func decodeUTxOs(data []byte) ([]UTxO, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse utxos json: %w", err)
+	}
+
 	var result []UTxO
+	for id, entry := range raw {
+		var u UTxO
+		var err error
+		if trimmed := bytes.TrimSpace(entry); len(trimmed) > 0 && trimmed[0] == '[' {
+			u, err = decodeUTxOAmounts(id, entry)
+		} else {
+			u, err = decodeUTxOValue(id, entry)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
 
-	// Attempt old cardano-cli shape first: map[string][]{{unit,quantity}}
-	var rawOld map[string][]struct {
+// decodeUTxOAmounts decodes the older unit/quantity list shape.
+func decodeUTxOAmounts(id string, entry json.RawMessage) (UTxO, error) {
+	var amounts []struct {
 		Unit     string `json:"unit"`
 		Quantity string `json:"quantity"`
 	}
-	if err := json.Unmarshal(data, &rawOld); err == nil {
-		for k, amounts := range rawOld {
-			var lov uint64
-			assets := make(map[string]uint64)
-			for _, a := range amounts {
-				if a.Unit == "lovelace" {
-					fmt.Sscanf(a.Quantity, "%d", &lov)
-				} else {
-					if q, err := strconv.ParseUint(a.Quantity, 10, 64); err == nil {
-						assets[a.Unit] = q
-					} else {
-						assets[a.Unit] = 0
-					}
-				}
-			}
-			result = append(result, UTxO{ID: k, Lovelace: lov, Assets: assets})
+	if err := json.Unmarshal(entry, &amounts); err != nil {
+		return UTxO{}, fmt.Errorf("utxo %s: %w", id, err)
+	}
+	u := UTxO{ID: id, Assets: make(map[string]uint64)}
+	for _, a := range amounts {
+		q, err := parseQuantity(a.Quantity)
+		if err != nil {
+			return UTxO{}, fmt.Errorf("utxo %s: %s: %w", id, a.Unit, err)
+		}
+		if a.Unit == "lovelace" {
+			u.Lovelace = q
+		} else {
+			u.Assets[assetKey(a.Unit)] = q
 		}
 	}
+	return u, nil
+}
 
-	// If old shape produced nothing, try the newer cardano-cli JSON shape
-	if len(result) == 0 {
-		var generic map[string]map[string]interface{}
-		dec := json.NewDecoder(bytes.NewReader(data))
-		dec.UseNumber()
-		if err := dec.Decode(&generic); err != nil {
-			return nil, fmt.Errorf("failed to parse utxos json: %w", err)
-		}
+// assetKey normalizes an asset unit to "policyid.assetname". Units written
+// without the dot (policy id and asset name run together, as Blockfrost
+// does) are split after the 56-character policy id.
+func assetKey(unit string) string {
+	if strings.Contains(unit, ".") || len(unit) < 56 {
+		return unit
+	}
+	return unit[:56] + "." + unit[56:]
+}
 
-		for k, entry := range generic {
-			// entry should contain a `value` map
-			valueIface, ok := entry["value"]
-			if !ok {
-				// skip entries without value
-				continue
-			}
-			valueMap, ok := valueIface.(map[string]interface{})
-			if !ok {
-				continue
+// decodeUTxOValue decodes the newer address/value shape, where value maps
+// "lovelace" to a number and each policy id to its asset quantities.
+func decodeUTxOValue(id string, entry json.RawMessage) (UTxO, error) {
+	var out struct {
+		Address string                     `json:"address"`
+		Value   map[string]json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(entry, &out); err != nil {
+		return UTxO{}, fmt.Errorf("utxo %s: %w", id, err)
+	}
+	if out.Value == nil {
+		return UTxO{}, fmt.Errorf("utxo %s: missing value", id)
+	}
+	u := UTxO{ID: id, Assets: make(map[string]uint64)}
+	for unit, v := range out.Value {
+		if unit == "lovelace" {
+			q, err := parseQuantityJSON(v)
+			if err != nil {
+				return UTxO{}, fmt.Errorf("utxo %s: lovelace: %w", id, err)
 			}
-
-			var lov uint64
-			assets := make(map[string]uint64)
-
-			for unit, v := range valueMap {
-				if unit == "lovelace" {
-					// lovelace is always an integer; JSON decoder produced json.Number
-					switch vv := v.(type) {
-					case json.Number:
-						if parsed, perr := vv.Int64(); perr == nil {
-							lov = uint64(parsed)
-						}
-					case string:
-						if parsed, perr := strconv.ParseUint(vv, 10, 64); perr == nil {
-							lov = parsed
-						}
-					}
-					continue
-				}
-
-				// unit is a policy id; v should be a map of assetname->quantity
-				if inner, ok := v.(map[string]interface{}); ok {
-					for assetName, qtyIface := range inner {
-						var q uint64
-						switch qv := qtyIface.(type) {
-						case json.Number:
-							if parsed, perr := qv.Int64(); perr == nil {
-								q = uint64(parsed)
-							}
-						case string:
-							if parsed, err := strconv.ParseUint(qv, 10, 64); err == nil {
-								q = parsed
-							}
-						}
-						// key as policyid.assetname (assetName may already be hex)
-						key := unit + "." + assetName
-						assets[key] = q
-					}
-				}
+			u.Lovelace = q
+			continue
+		}
+		var assets map[string]json.RawMessage
+		if err := json.Unmarshal(v, &assets); err != nil {
+			return UTxO{}, fmt.Errorf("utxo %s: policy %s: %w", id, unit, err)
+		}
+		for assetName, qty := range assets {
+			q, err := parseQuantityJSON(qty)
+			if err != nil {
+				return UTxO{}, fmt.Errorf("utxo %s: %s.%s: %w", id, unit, assetName, err)
 			}
-
-			result = append(result, UTxO{ID: k, Lovelace: lov, Assets: assets})
+			u.Assets[unit+"."+assetName] = q
 		}
 	}
+	return u, nil
+}
 
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no UTxOs found at address %s", address)
+// parseQuantity parses a lovelace or token quantity. Unlike Sscanf it
+// rejects trailing garbage, signs and overflow instead of yielding a
+// partial or zero amount.
+func parseQuantity(s string) (uint64, error) {
+	q, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q: %w", s, err)
 	}
+	return q, nil
+}
 
-	return result, nil
+// parseQuantityJSON parses a quantity that cardano-cli emits as a JSON
+// number (or, in some versions, a string).
+func parseQuantityJSON(v json.RawMessage) (uint64, error) {
+	var n json.Number
+	if err := json.Unmarshal(v, &n); err != nil {
+		return 0, fmt.Errorf("invalid quantity %s", v)
+	}
+	return parseQuantity(n.String())
 }
 
 // BuildTransactionMultipleMints constructs a Cardano transaction with multiple minting.
-func BuildTransactionMultipleMints(utxoIns []string, monitorAddr, recipientAddr string, nftNames []string, policyID, scriptFile string, invalidHereafter int64, network, testnetMagic string, deposit Deposit) (string, error) {
+// hexNames are the hex-encoded asset names.
+// metadata is the rendered CIP-25 JSON covering every token.
+func (cli cardanoCLI) BuildTransactionMultipleMints(utxoIns []string, monitorAddr, recipientAddr string, hexNames []string, policyID, scriptFile, metadata string, invalidBefore, invalidHereafter int64, deposit Deposit, witnesses int, plutus *PlutusPolicy) (_ string, err error) {
 	{
-		txFile := "/var/lib/flowmass/tx.raw"
-
-		args := []string{
-			"conway", "transaction", "build",
+		txFile, err := cli.tempPath("mint-" + deposit.TxHash + "-*.raw")
+		if err != nil {
+			return "", err
 		}
+		defer cli.removeOnError(txFile, &err)
+
+		var args []string
 
 		// add all inputs
 		for _, in := range utxoIns {
@@ -320,76 +394,63 @@ func BuildTransactionMultipleMints(utxoIns []string, monitorAddr, recipientAddr
 		}
 
 		// Prepare mint specification
-		// combine all nftNames into mint specs string to add to args
+		// combine all hexNames into mint specs string to add to args
 		var mintSpecs []string
-		for _, nftName := range nftNames {
-			mintSpec := fmt.Sprintf("1 %s.%s", policyID, nftName)
-			mintSpecs = append(mintSpecs, mintSpec)
+		for _, hexName := range hexNames {
+			unit, err := assetUnit(policyID, hexName)
+			if err != nil {
+				return "", err
+			}
+			mintSpecs = append(mintSpecs, "1 "+unit)
 		}
 		mintSpecStr := strings.Join(mintSpecs, " + ")
 		args = append(args, "--mint", mintSpecStr)
 
 		// Build tx-out with min-ADA and the minted assets.
-		// Combine all nftNames into a single tx-out
+		// Combine all hexNames into a single tx-out
 		// Use a conservative min-ADA value for NFT outputs (1_400_000 lovelace)
-		var assetSpecs []string
-		for _, nftName := range nftNames {
-			assetSpec := fmt.Sprintf("1 %s.%s", policyID, nftName)
-			assetSpecs = append(assetSpecs, assetSpec)
-		}
-		assetSpecStr := strings.Join(assetSpecs, "+")
+		assetSpecStr := strings.Join(mintSpecs, "+")
 		txOut := fmt.Sprintf("%s+%d+%s", recipientAddr, 1_400_000, assetSpecStr)
 
-		minUtxo, err := CalculateMinUtxo(monitorAddr, txOut, network, testnetMagic)
+		minUtxo, err := cli.CalculateMinUtxo(monitorAddr, txOut)
 		if err != nil {
 			return "", fmt.Errorf("failed to calculate min utxo: %w", err)
 		}
 		txOut = fmt.Sprintf("%s+%d+%s", recipientAddr, minUtxo, assetSpecStr)
 		args = append(args, "--tx-out", txOut)
 
-		// Prepare metadata file combining all NFTs
-		combinedMetadata, err := MetadatasTemplate(nftNames)
+		metadataFile, err := cli.tempPath("metadata-" + deposit.TxHash + "-*.json")
 		if err != nil {
-			return "", fmt.Errorf("failed to build metadata template: %w", err)
+			return "", err
+		}
+		defer cli.cleanupTemp(metadataFile)
+		if err := SaveMetadataToFile(metadata, metadataFile); err != nil {
+			return "", fmt.Errorf("failed to write metadata file: %w", err)
 		}
 
-		metadataFile := fmt.Sprintf("/var/lib/flowmass/%s.json", deposit.TxHash)
-		SaveMetadataToFile(combinedMetadata, metadataFile)
-
-		args = append(args,
-			"--minting-script-file", scriptFile,
-			"--invalid-hereafter", strconv.FormatInt(invalidHereafter, 10),
-			"--metadata-json-file", metadataFile,
-			"--change-address", monitorAddr,
-			"--witness-override", strconv.Itoa(len(nftNames)),
-			"--out-file", txFile,
-		)
+		args = append(args, mintScriptArgs(scriptFile, plutus)...)
+		args = append(args, validityArgs(invalidBefore, invalidHereafter)...)
+		args = append(args, "--metadata-json-file", metadataFile)
 
-		// append network args + socket
-		netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
-		if err != nil {
+		if err := cli.buildTx("transaction", args, utxoIns, minUtxo, monitorAddr, witnesses, txFile); err != nil {
 			return "", err
 		}
-		args = append(args, netArgsWithSocket...)
-		log.Printf("[cardano][build][transaction] running cardano-cli with args: %v", args)
-
-		cmd := exec.Command("cardano-cli", args...)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return "", fmt.Errorf("failed to build transaction: %w (output: %s)", err, string(output))
-		}
 
 		return txFile, nil
 	}
 }
 
 // Create function calculate the min utxo for the given address and tx-outs
-func CalculateMinUtxo(address string, txOut string, network, testnetMagic string) (uint64, error) {
+func (cli cardanoCLI) CalculateMinUtxo(address string, txOut string) (uint64, error) {
 	args := []string{
-		"conway", "transaction", "calculate-min-required-utxo",
+		cli.era, "transaction", "calculate-min-required-utxo",
 	}
 
 	// Prepare the --protocol-params-file argument
 	protocolParamsFile := "/home/cardano/cardano/pparams.json"
+	if cli.protocolParamsFile != "" {
+		protocolParamsFile = cli.protocolParamsFile
+	}
 	args = append(args, "--protocol-params-file", protocolParamsFile)
 
 	// Add the --tx-out argument
@@ -400,7 +461,7 @@ func CalculateMinUtxo(address string, txOut string, network, testnetMagic string
 	if err != nil {
 		return 0, fmt.Errorf("failed to calculate min utxo: %w (output: %s)", err, string(out))
 	}
-	log.Printf("[cardano][min-utxo] output: %s", string(out))
+	cardanoLog.Debug("min utxo", "output", strings.TrimSpace(string(out)))
 
 	// Parse the output to get the min utxo value
 	// The output looks like "Coin 2685130" so we need to parse the number
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTxID is the hash the fake cardano-cli reports for every transaction.
+const testTxID = "7f3a9c6e2b1d4f5a8e0c9b7d6a5f4e3d2c1b0a9f8e7d6c5b4a39281706f5e4d3"
+
+// fakeNode is a cardano-cli stand-in that talks to no node: queries and
+// calculations answer from files in its directory, builds and signs write
+// placeholder files, and every call is logged for inspection.
+type fakeNode struct {
+	t   *testing.T
+	dir string
+}
+
+// newFakeNode puts the fake cardano-cli first in PATH with a node socket
+// set, a synced tip and an empty UTxO set.
+func newFakeNode(t *testing.T) *fakeNode {
+	t.Helper()
+	n := &fakeNode{t: t, dir: t.TempDir()}
+	n.respond("tip.json", `{"block": 10934567, "epoch": 512, "era": "Conway", "slot": 139483917, "syncProgress": "100.00"}`)
+	n.setUTxOs("{}")
+	n.respond("calculate-min-fee.out", "180109 Lovelace")
+	n.respond("calculate-min-required-utxo.out", "Coin 1138760")
+	n.respond("signed.json", `{"type": "Tx ConwayEra", "description": "fake", "cborHex": "84a0"}`)
+	t.Setenv("FAKE_CLI_DIR", n.dir)
+	t.Setenv("FAKE_CLI_TXID", testTxID)
+	t.Setenv("CARDANO_NODE_SOCKET_PATH", filepath.Join(n.dir, "node.socket"))
+	fakeCardanoCLI(t, `printf '%s\037' "$@" >> "$FAKE_CLI_DIR/calls.log"; echo >> "$FAKE_CLI_DIR/calls.log"
+out=""; prev=""
+for a in "$@"; do
+	[ "$prev" = "--out-file" ] && out="$a"
+	prev="$a"
+done
+case "$*" in
+*"query tip"*) cat "$FAKE_CLI_DIR/tip.json" ;;
+*"query utxo"*) cat "$FAKE_CLI_DIR/utxos.json" > "$out" ;;
+*"calculate-min-fee"*) cat "$FAKE_CLI_DIR/calculate-min-fee.out" ;;
+*"calculate-min-required-utxo"*) cat "$FAKE_CLI_DIR/calculate-min-required-utxo.out" ;;
+*"transaction sign"*) cat "$FAKE_CLI_DIR/signed.json" > "$out" ;;
+*"transaction txid"*) echo "{\"txhash\": \"$FAKE_CLI_TXID\"}" ;;
+*"transaction submit"*) echo "Transaction successfully submitted." ;;
+*) [ -n "$out" ] && echo '{"type": "Tx ConwayEra", "description": "fake", "cborHex": "84a0"}' > "$out" ;;
+esac
+exit 0
+`)
+	return n
+}
+
+// respond sets what the fake answers from file name: tip.json,
+// utxos.json, calculate-min-fee.out, calculate-min-required-utxo.out or
+// signed.json.
+func (n *fakeNode) respond(name, content string) {
+	writeFile(n.t, filepath.Join(n.dir, name), content)
+}
+
+// respondFixture answers from file name with testdata/cli/fixture.
+func (n *fakeNode) respondFixture(name, fixture string) {
+	n.t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "cli", fixture))
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	n.respond(name, string(data))
+}
+
+// setUTxOs sets the JSON every UTxO query returns.
+func (n *fakeNode) setUTxOs(utxos string) {
+	n.respond("utxos.json", utxos)
+}
+
+// calls returns the arguments of every cardano-cli call so far.
+func (n *fakeNode) calls() [][]string {
+	n.t.Helper()
+	data, err := os.ReadFile(filepath.Join(n.dir, "calls.log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	var calls [][]string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		calls = append(calls, strings.Split(strings.TrimSuffix(line, "\x1f"), "\x1f"))
+	}
+	return calls
+}
+
+// call returns the first logged call whose arguments contain every word of
+// command in order, e.g. "transaction build".
+func (n *fakeNode) call(command string) ([]string, bool) {
+	for _, args := range n.calls() {
+		if strings.Contains(" "+strings.Join(args, " ")+" ", " "+command+" ") {
+			return args, true
+		}
+	}
+	return nil, false
+}
+
+// flagValue returns the value following flag in args.
+func flagValue(args []string, flag string) string {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// testCLI returns mainnet cardano-cli settings using the fake node.
+func testCLI(t *testing.T, buildMode string) cardanoCLI {
+	t.Helper()
+	work, err := newWorkDir(t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := newCardanoCLI("mainnet", "", defaultEra, buildMode, "", work, fileSigner{}, submitRetry{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+func TestGetCurrentSlotNetwork(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    int64
+		wantErr string
+	}{
+		{fixture: "tip.json", want: 139483917},
+		{fixture: "tip-no-slot.json", wantErr: "has no slot"},
+		{fixture: "tip-slot-string.json", wantErr: "failed to parse slot"},
+		{fixture: "tip-truncated.json", wantErr: "failed to parse slot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.respondFixture("tip.json", tt.fixture)
+			got, err := GetCurrentSlotNetwork("mainnet", "")
+			checkDecoded(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestDecodeUTxOsMalformed(t *testing.T) {
+	tests := map[string]string{
+		"utxos-negative-lovelace.json": "invalid quantity",
+		"utxos-float-quantity.json":    "invalid quantity",
+		"utxos-overflow.json":          "invalid quantity",
+		"utxos-no-value.json":          "missing value",
+		"utxos-truncated.json":         "failed to parse utxos json",
+	}
+	for fixture, wantErr := range tests {
+		t.Run(fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "cli", fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			utxos, err := decodeUTxOs(data)
+			if err == nil || !strings.Contains(err.Error(), wantErr) {
+				t.Errorf("decodeUTxOs() = %v, %v; want an error containing %q", utxos, err, wantErr)
+			}
+		})
+	}
+}
+
+func TestCalculateMinFee(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    uint64
+		wantErr string
+	}{
+		{fixture: "fee-text.txt", want: 180109},
+		{fixture: "fee-json.json", want: 180109},
+		{fixture: "fee-garbage.txt", wantErr: "unexpected calculate-min-fee output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.respondFixture("calculate-min-fee.out", tt.fixture)
+			got, err := testCLI(t, buildRaw).calculateMinFee("draft.raw", "pparams.json", 2, 2, 1)
+			checkDecoded(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestCalculateMinUtxo(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    uint64
+		wantErr string
+	}{
+		{fixture: "min-utxo.txt", want: 1138760},
+		{fixture: "min-utxo-fraction.txt", wantErr: "invalid syntax"},
+		{fixture: "min-utxo-garbage.txt", wantErr: "unexpected min-utxo output"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.respondFixture("calculate-min-required-utxo.out", tt.fixture)
+			got, err := testCLI(t, buildAuto).CalculateMinUtxo(testBuyer(t, 1), testBuyer(t, 1)+"+1400000")
+			checkDecoded(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+// checkDecoded checks a decoded value against want, or its error against
+// wantErr when that is set.
+func checkDecoded[T comparable](t *testing.T, got T, err error, want T, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("got %v, %v; want an error containing %q", got, err, wantErr)
+		}
+		return
+	}
+	if err != nil || got != want {
+		t.Errorf("got %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestDecodeUTxOsShapes(t *testing.T) {
+	want := []UTxO{
+		{ID: "0f8b7a6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a#1", Lovelace: 27_000_000, Assets: map[string]uint64{}},
+		{ID: "9f1c0a6b3e5d7c2a4b8e6f0d1c3a5b7e9d2f4a6c8e0b1d3f5a7c9e2b4d6f8a0c#0", Lovelace: 1_500_000, Assets: map[string]uint64{
+			testPolicyID + ".466c6f776d61737331": 1,
+			testPolicyID + ".466c6f776d61737332": 1,
+		}},
+	}
+	for _, fixture := range []string{"utxos-amounts.json", "utxos-value.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "cli", fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := decodeUTxOs(data)
+			if err != nil {
+				t.Fatalf("decodeUTxOs: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("decodeUTxOs() =\n%+v\nwant\n%+v", got, want)
+			}
+			held, lovelace := utxosWithAsset(got, testPolicyID+"466c6f776d61737332")
+			if len(held) != 1 || lovelace != 1_500_000 {
+				t.Errorf("utxosWithAsset() = %v, %d; want the token's UTxO", held, lovelace)
+			}
+		})
+	}
+}
+
+func TestGetUTxOsWithAsset(t *testing.T) {
+	const (
+		flowmass1 = "0f8b7a6c5d4e3f2a1b0c9d8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a#1"
+		flowmass2 = "9f1c0a6b3e5d7c2a4b8e6f0d1c3a5b7e9d2f4a6c8e0b1d3f5a7c9e2b4d6f8a0c#2"
+	)
+	tests := []struct {
+		name, fixture, unit string
+		want                []string
+		wantLovelace        uint64
+	}{
+		{name: "dotted unit", fixture: "utxos-mixed.json", unit: testPolicyID + ".466c6f776d61737331", want: []string{flowmass1}, wantLovelace: 1_400_000},
+		{name: "bare unit", fixture: "utxos-mixed.json", unit: testPolicyID + "466c6f776d61737332", want: []string{flowmass2}, wantLovelace: 1_600_000},
+		{name: "upper-case unit", fixture: "utxos-mixed.json", unit: strings.ToUpper(testPolicyID + ".466c6f776d61737332"), want: []string{flowmass2}, wantLovelace: 1_600_000},
+		{name: "name under another policy", fixture: "utxos-mixed.json", unit: testPolicyID + ".466c6f776d61737333"},
+		{name: "token of another policy", fixture: "utxos-mixed.json", unit: strings.Repeat("ab", 28) + ".466c6f776d61737331"},
+		{name: "older shape", fixture: "utxos-amounts.json", unit: testPolicyID + ".466c6f776d61737332", want: []string{"9f1c0a6b3e5d7c2a4b8e6f0d1c3a5b7e9d2f4a6c8e0b1d3f5a7c9e2b4d6f8a0c#0"}, wantLovelace: 1_500_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := newFakeNode(t)
+			node.respondFixture("utxos.json", tt.fixture)
+			utxos, lovelace, err := testCLI(t, buildAuto).GetUTxOsWithAsset(testMonitorAddr(t), tt.unit)
+			if tt.want == nil {
+				if !errors.Is(err, errNotHeld) {
+					t.Fatalf("GetUTxOsWithAsset() = %v, %d, %v; want errNotHeld", utxos, lovelace, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetUTxOsWithAsset: %v", err)
+			}
+			var ids []string
+			for _, u := range utxos {
+				ids = append(ids, u.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) || lovelace != tt.wantLovelace {
+				t.Errorf("GetUTxOsWithAsset() = %v, %d; want %v, %d", ids, lovelace, tt.want, tt.wantLovelace)
+			}
+		})
+	}
+
+	// An empty address holds nothing rather than failing the query.
+	newFakeNode(t)
+	if _, _, err := testCLI(t, buildAuto).GetUTxOsWithAsset(testMonitorAddr(t), testPolicyID+".466c6f776d61737331"); !errors.Is(err, errNotHeld) {
+		t.Errorf("GetUTxOsWithAsset() on an empty address error = %v, want errNotHeld", err)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Metadata standards, chosen with -metadata-standard. CIP-25 attaches the
+// metadata to the mint transaction under label 721. CIP-68 mints a
+// (100) reference token, held at the reference address with the metadata as
+// its inline datum, and a (222) user token that goes to the buyer.
+const (
+	metadataCIP25 = "cip25"
+	metadataCIP68 = "cip68"
+)
+
+// CIP-67 asset name labels: the 4-byte prefixes of the (100) reference and
+// (222) user token names.
+const (
+	cip68RefLabel  = "000643b0"
+	cip68UserLabel = "000de140"
+)
+
+// cip68NameLimit is the longest token name that still fits the ledger's
+// 32 bytes behind a 4-byte label.
+const cip68NameLimit = assetNameLimit - 4
+
+// cip68Version is the datum version field; 1 is the NFT standard's first.
+const cip68Version = 1
+
+// coinsPerUTxOByte is the protocol's min-ADA rate, used to size the
+// reference output, whose inline datum makes it larger than an NFT output.
+const coinsPerUTxOByte = 4310
+
+// validateMetadataStandard checks a -metadata-standard value and, for
+// CIP-68, that token names from pattern leave room for the label.
+func validateMetadataStandard(standard, pattern string) error {
+	switch standard {
+	case metadataCIP25:
+		return nil
+	case metadataCIP68:
+		if long := fmt.Sprintf(pattern, 9_999_999); len(long) > cip68NameLimit {
+			return fmt.Errorf("asset name pattern %q gives names longer than the %d bytes CIP-68 allows (e.g. %q)", pattern, cip68NameLimit, long)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown metadata standard %q (want cip25 or cip68)", standard)
+	}
+}
+
+// validateRefAddr checks the address CIP-68 reference tokens go to. It must
+// be given explicitly and must not be a wallet the engine spends from (the
+// monitor, change or funding address), or a later transaction could pick
+// up a reference token and move or alter its metadata. A script address
+// nobody can spend from is best.
+func validateRefAddr(standard, refAddr, network string, hot ...string) error {
+	if standard != metadataCIP68 {
+		return nil
+	}
+	if refAddr == "" {
+		return fmt.Errorf("cip68 requires -reference-address, ideally a script address nobody can spend from")
+	}
+	if err := ValidateAddress(refAddr, network); err != nil {
+		return fmt.Errorf("reference address: %v", err)
+	}
+	if isScriptAddress(refAddr) {
+		return nil
+	}
+	cred, err := PaymentCredential(refAddr)
+	if err != nil {
+		return fmt.Errorf("reference address: %v", err)
+	}
+	for _, addr := range hot {
+		if addr == "" {
+			continue
+		}
+		if c, err := PaymentCredential(addr); err == nil && c == cred {
+			return fmt.Errorf("reference address %s shares its payment key with %s, which the engine spends from; use a separate or script address", refAddr, addr)
+		}
+	}
+	return nil
+}
+
+// cip68Datum converts the CIP-25 metadata rendered for hexName into a
+// CIP-68 datum, Constr 0 [metadata, version, extra], in cardano-cli's
+// detailed JSON schema. Templates, manifests and traits therefore work
+// unchanged in either standard. CIP-25 splits long strings into arrays of
+// 64-byte chunks; those are joined back, since datum bytes have no limit.
+func cip68Datum(metadata, policyID, hexName string) (string, error) {
+	var doc struct {
+		Policies map[string]map[string]json.RawMessage `json:"721"`
+	}
+	if err := json.Unmarshal([]byte(metadata), &doc); err != nil {
+		return "", fmt.Errorf("metadata is not valid JSON: %v", err)
+	}
+	entries := doc.Policies[policyID]
+	name, err := hex.DecodeString(hexName)
+	if err != nil {
+		return "", err
+	}
+	entry, ok := entries[string(name)]
+	if !ok {
+		if entry, ok = entries[hexName]; !ok {
+			return "", fmt.Errorf("metadata has no entry for asset %q under policy %s", name, policyID)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(entry))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return "", fmt.Errorf("metadata entry for %q is not an object: %v", name, err)
+	}
+	datum := map[string]any{
+		"constructor": 0,
+		"fields": []any{
+			plutusData(fields),
+			map[string]any{"int": cip68Version},
+			map[string]any{"constructor": 0, "fields": []any{}},
+		},
+	}
+	out, err := json.Marshal(datum)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// plutusData converts a decoded JSON value to Plutus data: strings become
+// UTF-8 bytes, integers ints, arrays lists and objects maps (keys sorted,
+// so the datum is deterministic). Arrays holding one string split into
+// CIP-25's 64-byte chunks are joined; other arrays, such as trait or tag
+// lists, stay lists.
+func plutusData(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]any, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, map[string]any{"k": plutusBytes(k), "v": plutusData(v[k])})
+		}
+		return map[string]any{"map": pairs}
+	case []any:
+		if s, ok := joinChunks(v); ok {
+			return plutusBytes(s)
+		}
+		list := make([]any, 0, len(v))
+		for _, item := range v {
+			list = append(list, plutusData(item))
+		}
+		return map[string]any{"list": list}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return map[string]any{"int": n}
+		}
+		return plutusBytes(v.String())
+	case string:
+		return plutusBytes(v)
+	default:
+		return plutusBytes(fmt.Sprint(v))
+	}
+}
+
+// joinChunks joins an array that is one string split into CIP-25 chunks:
+// at least two strings, each but the last filling a chunk (up to 3 bytes
+// short, where a UTF-8 character was not split), the last non-empty.
+func joinChunks(items []any) (string, bool) {
+	if len(items) < 2 {
+		return "", false
+	}
+	var b strings.Builder
+	for i, item := range items {
+		s, ok := item.(string)
+		if !ok || len(s) == 0 || len(s) > metadataStringLimit {
+			return "", false
+		}
+		if i < len(items)-1 && len(s) <= metadataStringLimit-utf8.UTFMax {
+			return "", false
+		}
+		b.WriteString(s)
+	}
+	return b.String(), true
+}
+
+// plutusBytes is a Plutus bytes value holding s.
+func plutusBytes(s string) map[string]any {
+	return map[string]any{"bytes": hex.EncodeToString([]byte(s))}
+}
+
+// cip68RefLovelace is the lovelace put on a reference token output: the
+// min-ADA of an output with the datum, sized generously from its JSON
+// (whose hex doubles the bytes CBOR would take).
+func cip68RefLovelace(datum string) uint64 {
+	return coinsPerUTxOByte * uint64(160+200+len(datum)/2)
+}
+
+// BuildCIP68Transaction builds a transaction minting a CIP-68 pair per
+// token in hexNames: the (222) user tokens go to recipientAddr in one
+// output, and each (100) reference token to refAddr with its datum (from
+// datums, in the same order) inline. No transaction metadata is attached.
+func (cli cardanoCLI) BuildCIP68Transaction(utxoIns []string, changeAddr, recipientAddr, refAddr string, hexNames, datums []string, policyID, scriptFile string, invalidBefore, invalidHereafter int64, witnesses int, plutus *PlutusPolicy) (_ string, err error) {
+	if len(hexNames) == 0 || len(hexNames) != len(datums) {
+		return "", fmt.Errorf("need one datum per token: %d names, %d datums", len(hexNames), len(datums))
+	}
+	txFile, err := cli.tempPath("mint-" + hexNames[0] + "-*.raw")
+	if err != nil {
+		return "", err
+	}
+	defer cli.removeOnError(txFile, &err)
+
+	var args []string
+	for _, in := range utxoIns {
+		args = append(args, "--tx-in", in)
+	}
+
+	var mints, users []string
+	var refOuts [][]string
+	outLovelace := uint64(1_400_000)
+	for i, hexName := range hexNames {
+		userUnit, err := assetUnit(policyID, cip68UserLabel+hexName)
+		if err != nil {
+			return "", err
+		}
+		refUnit, err := assetUnit(policyID, cip68RefLabel+hexName)
+		if err != nil {
+			return "", err
+		}
+		mints = append(mints, "1 "+refUnit, "1 "+userUnit)
+		users = append(users, "1 "+userUnit)
+
+		datumFile, err := cli.tempPath("datum-" + hexName + "-*.json")
+		if err != nil {
+			return "", err
+		}
+		defer cli.cleanupTemp(datumFile)
+		if err := os.WriteFile(datumFile, []byte(datums[i]), 0o600); err != nil {
+			return "", fmt.Errorf("failed to write datum file: %w", err)
+		}
+		refLovelace := cip68RefLovelace(datums[i])
+		outLovelace += refLovelace
+		refOuts = append(refOuts, []string{
+			"--tx-out", fmt.Sprintf("%s+%d+1 %s", refAddr, refLovelace, refUnit),
+			"--tx-out-inline-datum-file", datumFile,
+		})
+	}
+
+	args = append(args, "--mint", strings.Join(mints, " + "))
+	args = append(args, mintScriptArgs(scriptFile, plutus)...)
+	args = append(args, "--tx-out", fmt.Sprintf("%s+%d+%s", recipientAddr, 1_400_000, strings.Join(users, "+")))
+	for _, out := range refOuts {
+		args = append(args, out...)
+	}
+	args = append(args, validityArgs(invalidBefore, invalidHereafter)...)
+
+	if err := cli.buildTx("transaction", args, utxoIns, outLovelace, changeAddr, witnesses, txFile); err != nil {
+		return "", err
+	}
+	return txFile, nil
+}
+
+// buyerAssetHex returns the hex asset name of the token the buyer receives
+// for name: the (222) user token under CIP-68.
+func (e *Engine) buyerAssetHex(name string) string {
+	if e.metadataStandard == metadataCIP68 {
+		return cip68UserLabel + hex.EncodeToString([]byte(name))
+	}
+	return hex.EncodeToString([]byte(name))
+}
+
+// cip68Datums converts the metadata of each token under policyID to its
+// datum, returning them with the lovelace the reference outputs lock.
+func (e *Engine) cip68Datums(metadata, policyID string, hexNames []string) ([]string, uint64, error) {
+	var datums []string
+	var lovelace uint64
+	for _, hexName := range hexNames {
+		datum, err := cip68Datum(metadata, policyID, hexName)
+		if err != nil {
+			return nil, 0, err
+		}
+		datums = append(datums, datum)
+		lovelace += cip68RefLovelace(datum)
+	}
+	return datums, lovelace, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// cardanoCLI is how flowmass drives cardano-cli: the network it talks to,
+// the era command group used for transaction, key and address commands,
+// how transactions are balanced, the work dir its files are written to,
+// the backend that signs, and how the commands that submit their own
+// transactions retry. Each engine's client and each one-off command
+// carries its own, so collections in one process can run with different
+// settings.
+type cardanoCLI struct {
+	network      string
+	testnetMagic string
+	era          string
+	// buildMode selects how transactions are balanced. In raw mode the fee
+	// comes from `transaction calculate-min-fee` against the current
+	// protocol parameters and the change output is computed here, so
+	// nothing depends on the node's build-time estimation.
+	buildMode string
+	// protocolParamsFile, when set, is read for the protocol parameters
+	// instead of querying the node.
+	protocolParamsFile string
+	// known remembers the UTxOs queries returned, so raw mode can balance
+	// the inputs selected from them without asking the node again.
+	known *knownUTxOs
+	// budgets remembers the key witnesses each raw build's fee covers, for
+	// the check after signing.
+	budgets *witnessBudgets
+	workDir
+	signer txSigner
+	submit submitRetry
+}
+
+// newCardanoCLI returns the cardano-cli settings for network, checking era,
+// buildMode and protocolParamsFile.
+func newCardanoCLI(network, testnetMagic, era, buildMode, protocolParamsFile string, work workDir, signer txSigner, submit submitRetry) (cardanoCLI, error) {
+	era, err := checkEra(era)
+	if err != nil {
+		return cardanoCLI{}, err
+	}
+	if buildMode, err = checkBuildMode(buildMode); err != nil {
+		return cardanoCLI{}, err
+	}
+	if protocolParamsFile != "" {
+		if _, err := os.Stat(protocolParamsFile); err != nil {
+			return cardanoCLI{}, fmt.Errorf("protocol parameters file: %w", err)
+		}
+	}
+	return cardanoCLI{
+		network:      network,
+		testnetMagic: testnetMagic,
+		era:          era,
+		buildMode:    buildMode,
+		workDir:      work,
+		signer:       signer,
+		submit:       submit,
+
+		protocolParamsFile: protocolParamsFile,
+		known:              &knownUTxOs{byAddr: make(map[string][]UTxO)},
+		budgets:            &witnessBudgets{byFile: make(map[string]int)},
+	}, nil
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CardanoClient is the chain side of the mint pipeline: the tip, the UTxOs
+// to spend, and building, signing and submitting transactions. The engine
+// only talks to the chain through it. cliClient drives cardano-cli;
+// mockClient fakes it for -mock-cardano and for exercising the engine
+// without a node.
+type CardanoClient interface {
+	GetCurrentSlot() (int64, error)
+	GetUTxOs(address string) ([]UTxO, error)
+	BuildTransactionMultipleMints(utxoIns []string, monitorAddr, recipientAddr string, hexNames []string, policyID, scriptFile, metadata string, invalidBefore, invalidHereafter int64, deposit Deposit, witnesses int, plutus *PlutusPolicy) (string, error)
+	BuildCIP68Transaction(utxoIns []string, changeAddr, recipientAddr, refAddr string, hexNames, datums []string, policyID, scriptFile string, invalidBefore, invalidHereafter int64, witnesses int, plutus *PlutusPolicy) (string, error)
+	BuildRefundTransaction(utxoIn, refundAddr string, invalidHereafter int64, witnesses int) (string, error)
+	SignTransaction(txFile string, signingKeyFiles []string) (string, error)
+	SubmitTransaction(signedFile string) (string, error)
+	// TxID returns the hash of a built or signed transaction file.
+	TxID(txFile string) (string, error)
+	// FeeParams returns the current linear fee parameters.
+	FeeParams() (feeParams, error)
+	// TxFee returns the fee of a built transaction file.
+	TxFee(txFile string) (int64, error)
+	// PolicyID derives the policy id of a minting script file.
+	PolicyID(scriptFile string) (string, error)
+	// CheckScriptSigners checks that signingKeyFiles can witness a native
+	// minting script.
+	CheckScriptSigners(script *nativeScript, scriptFile string, signingKeyFiles []string) error
+	// SyncLag returns the chain tip and how far it trails wall-clock time.
+	SyncLag() (int64, time.Duration, error)
+	// TxOnChain reports whether txHash has been included in a block.
+	TxOnChain(txHash string) (bool, error)
+	// CleanupTemp removes files the client wrote to its work dir, unless
+	// -keep-temp is set.
+	CleanupTemp(paths ...string)
+	// ValidateSigningKey checks a -signing-key file for the client's
+	// signing backend and returns its type.
+	ValidateSigningKey(signingFile string) (string, error)
+}
+
+// newCardanoClient returns an engine's client: the mock when mockDir is
+// set, otherwise cardano-cli with the settings in cli, checked against the
+// local node. Without a node socket, Blockfrost stands in for the node for
+// the tip, UTxOs and submission; transactions are then balanced in raw
+// mode from -protocol-params-file, since `transaction build` and the
+// protocol parameter query both need the node.
+func newCardanoClient(cli cardanoCLI, bf *blockfrostBudget, blockfrostKey, mockDir string) (CardanoClient, error) {
+	if mockDir != "" {
+		m, err := newMockClient(mockDir, cli.workDir)
+		if err != nil {
+			return nil, err
+		}
+		cardanoLog.Warn("mock cardano enabled: transactions are written locally and never submitted", "dir", mockDir)
+		return m, nil
+	}
+	c := cliClient{
+		cardanoCLI:     cli,
+		blockfrost:     bf,
+		blockfrostKey:  blockfrostKey,
+		blockfrostOnly: blockfrostKey != "" && os.Getenv("CARDANO_NODE_SOCKET_PATH") == "",
+	}
+	if c.blockfrostOnly {
+		if cli.buildMode != buildRaw || cli.protocolParamsFile == "" {
+			return nil, fmt.Errorf("CARDANO_NODE_SOCKET_PATH is not set: without a node, use -build-mode raw with -protocol-params-file")
+		}
+		if _, err := exec.LookPath("cardano-cli"); err != nil {
+			return nil, fmt.Errorf("cardano-cli not found in PATH: %v", err)
+		}
+		cardanoLog.Info("no node socket configured; using Blockfrost for chain tip, UTxOs and submission")
+	} else if err := ensureCardanoCLIAvailable(cli.network, cli.testnetMagic); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ensureCardanoCLIAvailable checks that `cardano-cli` is in PATH and that
+// `cardano-cli query tip` succeeds for the configured network. The engine
+// requires a working cardano node and CLI in order to mint.
+func ensureCardanoCLIAvailable(network, testnetMagic string) error {
+	if _, err := exec.LookPath("cardano-cli"); err != nil {
+		return fmt.Errorf("cardano-cli not found in PATH: %v", err)
+	}
+
+	args := []string{"query", "tip"}
+	// append network and socket args (socketAndNetArgs validates testnetMagic and socket)
+	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
+	if err != nil {
+		return err
+	}
+	args = append(args, netArgsWithSocket...)
+	cmd := exec.Command("cardano-cli", args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cardano-cli query tip failed: %v; output: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// cliClient talks to a cardano node through cardano-cli. Building,
+// signing and the other cardano-cli commands come from the embedded
+// cardanoCLI. Without a node socket (blockfrostOnly), the tip, UTxO
+// queries and submission go to Blockfrost.
+type cliClient struct {
+	cardanoCLI
+	blockfrost     *blockfrostBudget
+	blockfrostKey  string
+	blockfrostOnly bool
+}
+
+func (c cliClient) GetCurrentSlot() (int64, error) {
+	if c.blockfrostOnly {
+		return GetCurrentSlotBlockfrost(c.blockfrost, blockfrostBase(c.network), c.blockfrostKey)
+	}
+	return GetCurrentSlotNetwork(c.network, c.testnetMagic)
+}
+
+func (c cliClient) GetUTxOs(address string) ([]UTxO, error) {
+	if !c.blockfrostOnly {
+		return c.cardanoCLI.GetUTxOs(address)
+	}
+	utxos, err := GetUTxOsBlockfrost(c.blockfrost, blockfrostBase(c.network), c.blockfrostKey, address)
+	if err != nil {
+		return nil, err
+	}
+	// Raw builds read input values from here, not the node.
+	c.known.record(address, utxos)
+	return utxos, nil
+}
+
+func (c cliClient) SubmitTransaction(signedFile string) (string, error) {
+	if c.blockfrostOnly {
+		return SubmitTransactionBlockfrost(c.blockfrost, signedFile, blockfrostBase(c.network), c.blockfrostKey, c.workDir)
+	}
+	return c.cardanoCLI.SubmitTransaction(signedFile)
+}
+
+func (c cliClient) FeeParams() (feeParams, error) {
+	if c.blockfrostOnly {
+		var p struct {
+			MinFeeA int64 `json:"min_fee_a"`
+			MinFeeB int64 `json:"min_fee_b"`
+		}
+		if err := blockfrostGet(c.blockfrost, c.blockfrostKey, blockfrostBase(c.network)+"/epochs/latest/parameters", &p); err != nil {
+			return feeParams{}, err
+		}
+		return feeParams{PerByte: p.MinFeeA, Fixed: p.MinFeeB}, nil
+	}
+	return QueryFeeParams(c.network, c.testnetMagic)
+}
+
+func (c cliClient) PolicyID(scriptFile string) (string, error) {
+	return c.ScriptPolicyID(scriptFile)
+}
+
+func (c cliClient) CheckScriptSigners(script *nativeScript, scriptFile string, signingKeyFiles []string) error {
+	return c.checkScriptSigners(script, scriptFile, signingKeyFiles)
+}
+
+func (c cliClient) SyncLag() (int64, time.Duration, error) {
+	tip, err := c.GetCurrentSlot()
+	if err != nil {
+		return 0, 0, err
+	}
+	return tip, syncLag(c.network, tip, time.Now()), nil
+}
+
+func (c cliClient) TxOnChain(txHash string) (bool, error) {
+	return TxOnChain(c.blockfrost, txHash, c.network, c.testnetMagic, c.blockfrostKey)
+}
+
+func (c cliClient) CleanupTemp(paths ...string) {
+	c.cleanupTemp(paths...)
+}
+
+func (c cliClient) ValidateSigningKey(signingFile string) (string, error) {
+	return c.signer.Validate(signingFile)
+}
+
+// mockShelleyStart is the Unix time of mainnet slot 0 in Shelley terms, so
+// mock slots track wall-clock time like real ones.
+const mockShelleyStart = 1591566291
+
+// mockUTxOLovelace is the value of each UTxO the mock reports.
+const mockUTxOLovelace = 1_000_000_000
+
+// mockClient stands in for cardano-cli and the node. It reports a few
+// lovelace-only UTxOs with fresh ids on every query, writes each built
+// transaction as readable JSON, "signs" by recording the key files, and
+// "submits" by copying the signed file into dir under a fake tx hash (the
+// SHA-256 of its contents). Nothing reaches the chain.
+type mockClient struct {
+	dir     string
+	queries atomic.Int64
+	// workDir holds the built and signed transactions, as for cardano-cli.
+	workDir
+}
+
+// newMockClient creates the artifact directory.
+func newMockClient(dir string, work workDir) (*mockClient, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mock cardano directory: %w", err)
+	}
+	return &mockClient{dir: dir, workDir: work}, nil
+}
+
+// mockTx is the JSON a mock build writes in place of a transaction body.
+type mockTx struct {
+	Kind             string            `json:"kind"`
+	Inputs           []string          `json:"inputs"`
+	Outputs          []string          `json:"outputs"`
+	Mint             []string          `json:"mint,omitempty"`
+	Script           string            `json:"script,omitempty"`
+	Redeemer         string            `json:"redeemer,omitempty"`
+	Collateral       string            `json:"collateral,omitempty"`
+	Metadata         json.RawMessage   `json:"metadata,omitempty"`
+	Datums           []json.RawMessage `json:"datums,omitempty"`
+	ChangeAddress    string            `json:"change_address"`
+	InvalidBefore    int64             `json:"invalid_before,omitempty"`
+	InvalidHereafter int64             `json:"invalid_hereafter"`
+	Witnesses        int               `json:"witnesses"`
+	SigningKeys      []string          `json:"signing_keys,omitempty"`
+}
+
+func (m *mockClient) GetCurrentSlot() (int64, error) {
+	return time.Now().Unix() - mockShelleyStart, nil
+}
+
+func (m *mockClient) GetUTxOs(address string) ([]UTxO, error) {
+	n := m.queries.Add(1)
+	utxos := make([]UTxO, 4)
+	for i := range utxos {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d", address, n, i)))
+		utxos[i] = UTxO{ID: hex.EncodeToString(sum[:]) + "#0", Lovelace: mockUTxOLovelace}
+	}
+	return utxos, nil
+}
+
+func (m *mockClient) BuildTransactionMultipleMints(utxoIns []string, monitorAddr, recipientAddr string, hexNames []string, policyID, scriptFile, metadata string, invalidBefore, invalidHereafter int64, deposit Deposit, witnesses int, plutus *PlutusPolicy) (string, error) {
+	if !json.Valid([]byte(metadata)) {
+		return "", fmt.Errorf("mock: %w: not valid JSON", errMetadataInvalid)
+	}
+	var assets []string
+	for _, hexName := range hexNames {
+		unit, err := assetUnit(policyID, hexName)
+		if err != nil {
+			return "", err
+		}
+		assets = append(assets, "1 "+unit)
+	}
+	tx := mockTx{
+		Kind:             "mint",
+		Inputs:           utxoIns,
+		Outputs:          []string{fmt.Sprintf("%s+%d+%s", recipientAddr, 1_400_000, strings.Join(assets, "+"))},
+		Mint:             assets,
+		Script:           scriptFile,
+		Metadata:         json.RawMessage(metadata),
+		ChangeAddress:    monitorAddr,
+		InvalidBefore:    invalidBefore,
+		InvalidHereafter: invalidHereafter,
+		Witnesses:        witnesses,
+	}
+	if plutus != nil {
+		tx.Redeemer, tx.Collateral = plutus.Redeemer, plutus.Collateral
+	}
+	return m.write("mint-"+deposit.TxHash+"-*.raw", tx)
+}
+
+func (m *mockClient) BuildCIP68Transaction(utxoIns []string, changeAddr, recipientAddr, refAddr string, hexNames, datums []string, policyID, scriptFile string, invalidBefore, invalidHereafter int64, witnesses int, plutus *PlutusPolicy) (string, error) {
+	if len(hexNames) == 0 || len(hexNames) != len(datums) {
+		return "", fmt.Errorf("mock: need one datum per token: %d names, %d datums", len(hexNames), len(datums))
+	}
+	tx := mockTx{
+		Kind:             "mint",
+		Inputs:           utxoIns,
+		Script:           scriptFile,
+		ChangeAddress:    changeAddr,
+		InvalidBefore:    invalidBefore,
+		InvalidHereafter: invalidHereafter,
+		Witnesses:        witnesses,
+	}
+	var users []string
+	for i, hexName := range hexNames {
+		userUnit, err := assetUnit(policyID, cip68UserLabel+hexName)
+		if err != nil {
+			return "", err
+		}
+		refUnit, err := assetUnit(policyID, cip68RefLabel+hexName)
+		if err != nil {
+			return "", err
+		}
+		if !json.Valid([]byte(datums[i])) {
+			return "", fmt.Errorf("mock: datum for %s is not valid JSON", hexName)
+		}
+		tx.Mint = append(tx.Mint, "1 "+refUnit, "1 "+userUnit)
+		tx.Outputs = append(tx.Outputs, fmt.Sprintf("%s+%d+1 %s", refAddr, cip68RefLovelace(datums[i]), refUnit))
+		tx.Datums = append(tx.Datums, json.RawMessage(datums[i]))
+		users = append(users, "1 "+userUnit)
+	}
+	tx.Outputs = append([]string{fmt.Sprintf("%s+%d+%s", recipientAddr, 1_400_000, strings.Join(users, "+"))}, tx.Outputs...)
+	if plutus != nil {
+		tx.Redeemer, tx.Collateral = plutus.Redeemer, plutus.Collateral
+	}
+	return m.write("mint-"+hexNames[0]+"-*.raw", tx)
+}
+
+func (m *mockClient) BuildRefundTransaction(utxoIn, refundAddr string, invalidHereafter int64, witnesses int) (string, error) {
+	return m.write("refund-*.raw", mockTx{
+		Kind:             "refund",
+		Inputs:           []string{utxoIn},
+		Outputs:          []string{refundAddr},
+		ChangeAddress:    refundAddr,
+		InvalidHereafter: invalidHereafter,
+		Witnesses:        witnesses,
+	})
+}
+
+func (m *mockClient) SignTransaction(txFile string, signingKeyFiles []string) (string, error) {
+	data, err := os.ReadFile(txFile)
+	if err != nil {
+		return "", fmt.Errorf("mock: failed to read transaction: %w", err)
+	}
+	var tx mockTx
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return "", fmt.Errorf("mock: %s is not a mock transaction: %v", txFile, err)
+	}
+	tx.SigningKeys = signingKeyFiles
+	out, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	signedFile := signedPath(txFile)
+	if err := os.WriteFile(signedFile, out, 0o644); err != nil {
+		return "", fmt.Errorf("mock: failed to write signed transaction: %w", err)
+	}
+	return signedFile, nil
+}
+
+func (m *mockClient) SubmitTransaction(signedFile string) (string, error) {
+	data, err := os.ReadFile(signedFile)
+	if err != nil {
+		return "", fmt.Errorf("mock: failed to read signed transaction: %w", err)
+	}
+	txHash := mockTxID(data)
+	artifact := filepath.Join(m.dir, txHash+".json")
+	if err := os.WriteFile(artifact, data, 0o644); err != nil {
+		return "", fmt.Errorf("mock: failed to write submitted transaction: %w", err)
+	}
+	cardanoLog.Info("mock submit", "tx_hash", txHash, "file", artifact)
+	return txHash, nil
+}
+
+func (m *mockClient) TxID(txFile string) (string, error) {
+	data, err := os.ReadFile(txFile)
+	if err != nil {
+		return "", fmt.Errorf("mock: failed to read transaction: %w", err)
+	}
+	return mockTxID(data), nil
+}
+
+func (m *mockClient) FeeParams() (feeParams, error) {
+	return defaultFeeParams, nil
+}
+
+// TxFee charges the mainnet fee for the mock transaction's JSON size.
+func (m *mockClient) TxFee(txFile string) (int64, error) {
+	info, err := os.Stat(txFile)
+	if err != nil {
+		return 0, fmt.Errorf("mock: %w", err)
+	}
+	return defaultFeeParams.fee(int(info.Size())), nil
+}
+
+// PolicyID cannot hash a script without cardano-cli; the engine skips the
+// policy id check.
+func (m *mockClient) PolicyID(scriptFile string) (string, error) {
+	return "", fmt.Errorf("mock: policy ids are not derived")
+}
+
+// CheckScriptSigners cannot hash keys without cardano-cli; any signers pass.
+func (m *mockClient) CheckScriptSigners(script *nativeScript, scriptFile string, signingKeyFiles []string) error {
+	return nil
+}
+
+// SyncLag reports the mock tip, which follows wall-clock time, as in sync.
+func (m *mockClient) SyncLag() (int64, time.Duration, error) {
+	tip, err := m.GetCurrentSlot()
+	return tip, 0, err
+}
+
+// TxOnChain counts a mock transaction as landed once submitted.
+func (m *mockClient) TxOnChain(txHash string) (bool, error) {
+	return m.landed(txHash), nil
+}
+
+func (m *mockClient) CleanupTemp(paths ...string) {
+	m.cleanupTemp(paths...)
+}
+
+// ValidateSigningKey checks the key as the file backend would; the mock
+// never signs with it.
+func (m *mockClient) ValidateSigningKey(signingFile string) (string, error) {
+	return ValidateSigningKey(signingFile)
+}
+
+// mockTxID is the fake hash of a mock transaction file's contents.
+func mockTxID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// landed reports whether txHash was "submitted" to this mock.
+func (m *mockClient) landed(txHash string) bool {
+	_, err := os.Stat(filepath.Join(m.dir, txHash+".json"))
+	return err == nil
+}
+
+// write stores tx as a new work-dir file matching pattern.
+func (m *mockClient) write(pattern string, tx mockTx) (_ string, err error) {
+	txFile, err := m.tempPath(pattern)
+	if err != nil {
+		return "", err
+	}
+	defer m.removeOnError(txFile, &err)
+	out, err := json.MarshalIndent(tx, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(txFile, out, 0o644); err != nil {
+		return "", fmt.Errorf("mock: failed to write transaction: %w", err)
+	}
+	return txFile, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMockCardanoNeedsNoNode(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("CARDANO_NODE_SOCKET_PATH", "")
+	cli := testCLI(t, buildAuto)
+
+	c, err := newCardanoClient(cli, nil, "", filepath.Join(t.TempDir(), "chain"))
+	if err != nil {
+		t.Fatalf("newCardanoClient with -mock-cardano: %v", err)
+	}
+	if _, ok := c.(*mockClient); !ok {
+		t.Fatalf("newCardanoClient with -mock-cardano = %T, want *mockClient", c)
+	}
+	if _, err := newCardanoClient(cli, nil, "", ""); err == nil || !strings.Contains(err.Error(), "cardano-cli not found") {
+		t.Errorf("newCardanoClient without -mock-cardano error = %v, want cardano-cli missing", err)
+	}
+}
+
+func TestMockCardanoPipeline(t *testing.T) {
+	hook := newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, hook.URL)
+	te := newTestEngine(t, nil)
+	dep, buyer := testTxHash(1), testBuyer(t, 1)
+	te.setDeposits(mockDeposit{SenderAddr: buyer, Amount: testMintPrice, TxHash: dep})
+
+	te.poll()
+
+	// Built, signed with the configured key and "submitted" as an artifact
+	// named by its fake tx hash.
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 {
+		t.Fatalf("got %d mint artifacts, want 1", len(mints))
+	}
+	unit := testPolicyID + "." + hex.EncodeToString([]byte("Flowmass1"))
+	if !reflect.DeepEqual(mints[0].Mint, []string{"1 " + unit}) {
+		t.Errorf("minted %v, want 1 %s", mints[0].Mint, unit)
+	}
+	if !reflect.DeepEqual(mints[0].SigningKeys, te.signingKeyFiles) {
+		t.Errorf("signed with %v, want %v", mints[0].SigningKeys, te.signingKeyFiles)
+	}
+
+	// Recorded in the state under that hash.
+	rec, ok := te.state.GetMintRecord(dep)
+	if !ok || rec.MintID != 1 || rec.TokenName != "Flowmass1" || rec.Recipient != buyer {
+		t.Fatalf("GetMintRecord = %+v, %v; want Flowmass1 to the buyer", rec, ok)
+	}
+	data, err := os.ReadFile(filepath.Join(te.mock.dir, rec.MintTxHash+".json"))
+	if err != nil {
+		t.Fatalf("no artifact for the recorded mint tx: %v", err)
+	}
+	if got := mockTxID(data); got != rec.MintTxHash {
+		t.Errorf("artifact hashes to %s, recorded as %s", got, rec.MintTxHash)
+	}
+	if !te.audited(auditSubmitted, dep) {
+		t.Error("no submitted audit entry")
+	}
+
+	// Announced on the webhook.
+	posts := hook.received()
+	if len(posts) != 1 {
+		t.Fatalf("webhook got %d posts, want 1", len(posts))
+	}
+	embeds, _ := posts[0]["embeds"].([]any)
+	if len(embeds) != 1 {
+		t.Fatalf("webhook post = %v, want one mint embed", posts[0])
+	}
+	embed, _ := embeds[0].(map[string]any)
+	url, _ := embed["url"].(string)
+	if embed["title"] != "Minted Flowmass1" || !strings.Contains(url, rec.MintTxHash) {
+		t.Errorf("mint embed = %v, want Flowmass1 linked to its tx", embed)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Collection is one drop managed by the process: its own monitor address,
+// policy, pricing, metadata and state. Each collection runs in its own
+// Engine with its own cardano-cli client; the node, Blockfrost key, signing
+// key and notifications are shared.
+//
+// Collections are loaded from a JSON file:
+/*
+[
+	{"name": "sharks", "monitor_address": "addr1...", "policy_id": "1d0c...", "script": "sharks/policy.script",
+	 "mint_price": 27000000, "state": "sharks.state"},
+	{"name": "whales", "monitor_address": "addr1...", "policy_id": "9f3a...", "script": "whales/policy.script",
+	 "tiers": "whales/tiers.json", "traits": "whales/traits.json", "seed": 42, "state": "whales.state"}
+]
+*/
+type Collection struct {
+	Name           string `json:"name"`
+	MonitorAddress string `json:"monitor_address"`
+	PolicyID       string `json:"policy_id"`
+	Script         string `json:"script"`
+	MintPrice      int64  `json:"mint_price,omitempty"`
+	State          string `json:"state"`
+	Tiers          string `json:"tiers,omitempty"`
+	Traits         string `json:"traits,omitempty"`
+	Seed           *int64 `json:"seed,omitempty"` // nil: time-based; 0 is a valid seed
+	Manifest       string `json:"manifest,omitempty"`
+	Description    string `json:"description,omitempty"`
+	AssetName      string `json:"asset_name,omitempty"`
+	ChangeAddress  string `json:"change_address,omitempty"`
+	FundingAddress string `json:"funding_address,omitempty"`
+	// MetadataStandard is cip25 (the default) or cip68.
+	MetadataStandard string `json:"metadata_standard,omitempty"`
+	ReferenceAddress string `json:"reference_address,omitempty"`
+	MockDeposits     string `json:"mock_deposits,omitempty"`
+	// Era, BuildMode and WorkDir override -era, -build-mode and -work-dir
+	// for this collection's cardano-cli commands.
+	Era       string `json:"era,omitempty"`
+	BuildMode string `json:"build_mode,omitempty"`
+	WorkDir   string `json:"work_dir,omitempty"`
+	// MaxProcessed overrides -state-max-processed; 0 keeps all.
+	MaxProcessed *int `json:"state_max_processed,omitempty"`
+	// FeeBuffer overrides -fee-buffer.
+	FeeBuffer *int64 `json:"fee_buffer,omitempty"`
+	// MaxMintAttempts overrides -max-mint-attempts; 0 retries forever.
+	MaxMintAttempts *int `json:"max_mint_attempts,omitempty"`
+	// MintStartSlot overrides -mint-start-slot; 0 opens with the policy.
+	MintStartSlot *int64 `json:"mint_start_slot,omitempty"`
+}
+
+// LoadCollections reads the collection list from a JSON file. Relative paths
+// are resolved against the file's directory. Monitor addresses and state
+// files must be distinct so every deposit is routed to exactly one
+// collection and counters stay independent.
+func LoadCollections(filePath string) ([]Collection, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collections file: %w", err)
+	}
+
+	var collections []Collection
+	if err := json.Unmarshal(data, &collections); err != nil {
+		return nil, fmt.Errorf("failed to parse collections file: %w", err)
+	}
+	if len(collections) == 0 {
+		return nil, fmt.Errorf("collections file %s defines no collections", filePath)
+	}
+
+	dir := filepath.Dir(filePath)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	names := make(map[string]bool)
+	addrs := make(map[string]string)
+	states := make(map[string]string)
+	for i := range collections {
+		c := &collections[i]
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("collection%d", i+1)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("collection %q is defined twice", c.Name)
+		}
+		names[c.Name] = true
+
+		if c.MonitorAddress == "" || c.Script == "" {
+			return nil, fmt.Errorf("collection %q: monitor_address and script are required", c.Name)
+		}
+		if c.Tiers == "" && c.MintPrice <= 0 {
+			return nil, fmt.Errorf("collection %q: set mint_price or tiers", c.Name)
+		}
+		if c.State == "" {
+			c.State = c.Name + ".state"
+		}
+		c.Script = resolve(c.Script)
+		c.State = resolve(c.State)
+		c.Tiers = resolve(c.Tiers)
+		c.Traits = resolve(c.Traits)
+		c.Manifest = resolve(c.Manifest)
+		c.MockDeposits = resolve(c.MockDeposits)
+		c.WorkDir = resolve(c.WorkDir)
+		if c.Era != "" {
+			era, err := checkEra(c.Era)
+			if err != nil {
+				return nil, fmt.Errorf("collection %q: %v", c.Name, err)
+			}
+			c.Era = era
+		}
+		if c.BuildMode != "" {
+			if _, err := checkBuildMode(c.BuildMode); err != nil {
+				return nil, fmt.Errorf("collection %q: %v", c.Name, err)
+			}
+		}
+
+		if other, ok := addrs[c.MonitorAddress]; ok {
+			return nil, fmt.Errorf("collection %q: monitor_address already used by %q", c.Name, other)
+		}
+		addrs[c.MonitorAddress] = c.Name
+		if other, ok := states[c.State]; ok {
+			return nil, fmt.Errorf("collection %q: state file already used by %q", c.Name, other)
+		}
+		states[c.State] = c.Name
+	}
+	return collections, nil
+}
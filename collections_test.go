@@ -0,0 +1,110 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCollections(t *testing.T) {
+	dir := t.TempDir()
+	sharks, whales := testAddress(t, "addr", 0x61, 0x01), testAddress(t, "addr", 0x61, 0x02)
+	path := filepath.Join(dir, "collections.json")
+	writeFile(t, path, `[
+		{"name": "sharks", "monitor_address": "`+sharks+`", "script": "sharks/policy.script", "mint_price": 27000000},
+		{"monitor_address": "`+whales+`", "script": "/abs/whales.script", "tiers": "whales/tiers.json", "state": "whales.db"}
+	]`)
+	collections, err := LoadCollections(path)
+	if err != nil {
+		t.Fatalf("LoadCollections: %v", err)
+	}
+	if len(collections) != 2 {
+		t.Fatalf("got %d collections, want 2", len(collections))
+	}
+	s, w := collections[0], collections[1]
+	if s.Script != filepath.Join(dir, "sharks", "policy.script") || s.State != filepath.Join(dir, "sharks.state") {
+		t.Errorf("sharks script %s, state %s; want both resolved against the file's directory", s.Script, s.State)
+	}
+	if w.Name != "collection2" || w.Script != "/abs/whales.script" || w.Tiers != filepath.Join(dir, "whales", "tiers.json") {
+		t.Errorf("second collection = %+v; want the default name, the absolute script kept and tiers resolved", w)
+	}
+
+	for name, tt := range map[string]struct{ body, wantErr string }{
+		"duplicate name": {
+			body:    `[{"name": "a", "monitor_address": "` + sharks + `", "script": "s", "mint_price": 1}, {"name": "a", "monitor_address": "` + whales + `", "script": "s", "mint_price": 1}]`,
+			wantErr: `collection "a" is defined twice`,
+		},
+		"shared monitor address": {
+			body:    `[{"name": "a", "monitor_address": "` + sharks + `", "script": "s", "mint_price": 1}, {"name": "b", "monitor_address": "` + sharks + `", "script": "s", "mint_price": 1}]`,
+			wantErr: `monitor_address already used by "a"`,
+		},
+		"shared state": {
+			body:    `[{"name": "a", "monitor_address": "` + sharks + `", "script": "s", "mint_price": 1, "state": "x"}, {"name": "b", "monitor_address": "` + whales + `", "script": "s", "mint_price": 1, "state": "x"}]`,
+			wantErr: `state file already used by "a"`,
+		},
+		"no price": {
+			body:    `[{"name": "a", "monitor_address": "` + sharks + `", "script": "s"}]`,
+			wantErr: "set mint_price or tiers",
+		},
+		"empty": {body: `[]`, wantErr: "defines no collections"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeFile(t, path, tt.body)
+			if _, err := LoadCollections(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadCollections() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCollectionsRouteDepositsToTheirEngine(t *testing.T) {
+	shared := filepath.Join(t.TempDir(), "deposits.json")
+	sharksAddr, whalesAddr := testAddress(t, "addr", 0x61, 0x01), testAddress(t, "addr", 0x61, 0x02)
+	collection := func(name, monitor string) func(*EngineConfig) {
+		return func(cfg *EngineConfig) {
+			cfg.Name = name
+			cfg.MonitorAddr = monitor
+			cfg.MockFile = shared
+		}
+	}
+	sharks := newTestEngine(t, collection("sharks", sharksAddr))
+	whales := newTestEngine(t, collection("whales", whalesAddr))
+	sharks.deposits, whales.deposits = shared, shared
+
+	sharks.setDeposits(
+		mockDeposit{Monitor: sharksAddr, SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: testTxHash(1)},
+		mockDeposit{Monitor: whalesAddr, SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: testTxHash(2)},
+		mockDeposit{Monitor: sharksAddr, SenderAddr: testBuyer(t, 3), Amount: testMintPrice, TxHash: testTxHash(3)},
+	)
+	sharks.poll()
+	whales.poll()
+
+	for _, tt := range []struct {
+		name    string
+		te      *testEngine
+		own     []string
+		foreign []string
+		counter int
+	}{
+		{name: "sharks", te: sharks, own: []string{testTxHash(1), testTxHash(3)}, foreign: []string{testTxHash(2)}, counter: 3},
+		{name: "whales", te: whales, own: []string{testTxHash(2)}, foreign: []string{testTxHash(1), testTxHash(3)}, counter: 2},
+	} {
+		for i, dep := range tt.own {
+			rec, ok := tt.te.state.GetMintRecord(dep)
+			if !ok || rec.MintID != i+1 {
+				t.Errorf("%s: deposit %s minted %+v, %v; want id %d from its own counter", tt.name, dep, rec, ok, i+1)
+			}
+		}
+		for _, dep := range tt.foreign {
+			if tt.te.state.IsProcessed(dep) {
+				t.Errorf("%s minted deposit %s paid to the other collection", tt.name, dep)
+			}
+		}
+		if got := tt.te.state.Counter(); got != tt.counter {
+			t.Errorf("%s: counter %d, want %d", tt.name, got, tt.counter)
+		}
+		if n := len(tt.te.submittedKind("mint")); n != len(tt.own) {
+			t.Errorf("%s: %d mints, want %d", tt.name, n, len(tt.own))
+		}
+	}
+}
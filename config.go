@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loadConfigFile reads a flat YAML ("key: value") or TOML ("key = value")
+// file and applies each entry to the flag of the same name. Keys may use
+// dashes or underscores (policy_id and policy-id both set -policy-id).
+// Repeatable flags (-signing-key, -webhook-url, ...) take a list value or
+// the key repeated; any other flag may be set once.
+//
+// Precedence, highest first: flags given on the command line, the config
+// file, environment variables, built-in defaults. Env vars only supply flag
+// defaults, so a value in the file replaces them.
+func loadConfigFile(fs *flag.FlagSet, filePath string) error {
+	values, err := parseConfigFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for name, vals := range values {
+		if name == "config" {
+			return fmt.Errorf("config file %s: nested config is not supported", filePath)
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", filePath, name)
+		}
+		if _, repeatable := f.Value.(*stringList); len(vals) > 1 && !repeatable {
+			return fmt.Errorf("config file %s: %s is set more than once", filePath, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		for _, value := range vals {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("config file %s: invalid value for %s: %v", filePath, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseConfigFile returns the values of each key of a flat YAML or TOML
+// file, picking the syntax from the file extension. Keys are flag names:
+// underscores become dashes, so policy_id and policy-id are the same key.
+// Values are scalars or lists of scalars (a [a, b] flow list, or a YAML
+// block list of "- item" lines under an empty key); a repeated key adds
+// its values to the earlier ones. Sections, TOML tables and nested maps
+// are not supported; that is all the flag set needs.
+func parseConfigFile(filePath string) (map[string][]string, error) {
+	sep := ":"
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		sep = "="
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension (want .yaml, .yml or .toml)", filePath)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	// listKey is the key of a YAML block list being read, if any;
+	// listEmpty is set until its first item replaces the empty value.
+	listKey, listEmpty := "", false
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(line, "- "); ok && sep == ":" {
+			if listKey == "" {
+				return nil, fmt.Errorf("config file %s:%d: list item without a key", filePath, lineNo)
+			}
+			value, err := configValue(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("config file %s:%d: %v", filePath, lineNo, err)
+			}
+			if listEmpty {
+				values[listKey] = values[listKey][:len(values[listKey])-1]
+				listEmpty = false
+			}
+			values[listKey] = append(values[listKey], value)
+			continue
+		}
+		listKey, listEmpty = "", false
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("config file %s:%d: sections are not supported; put every setting at the top level", filePath, lineNo)
+		}
+		key, raw, ok := strings.Cut(line, sep)
+		if !ok {
+			return nil, fmt.Errorf("config file %s:%d: expected key%svalue", filePath, lineNo, sep)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if key == "" {
+			return nil, fmt.Errorf("config file %s:%d: missing key", filePath, lineNo)
+		}
+		raw = strings.TrimSpace(raw)
+		if raw == "" && sep == ":" {
+			// An empty value, unless a YAML block list follows.
+			listKey, listEmpty = key, true
+			values[key] = append(values[key], "")
+			continue
+		}
+		vals, err := configValues(raw)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s:%d: %v", filePath, lineNo, err)
+		}
+		values[key] = append(values[key], vals...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return values, nil
+}
+
+// configValues returns the scalars of a [a, b] list, or raw as one scalar.
+func configValues(raw string) ([]string, error) {
+	inner, ok := strings.CutPrefix(raw, "[")
+	if !ok {
+		value, err := configValue(raw)
+		return []string{value}, err
+	}
+	inner, ok = strings.CutSuffix(inner, "]")
+	if !ok {
+		return nil, fmt.Errorf("unterminated list %s", raw)
+	}
+	var vals []string
+	for _, item := range strings.Split(inner, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		value, err := configValue(item)
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, value)
+	}
+	return vals, nil
+}
+
+// configValue unquotes a scalar and strips a trailing # comment from bare values.
+func configValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '"':
+		end := strings.LastIndexByte(raw, '"')
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return strconv.Unquote(raw[:end+1])
+	case '\'':
+		end := strings.LastIndexByte(raw, '\'')
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1:end], nil
+	case '[', '{':
+		return "", fmt.Errorf("nested lists and tables are not supported")
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = strings.TrimSpace(raw[:i])
+	}
+	return raw, nil
+}
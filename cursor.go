@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+
+// Blockfrost cannot list an address's UTxOs incrementally, but it can list
+// its transactions newest first. The deposit cursor is the newest one
+// ("height:index") as of the last scan that left no deposit outstanding:
+// while it is still the newest, nothing arrived and the scan is skipped.
+// Matching by payment credential always scans, as the transactions endpoint
+// takes a single address. The cursor is cleared at start and on reload:
+// changed settings may match UTxOs the last scan passed over.
+
+// latestAddressTx returns the position ("height:index") of the newest
+// transaction touching the monitor address, or "" if it has none.
+func (e *Engine) latestAddressTx(base string) (string, error) {
+	var txs []struct {
+		TxIndex     int   `json:"tx_index"`
+		BlockHeight int64 `json:"block_height"`
+	}
+	err := blockfrostGet(e.settings.blockfrost, e.blockfrostKey, fmt.Sprintf("%s/addresses/%s/transactions?order=desc&count=1", base, e.monitorAddr), &txs)
+	if err != nil {
+		// A never-used address is a 404, not an empty list.
+		if isBlockfrostNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(txs) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("%d:%d", txs[0].BlockHeight, txs[0].TxIndex), nil
+}
+
+// depositScanNeeded reports whether a poll must scan the monitor address's
+// UTxOs, and returns the newest transaction to store as the cursor if that
+// scan leaves nothing outstanding ("" to store none).
+func (e *Engine) depositScanNeeded(base string) (bool, string) {
+	if e.paymentCred != "" {
+		return true, ""
+	}
+	latest, err := e.latestAddressTx(base)
+	if err != nil {
+		e.log.Warn("failed to check monitor address for new transactions; scanning", "error", err)
+		return true, ""
+	}
+	if latest != "" && latest == e.state.DepositCursor() {
+		e.log.Debug("no new transactions at monitor address; skipping deposit scan", "cursor", latest)
+		return false, ""
+	}
+	return true, latest
+}
+
+// clearDepositCursor makes the next poll scan.
+func (e *Engine) clearDepositCursor() {
+	e.updateDepositCursor("", true)
+}
+
+// updateDepositCursor stores latest as the cursor after a scan, or clears
+// it when the scan left deposits outstanding so the next poll scans again.
+func (e *Engine) updateDepositCursor(latest string, outstanding bool) {
+	if outstanding {
+		latest = ""
+	}
+	if latest == e.state.DepositCursor() {
+		return
+	}
+	if err := e.state.SetDepositCursor(latest); err != nil {
+		e.log.Warn("failed to save deposit cursor", "error", err)
+	}
+}
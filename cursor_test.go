@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestDepositCursorSkipsUnchangedAddress(t *testing.T) {
+	var mu sync.Mutex
+	latest := `[{"tx_hash": "aa", "tx_index": 2, "block_height": 100}]`
+	status := http.StatusOK
+	requests := 0
+	te := newTestEngine(t, nil)
+	te.useBlockfrost()
+	base := newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if want := "/addresses/" + te.monitorAddr + "/transactions"; r.URL.Path != want || r.URL.Query().Get("order") != "desc" {
+			t.Errorf("request for %s, want %s newest first", r.URL, want)
+		}
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			fmt.Fprintf(w, `{"status_code": %d, "error": "Error", "message": "failed"}`, status)
+			return
+		}
+		fmt.Fprint(w, latest)
+	})
+	respond := func(code int, body string) {
+		mu.Lock()
+		defer mu.Unlock()
+		status, latest = code, body
+	}
+	check := func(step string, wantScan bool, wantLatest string) {
+		t.Helper()
+		scan, got := te.depositScanNeeded(base)
+		if scan != wantScan || got != wantLatest {
+			t.Errorf("%s: depositScanNeeded() = %v, %q; want %v, %q", step, scan, got, wantScan, wantLatest)
+		}
+	}
+
+	check("no cursor", true, "100:2")
+	te.updateDepositCursor("100:2", false)
+	if got := te.state.DepositCursor(); got != "100:2" {
+		t.Fatalf("cursor after a clean scan = %q, want 100:2", got)
+	}
+	check("unchanged address", false, "")
+
+	respond(http.StatusOK, `[{"tx_hash": "bb", "tx_index": 0, "block_height": 101}]`)
+	check("new transaction", true, "101:0")
+
+	// A scan that leaves a deposit outstanding keeps scanning.
+	te.updateDepositCursor("101:0", true)
+	if got := te.state.DepositCursor(); got != "" {
+		t.Errorf("cursor after a scan with deposits outstanding = %q, want none", got)
+	}
+	check("deposits outstanding", true, "101:0")
+
+	respond(http.StatusNotFound, "")
+	check("never-used address", true, "")
+	respond(http.StatusInternalServerError, "")
+	check("lookup failure", true, "")
+
+	// Matching by payment credential has no single address to ask about.
+	te.paymentCred = "addr_vkh1test"
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+	before := count()
+	check("payment credential", true, "")
+	if count() != before {
+		t.Error("a payment credential engine asked for the address's transactions")
+	}
+}
+
+func TestDepositCursorPersists(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			s, path := openTestState(t, backend)
+			if err := s.SetDepositCursor("100:2"); err != nil {
+				t.Fatalf("SetDepositCursor: %v", err)
+			}
+			s.Close()
+
+			r, err := OpenStateStore(backend, path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer r.Close()
+			if got := r.DepositCursor(); got != "100:2" {
+				t.Fatalf("reopened cursor = %q, want 100:2", got)
+			}
+			if err := r.Reset(1, nil); err != nil {
+				t.Fatalf("Reset: %v", err)
+			}
+			if got := r.DepositCursor(); got != "" {
+				t.Errorf("cursor after Reset = %q, want none", got)
+			}
+		})
+	}
+}
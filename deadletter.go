@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultMaxMintAttempts is how many failed mint attempts a deposit gets,
+// unless -max-mint-attempts says otherwise, before it is dead-lettered:
+// moved to the state's dead-letter list, no longer retried, and announced
+// for manual review. `flowmass requeue` puts it back.
+const defaultMaxMintAttempts = 10
+
+// DeadLetter is a deposit the engine gave up minting for.
+type DeadLetter struct {
+	DepositTx   string    `json:"deposit_tx"`
+	OutputIndex int       `json:"output_index"`
+	Sender      string    `json:"sender,omitempty"`
+	Lovelace    int64     `json:"lovelace"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	At          time.Time `json:"dead_lettered_at"`
+}
+
+// mintFailed counts a failed mint attempt for dep and dead-letters the
+// deposit once it has failed the engine's maxMintAttempts times (0 retries
+// forever). Its reservation, if any, is kept so a requeued deposit mints
+// the same id.
+func (e *Engine) mintFailed(dep Deposit, cause error) {
+	attempts, err := e.state.RecordFailure(dep.TxHash)
+	if err != nil {
+		e.log.Warn("failed to record mint failure", "deposit_tx", dep.TxHash, "error", err)
+		return
+	}
+	if e.settings.maxMintAttempts <= 0 || attempts < e.settings.maxMintAttempts {
+		return
+	}
+
+	dl := DeadLetter{
+		DepositTx:   dep.TxHash,
+		OutputIndex: dep.OutputIndex,
+		Sender:      dep.SenderAddr,
+		Lovelace:    dep.Amount,
+		Attempts:    attempts,
+		LastError:   strings.Join(strings.Fields(cause.Error()), " "),
+		At:          time.Now().UTC(),
+	}
+	if err := e.state.AddDeadLetter(dl); err != nil {
+		e.log.Warn("failed to dead-letter deposit", "deposit_tx", dep.TxHash, "error", err)
+		return
+	}
+	e.log.Error("deposit keeps failing to mint; dead-lettered", "deposit_tx", dep.TxHash, "attempts", attempts, "error", cause)
+	Notify(eventDeadLetter, fmt.Sprintf("%s gave up on deposit %s (%d lovelace from %s) after %d failed mint attempts: %v. Fix the cause and run `flowmass requeue %s`, or refund it.",
+		e.displayName(), dep.TxHash, dep.Amount, dep.SenderAddr, attempts, cause, dep.TxHash))
+}
+
+// deadLetterDeposits returns the dead-lettered deposits' UTxOs, which must
+// not fund other mints: the buyer's lovelace waits there for a requeue or
+// refund.
+func (e *Engine) deadLetterDeposits() []Deposit {
+	var deps []Deposit
+	for _, dl := range e.state.DeadLetters() {
+		deps = append(deps, Deposit{TxHash: dl.DepositTx, OutputIndex: dl.OutputIndex})
+	}
+	return deps
+}
+
+// runRequeue implements `flowmass requeue [txhash]`: without an argument it
+// lists the dead-lettered deposits; with one it takes that deposit off the
+// list and resets its failure count, so the engine retries it on its next
+// poll. Requeuing takes the state lock, so stop the engine first.
+func runRequeue(args []string) error {
+	fs := flag.NewFlagSet("requeue", flag.ExitOnError)
+	stateFile := fs.String("state", envOr("STATE_FILE", "flowmass.state"), "Path to state file")
+	stateBackend := fs.String("state-backend", envOr("STATE_BACKEND", "json"), "State storage backend: json or sqlite")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flowmass requeue [flags] [txhash]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		state, err := ReadStateStore(*stateBackend, *stateFile)
+		if err != nil {
+			return fmt.Errorf("failed to read state %s: %w", *stateFile, err)
+		}
+		dead := state.DeadLetters()
+		if len(dead) == 0 {
+			cmdLog.Info("no dead-lettered deposits", "state", *stateFile)
+		}
+		for _, dl := range dead {
+			fmt.Printf("%s#%d  %d lovelace from %s  %d attempts, %s\n    %s\n",
+				dl.DepositTx, dl.OutputIndex, dl.Lovelace, dl.Sender, dl.Attempts, dl.At.Format(time.RFC3339), dl.LastError)
+		}
+		return nil
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("requeue takes at most one deposit tx")
+	}
+
+	depositTx, _, _ := strings.Cut(fs.Arg(0), "#")
+	state, err := OpenStateStore(*stateBackend, *stateFile, defaultMaxProcessed)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+	requeued, err := state.Requeue(depositTx)
+	if err != nil {
+		return fmt.Errorf("failed to requeue %s: %w", depositTx, err)
+	}
+	if !requeued {
+		return fmt.Errorf("%s is not dead-lettered in %s", depositTx, *stateFile)
+	}
+	cmdLog.Info("requeued; the engine retries it on its next poll", "deposit_tx", depositTx)
+	return nil
+}
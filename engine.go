@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,89 +22,465 @@ import (
 type Engine struct {
 	monitorAddr string
 	mintPrice   int64
-	policyID    string
-	scriptFile  string
+	// priceTolerance is how much a deposit may overpay a price and still
+	// match it; the excess stays at the monitor address as a tip.
+	priceTolerance int64
+	policyID       string
+	scriptFile     string
 	// metadataFile   string
-	state          *State
-	blockfrostKey  string
-	network        string
-	testnetMagic   string
-	signingKeyFile string
-	quit           chan struct{}
+	state         StateStore
+	blockfrostKey string
+	network       string
+	testnetMagic  string
+	// signingKeyFiles witness every transaction: the payment key plus any
+	// keys a multisig policy script requires.
+	signingKeyFiles []string
+	// tiers, when non-empty, replaces the single mintPrice with a set of
+	// price points each minting with its own metadata template.
+	tiers []Tier
+	// refundUnmatched refunds deposits that match no tier instead of ignoring them.
+	refundUnmatched bool
+	// traits, when set, assigns a seeded, shuffled trait set to each mint id.
+	traits *TraitPool
+	// manifest, when set, gives each mint id its own metadata and takes
+	// precedence over tier templates and traits.
+	manifest *Manifest
+	// paymentCred, when set, widens monitoring from monitorAddr to every
+	// address sharing its payment credential (bech32 addr_vkh1/script1).
+	paymentCred string
+	// refundGrace holds unmatched deposits this long before refunding, so a
+	// buyer can top up to a tier price with a follow-up transaction.
+	refundGrace time.Duration
+	heldMu      sync.Mutex
+	held        map[string]*heldDeposits // sender -> deposits awaiting top-up
+	// minConfirmations delays minting until a deposit is this deep.
+	minConfirmations int
+	// mockFile, when set, reads deposits from a JSON file instead of Blockfrost.
+	mockFile string
+	// cardano builds, signs and submits transactions: cardano-cli, or a
+	// mock with -mock-cardano.
+	cardano CardanoClient
+	// description is the collection-wide CIP-25 description; tiers and
+	// per-token "description" traits override it.
+	description string
+	// onPermanentFailure is what happens to a reserved id whose mint can
+	// never succeed: failureRetry, failureReuse or failureSkip.
+	onPermanentFailure string
+	// mintWorkers is the number of deposits processed concurrently.
+	mintWorkers int
+	// maxPerPoll caps the deposits handled per poll cycle; 0 means no cap.
+	maxPerPoll int
+	// timeLock is the minting script's before/after window; mint
+	// transactions are built inside it.
+	timeLock timeLock
+	// scriptSigners is how many key witnesses the minting script needs.
+	scriptSigners int
+	// tierPolicies maps the name of a tier with its own script to the
+	// policy it mints under; see policyFor.
+	tierPolicies map[string]mintPolicy
+	// ttlSlots is how many slots past the current one a transaction stays
+	// valid (its --invalid-hereafter), within the time lock.
+	ttlSlots int64
+	// assetName is the printf pattern naming default-tier tokens from their
+	// mint id, e.g. "Flowmass%d"; tiers with an asset_prefix name theirs
+	// with it.
+	assetName string
+	// plutus, when set, mints through a Plutus policy with a redeemer and
+	// collateral instead of the native script.
+	plutus *PlutusPolicy
+	// allowlist, when set, limits minting to listed senders; maxPerWallet
+	// caps the tokens any one sender can mint (0 = no cap). rejected
+	// records the skipped deposits already logged.
+	allowlist    *Allowlist
+	maxPerWallet int
+	rejected     sync.Map
+	// claimed tracks UTxOs spent or reserved during the current poll cycle
+	// (utxo -> claimSpent or owning deposit tx), so no two transactions
+	// spend one input.
+	claimMu sync.Mutex
+	claimed map[string]string
+	// utxos is the poll cycle's snapshot of the monitor address's UTxOs,
+	// queried once by the first mint that needs it (utxosOK) and shared
+	// by the rest.
+	utxoMu  sync.Mutex
+	utxos   []UTxO
+	utxosOK bool
+	// fundingAddr, when set, holds extra lovelace-only UTxOs that top up
+	// a mint the monitor address cannot cover; fundingUtxos is its
+	// snapshot, queried on first use each cycle like utxos.
+	fundingAddr    string
+	fundingUtxos   []UTxO
+	fundingUtxosOK bool
+	// metadataStandard is cip25 or cip68; with cip68 the (100) reference
+	// tokens are sent to refAddr.
+	metadataStandard string
+	refAddr          string
+	// locks holds the inputs of submitted transactions not yet seen in a
+	// block, keyed by deposit tx; they stay claimed across poll cycles.
+	locks map[string]inputLock
+	// changeAddr receives mint change: the monitor address unless
+	// -change-address sends it to a reserve wallet.
+	changeAddr string
+	// stateFile is the state's path, which names its backups.
+	stateFile string
+	// name is the collection this engine mints; log carries it as a field.
+	name string
+	log  *slog.Logger
+	// pollMu keeps polls from overlapping.
+	pollMu sync.Mutex
+	// reloadMu guards the settings Reload swaps (tiers, manifest,
+	// description, priceTolerance, maxPerWallet); polls hold it for reading.
+	reloadMu sync.RWMutex
+	// failures counts failed fetches and deposits, for -once's exit code.
+	failures atomic.Int64
+	// breaker spaces out and pauses polls while fetching deposits fails.
+	breaker pollBreaker
+	// started is set by Start, so only a running daemon announces its stop.
+	started atomic.Bool
+	// drained is set once Drain holds the poll lock.
+	drained atomic.Bool
+	quit    chan struct{}
+	// halt closes quit once, for Drain and Stop.
+	halt sync.Once
+	// positions caches deposit transactions' chain positions (txPosition).
+	positions sync.Map
+	// tip holds the latest background tip lag check, for /status.
+	tip tipMonitor
+	// openingAnnounced is set once a poll before the drop opens has sent
+	// the drop_opens notification.
+	openingAnnounced atomic.Bool
+	// reprocessTx is the deposit Reprocess is fetching despite its state.
+	reprocessTx string
+	// settings are the operational knobs set from flags.
+	settings engineSettings
 }
 
-// NewEngine creates a new minting engine.
-func NewEngine(monitorAddr string, mintPrice int64, policyID, scriptFile, stateFile, blockfrostKey, network, testnetMagic, signingKeyFile string) (*Engine, error) {
+// EngineConfig is what NewEngine builds an engine from: a collection's
+// addresses, policy and mint options, and the engine settings.
+type EngineConfig struct {
+	// Name identifies the collection in logs when several run in one
+	// process; it may be empty.
+	Name string
+	// MonitorAddr receives deposits. ChangeAddr (default MonitorAddr)
+	// receives mint change and FundingAddr optionally pays mint fees.
+	MonitorAddr string
+	ChangeAddr  string
+	FundingAddr string
+	// MintPrice is the lovelace a deposit pays per token, give or take
+	// PriceTolerance.
+	MintPrice      int64
+	PriceTolerance int64
+	// PolicyID is checked against ScriptFile's, or derived from it when
+	// empty. Plutus is set for Plutus minting policies.
+	PolicyID   string
+	ScriptFile string
+	Plutus     *PlutusPolicy
+	// StateFile and StateBackend select the state store.
+	StateFile    string
+	StateBackend string
+	// BlockfrostKey, Network and TestnetMagic select the chain. MockFile
+	// reads deposits from a file instead.
+	BlockfrostKey string
+	Network       string
+	TestnetMagic  string
+	MockFile      string
+	// SigningKeyFiles sign every mint.
+	SigningKeyFiles []string
+	// AssetName is the token name pattern, Description the metadata
+	// description and MetadataStandard cip25 or cip68; RefAddr holds CIP-68
+	// reference tokens.
+	AssetName        string
+	Description      string
+	MetadataStandard string
+	RefAddr          string
+	// Tiers, Traits, Manifest and Allowlist are optional.
+	Tiers     []Tier
+	Traits    *TraitPool
+	Manifest  *Manifest
+	Allowlist *Allowlist
+	// Deposit handling.
+	RefundUnmatched    bool
+	MatchPaymentCred   bool
+	RefundGrace        time.Duration
+	MinConfirmations   int
+	OnPermanentFailure string
+	MaxPerWallet       int
+	// Minting throughput and transaction validity.
+	MintWorkers int
+	MaxPerPoll  int
+	TTLSlots    int64
+	// Settings are the operational knobs set from flags.
+	Settings engineSettings
+}
+
+// NewEngine creates a new minting engine from cfg.
+func NewEngine(cfg EngineConfig, cardano CardanoClient) (*Engine, error) {
+	logger := engineLog
+	if cfg.Name != "" {
+		logger = engineLog.With("collection", cfg.Name)
+	}
+
+	if err := ValidateAddress(cfg.MonitorAddr, cfg.Network); err != nil {
+		return nil, fmt.Errorf("monitor address: %v", err)
+	}
+	if cfg.ChangeAddr == "" {
+		cfg.ChangeAddr = cfg.MonitorAddr
+	} else if err := ValidateAddress(cfg.ChangeAddr, cfg.Network); err != nil {
+		return nil, fmt.Errorf("change address: %v", err)
+	}
+	if cfg.FundingAddr != "" {
+		if err := ValidateAddress(cfg.FundingAddr, cfg.Network); err != nil {
+			return nil, fmt.Errorf("funding address: %v", err)
+		}
+		if cfg.FundingAddr == cfg.MonitorAddr {
+			return nil, fmt.Errorf("funding address must differ from the monitor address")
+		}
+	}
+	if cfg.AssetName == "" {
+		cfg.AssetName = defaultAssetName
+	}
+	if err := validateAssetName(cfg.AssetName); err != nil {
+		return nil, err
+	}
+	if cfg.MetadataStandard == "" {
+		cfg.MetadataStandard = metadataCIP25
+	}
+	if err := validateMetadataStandard(cfg.MetadataStandard, cfg.AssetName); err != nil {
+		return nil, err
+	}
+	if err := validateRefAddr(cfg.MetadataStandard, cfg.RefAddr, cfg.Network, cfg.MonitorAddr, cfg.ChangeAddr, cfg.FundingAddr); err != nil {
+		return nil, err
+	}
+	if cfg.TTLSlots <= 0 {
+		return nil, fmt.Errorf("-tx-ttl-slots must be positive, got %d", cfg.TTLSlots)
+	}
+	if cfg.PriceTolerance < 0 || (len(cfg.Tiers) == 0 && cfg.PriceTolerance >= cfg.MintPrice) {
+		return nil, fmt.Errorf("-price-tolerance must be between 0 and the mint price")
+	}
+	if cfg.OnPermanentFailure == "" {
+		cfg.OnPermanentFailure = failureRetry
+	}
+	if err := validFailurePolicy(cfg.OnPermanentFailure); err != nil {
+		return nil, err
+	}
+
+	var paymentCred string
+	if cfg.MatchPaymentCred {
+		var err error
+		if paymentCred, err = PaymentCredential(cfg.MonitorAddr); err != nil {
+			return nil, fmt.Errorf("cannot match by payment credential: %v", err)
+		}
+		logger.Info("monitoring all addresses with payment credential", "payment_credential", paymentCred)
+	}
+
 	// Load or initialize state
-	state, err := LoadState(stateFile)
+	state, err := OpenStateStore(cfg.StateBackend, cfg.StateFile, cfg.Settings.maxProcessed)
 	if err != nil {
 		return nil, err
 	}
+	// Close the store (and release its lock file) unless the engine is
+	// built.
+	built := false
+	defer func() {
+		if !built {
+			state.Close()
+		}
+	}()
 
-	// Ensure cardano-cli is present and can query the local node tip.
-	if err := ensureCardanoCLIAvailable(network, testnetMagic); err != nil {
+	// Fail fast on a key cardano-cli would reject at signing time.
+	for _, keyFile := range cfg.SigningKeyFiles {
+		keyType, err := cardano.ValidateSigningKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("signing key loaded", "file", keyFile, "key_type", keyType)
+	}
+
+	// A bad script path or a malformed script would only fail the first
+	// mint, and a key that is valid but not in the script only at submit.
+	// Plutus policies are checked by the node when the transaction is built.
+	var lock timeLock
+	var scriptSigners int
+	script, err := LoadNativeScript(cfg.ScriptFile)
+	switch {
+	case cfg.Plutus != nil:
+		if !isPlutusScript(cfg.ScriptFile) {
+			return nil, fmt.Errorf("script %s is not a Plutus script; drop -plutus-redeemer and -collateral for native-script minting", cfg.ScriptFile)
+		}
+		logger.Info("minting with Plutus policy", "script", cfg.ScriptFile, "redeemer", cfg.Plutus.Redeemer, "collateral", cfg.Plutus.Collateral)
+	case err != nil:
 		return nil, err
+	default:
+		if len(cfg.SigningKeyFiles) > 0 {
+			if err := cardano.CheckScriptSigners(script, cfg.ScriptFile, cfg.SigningKeyFiles); err != nil {
+				return nil, err
+			}
+		}
+		lock = script.window()
+		scriptSigners = script.requiredSigners()
+		if lock.before > 0 || lock.after > 0 {
+			logger.Info("minting script time lock", "after_slot", lock.after, "before_slot", lock.before)
+		}
+	}
+	if cfg.Settings.mintStartSlot > lock.after {
+		lock.after = cfg.Settings.mintStartSlot
+		logger.Info("mints open at -mint-start-slot", "slot", cfg.Settings.mintStartSlot)
 	}
 
-	// If we have a Blockfrost key, sync next mint counter with on-chain assets
-	if blockfrostKey == "" {
-		return nil, fmt.Errorf("no blockfrost key provided; skipping on-chain sync")
+	// A script for another policy mints tokens the metadata does not
+	// describe. Without -policy-id, the script's is used.
+	derived, err := cardano.PolicyID(cfg.ScriptFile)
+	switch {
+	case err != nil && cfg.PolicyID == "":
+		return nil, fmt.Errorf("no policy id given, and it cannot be derived from %s: %v", cfg.ScriptFile, err)
+	case err != nil:
+		logger.Warn("cannot derive policy id from minting script; not checked", "script", cfg.ScriptFile, "error", err)
+	case cfg.PolicyID == "":
+		cfg.PolicyID = derived
+		logger.Info("policy id derived from minting script", "script", cfg.ScriptFile, "policy_id", cfg.PolicyID)
+	case !strings.EqualFold(derived, cfg.PolicyID):
+		return nil, fmt.Errorf("minting script %s has policy id %s, but the configured policy id is %s; fix -policy-id or -script", cfg.ScriptFile, derived, cfg.PolicyID)
+	default:
+		// The derived id is lowercase hex, as metadata keys must be.
+		cfg.PolicyID = derived
+		logger.Info("minting script matches policy id", "script", cfg.ScriptFile, "policy_id", cfg.PolicyID)
 	}
-	maxOnChain, err := getMaxOnChainFlowmass(policyID, blockfrostKey, network)
-	if err == nil && maxOnChain+1 > state.NextMintCounter {
-		state.mu.Lock()
-		state.NextMintCounter = maxOnChain + 1
-		state.mu.Unlock()
-		if err := state.Save(); err != nil {
-			return nil, fmt.Errorf("failed to save state after syncing on-chain")
-		} else {
-			log.Printf("[engine] synced next_mint_counter to %d based on on-chain assets", state.NextMintCounter)
-		}
+
+	tierPolicies, err := loadTierPolicies(cfg.Tiers, cardano, cfg.SigningKeyFiles, cfg.Plutus, cfg.Settings.mintStartSlot, logger)
+	if err != nil {
+		return nil, err
 	}
 
-	// Reconcile any pending reservations saved from a previous run.
-	if len(state.PendingDeposits) > 0 {
-		if maxOnChain == 0 {
-			// try to fetch maxOnChain if not already available
-			if m, merr := getMaxOnChainFlowmass(policyID, blockfrostKey, network); merr == nil {
-				maxOnChain = m
-			}
+	// If we have a Blockfrost key, sync next mint counter with on-chain
+	// assets. Tiers under their own policies share the counter.
+	if cfg.BlockfrostKey != "" {
+		patterns := namePatterns(cfg.AssetName, cfg.Tiers)
+		if err := syncOnChainCounter(cfg.Settings.blockfrost, state, cfg.PolicyID, patterns, cfg.BlockfrostKey, cfg.Network); err != nil {
+			return nil, err
 		}
-		for tx, id := range state.PendingDeposits {
-			if maxOnChain >= id {
-				log.Printf("[engine] pending reservation for tx %s (id=%d) appears minted on-chain; marking processed", tx, id)
-				state.MarkProcessed(tx)
-				if err := state.ClearPending(tx); err != nil {
-					log.Printf("[engine] warning: failed to clear pending for %s: %v", tx, err)
-				}
-				if err := state.Save(); err != nil {
-					log.Printf("[engine] warning: failed to save state while reconciling pending: %v", err)
-				}
+		for _, p := range tierPolicies {
+			if err := syncOnChainCounter(cfg.Settings.blockfrost, state, p.ID, patterns, cfg.BlockfrostKey, cfg.Network); err != nil {
+				return nil, err
 			}
 		}
+	} else if cfg.MockFile != "" {
+		logger.Info("mock deposits enabled; skipping on-chain sync", "file", cfg.MockFile)
+	} else {
+		return nil, fmt.Errorf("no blockfrost key provided; skipping on-chain sync")
+	}
+
+	// The collateral is only forfeited if the script fails; never spend it.
+	claimed := make(map[string]string)
+	if cfg.Plutus != nil {
+		claimed[cfg.Plutus.Collateral] = claimSpent
 	}
 
+	built = true
 	return &Engine{
-		monitorAddr: monitorAddr,
-		mintPrice:   mintPrice,
-		policyID:    policyID,
-		scriptFile:  scriptFile,
+		monitorAddr:    cfg.MonitorAddr,
+		mintPrice:      cfg.MintPrice,
+		priceTolerance: cfg.PriceTolerance,
+		policyID:       cfg.PolicyID,
+		scriptFile:     cfg.ScriptFile,
 		// metadataFile:   metadataFile,
-		state:          state,
-		blockfrostKey:  blockfrostKey,
-		network:        network,
-		testnetMagic:   testnetMagic,
-		signingKeyFile: signingKeyFile,
-		quit:           make(chan struct{}),
+		state:              state,
+		blockfrostKey:      cfg.BlockfrostKey,
+		network:            cfg.Network,
+		testnetMagic:       cfg.TestnetMagic,
+		signingKeyFiles:    cfg.SigningKeyFiles,
+		tiers:              cfg.Tiers,
+		refundUnmatched:    cfg.RefundUnmatched,
+		traits:             cfg.Traits,
+		manifest:           cfg.Manifest,
+		paymentCred:        paymentCred,
+		refundGrace:        cfg.RefundGrace,
+		held:               make(map[string]*heldDeposits),
+		minConfirmations:   cfg.MinConfirmations,
+		mockFile:           cfg.MockFile,
+		cardano:            cardano,
+		onPermanentFailure: cfg.OnPermanentFailure,
+		mintWorkers:        cfg.MintWorkers,
+		maxPerPoll:         cfg.MaxPerPoll,
+		timeLock:           lock,
+		scriptSigners:      scriptSigners,
+		tierPolicies:       tierPolicies,
+		ttlSlots:           cfg.TTLSlots,
+		assetName:          cfg.AssetName,
+		stateFile:          cfg.StateFile,
+		changeAddr:         cfg.ChangeAddr,
+		fundingAddr:        cfg.FundingAddr,
+		metadataStandard:   cfg.MetadataStandard,
+		refAddr:            cfg.RefAddr,
+		plutus:             cfg.Plutus,
+		allowlist:          cfg.Allowlist,
+		maxPerWallet:       cfg.MaxPerWallet,
+		description:        cfg.Description,
+		name:               cfg.Name,
+		log:                logger,
+		claimed:            claimed,
+		locks:              make(map[string]inputLock),
+		quit:               make(chan struct{}),
+		breaker:            pollBreaker{threshold: cfg.Settings.breakerThreshold, cooldown: cfg.Settings.breakerCooldown},
+		settings:           cfg.Settings,
 	}, nil
 }
 
+// syncOnChainCounter moves the mint counter past the highest id already minted
+// under the policy. Pending reservations are settled by reconcilePending. A
+// failed lookup fails startup: minting from an unchecked counter could reuse
+// an id already on chain.
+func syncOnChainCounter(bf *blockfrostBudget, state StateStore, policyID string, patterns []string, blockfrostKey, network string) error {
+	maxOnChain, _, err := maxOnChainMintID(bf, policyID, patterns, blockfrostKey, network)
+	if err != nil {
+		return fmt.Errorf("failed to sync mint counter with the assets under policy %s: %w", policyID, err)
+	}
+	if maxOnChain+1 > state.Counter() {
+		if err := state.SetCounter(maxOnChain + 1); err != nil {
+			return fmt.Errorf("failed to save state after syncing on-chain: %w", err)
+		}
+		engineLog.Info("synced next_mint_counter from on-chain assets", "next_mint", state.Counter())
+	}
+	return nil
+}
+
+// warnTimeLock warns when the minting policy is not open at the current
+// slot: every mint would fail until it opens, or forever once it has locked.
+func (e *Engine) warnTimeLock() {
+	if e.timeLock.before == 0 && e.timeLock.after == 0 {
+		return
+	}
+	slot, err := e.currentSlot()
+	if err != nil {
+		return
+	}
+	if slot < e.timeLock.after {
+		e.log.Info("drop not open yet; deposits wait until it opens", "slot", slot, "opens_at", e.timeLock.after)
+	} else if _, _, err := e.timeLock.interval(slot, e.ttlSlots); err != nil {
+		e.log.Warn("minting policy is outside its time lock window; mints will fail", "slot", slot, "error", err)
+	} else if e.timeLock.before > 0 && slot+e.ttlSlots > e.timeLock.before {
+		e.log.Warn("-tx-ttl-slots reaches past the minting policy's time lock; transactions expire when it locks", "slot", slot, "ttl_slots", e.ttlSlots, "locks_at", e.timeLock.before)
+	}
+}
+
 // Start begins the deposit polling loop.
 func (e *Engine) Start() {
-	ticker := time.NewTicker(60 * time.Second)
+	// Nothing, reconciliation included, is trusted to a chain still syncing.
+	if !e.waitForSync() {
+		return
+	}
+	ticker := time.NewTicker(pollInterval)
 	defer ticker.Stop()
 
-	log.Println("[engine] Starting deposit polling (60s interval)")
+	// Settle mints from a previous run before any deposit is looked at.
+	e.reconcilePending()
+	e.clearDepositCursor()
+	e.warnTimeLock()
+
+	e.log.Info("starting deposit polling", "interval", pollInterval)
+	e.started.Store(true)
+	go e.monitorTip()
+	Notify(eventStarted, fmt.Sprintf("%s started %s on %s: monitoring %s, next mint id %d",
+		e.displayName(), version, e.network, truncateAddress(e.monitorAddr), e.state.Counter()))
 
 	// Do an immediate poll on startup so we don't wait for the first tick.
 	go func() {
@@ -111,537 +492,1056 @@ func (e *Engine) Start() {
 		case <-ticker.C:
 			e.pollDeposits()
 		case <-e.quit:
-			log.Println("[engine] Stopping")
+			e.log.Info("stopping")
 			return
 		}
 	}
 }
 
-// Stop signals the engine to halt.
+// RunOnce reconciles pending reservations and processes every deposit that
+// is currently eligible in a single poll, without starting the ticker. It
+// returns an error if the chain is not in sync, or if fetching or any
+// deposit failed.
+func (e *Engine) RunOnce() error {
+	if err := e.checkSync(); err != nil {
+		return err
+	}
+	e.reconcilePending()
+	e.clearDepositCursor()
+	e.failures.Store(0)
+	e.pollDeposits()
+	if err := e.state.Save(); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+	if n := e.failures.Load(); n > 0 {
+		return fmt.Errorf("%d failure(s) during poll", n)
+	}
+	return nil
+}
+
+// MintTo mints a token to recipient without a deposit, for giveaways,
+// team allocations and manual fixes, and returns the mint transaction
+// hash. With id 0 it takes the next id, as a paid mint would; otherwise
+// it reserves id, which must not be held by a reservation, a processed
+// deposit or a token already on chain. An id past the counter moves the
+// counter beyond it, so paid mints skip it. The trait supply caps manual
+// mints as it does paid ones; per-wallet caps do not. The mint is recorded
+// under a synthetic manual deposit key; if it fails, its reserved id is
+// released.
+func (e *Engine) MintTo(recipient string, id int) (string, error) {
+	e.reloadMu.RLock()
+	defer e.reloadMu.RUnlock()
+	dep := Deposit{
+		TxHash:        manualDepositKey(),
+		SenderAddr:    recipient,
+		Amount:        e.mintPrice,
+		Confirmations: -1,
+		manual:        true,
+	}
+	if e.settings.recipientGuard {
+		if err := checkRecipient(recipient, e.network); err != nil {
+			return "", fmt.Errorf("recipient rejected: %v", err)
+		}
+	}
+	if id > 0 {
+		if err := ValidateAddress(recipient, e.network); err != nil {
+			return "", fmt.Errorf("recipient: %v", err)
+		}
+		if err := e.checkIDOnChain(id); err != nil {
+			return "", err
+		}
+		if err := e.state.ReserveMintID(dep.TxHash, id); err != nil {
+			return "", err
+		}
+		e.log.Info("reserved explicit mint id", "deposit_tx", dep.TxHash, "mint_id", id)
+	}
+	if err := e.mintNFTsForDeposit(dep); err != nil {
+		e.auditFailure(dep, err)
+		if _, rerr := e.state.ReleaseMintID(dep.TxHash); rerr != nil {
+			e.log.Warn("failed to release mint id", "deposit_tx", dep.TxHash, "error", rerr)
+		}
+		return "", err
+	}
+	rec, _ := e.state.GetMintRecord(dep.TxHash)
+	e.log.Info("minted manually", "mint_id", rec.MintID, "token_name", rec.TokenName, "recipient", recipient, "tx_hash", rec.MintTxHash)
+	return rec.MintTxHash, e.state.Save()
+}
+
+// manualKeyPrefix starts the synthetic deposit keys of manual mints. No
+// deposit transaction stands behind them, so reconcile and stop refunds
+// skip their reservations.
+const manualKeyPrefix = "manual-"
+
+// manualDepositKey returns a new synthetic deposit key for a manual mint,
+// "manual-<unix nanoseconds>-<random hex>", so two mints started in the
+// same instant still get their own reservation.
+func manualDepositKey() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand: %v", err))
+	}
+	return fmt.Sprintf("%s%d-%s", manualKeyPrefix, time.Now().UnixNano(), hex.EncodeToString(b))
+}
+
+// isManualKey reports whether a pending or processed key belongs to a
+// manual mint.
+func isManualKey(key string) bool {
+	return strings.HasPrefix(key, manualKeyPrefix)
+}
+
+// splitPendingKey returns the deposit tx a pending key reserves for and the
+// token index of a multi-mint key ("<tx>-<n>"), or -1 for a key that is the
+// deposit tx alone.
+func splitPendingKey(key string) (string, int) {
+	tx, n, ok := strings.Cut(key, "-")
+	if !ok {
+		return key, -1
+	}
+	i, err := strconv.Atoi(n)
+	if err != nil {
+		return key, -1
+	}
+	return tx, i
+}
+
+// checkIDOnChain fails if any name mint id may carry is already on chain.
+// Without a Blockfrost key the check is skipped and only state is checked.
+func (e *Engine) checkIDOnChain(id int) error {
+	if e.blockfrostKey == "" {
+		e.log.Warn("no blockfrost key; mint id checked against state only", "mint_id", id)
+		return nil
+	}
+	for _, c := range e.mintCandidates(id) {
+		minted, err := assetMinted(e.settings.blockfrost, c.policy.ID+e.buyerAssetHex(c.name), e.blockfrostKey, e.network)
+		if err != nil {
+			return fmt.Errorf("failed to check %s on chain: %v", c.name, err)
+		}
+		if minted {
+			return fmt.Errorf("mint id %d is taken: %s is already on chain", id, c.name)
+		}
+	}
+	return nil
+}
+
+// Drain stops the engine from starting polls or mints and waits up to
+// timeout for the poll in progress, and the mints it has begun, to finish.
+// It reports whether the engine went idle in time. Call Stop afterwards.
+func (e *Engine) Drain(timeout time.Duration) bool {
+	e.halt.Do(func() { close(e.quit) })
+	deadline := time.Now().Add(timeout)
+	// The poll lock is kept, so no tick racing the quit starts a new poll.
+	for !e.pollMu.TryLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	e.drained.Store(true)
+	return true
+}
+
+// Stop signals the engine to halt. Engines that never started (one-off
+// commands) have nothing to refund; see StopBy.
 func (e *Engine) Stop() {
-	close(e.quit)
+	e.StopBy(time.Now())
+}
+
+// StopBy signals the engine to halt. With -refund-pending-on-stop, a
+// running daemon first refunds its pending deposits, until deadline.
+func (e *Engine) StopBy(deadline time.Time) {
+	e.halt.Do(func() { close(e.quit) })
+	if e.started.Load() && e.settings.refundPendingOnStop {
+		e.refundPending(deadline)
+	}
+	if e.started.Load() {
+		Notify(eventStopped, fmt.Sprintf("%s stopped, next mint id %d", e.displayName(), e.state.Counter()))
+	}
+	if err := e.state.Close(); err != nil {
+		e.log.Warn("failed to close state", "error", err)
+	}
+}
+
+// displayName names the engine in notifications: its collection, if any.
+func (e *Engine) displayName() string {
+	if e.name != "" {
+		return "Flowmass (" + e.name + ")"
+	}
+	return "Flowmass"
 }
 
 // pollDeposits checks for new 27 ADA deposits and mints NFTs.
 func (e *Engine) pollDeposits() {
-	log.Println("[engine] poll tick")
+	if !e.pollMu.TryLock() {
+		e.log.Info("previous poll still running; skipping tick")
+		return
+	}
+	defer e.pollMu.Unlock()
+	e.reloadMu.RLock()
+	defer e.reloadMu.RUnlock()
+
+	if !e.breaker.allow(time.Now()) {
+		e.log.Debug("deposit polling backed off; skipping tick")
+		return
+	}
+	if !e.dropOpen() {
+		return
+	}
+	e.log.Debug("poll tick")
 	deposits, err := e.fetchDeposits()
 	if err != nil {
-		log.Printf("[engine] error fetching deposits: %v", err)
+		e.log.Error("error fetching deposits", "error", err)
+		e.failures.Add(1)
+		e.fetchFailed(err)
 		return
 	}
+	e.fetchSucceeded()
 
+	// Skip deposits already handled and those still waiting for depth
+	// before handing the rest to the workers.
+	var ready []Deposit
 	for _, dep := range deposits {
 		// Check if already processed
 		if e.state.IsProcessed(dep.TxHash) {
 			continue
 		}
 
-		log.Printf("[engine] found deposit: %s -> %d lovelace (tx=%s)", dep.SenderAddr, dep.Amount, dep.TxHash)
+		if e.minConfirmations > 0 && dep.Confirmations >= 0 && dep.Confirmations < e.minConfirmations {
+			e.log.Info("waiting for deposit confirmations", "deposit_tx", dep.TxHash, "confirmations", dep.Confirmations, "required", e.minConfirmations)
+			continue
+		}
+		ready = append(ready, dep)
+	}
+	// Oldest first by chain position, so mint ids follow arrival order and
+	// are the same on a replay, and at most maxPerPoll per cycle. Deferred
+	// deposits keep their UTxOs reserved so this cycle's mints cannot spend
+	// them as funding.
+	sort.SliceStable(ready, func(i, j int) bool { return depositBefore(ready[i], ready[j]) })
+	var deferred []Deposit
+	if e.maxPerPoll > 0 && len(ready) > e.maxPerPoll {
+		deferred = ready[e.maxPerPoll:]
+		ready = ready[:e.maxPerPoll]
+		e.log.Info("deposit backlog exceeds -max-per-poll; deferring the newest", "processing", len(ready), "deferred", len(deferred))
+	}
 
-		dep.MintCount = int(dep.Amount / e.mintPrice)
-		log.Printf("[engine] deposit qualifies for %d mints", dep.MintCount)
-		// Mint NFT for this deposit
-		if dep.MintCount > 1 {
-			log.Printf("[engine] minting %d NFTs for deposit %s", dep.MintCount, dep.TxHash)
-			if err := e.mintNFTsForDeposit(dep); err != nil {
-				log.Printf("[engine] failed to mint for deposit %s: %v", dep.TxHash, err)
-				continue
-			}
-		} else {
-			if err := e.mintNFTForDeposit(dep); err != nil {
-				log.Printf("[engine] failed to mint for deposit %s: %v", dep.TxHash, err)
-				continue
+	e.expireLocks()
+	e.resetClaims(append(e.reservedDeposits(ready), deferred...))
+	e.runWorkers(ready)
+
+	if e.refundGrace > 0 {
+		e.settleHeldDeposits()
+	}
+}
+
+// reservedDeposits returns the deposits whose UTxOs must not fund other
+// mints this cycle: those that will be held or refunded, those already held
+// for a top-up, and dead-lettered ones.
+func (e *Engine) reservedDeposits(ready []Deposit) []Deposit {
+	var reserved []Deposit
+	for _, dep := range ready {
+		if (len(e.tiers) > 0 && dep.Tier == nil) || (len(e.tiers) == 0 && dep.Amount < e.mintPrice) {
+			reserved = append(reserved, dep)
+		}
+	}
+	e.heldMu.Lock()
+	for _, h := range e.held {
+		reserved = append(reserved, h.parts...)
+	}
+	e.heldMu.Unlock()
+	return append(reserved, e.deadLetterDeposits()...)
+}
+
+// processDeposit mints for, holds or refunds a single deposit. It runs on a
+// mint worker, so everything it touches must be safe for concurrent use.
+func (e *Engine) processDeposit(dep Deposit) {
+	e.log.Info("found deposit", "deposit_tx", dep.TxHash, "sender", dep.SenderAddr, "lovelace", dep.Amount)
+	e.audit(auditEvent{Event: auditDepositSeen, DepositTx: dep.TxHash, Sender: dep.SenderAddr, Lovelace: dep.Amount})
+	if !e.admitSender(dep) {
+		return
+	}
+	if !e.admitRecipient(dep) {
+		return
+	}
+
+	if len(e.tiers) > 0 {
+		if dep.Tier == nil && e.refundGrace > 0 {
+			e.holdDeposit(dep)
+			return
+		}
+		if dep.Tier == nil {
+			// fetchDeposits only returns unmatched deposits when refunds are enabled
+			e.refundOffPrice(dep)
+			return
+		}
+		if err := e.mintTierDeposit(dep); e.deferIfWalletEmpty(dep, err) || e.rejectIfCapped(dep, err) {
+			return
+		} else if err != nil {
+			e.log.Error("failed to mint for deposit", "deposit_tx", dep.TxHash, "error", err)
+			e.failures.Add(1)
+			e.auditFailure(dep, err)
+			if !e.settlePermanentFailure(dep, err) {
+				e.mintFailed(dep, err)
 			}
+			return
 		}
 
-		// Mark processed
-		e.state.MarkProcessed(dep.TxHash)
+		e.markProcessed(dep.TxHash)
 		if err := e.state.Save(); err != nil {
-			log.Printf("[engine] warning: failed to save state: %v", err)
+			e.log.Warn("failed to save state", "error", err)
+		}
+		return
+	}
+
+	if !e.paysMintPrice(dep.Amount) {
+		if e.refundGrace > 0 && dep.Amount < e.mintPrice {
+			e.holdDeposit(dep)
+			return
 		}
+		// fetchDeposits only returns other off-price deposits when refunds
+		// are enabled
+		e.refundOffPrice(dep)
+		return
+	}
+
+	dep.MintCount = int(dep.Amount / e.mintPrice)
+	e.log.Info("deposit qualifies for mints", "deposit_tx", dep.TxHash, "mint_count", dep.MintCount)
+	if err := e.mintNFTsForDeposit(dep); e.deferIfWalletEmpty(dep, err) || e.rejectIfCapped(dep, err) {
+		return
+	} else if err != nil {
+		e.log.Error("failed to mint for deposit", "deposit_tx", dep.TxHash, "error", err)
+		e.failures.Add(1)
+		e.auditFailure(dep, err)
+		if !e.settlePermanentFailure(dep, err) {
+			e.mintFailed(dep, err)
+		}
+		return
+	}
+
+	// Mark processed
+	e.markProcessed(dep.TxHash)
+	if err := e.state.Save(); err != nil {
+		e.log.Warn("failed to save state", "error", err)
+	}
+
+	e.log.Info("successfully minted NFT for deposit", "deposit_tx", dep.TxHash)
+
+	// max := GetOnChainCount(e.network, e.policyID, e.blockfrostKey)
+	// Webhook(fmt.Sprintf("Total Flowmass: %d", max))
+}
+
+// refundOffPrice refunds a deposit that pays no price and marks it
+// processed.
+func (e *Engine) refundOffPrice(dep Deposit) {
+	if err := e.refundDeposit(dep); err != nil {
+		e.log.Error("failed to refund deposit", "deposit_tx", dep.TxHash, "error", err)
+		e.failures.Add(1)
+		e.auditFailure(dep, err)
+		return
+	}
+	e.markProcessed(dep.TxHash)
+	if err := e.state.Save(); err != nil {
+		e.log.Warn("failed to save state", "error", err)
+	}
+}
 
-		log.Printf("[engine] successfully minted NFT for deposit %s", dep.TxHash)
+// mintTierDeposit mints for a deposit that pays a tier: one token, or the
+// tier's bundle in a single transaction. A nil tier mints one token.
+func (e *Engine) mintTierDeposit(dep Deposit) error {
+	dep.MintCount = 1
+	if dep.Tier != nil && dep.Tier.Quantity > 1 {
+		dep.MintCount = dep.Tier.Quantity
+		e.log.Info("minting bundle for deposit", "deposit_tx", dep.TxHash, "tier", dep.Tier.Name, "mint_count", dep.MintCount)
+	}
+	return e.mintNFTsForDeposit(dep)
+}
 
-		// max := GetOnChainCount(e.network, e.policyID, e.blockfrostKey)
-		// Webhook(fmt.Sprintf("Total Flowmass: %d", max))
+// deferIfWalletEmpty reports whether a mint failed only because the monitor
+// wallet is temporarily out of spendable UTxOs. Such a deposit waits for
+// the next poll, keeping its reservation, and is not counted as a failure.
+func (e *Engine) deferIfWalletEmpty(dep Deposit, err error) bool {
+	if !errors.Is(err, errWalletEmpty) {
+		return false
 	}
+	e.log.Info("monitor wallet has nothing to spend; deferring deposit to the next poll", "deposit_tx", dep.TxHash, "reason", err)
+	return true
+}
+
+// paysMintPrice reports whether a single-price deposit pays for one or more
+// mints: a multiple of the mint price, overpaid by at most the tolerance.
+func (e *Engine) paysMintPrice(lovelace int64) bool {
+	return lovelace >= e.mintPrice && lovelace%e.mintPrice <= e.priceTolerance
 }
 
 // fetchDeposits retrieves unprocessed deposits matching the mint price.
 func (e *Engine) fetchDeposits() ([]Deposit, error) {
-	return e.fetchDepositsBlockfrost()
+	if e.mockFile != "" {
+		return e.fetchDepositsMock()
+	}
+	return e.fetchDepositsBlockfrost(blockfrostBase(e.network))
 }
 
-// fetchDepositsBlockfrost queries Blockfrost for UTxOs.
-func (e *Engine) fetchDepositsBlockfrost() ([]Deposit, error) {
+// fetchDepositsBlockfrost queries the Blockfrost API at base for UTxOs.
+func (e *Engine) fetchDepositsBlockfrost(base string) ([]Deposit, error) {
 	lovelaceTarget := e.mintPrice
-	var base string
-	if e.network == "mainnet" {
-		base = "https://cardano-mainnet.blockfrost.io/api/v0"
-	} else {
-		base = "https://cardano-preprod.blockfrost.io/api/v0"
+	scan, latest := e.depositScanNeeded(base)
+	if !scan {
+		return nil, nil
 	}
-	url := fmt.Sprintf("%s/addresses/%s/utxos", base, e.monitorAddr)
-	log.Printf("[engine] fetching deposits from Blockfrost URL=%s", url)
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-	cmd := exec.CommandContext(ctx, "curl", "-s",
-		"-H", fmt.Sprintf("project_id:%s", e.blockfrostKey),
-		url)
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return nil, fmt.Errorf("blockfrost curl failed: %v; output: %s", err, strings.TrimSpace(string(out)))
+	// Blockfrost accepts a bech32 payment credential in place of an address,
+	// returning UTxOs at every address that shares it.
+	target := e.monitorAddr
+	if e.paymentCred != "" {
+		target = e.paymentCred
 	}
-
-	// Try parsing expected array response first
-	var utxos []struct {
-		TxHash string `json:"tx_hash"`
-		Amount []struct {
+	// order=asc lists UTxOs in chain order (block, then position in block),
+	// which is the arrival order deposits are minted in. Blockfrost pages
+	// them blockfrostPageSize at a time.
+	type addressUTxO struct {
+		TxHash      string `json:"tx_hash"`
+		OutputIndex int    `json:"output_index"`
+		Block       string `json:"block"`
+		Amount      []struct {
 			Unit     string `json:"unit"`
 			Quantity string `json:"quantity"`
 		} `json:"amount"`
 	}
-	if err := json.Unmarshal(out, &utxos); err != nil {
-		// Not the expected array — likely an error object from Blockfrost.
-		// Try to parse a common error shape and return a helpful message.
-		var errObj map[string]interface{}
-		if jerr := json.Unmarshal(out, &errObj); jerr == nil {
-			// include the full body in the error for easier debugging
-			return nil, fmt.Errorf("unexpected Blockfrost response for %s: %v", url, errObj)
+	var utxos []addressUTxO
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/addresses/%s/utxos?order=asc&count=%d&page=%d", base, target, blockfrostPageSize, page)
+		e.log.Debug("fetching deposits from Blockfrost", "url", url)
+		var batch []addressUTxO
+		if err := blockfrostGet(e.settings.blockfrost, e.blockfrostKey, url, &batch); isBlockfrostNotFound(err) {
+			// A never-used address is a 404, not an empty list.
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, batch...)
+		if len(batch) < blockfrostPageSize {
+			break
 		}
-		// Last resort: return raw body as string
-		return nil, fmt.Errorf("failed to parse Blockfrost utxos response: %v; raw=%s", err, strings.TrimSpace(string(out)))
 	}
 
 	var deposits []Deposit
-	for _, u := range utxos {
-		if e.state.IsProcessed(u.TxHash) {
+	unresolved := false // a deposit whose sender or chain position lookup failed
+	resolved := make(map[string]string)
+	defer e.cacheSenders(resolved)
+	for i, u := range utxos {
+		if e.settled(u.TxHash) {
 			continue
 		}
 		// Parse lovelace amount
 		var lovelace int64
+		hasAssets := false
+		var parseErr error
 		for _, a := range u.Amount {
 			if a.Unit == "lovelace" {
-				fmt.Sscanf(a.Quantity, "%d", &lovelace)
+				lovelace, parseErr = strconv.ParseInt(a.Quantity, 10, 64)
+			} else {
+				hasAssets = true
 			}
 		}
-		if lovelace%lovelaceTarget == 0 {
-			// Resolve sender from transaction inputs via Blockfrost /txs/{hash}/utxos
-			sender := "unknown"
-			txCtx, txCancel := context.WithTimeout(context.Background(), 15*time.Second)
-			defer txCancel()
-			txCmd := exec.CommandContext(txCtx, "curl", "-s",
-				"-H", fmt.Sprintf("project_id:%s", e.blockfrostKey),
-				fmt.Sprintf("%s/txs/%s/utxos", base, u.TxHash))
-			if txOut, err := txCmd.CombinedOutput(); err == nil {
-				var txDetails struct {
-					Inputs []struct {
-						Address string `json:"address"`
-					} `json:"inputs"`
-				}
-				if err := json.Unmarshal(txOut, &txDetails); err == nil && len(txDetails.Inputs) > 0 {
-					sender = txDetails.Inputs[0].Address
+		if parseErr != nil {
+			return nil, fmt.Errorf("utxo %s#%d: invalid lovelace quantity: %w", u.TxHash, u.OutputIndex, parseErr)
+		}
+
+		// Unmatched pure-ADA deposits are returned only so they can be held
+		// for a top-up (refund grace) or refunded.
+		var tier *Tier
+		unmatched := false
+		if len(e.tiers) > 0 {
+			tier = matchTier(e.tiers, lovelace, e.priceTolerance)
+			unmatched = tier == nil && (e.refundUnmatched || e.refundGrace > 0) && !hasAssets
+		} else if !e.paysMintPrice(lovelace) {
+			unmatched = (e.refundUnmatched || e.refundGrace > 0 && lovelace < lovelaceTarget) && !hasAssets
+		}
+		if tier != nil || unmatched || (len(e.tiers) == 0 && e.paysMintPrice(lovelace)) {
+			sender := e.resolveSender(base, u.TxHash, resolved)
+
+			if unmatched && (sender == "unknown" || e.isMonitored(sender)) {
+				// never refund our own change outputs or to an unresolved sender
+				unresolved = unresolved || sender == "unknown"
+				continue
+			}
+
+			pos, err := e.txPosition(base, u.TxHash)
+			if err != nil {
+				// Without its position the deposit could take another's id,
+				// and so could every later one: defer them all, so ids keep
+				// following arrival order.
+				e.log.Warn("failed to get deposit chain position; deferring it and later deposits to the next poll", "deposit_tx", u.TxHash, "utxos_left", len(utxos)-i, "error", err)
+				unresolved = true
+				break
+			}
+
+			confirmations := -1
+			if e.minConfirmations > 0 {
+				if c, err := e.blockConfirmations(base, u.Block); err == nil {
+					confirmations = c
+				} else {
+					// unknown depth: treat as unconfirmed and retry next poll
+					e.log.Warn("failed to get deposit confirmations", "deposit_tx", u.TxHash, "error", err)
+					confirmations = 0
 				}
-			} else {
-				log.Printf("[engine] warning: failed to resolve tx sender for %s: %v; out=%s", u.TxHash, err, strings.TrimSpace(string(txOut)))
 			}
 
 			deposits = append(deposits, Deposit{
-				TxHash:     u.TxHash,
-				SenderAddr: sender,
-				Amount:     lovelace,
+				TxHash:        u.TxHash,
+				OutputIndex:   u.OutputIndex,
+				SenderAddr:    sender,
+				Amount:        lovelace,
+				Tier:          tier,
+				Confirmations: confirmations,
+				Seq:           i,
+				BlockHeight:   pos.BlockHeight,
+				TxIndex:       pos.Index,
 			})
 		}
 	}
+	e.updateDepositCursor(latest, len(deposits) > 0 || unresolved)
 	return deposits, nil
 }
 
-// getMaxOnChainFlowmass queries Blockfrost for assets under the policy and
-// returns the maximum index N found for asset names decoding to "Flowmass N".
-func getMaxOnChainFlowmass(policyID, blockfrostKey, network string) (int, error) {
-	var base string
-	if network == "mainnet" {
-		base = "https://cardano-mainnet.blockfrost.io/api/v0"
-	} else {
-		base = "https://cardano-preprod.blockfrost.io/api/v0"
-	}
-	max := 0
-	// fetch several pages to be safer (pagination)
-	for page := 1; page <= 100; page++ {
-		// fetch this page
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		cmd := exec.CommandContext(ctx, "curl", "-s",
-			"-H", fmt.Sprintf("project_id:%s", blockfrostKey),
-			fmt.Sprintf("%s/assets/policy/%s?page=%d", base, policyID, page))
-		out, err := cmd.CombinedOutput()
-		cancel()
-		if err != nil {
-			return max, fmt.Errorf("blockfrost assets fetch failed: %v; output: %s", err, strings.TrimSpace(string(out)))
-		}
+// blockConfirmations returns the confirmation count of a block via Blockfrost.
+func (e *Engine) blockConfirmations(base, blockHash string) (int, error) {
+	e.settings.blockfrost.throttle()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "curl", "-s",
+		"-H", fmt.Sprintf("project_id:%s", e.blockfrostKey),
+		fmt.Sprintf("%s/blocks/%s", base, blockHash))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("blockfrost curl failed: %v; output: %s", err, strings.TrimSpace(string(out)))
+	}
+	var block struct {
+		Confirmations *int `json:"confirmations"`
+	}
+	if err := json.Unmarshal(out, &block); err != nil || block.Confirmations == nil {
+		return 0, fmt.Errorf("unexpected Blockfrost block response: %s", strings.TrimSpace(string(out)))
+	}
+	return *block.Confirmations, nil
+}
 
-		var assets []struct {
-			Asset    string `json:"asset"`
-			Quantity string `json:"quantity"`
+// witnessCount is the number of key witnesses to budget the fee of a
+// transaction under policy for: the payment key plus the signatures its
+// script requires, which cosigners may add outside flowmass, but never
+// fewer than the -signing-key files, since each of them signs. Refunds
+// mint nothing and pass the zero policy.
+func (e *Engine) witnessCount(policy mintPolicy) int {
+	return max(1+policy.signers, len(e.signingKeyFiles))
+}
+
+// tokenDescription picks a token's description: a "description" trait, then
+// the tier's, then the collection-wide description.
+func tokenDescription(tier *Tier, traits map[string]string, description string) string {
+	if d := traits["description"]; d != "" {
+		return d
+	}
+	if tier != nil && tier.Description != "" {
+		return tier.Description
+	}
+	return description
+}
+
+// tokenMetadata renders the metadata for one deposit's tokens under
+// policyID, each with its own traits and description.
+func (e *Engine) tokenMetadata(tier *Tier, policyID string, ids []int, displayNames []string, traits []map[string]string) (string, error) {
+	tokens := make([]TierMetadata, len(ids))
+	for i, id := range ids {
+		tokens[i] = TierMetadata{
+			ID:          id,
+			Name:        displayNames[i],
+			HexName:     hex.EncodeToString([]byte(displayNames[i])),
+			PolicyID:    policyID,
+			Traits:      traits[i],
+			Description: tokenDescription(tier, traits[i], e.description),
 		}
-		if err := json.Unmarshal(out, &assets); err != nil {
-			// try to report the body for easier debugging
-			var errObj map[string]interface{}
-			if jerr := json.Unmarshal(out, &errObj); jerr == nil {
-				return max, fmt.Errorf("unexpected Blockfrost response for assets (page=%d): %v", page, errObj)
-			}
-			return max, fmt.Errorf("failed to parse Blockfrost assets response (page=%d): %v; raw=%s", page, err, strings.TrimSpace(string(out)))
+	}
+	return renderMetadata(e.manifest, tier, tokens)
+}
+
+// renderMetadata renders tokens from the manifest when one is loaded, else
+// from the tier's template, else from the default template.
+func renderMetadata(manifest *Manifest, tier *Tier, tokens []TierMetadata) (string, error) {
+	policyID := tokens[0].PolicyID
+	if manifest != nil {
+		ids := make([]int, len(tokens))
+		names := make([]string, len(tokens))
+		descriptions := make([]string, len(tokens))
+		for i, t := range tokens {
+			ids[i], names[i], descriptions[i] = t.ID, t.Name, t.Description
 		}
-		if len(assets) == 0 {
-			break
+		return manifest.Render(policyID, ids, names, descriptions)
+	}
+	if tier != nil {
+		if len(tokens) == 1 {
+			return tier.RenderMetadata(tokens[0])
 		}
+		return tier.RenderMetadatas(tokens)
+	}
+	if len(tokens) == 1 {
+		return MetadataTemplate(policyID, tokens[0].HexName, tokens[0].Description)
+	}
+	hexNames := make([]string, len(tokens))
+	descriptions := make([]string, len(tokens))
+	for i, t := range tokens {
+		hexNames[i], descriptions[i] = t.HexName, t.Description
+	}
+	return MetadatasTemplate(policyID, hexNames, descriptions)
+}
 
-		for _, a := range assets {
-			// Blockfrost /assets/policy returns objects with `asset` which is
-			// policyID + hex(asset_name). Extract hex suffix and decode it.
-			assetStr := a.Asset
-			var assetNameHex string
-			if strings.HasPrefix(assetStr, policyID) {
-				assetNameHex = assetStr[len(policyID):]
-			} else {
-				// fallback: if asset doesn't start with policyID, try to parse whole string
-				assetNameHex = assetStr
-			}
+// currentSlot returns the chain tip slot from the local node, or from
+// Blockfrost in Blockfrost-only mode.
+func (e *Engine) currentSlot() (int64, error) {
+	return e.cardano.GetCurrentSlot()
+}
 
-			if assetNameHex == "" {
-				continue
-			}
-			if b, err := hex.DecodeString(assetNameHex); err == nil {
-				name := string(b)
-				if strings.HasPrefix(name, "Flowmass ") {
-					var n int
-					if _, err := fmt.Sscanf(name, "Flowmass %d", &n); err == nil {
-						if n > max {
-							max = n
-						}
-					}
-				}
-			}
-		}
+// submit broadcasts a signed transaction through the local node, or through
+// Blockfrost in Blockfrost-only mode, retrying transient failures with
+// backoff. A rejection for spent inputs is treated as success when the transaction
+// itself is already on chain.
+func (e *Engine) submit(signedFile string) (string, error) {
+	txHash, err := e.settings.submit.retrySubmit(func() (string, error) {
+		return e.cardano.SubmitTransaction(signedFile)
+	})
+	if err != nil {
+		return e.confirmLanded(signedFile, err)
 	}
-	return max, nil
+	return txHash, nil
 }
 
-// ensureCardanoCLIAvailable checks that `cardano-cli` is in PATH and that
-// `cardano-cli query tip` succeeds for the configured network. The engine
-// requires a working cardano node and CLI in order to mint.
-func ensureCardanoCLIAvailable(network, testnetMagic string) error {
-	if _, err := exec.LookPath("cardano-cli"); err != nil {
-		return fmt.Errorf("cardano-cli not found in PATH: %v", err)
+// markProcessed marks a deposit processed, alerting when the mark could
+// not be persisted.
+func (e *Engine) markProcessed(depositTx string) {
+	if err := e.state.MarkProcessed(depositTx); err != nil {
+		e.processedNotPersisted(depositTx, err)
 	}
+}
 
-	args := []string{"query", "tip"}
-	// append network and socket args (socketAndNetArgs validates testnetMagic and socket)
-	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
-	if err != nil {
-		return err
+// processedNotPersisted alerts that a handled deposit is processed in
+// memory only: after a restart it would be handled again.
+func (e *Engine) processedNotPersisted(depositTx string, err error) {
+	e.log.Error("failed to persist processed deposit", "deposit_tx", depositTx, "error", err)
+	Notify(eventFailure, fmt.Sprintf("%s: deposit %s was handled but could not be recorded processed (%v); fix the state store before restarting or it is handled again",
+		e.displayName(), depositTx, err))
+}
+
+// settled reports whether a deposit is done with: processed or dead
+// lettered. The deposit being reprocessed counts as unsettled, so it is
+// fetched again before its state is reset.
+func (e *Engine) settled(depositTx string) bool {
+	if depositTx == e.reprocessTx {
+		return false
 	}
-	args = append(args, netArgsWithSocket...)
-	cmd := exec.Command("cardano-cli", args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("cardano-cli query tip failed: %v; output: %s", err, strings.TrimSpace(string(out)))
+	return e.state.IsProcessed(depositTx) || e.state.IsDeadLettered(depositTx)
+}
+
+// isMonitored reports whether deposits to addr belong to this engine: addr is
+// the monitor address or, when matching by payment credential, shares it.
+func (e *Engine) isMonitored(addr string) bool {
+	if addr == e.monitorAddr {
+		return true
 	}
-	return nil
+	if e.paymentCred == "" {
+		return false
+	}
+	cred, err := PaymentCredential(addr)
+	return err == nil && cred == e.paymentCred
+}
+
+// mockDeposit is one entry of the mock deposits file. Beyond the basic
+// monitor/sender/amount/tx fields, entries can script error paths:
+//
+//	confirmations   current confirmation count (omit for "fully confirmed")
+//	shouldFailMint  make the mint fail after its id is reserved
+//	assets          non-lovelace assets carried by the deposit (unit -> qty)
+type mockDeposit struct {
+	Monitor        string            `json:"monitor"`
+	SenderAddr     string            `json:"sender"`
+	Amount         int64             `json:"amount"`
+	TxHash         string            `json:"tx"`
+	OutputIndex    int               `json:"output_index"`
+	Confirmations  *int              `json:"confirmations,omitempty"`
+	ShouldFailMint bool              `json:"shouldFailMint,omitempty"`
+	Assets         map[string]uint64 `json:"assets,omitempty"`
 }
 
-// fetchDepositsMock reads from mock_deposits.json for testing.
+// fetchDepositsMock reads deposits from the mock file for testing.
 func (e *Engine) fetchDepositsMock() ([]Deposit, error) {
-	const mockFile = "mock_deposits.json"
-	data, err := os.ReadFile(mockFile)
+	data, err := os.ReadFile(e.mockFile)
 	if err != nil {
-		// No mock file; return empty
-		return []Deposit{}, nil
+		return nil, fmt.Errorf("failed to read mock deposits: %w", err)
 	}
 
-	var mockDeposits []struct {
-		Monitor    string `json:"monitor"`
-		SenderAddr string `json:"sender"`
-		Amount     int64  `json:"amount"`
-		TxHash     string `json:"tx"`
-	}
+	var mockDeposits []mockDeposit
 	if err := json.Unmarshal(data, &mockDeposits); err != nil {
 		return nil, err
 	}
 
 	var deposits []Deposit
 	lovelaceTarget := e.mintPrice
-	for _, m := range mockDeposits {
-		if m.Monitor != e.monitorAddr || e.state.IsProcessed(m.TxHash) {
+	for i, m := range mockDeposits {
+		if !e.isMonitored(m.Monitor) || e.settled(m.TxHash) {
 			continue
 		}
-		if m.Amount == lovelaceTarget {
-			deposits = append(deposits, Deposit{
-				TxHash:     m.TxHash,
-				SenderAddr: m.SenderAddr,
-				Amount:     m.Amount,
-			})
+		hasAssets := len(m.Assets) > 0
+
+		// same eligibility rules as fetchDepositsBlockfrost
+		var tier *Tier
+		unmatched := false
+		if len(e.tiers) > 0 {
+			tier = matchTier(e.tiers, m.Amount, e.priceTolerance)
+			unmatched = tier == nil && (e.refundUnmatched || e.refundGrace > 0) && !hasAssets
+		} else if !e.paysMintPrice(m.Amount) {
+			unmatched = (e.refundUnmatched || e.refundGrace > 0 && m.Amount < lovelaceTarget) && !hasAssets
+		}
+		if tier == nil && !unmatched && (len(e.tiers) > 0 || !e.paysMintPrice(m.Amount)) {
+			continue
 		}
+
+		confirmations := -1
+		if m.Confirmations != nil {
+			confirmations = *m.Confirmations
+		}
+		deposits = append(deposits, Deposit{
+			TxHash:        m.TxHash,
+			OutputIndex:   m.OutputIndex,
+			SenderAddr:    m.SenderAddr,
+			Amount:        m.Amount,
+			Tier:          tier,
+			Confirmations: confirmations,
+			Assets:        m.Assets,
+			Seq:           i,
+			failMint:      m.ShouldFailMint,
+		})
 	}
 	return deposits, nil
 }
 
-// mintNFTForDeposit orchestrates the full minting workflow.
-func (e *Engine) mintNFTForDeposit(dep Deposit) error {
-	log.Printf("[engine] minting NFT for sender %s (tx=%s)", dep.SenderAddr, dep.TxHash)
+// mintNFTsForDeposit mints dep.MintCount tokens (one if unset) for a
+// deposit, all in ONE transaction to avoid multiple tx fees.
+func (e *Engine) mintNFTsForDeposit(dep Deposit) error {
+	policy := e.policyFor(dep.Tier)
+	count := max(dep.MintCount, 1)
+	e.log.Info("minting NFTs", "deposit_tx", dep.TxHash, "recipient", dep.SenderAddr, "mint_count", count)
+
+	if err := ValidateAddress(dep.SenderAddr, e.network); err != nil {
+		return fmt.Errorf("recipient: %v", err)
+	}
 
-	// Reserve and persist the next mint id for this deposit to avoid gaps
-	id, rerr := e.state.ReservePendingMint(dep.TxHash)
+	// Reserve and persist the deposit's mint ids as one block, so
+	// concurrent mints cannot interleave with it. A single token is
+	// reserved under the deposit's tx hash, several as "<tx>-<i>".
+	limit := e.capFor(dep)
+	var reservedIDs []int
+	var rerr error
+	if count == 1 {
+		var id int
+		id, rerr = e.state.ReservePendingMint(dep.TxHash, dep.SenderAddr, limit)
+		reservedIDs = []int{id}
+	} else {
+		reservedIDs, rerr = e.state.ReservePendingMints(dep.TxHash, dep.SenderAddr, count, limit)
+	}
 	if rerr != nil {
-		return fmt.Errorf("failed to reserve mint id: %v", rerr)
+		return fmt.Errorf("failed to reserve mint ids: %w", rerr)
+	}
+	e.audit(auditEvent{Event: auditReserved, DepositTx: dep.TxHash, MintID: reservedIDs[0]})
+	if dep.failMint {
+		return permanent(fmt.Errorf("mock: forced mint failure for reserved ids %v", reservedIDs))
+	}
+
+	// Render the metadata first: its size goes into the fee estimate.
+	price := e.mintPrice * int64(count)
+	if dep.Tier != nil {
+		price = dep.Tier.Price
+	}
+	var hexNames, displayNames []string
+	for _, id := range reservedIDs {
+		displayName := e.tokenName(id)
+		if dep.Tier != nil {
+			displayName = dep.Tier.DisplayName(e.assetName, id)
+		}
+		displayNames = append(displayNames, displayName)
+		hexNames = append(hexNames, hex.EncodeToString([]byte(displayName)))
+	}
+	// The trait supply caps the collection: every token of a bundle must
+	// fit, or none is minted.
+	traits := make([]map[string]string, len(reservedIDs))
+	if e.traits != nil {
+		for i, id := range reservedIDs {
+			var err error
+			if traits[i], err = e.traits.ForID(id); err != nil {
+				return permanent(err)
+			}
+			e.log.Info("assigned traits", "token_name", displayNames[i], "traits", traits[i], "seed", e.traits.Seed)
+		}
+	}
+	metadata, err := e.tokenMetadata(dep.Tier, policy.ID, reservedIDs, displayNames, traits)
+	if err != nil {
+		return permanent(fmt.Errorf("failed to build metadata: %v", err))
+	}
+	if err := ValidateMetadata(metadata, policy.ID); err != nil {
+		return permanent(fmt.Errorf("invalid metadata: %v", err))
+	}
+	metadataSize, err := checkMetadataSize(metadata, e.settings.maxMetadataBytes)
+	if err != nil {
+		return permanent(fmt.Errorf("invalid metadata: %v", err))
+	}
+	e.log.Info("metadata size", "deposit_tx", dep.TxHash, "bytes", metadataSize, "limit", e.settings.maxMetadataBytes)
+	if err := checkMetadataNames(metadata, policy.ID, hexNames); err != nil {
+		return permanent(fmt.Errorf("invalid metadata: %v", err))
+	}
+	if e.settings.ipfs.enabled {
+		if err := e.settings.ipfs.verifyIPFSMedia(metadata); err != nil {
+			return err
+		}
+	}
+	var datums []string
+	var refLovelace uint64
+	if e.metadataStandard == metadataCIP68 {
+		if datums, refLovelace, err = e.cip68Datums(metadata, policy.ID, hexNames); err != nil {
+			return permanent(fmt.Errorf("invalid metadata: %v", err))
+		}
 	}
-	// Display name and hex-encoded on-chain asset name
-	displayName := fmt.Sprintf("Flowmass%d", id)
-	hexName := hex.EncodeToString([]byte(displayName))
 
 	// Get current slot
-	slot, err := GetCurrentSlotNetwork(e.network, e.testnetMagic)
+	timer := e.startMintTimer(dep.TxHash)
+	defer timer.log()
+	slot, err := e.currentSlot()
 	if err != nil {
 		return fmt.Errorf("failed to get current slot: %v", err)
 	}
-	invalidHereafter := slot + 10000
+	timer.mark(phaseSlot)
+	invalidBefore, invalidHereafter, err := policy.lock.interval(slot, e.ttlSlots)
+	if err != nil {
+		return err
+	}
 
-	log.Printf("[engine] minting %s (hex=%s) (slot=%d, invalid-hereafter=%d)", displayName, hexName, slot, invalidHereafter)
+	e.log.Info("minting tokens", "deposit_tx", dep.TxHash, "slot", slot, "invalid_before", invalidBefore, "invalid_hereafter", invalidHereafter)
 
 	// 1. Get UTxO from monitor address (choose lovelace-only UTxOs that cover mint + fee buffer)
-	utxos, err := GetUTxOs(e.monitorAddr, e.network, e.testnetMagic)
+	utxos, err := e.monitorUTxOs()
 	if err != nil {
 		return fmt.Errorf("failed to get utxos: %v", err)
 	}
 
-	// collect strict lovelace-only candidates (no non-lovelace assets at all)
-	var candidates []UTxO
-	for _, u := range utxos {
-		if (u.Assets == nil || len(u.Assets) == 0) && u.Lovelace > 0 {
-			candidates = append(candidates, u)
-		}
+	if len(utxos) == 0 && len(dep.Parts) == 0 && e.fundingAddr == "" {
+		return fmt.Errorf("%w: no UTxOs left at monitor address", errWalletEmpty)
 	}
-	if len(candidates) == 0 {
-		// debug: report counts and sample UTxOs to help operator diagnose
-		total := len(utxos)
-		withAssets := 0
-		withLovelace := 0
-		for _, u := range utxos {
-			if u.Lovelace > 0 {
-				withLovelace++
-			}
-			if u.Assets != nil && len(u.Assets) > 0 {
-				withAssets++
-			}
-		}
-		log.Printf("[engine] debug: total_utxos=%d lovelace_utxos=%d utxos_with_assets=%d", total, withLovelace, withAssets)
-		for i, u := range utxos {
-			if i >= 8 {
-				break
-			}
-			log.Printf("[engine] debug utxo[%d]: id=%s lovelace=%d assets=%v", i, u.ID, u.Lovelace, u.Assets)
+	for i, u := range utxos {
+		if i >= 8 {
+			break
 		}
-		return fmt.Errorf("no lovelace-only UTxO available at monitor address")
+		e.log.Debug("utxo sample", "index", i, "utxo", u.ID, "lovelace", u.Lovelace, "assets", u.Assets)
 	}
 
-	// sort descending by lovelace to minimize inputs
-	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Lovelace > candidates[j].Lovelace })
-
-	// require mint price + buffer (2 ADA) to cover fees and change
-	required := uint64(e.mintPrice + 2000000)
-	var selectedIns []string
-	var sum uint64
-	for _, c := range candidates {
-		selectedIns = append(selectedIns, c.ID)
-		sum += c.Lovelace
-		if sum >= required {
-			break
-		}
+	// require mint price * count + estimated fee + -fee-buffer (change and
+	// slack). Combined deposits spend their own UTxOs first, topping up from
+	// the remaining candidates only if needed.
+	estFee := e.estimateMintFee(policy, len(dep.Parts)+2, len(hexNames), metadata)
+	required := uint64(price+estFee+e.settings.feeBuffer) + refLovelace
+	var forced []string
+	var forcedSum uint64
+	for _, p := range dep.Parts {
+		forced = append(forced, fmt.Sprintf("%s#%d", p.TxHash, p.OutputIndex))
+		forcedSum += uint64(p.Amount)
 	}
-	if sum < required {
-		return fmt.Errorf("insufficient lovelace in lovelace-only UTxOs: have=%d required=%d", sum, required)
+	selectedIns, sum, release, err := e.claimInputs(utxos, forced, forcedSum, required)
+	if err != nil {
+		return err
 	}
+	timer.mark(phaseUTxO)
+	submitted := false
+	defer func() {
+		if !submitted {
+			release()
+		}
+	}()
 
-	log.Printf("[engine] selected UTxOs: %v (total lovelace=%d)", selectedIns, sum)
+	e.log.Info("selected utxos", "deposit_tx", dep.TxHash, "utxos", selectedIns, "lovelace", sum, "required", required, "estimated_fee", estFee)
 
-	// 2. Build mint transaction
-	txFile, err := BuildTransaction(
-		selectedIns,
-		e.monitorAddr,
-		dep.SenderAddr,
-		hexName,
-		e.policyID,
-		e.scriptFile,
-		// e.metadataFile,
-		invalidHereafter,
-		e.network,
-		e.testnetMagic,
-	)
+	// 2. Build mint transaction that mints all NFTs
+	var txFile string
+	if e.metadataStandard == metadataCIP68 {
+		txFile, err = e.cardano.BuildCIP68Transaction(selectedIns, e.changeAddr, dep.SenderAddr, e.refAddr, hexNames, datums, policy.ID, policy.Script, invalidBefore, invalidHereafter, e.witnessCount(policy), e.plutus)
+	} else {
+		txFile, err = e.cardano.BuildTransactionMultipleMints(
+			selectedIns,
+			e.changeAddr,
+			dep.SenderAddr,
+			hexNames,
+			policy.ID,
+			policy.Script,
+			metadata,
+			invalidBefore,
+			invalidHereafter,
+			dep,
+			e.witnessCount(policy),
+			e.plutus,
+		)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to build transaction: %v", err)
+		err = fmt.Errorf("failed to build transaction: %w", err)
+		if errors.Is(err, errMetadataInvalid) {
+			return permanent(err)
+		}
+		return err
 	}
-	log.Printf("[engine] built transaction: %s", txFile)
+	e.log.Info("built transaction", "deposit_tx", dep.TxHash, "file", txFile)
+	fee := e.reportFee(dep.TxHash, txFile, estFee)
+	e.audit(auditEvent{Event: auditBuilt, DepositTx: dep.TxHash, MintID: reservedIDs[0], TokenName: strings.Join(displayNames, ","), Recipient: dep.SenderAddr, Fee: fee})
+	timer.mark(phaseBuild)
 
 	// 3. Sign transaction
-	signedFile, err := SignTransaction(txFile, e.signingKeyFile, e.network, e.testnetMagic)
+	defer e.cardano.CleanupTemp(txFile)
+	signedFile, err := e.cardano.SignTransaction(txFile, e.signingKeyFiles)
 	if err != nil {
 		return fmt.Errorf("failed to sign transaction: %v", err)
 	}
-	log.Printf("[engine] signed transaction: %s", signedFile)
+	timer.mark(phaseSign)
+	defer e.cardano.CleanupTemp(signedFile)
+	e.log.Info("signed transaction", "deposit_tx", dep.TxHash, "file", signedFile)
 
 	// 4. Submit transaction
-	txHash, err := SubmitTransaction(signedFile, e.network, e.testnetMagic)
+	txHash, err := e.submit(signedFile)
 	if err != nil {
 		return fmt.Errorf("failed to submit transaction: %v", err)
 	}
-	log.Printf("[engine] submitted transaction: %s", txHash)
-
-	// Mark deposit processed and clear pending reservation (persisting both changes)
-	e.state.MarkProcessed(dep.TxHash)
-	if err := e.state.ClearPending(dep.TxHash); err != nil {
-		// ClearPending persists state; if it fails, attempt a Save and warn
-		log.Printf("[engine] warning: failed to clear pending reservation: %v", err)
-		if serr := e.state.Save(); serr != nil {
-			log.Printf("[engine] warning: failed to save state after marking processed: %v", serr)
+	timer.mark(phaseSubmit)
+	e.log.Info("submitted transaction", "deposit_tx", dep.TxHash, "tx_hash", txHash)
+	submitted = true
+	e.audit(auditEvent{Event: auditSubmitted, DepositTx: dep.TxHash, Sender: dep.SenderAddr, Lovelace: dep.Amount, MintID: reservedIDs[0], TokenName: strings.Join(displayNames, ","), Recipient: dep.SenderAddr, TxHash: txHash, Fee: fee})
+	e.lockInputs(dep, txHash, invalidHereafter, selectedIns, true)
+
+	// Record the mint against the deposit and clear the pending reservations
+	names := displayNames
+	rec := MintRecord{
+		DepositTx:  dep.TxHash,
+		MintID:     reservedIDs[0],
+		TokenName:  strings.Join(names, ","),
+		Recipient:  dep.SenderAddr,
+		MintTxHash: txHash,
+	}
+	keys := []string{dep.TxHash}
+	if count > 1 {
+		rec.MintIDs = reservedIDs
+		keys = keys[:0]
+		for i := range reservedIDs {
+			keys = append(keys, fmt.Sprintf("%s-%d", dep.TxHash, i))
+		}
+	}
+	if err := e.state.RecordMint(rec); err != nil {
+		e.processedNotPersisted(dep.TxHash, err)
+	}
+	for _, key := range keys {
+		if err := e.state.ClearPending(key); err != nil {
+			// ClearPending persists state; if it fails, attempt a Save and warn
+			e.log.Warn("failed to clear pending reservation", "deposit_tx", key, "error", err)
+			if serr := e.state.Save(); serr != nil {
+				e.log.Warn("failed to save state after marking processed", "deposit_tx", dep.TxHash, "error", serr)
+			}
 		}
 	}
 
-	Webhook(fmt.Sprintf("Minted NFT: %s", displayName))
+	if e.notifyOnce(dep.TxHash, eventMinted) {
+		NotifyMinted(mintNotice{
+			TokenName: strings.Join(names, ", "),
+			Recipient: dep.SenderAddr,
+			TxHash:    txHash,
+			TxURL:     explorerTxURL(e.network, txHash),
+			Fee:       fee,
+		})
+	}
 
 	return nil
 }
 
-// Function MintNFTsForDeposit mints multiple NFTs for a single deposit.
-// Needs to do everything in ONE transaction per deposit to avoid multiple tx fees.
-func (e *Engine) mintNFTsForDeposit(dep Deposit) error {
-	log.Printf("[engine] minting %d NFTs for sender %s (tx=%s)", dep.MintCount, dep.SenderAddr, dep.TxHash)
+// refundDeposit returns an unmatched deposit to its sender by spending the
+// deposit UTxO back to the sender's address.
+func (e *Engine) refundDeposit(dep Deposit) error {
+	e.log.Info("refunding unmatched deposit", "deposit_tx", dep.TxHash, "recipient", dep.SenderAddr, "lovelace", dep.Amount)
 
-	// Reserve and persist the next mint ids for this deposit to avoid gaps
-	var reservedIDs []int
-	for i := 0; i < dep.MintCount; i++ {
-		id, rerr := e.state.ReservePendingMint(fmt.Sprintf("%s-%d", dep.TxHash, i))
-		if rerr != nil {
-			return fmt.Errorf("failed to reserve mint id: %v", rerr)
-		}
-		reservedIDs = append(reservedIDs, id)
+	if err := ValidateAddress(dep.SenderAddr, e.network); err != nil {
+		return fmt.Errorf("refund address: %v", err)
 	}
 
-	// Get current slot
-	slot, err := GetCurrentSlotNetwork(e.network, e.testnetMagic)
+	slot, err := e.currentSlot()
 	if err != nil {
 		return fmt.Errorf("failed to get current slot: %v", err)
 	}
-	invalidHereafter := slot + 10000
-
-	log.Printf("[engine] minting NFTs (slot=%d, invalid-hereafter=%d)", slot, invalidHereafter)
 
-	// 1. Get UTxO from monitor address (choose lovelace-only UTxOs that cover mint + fee buffer)
-	utxos, err := GetUTxOs(e.monitorAddr, e.network, e.testnetMagic)
+	utxoIn := fmt.Sprintf("%s#%d", dep.TxHash, dep.OutputIndex)
+	_, _, release, err := e.claimInputs(nil, []string{utxoIn}, uint64(dep.Amount), 0)
 	if err != nil {
-		return fmt.Errorf("failed to get utxos: %v", err)
-	}
-
-	// collect strict lovelace-only candidates (no non-lovelace assets at all)
-	var candidates []UTxO
-	for _, u := range utxos {
-		if (u.Assets == nil || len(u.Assets) == 0) && u.Lovelace > 0 {
-			candidates = append(candidates, u)
-		}
-	}
-	if len(candidates) == 0 {
-		return fmt.Errorf("no lovelace-only UTxO available at monitor address")
+		return err
 	}
-
-	// sort descending by lovelace to minimize inputs
-	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Lovelace > candidates[j].Lovelace })
-
-	// require mint price * count + buffer (2 ADA) to cover fees and change
-	required := uint64(e.mintPrice*int64(dep.MintCount) + 2000000)
-	var selectedIns []string
-	var sum uint64
-	for _, c := range candidates {
-		selectedIns = append(selectedIns, c.ID)
-		sum += c.Lovelace
-		if sum >= required {
-			break
+	submitted := false
+	defer func() {
+		if !submitted {
+			release()
 		}
-	}
-	if sum < required {
-		return fmt.Errorf("insufficient lovelace in lovelace-only UTxOs: have=%d required=%d", sum, required)
-	}
-
-	log.Printf("[engine] selected UTxOs: %v (total lovelace=%d)", selectedIns, sum)
+	}()
 
-	// 2. Build mint transaction that mints all NFTs
-	var hexNames []string
-	for _, id := range reservedIDs {
-		displayName := fmt.Sprintf("Flowmass%d", id)
-		hexName := hex.EncodeToString([]byte(displayName))
-		hexNames = append(hexNames, hexName)
-	}
-
-	txFile, err := BuildTransactionMultipleMints(
-		selectedIns,
-		e.monitorAddr,
-		dep.SenderAddr,
-		hexNames,
-		e.policyID,
-		e.scriptFile,
-		invalidHereafter,
-		e.network,
-		e.testnetMagic,
-		dep,
-	)
+	invalidHereafter := slot + e.ttlSlots
+	txFile, err := e.cardano.BuildRefundTransaction(utxoIn, dep.SenderAddr, invalidHereafter, e.witnessCount(mintPolicy{}))
 	if err != nil {
-		return fmt.Errorf("failed to build transaction: %v", err)
+		return err
 	}
-	log.Printf("[engine] built transaction: %s", txFile)
 
-	// 3. Sign transaction
-	signedFile, err := SignTransaction(txFile, e.signingKeyFile, e.network, e.testnetMagic)
+	defer e.cardano.CleanupTemp(txFile)
+	signedFile, err := e.cardano.SignTransaction(txFile, e.signingKeyFiles)
 	if err != nil {
-		return fmt.Errorf("failed to sign transaction: %v", err)
+		return fmt.Errorf("failed to sign refund: %v", err)
 	}
-	log.Printf("[engine] signed transaction: %s", signedFile)
+	defer e.cardano.CleanupTemp(signedFile)
 
-	// 4. Submit transaction
-	txHash, err := SubmitTransaction(signedFile, e.network, e.testnetMagic)
+	txHash, err := e.submit(signedFile)
 	if err != nil {
-		return fmt.Errorf("failed to submit transaction: %v", err)
+		return fmt.Errorf("failed to submit refund: %v", err)
 	}
-	log.Printf("[engine] submitted transaction: %s", txHash)
-
-	// Mark deposit processed and clear pending reservations (persisting both changes)
-	e.state.MarkProcessed(dep.TxHash)
-	if err := e.state.ClearPending(dep.TxHash); err != nil {
-		// ClearPending persists state; if it fails, attempt a Save and warn
-		log.Printf("[engine] warning: failed to clear pending reservation: %v", err)
-		if serr := e.state.Save(); serr != nil {
-			log.Printf("[engine] warning: failed to save state after marking processed: %v", serr)
-		}
+	submitted = true
+	e.lockInputs(dep, txHash, invalidHereafter, []string{utxoIn}, false)
+	e.log.Info("submitted refund", "deposit_tx", dep.TxHash, "tx_hash", txHash)
+	e.audit(auditEvent{Event: auditRefunded, DepositTx: dep.TxHash, Sender: dep.SenderAddr, Lovelace: dep.Amount, Recipient: dep.SenderAddr, TxHash: txHash})
+	e.releaseRefundedReservation(dep)
+
+	if e.notifyOnce(dep.TxHash, eventRefunded) {
+		Notify(eventRefunded, fmt.Sprintf("Refunded %d lovelace for deposit %s", dep.Amount, dep.TxHash))
 	}
 
-	Webhook(fmt.Sprintf("Minted %d NFTs for deposit %s", dep.MintCount, dep.TxHash))
-
 	return nil
 }
 
 // Deposit represents an incoming ADA transfer.
 type Deposit struct {
-	TxHash     string
-	SenderAddr string
-	Amount     int64
-	MintCount  int
+	TxHash      string
+	OutputIndex int
+	SenderAddr  string
+	Amount      int64
+	MintCount   int
+	Tier        *Tier     // matched price tier; nil when tiers are not configured or none match
+	Parts       []Deposit // deposits combined into this one within the refund grace window
+	// Confirmations is the deposit's confirmation depth, or -1 when not tracked.
+	Confirmations int
+	Assets        map[string]uint64 // non-lovelace assets on the deposit UTxO
+	// Seq is the deposit's position in arrival (chain) order; lower is older.
+	Seq int
+	// BlockHeight and TxIndex place the deposit's transaction on chain;
+	// zero when unknown (mock deposits).
+	BlockHeight int64
+	TxIndex     int
+	failMint    bool // mock only: force the mint to fail
+	manual      bool // minted by MintTo, outside the per-wallet cap
 }
 
 // Get the total count of minted NFTs on-chain
-func GetOnChainCount(network string, policyID, blockfrostKey string) int {
-	max, err := getMaxOnChainFlowmass(policyID, blockfrostKey, network)
+func GetOnChainCount(bf *blockfrostBudget, network string, policyID, blockfrostKey string) int {
+	max, _, err := maxOnChainMintID(bf, policyID, []string{defaultAssetName}, blockfrostKey, network)
 	if err != nil {
-		log.Printf("Error fetching on-chain count: %v", err)
+		engineLog.Error("error fetching on-chain count", "error", err)
 		return 0
 	}
-	log.Printf("Total on-chain Flowmass NFTs: %d", max)
+	engineLog.Info("on-chain Flowmass NFTs", "count", max)
 	return max
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	testPolicyID  = "1d0cf168b30d27c6619e7ca7c18e02c8cebc011bf056216a1ea829ff"
+	testKeyHash   = "5b06d6ab0b09dcd2e37de9d7a2f2e6f8d5a37f3e56b3cba8a2e7b9cf"
+	testMintPrice = 27_000_000
+)
+
+// testEngine is an engine minting through a mockClient, with its deposits
+// read from a mock deposits file and its audit trail kept for inspection.
+type testEngine struct {
+	*Engine
+	t         *testing.T
+	mock      *mockClient
+	deposits  string
+	auditPath string
+}
+
+// newTestEngine builds an engine on mainnet test addresses with a one-key
+// native script, the JSON state backend and a fresh mock chain. setup, if
+// not nil, adjusts the config first.
+func newTestEngine(t *testing.T, setup func(*EngineConfig)) *testEngine {
+	t.Helper()
+	cfg, mock, deposits, auditPath := testEngineConfig(t)
+	if setup != nil {
+		setup(&cfg)
+	}
+	e, err := NewEngine(cfg, mock)
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	t.Cleanup(e.Stop)
+	return &testEngine{Engine: e, t: t, mock: mock, deposits: deposits, auditPath: auditPath}
+}
+
+// testEngineConfig lays out a test engine's files in a temp dir and
+// returns its config, its mock chain and the paths of its mock deposits
+// and audit trail.
+func testEngineConfig(t *testing.T) (EngineConfig, *mockClient, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	work, err := newWorkDir(filepath.Join(dir, "work"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock, err := newMockClient(filepath.Join(dir, "chain"), work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "policy.script")
+	writeFile(t, script, `{"type": "sig", "keyHash": "`+testKeyHash+`"}`)
+	deposits := filepath.Join(dir, "deposits.json")
+	writeFile(t, deposits, "[]")
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	trail, err := openAuditLog(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trail.Close() })
+
+	cfg := EngineConfig{
+		MonitorAddr:     testMonitorAddr(t),
+		MintPrice:       testMintPrice,
+		PolicyID:        testPolicyID,
+		ScriptFile:      script,
+		StateFile:       filepath.Join(dir, "state.json"),
+		Network:         "mainnet",
+		MockFile:        deposits,
+		SigningKeyFiles: []string{filepath.Join("testdata", "keys", "payment.skey")},
+		MintWorkers:     1,
+		TTLSlots:        defaultTTLSlots,
+		Settings:        engineSettings{audit: trail},
+	}
+	return cfg, mock, deposits, auditPath
+}
+
+// useBlockfrost gives the engine a Blockfrost key once it is built, so
+// NewEngine does not sync its counter against the real API. Tests pass
+// their fake server's base to the calls they make.
+func (te *testEngine) useBlockfrost() {
+	te.blockfrostKey = testBlockfrostKey
+	te.settings.blockfrost = testBudget()
+}
+
+// writeFile writes content to path, failing the test on error.
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testMonitorAddr is the monitor address of test engines.
+func testMonitorAddr(t *testing.T) string {
+	return testAddress(t, "addr", 0x61, 0x01)
+}
+
+// testBuyer returns the enterprise address of buyer n.
+func testBuyer(t *testing.T, n byte) string {
+	return testAddress(t, "addr", 0x61, 0x80+n)
+}
+
+// testTxHash returns a deposit tx hash made of one repeated hex digit
+// pair, so tests can tell deposits apart at a glance.
+func testTxHash(n int) string {
+	return strings.Repeat(string("0123456789abcdef"[n/16%16])+string("0123456789abcdef"[n%16]), 32)
+}
+
+// setDeposits replaces the mock deposits at the monitor address.
+func (te *testEngine) setDeposits(deps ...mockDeposit) {
+	te.t.Helper()
+	for i := range deps {
+		if deps[i].Monitor == "" {
+			deps[i].Monitor = te.monitorAddr
+		}
+	}
+	data, err := json.Marshal(deps)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	writeFile(te.t, te.deposits, string(data))
+}
+
+// submitted returns the mock transactions submitted so far, ordered by
+// their first input for a stable order.
+func (te *testEngine) submitted() []mockTx {
+	te.t.Helper()
+	files, err := filepath.Glob(filepath.Join(te.mock.dir, "*.json"))
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	var txs []mockTx
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			te.t.Fatal(err)
+		}
+		var tx mockTx
+		if err := json.Unmarshal(data, &tx); err != nil {
+			te.t.Fatalf("%s: %v", f, err)
+		}
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return strings.Join(txs[i].Inputs, ",") < strings.Join(txs[j].Inputs, ",") })
+	return txs
+}
+
+// submittedKind returns the submitted mock transactions of one kind.
+func (te *testEngine) submittedKind(kind string) []mockTx {
+	var txs []mockTx
+	for _, tx := range te.submitted() {
+		if tx.Kind == kind {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+// auditEvents returns the audit trail written so far.
+func (te *testEngine) auditEvents() []auditEvent {
+	te.t.Helper()
+	f, err := os.Open(te.auditPath)
+	if err != nil {
+		te.t.Fatal(err)
+	}
+	defer f.Close()
+	var events []auditEvent
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev auditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			te.t.Fatalf("audit line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// audited reports whether the trail holds event for depositTx.
+func (te *testEngine) audited(event, depositTx string) bool {
+	for _, ev := range te.auditEvents() {
+		if ev.Event == event && ev.DepositTx == depositTx {
+			return true
+		}
+	}
+	return false
+}
+
+// poll runs one poll cycle.
+func (te *testEngine) poll() {
+	te.pollDeposits()
+}
+
+func TestMockDepositsWaitForConfirmations(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.MinConfirmations = 3
+	})
+	shallow, untracked := testTxHash(1), testTxHash(2)
+	depth := 1
+	te.setDeposits(
+		mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: shallow, Confirmations: &depth},
+		mockDeposit{SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: untracked},
+	)
+
+	te.poll()
+	if te.state.IsProcessed(shallow) {
+		t.Error("a deposit with 1 of 3 confirmations was minted")
+	}
+	if !te.state.IsProcessed(untracked) {
+		t.Error("a deposit without a confirmation count was not minted")
+	}
+
+	depth = 3
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: shallow, Confirmations: &depth})
+	te.poll()
+	if !te.state.IsProcessed(shallow) {
+		t.Error("a deposit at the required depth was not minted")
+	}
+	if n := len(te.submittedKind("mint")); n != 2 {
+		t.Errorf("got %d mints, want 2", n)
+	}
+}
+
+func TestMockDepositsForcedFailure(t *testing.T) {
+	te := newTestEngine(t, nil)
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: dep, ShouldFailMint: true})
+
+	te.poll()
+	if n := len(te.submitted()); n != 0 {
+		t.Fatalf("%d transactions submitted for a forced failure, want none", n)
+	}
+	if te.state.IsProcessed(dep) {
+		t.Error("a failed mint was marked processed")
+	}
+	if !te.audited(auditFailed, dep) {
+		t.Error("no failure audit entry")
+	}
+	if id, ok := te.state.Pending()[dep]; !ok || id != 1 {
+		t.Errorf("pending reservation = %d, %v; want id 1 kept for the retry", id, ok)
+	}
+}
+
+func TestMockDepositsUnreadable(t *testing.T) {
+	te := newTestEngine(t, nil)
+	if err := os.Remove(te.deposits); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := te.fetchDeposits(); err == nil || !strings.Contains(err.Error(), "failed to read mock deposits") {
+		t.Errorf("fetchDeposits() error = %v, want a read error", err)
+	}
+}
+
+// rejectingNode is a CardanoClient that builds and signs like the mock but
+// whose node rejects every submission.
+type rejectingNode struct {
+	*mockClient
+	submits int
+}
+
+func (n *rejectingNode) SubmitTransaction(signedFile string) (string, error) {
+	n.submits++
+	return "", errors.New("node rejected the transaction")
+}
+
+func TestEngineUsesItsCardanoClient(t *testing.T) {
+	te := newTestEngine(t, nil)
+	node := &rejectingNode{mockClient: te.mock}
+	te.cardano = node
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: dep})
+
+	te.poll()
+	if node.submits == 0 {
+		t.Fatal("the engine never submitted through its client")
+	}
+	if n := len(te.submitted()); n != 0 {
+		t.Errorf("%d transactions reached the mock chain past the rejecting client", n)
+	}
+	if te.state.IsProcessed(dep) || !te.audited(auditFailed, dep) {
+		t.Errorf("rejected deposit: processed %v, failure audited %v; want false, true", te.state.IsProcessed(dep), te.audited(auditFailed, dep))
+	}
+}
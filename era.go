@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// defaultEra is the cardano-cli era used when -era is not given.
+const defaultEra = "conway"
+
+// knownEras are the era command groups this tool supports.
+var knownEras = []string{"babbage", "conway"}
+
+// checkEra validates an -era value and returns it lowercased. The era must
+// match the one the node is in.
+func checkEra(era string) (string, error) {
+	era = strings.ToLower(era)
+	for _, known := range knownEras {
+		if era == known {
+			return era, nil
+		}
+	}
+	return "", fmt.Errorf("unknown era %q (want one of %s)", era, strings.Join(knownEras, ", "))
+}
+
+// eraMismatchRe matches the node's EraMismatch error, e.g.
+// EraMismatch {ledgerEraName = "Conway", otherEraName = "Babbage"}
+var eraMismatchRe = regexp.MustCompile(`EraMismatch\s*\{\s*ledgerEraName\s*=\s*"?(\w+)"?\s*,\s*otherEraName\s*=\s*"?(\w+)"?`)
+
+// parseEraMismatch reports whether cardano-cli output contains an
+// EraMismatch error, returning the node's era and the era the query used.
+func parseEraMismatch(output string) (nodeEra, queryEra string, ok bool) {
+	m := eraMismatchRe.FindStringSubmatch(output)
+	if m == nil {
+		if strings.Contains(output, "EraMismatch") {
+			return "", "", true
+		}
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// eraMismatchError turns EraMismatch output into an actionable error.
+func eraMismatchError(what, output string) error {
+	nodeEra, queryEra, _ := parseEraMismatch(output)
+	if nodeEra == "" {
+		return fmt.Errorf("%s: node and cardano-cli disagree on the ledger era (EraMismatch); upgrade cardano-cli to a release that supports the node's current era (output: %s)",
+			what, strings.TrimSpace(output))
+	}
+	return fmt.Errorf("%s: node is in the %s era but cardano-cli used the %s era (EraMismatch); upgrade cardano-cli or run flowmass with -era %s to match the node",
+		what, nodeEra, queryEra, strings.ToLower(nodeEra))
+}
+
+// runCardanoQuery runs a cardano-cli query. If the node answers with
+// EraMismatch and reports its era, the query is retried once under that
+// era's command group (e.g. `cardano-cli conway query tip`); a second
+// failure is reported with an actionable message.
+func runCardanoQuery(what string, args []string) ([]byte, error) {
+	out, err := exec.Command("cardano-cli", args...).CombinedOutput()
+	if err == nil {
+		return out, nil
+	}
+	nodeEra, _, mismatch := parseEraMismatch(string(out))
+	if !mismatch {
+		return out, fmt.Errorf("failed to %s: %w (output: %s)", what, err, string(out))
+	}
+	if nodeEra == "" {
+		return out, eraMismatchError("failed to "+what, string(out))
+	}
+
+	era := strings.ToLower(nodeEra)
+	cardanoLog.Warn("era mismatch; retrying with node era", "era", era, "query", args[0]+" "+args[1])
+	retry := append([]string{era}, args...)
+	out2, err := exec.Command("cardano-cli", retry...).CombinedOutput()
+	if err == nil {
+		return out2, nil
+	}
+	return out2, eraMismatchError("failed to "+what, string(out))
+}
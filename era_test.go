@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEraMismatch(t *testing.T) {
+	tests := []struct {
+		name, output      string
+		nodeEra, queryEra string
+		mismatch          bool
+	}{
+		{
+			name:     "quoted eras",
+			output:   `Command failed: query utxo Error: EraMismatch {ledgerEraName = "Conway", otherEraName = "Babbage"}`,
+			nodeEra:  "Conway",
+			queryEra: "Babbage",
+			mismatch: true,
+		},
+		{
+			name:     "bare eras",
+			output:   "EraMismatch { ledgerEraName = Conway , otherEraName = Babbage }",
+			nodeEra:  "Conway",
+			queryEra: "Babbage",
+			mismatch: true,
+		},
+		{name: "unparsed mismatch", output: "Error: EraMismatch (unexpected format)", mismatch: true},
+		{name: "other failure", output: "Network.Socket.connect: does not exist (No such file or directory)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodeEra, queryEra, mismatch := parseEraMismatch(tt.output)
+			if nodeEra != tt.nodeEra || queryEra != tt.queryEra || mismatch != tt.mismatch {
+				t.Errorf("parseEraMismatch() = %q, %q, %v; want %q, %q, %v", nodeEra, queryEra, mismatch, tt.nodeEra, tt.queryEra, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestEraMismatchError(t *testing.T) {
+	err := eraMismatchError("failed to query tip", `EraMismatch {ledgerEraName = "Conway", otherEraName = "Babbage"}`)
+	for _, want := range []string{"failed to query tip", "node is in the Conway era", "used the Babbage era", "-era conway"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	err = eraMismatchError("failed to query tip", "EraMismatch\n")
+	if !strings.Contains(err.Error(), "upgrade cardano-cli") || !strings.Contains(err.Error(), "output: EraMismatch)") {
+		t.Errorf("unparsed mismatch error = %q, want the upgrade hint and the trimmed output", err)
+	}
+}
+
+// fakeCardanoCLI puts a cardano-cli shell script running body first in PATH.
+func fakeCardanoCLI(t *testing.T, body string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cardano-cli"), []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunCardanoQueryRetriesUnderNodeEra(t *testing.T) {
+	fakeCardanoCLI(t, `if [ "$1" = conway ]; then echo "retried: $*"; exit 0; fi
+echo 'EraMismatch {ledgerEraName = "Conway", otherEraName = "Babbage"}'
+exit 1
+`)
+	out, err := runCardanoQuery("query tip", []string{"query", "tip", "--mainnet"})
+	if err != nil {
+		t.Fatalf("runCardanoQuery() error = %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "retried: conway query tip --mainnet" {
+		t.Errorf("output = %q, want the query retried under the conway group", got)
+	}
+}
+
+func TestRunCardanoQueryReportsPersistentMismatch(t *testing.T) {
+	fakeCardanoCLI(t, `echo 'EraMismatch {ledgerEraName = "Conway", otherEraName = "Babbage"}'
+exit 1
+`)
+	_, err := runCardanoQuery("query tip", []string{"query", "tip", "--mainnet"})
+	if err == nil || !strings.Contains(err.Error(), "-era conway") {
+		t.Errorf("runCardanoQuery() error = %v, want the actionable era mismatch error", err)
+	}
+}
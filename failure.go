@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// permanentError marks a mint failure that will recur on every retry (e.g.
+// metadata that does not render or build), as opposed to transient chain or
+// wallet problems.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err as a permanentError.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// isPermanent reports whether err is, or wraps, a permanentError.
+func isPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Policies for a reserved mint id whose mint fails permanently.
+const (
+	failureRetry = "retry" // keep the reservation and retry every poll (default)
+	failureReuse = "reuse" // hand the id back if it is the newest, else leave a gap
+	failureSkip  = "skip"  // burn the id: leave a recorded gap in the sequence
+)
+
+// validFailurePolicy checks a -on-permanent-failure value.
+func validFailurePolicy(policy string) error {
+	switch policy {
+	case failureRetry, failureReuse, failureSkip:
+		return nil
+	}
+	return fmt.Errorf("invalid -on-permanent-failure %q (want retry, reuse or skip)", policy)
+}
+
+// reservation is a mint id reserved for a deposit, under its pending key.
+type reservation struct {
+	key string
+	id  int
+}
+
+// depositReservations returns the ids reserved for depositTx, lowest first:
+// the one under the bare tx hash, or one per token under "<tx>-<i>" for
+// deposits minting several (tiers, bundles, grace-combined deposits).
+func (e *Engine) depositReservations(depositTx string) []reservation {
+	var res []reservation
+	for key, id := range e.state.Pending() {
+		if key == depositTx || strings.HasPrefix(key, depositTx+"-") {
+			res = append(res, reservation{key: key, id: id})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].id < res[j].id })
+	return res
+}
+
+// settlePermanentFailure applies the engine's failure policy to a deposit
+// whose mint failed permanently. It returns true when the deposit has been
+// settled (marked processed) and must not be retried. The buyer's lovelace
+// stays at the monitor address; an alert asks the operator to refund it.
+func (e *Engine) settlePermanentFailure(dep Deposit, cause error) bool {
+	if e.onPermanentFailure == failureRetry || !isPermanent(cause) {
+		return false
+	}
+
+	reserved := e.depositReservations(dep.TxHash)
+	switch e.onPermanentFailure {
+	case failureReuse:
+		if err := e.releaseIDs(reserved); err != nil {
+			return false
+		}
+		e.markProcessed(dep.TxHash)
+	case failureSkip:
+		for _, r := range reserved {
+			if err := e.state.ClearPending(r.key); err != nil {
+				e.log.Warn("failed to clear pending reservation", "deposit_tx", r.key, "error", err)
+				return false
+			}
+			// The record keeps the skipped id with no token or mint tx, so
+			// the gap is visible in the state file.
+			if err := e.state.RecordMint(MintRecord{DepositTx: r.key, MintID: r.id}); err != nil {
+				e.log.Warn("failed to record skipped mint id", "deposit_tx", r.key, "mint_id", r.id, "error", err)
+			}
+			e.log.Info("skipped mint id after permanent failure", "deposit_tx", r.key, "mint_id", r.id)
+		}
+		e.markProcessed(dep.TxHash)
+	}
+	e.unlockInputs(dep.TxHash)
+	for _, p := range dep.Parts {
+		e.markProcessed(p.TxHash)
+	}
+	if err := e.state.Save(); err != nil {
+		e.log.Warn("failed to save state", "error", err)
+	}
+
+	Notify(eventFailure, fmt.Sprintf("Mint for deposit %s failed permanently (%v); deposit settled without minting, refund %d lovelace to %s manually",
+		dep.TxHash, cause, dep.Amount, dep.SenderAddr))
+	return true
+}
+
+// releaseRefundedReservation drops the mint ids reserved for a deposit that
+// has just been refunded, e.g. a combined deposit whose mint failed before
+// its grace window ran out. The newest go back to the counter for the next
+// deposit; an older one is abandoned.
+func (e *Engine) releaseRefundedReservation(dep Deposit) {
+	e.releaseIDs(e.depositReservations(dep.TxHash))
+}
+
+// releaseIDs drops reservations, handing their ids back to the counter.
+// Only the newest ids can be handed back without leaving a hole, so they
+// are released from the highest down; an older one stays a gap. It stops
+// at the first reservation it fails to drop.
+func (e *Engine) releaseIDs(reserved []reservation) error {
+	for i := len(reserved) - 1; i >= 0; i-- {
+		r := reserved[i]
+		released, err := e.state.ReleaseMintID(r.key)
+		if err != nil {
+			e.log.Warn("failed to release mint id", "deposit_tx", r.key, "mint_id", r.id, "error", err)
+			return err
+		}
+		if released {
+			e.log.Info("released mint id for reuse", "deposit_tx", r.key, "mint_id", r.id)
+		} else {
+			e.log.Warn("mint id cannot be reused; later ids already reserved", "deposit_tx", r.key, "mint_id", r.id)
+		}
+	}
+	return nil
+}
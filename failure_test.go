@@ -0,0 +1,84 @@
+package main
+
+import "testing"
+
+func TestPermanentFailureReuse(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.OnPermanentFailure = failureReuse
+	})
+	failed, next := testTxHash(1), testTxHash(2)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: failed, ShouldFailMint: true})
+
+	te.poll()
+	if !te.state.IsProcessed(failed) {
+		t.Fatal("the failed deposit was not settled")
+	}
+	if len(te.state.Pending()) != 0 || te.state.Counter() != 1 {
+		t.Fatalf("pending %v, counter %d; want id 1 released", te.state.Pending(), te.state.Counter())
+	}
+
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: next})
+	te.poll()
+	if rec, ok := te.state.GetMintRecord(next); !ok || rec.MintID != 1 {
+		t.Errorf("next deposit minted %+v, %v; want the released id 1", rec, ok)
+	}
+}
+
+func TestPermanentFailureSkip(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.OnPermanentFailure = failureSkip
+	})
+	failed, next := testTxHash(1), testTxHash(2)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: failed, ShouldFailMint: true})
+
+	te.poll()
+	rec, ok := te.state.GetMintRecord(failed)
+	if !ok || rec.MintID != 1 || rec.MintTxHash != "" || rec.TokenName != "" {
+		t.Fatalf("failed deposit record = %+v, %v; want a gap at id 1 with no token", rec, ok)
+	}
+	if len(te.state.Pending()) != 0 {
+		t.Fatalf("pending %v after skipping, want none", te.state.Pending())
+	}
+
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: next})
+	te.poll()
+	if rec, ok := te.state.GetMintRecord(next); !ok || rec.MintID != 2 {
+		t.Errorf("next deposit minted %+v, %v; want id 2 after the gap", rec, ok)
+	}
+}
+
+func TestPermanentFailureSkipMultiMint(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.OnPermanentFailure = failureSkip
+	})
+	failed := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: 2 * testMintPrice, TxHash: failed, ShouldFailMint: true})
+
+	te.poll()
+	if !te.state.IsProcessed(failed) || len(te.state.Pending()) != 0 {
+		t.Fatalf("processed %v, pending %v; want the two-token deposit settled", te.state.IsProcessed(failed), te.state.Pending())
+	}
+	for i, key := range []string{failed + "-0", failed + "-1"} {
+		if rec, ok := te.state.GetMintRecord(key); !ok || rec.MintID != i+1 || rec.MintTxHash != "" {
+			t.Errorf("record %s = %+v, %v; want a gap at id %d", key, rec, ok, i+1)
+		}
+	}
+	if n := len(te.submitted()); n != 0 {
+		t.Errorf("%d transactions submitted, want none", n)
+	}
+}
+
+func TestPermanentFailureRetryKeepsReservation(t *testing.T) {
+	te := newTestEngine(t, nil)
+	failed := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: 2 * testMintPrice, TxHash: failed, ShouldFailMint: true})
+
+	te.poll()
+	te.poll()
+	if te.state.IsProcessed(failed) {
+		t.Error("the retry policy settled a failed deposit")
+	}
+	if got := len(te.state.Pending()); got != 2 || te.state.Counter() != 3 {
+		t.Errorf("pending %v, counter %d; want the same two ids kept across retries", te.state.Pending(), te.state.Counter())
+	}
+}
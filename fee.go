@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// defaultFeeBuffer is the lovelace selected inputs must hold beyond the
+// mint price and the estimated fee unless -fee-buffer says otherwise,
+// covering the change output's min-UTxO and any underestimate (e.g. Plutus
+// execution units).
+const defaultFeeBuffer int64 = 2_000_000
+
+// feeParams are the linear fee protocol parameters: fee = perByte * size +
+// fixed.
+type feeParams struct {
+	PerByte int64 `json:"txFeePerByte"`
+	Fixed   int64 `json:"txFeeFixed"`
+}
+
+// defaultFeeParams are mainnet's, used when the node cannot be asked.
+var defaultFeeParams = feeParams{PerByte: 44, Fixed: 155381}
+
+// fee returns the fee of a transaction of size bytes.
+func (p feeParams) fee(size int) int64 {
+	return p.PerByte*int64(size) + p.Fixed
+}
+
+// QueryFeeParams reads the fee parameters from the node's protocol
+// parameters.
+func QueryFeeParams(network, testnetMagic string) (feeParams, error) {
+	args := []string{"query", "protocol-parameters"}
+	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
+	if err != nil {
+		return feeParams{}, err
+	}
+	out, err := runCardanoQuery("query protocol parameters", append(args, netArgsWithSocket...))
+	if err != nil {
+		return feeParams{}, err
+	}
+	var p feeParams
+	if err := json.Unmarshal(out, &p); err != nil {
+		return feeParams{}, fmt.Errorf("failed to parse protocol parameters: %w", err)
+	}
+	if p.PerByte <= 0 {
+		return feeParams{}, fmt.Errorf("protocol parameters have no txFeePerByte")
+	}
+	return p, nil
+}
+
+// TxFee returns the fee of a built transaction, read back from its body.
+func (cli cardanoCLI) TxFee(txFile string) (int64, error) {
+	out, err := exec.Command("cardano-cli", "debug", "transaction", "view", "--output-json", "--tx-file", txFile).CombinedOutput()
+	if err != nil {
+		// cardano-cli before 10.x: transaction view under the era
+		if out, err = exec.Command("cardano-cli", cli.era, "transaction", "view", "--output-json", "--tx-file", txFile).CombinedOutput(); err != nil {
+			return 0, fmt.Errorf("failed to view transaction: %w (output: %s)", err, strings.TrimSpace(string(out)))
+		}
+	}
+	var view struct {
+		Fee json.RawMessage `json:"fee"`
+	}
+	if err := json.Unmarshal(out, &view); err != nil || view.Fee == nil {
+		return 0, fmt.Errorf("transaction view has no fee: %s", strings.TrimSpace(string(out)))
+	}
+	// The fee is a number or, depending on the version, "180109 Lovelace".
+	fee := strings.Trim(string(view.Fee), `"`)
+	fee = strings.TrimSuffix(strings.TrimSpace(fee), " Lovelace")
+	n, err := strconv.ParseInt(fee, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected fee %s in transaction view", view.Fee)
+	}
+	return n, nil
+}
+
+// estimateMintSize is a generous size estimate, in bytes, of a mint
+// transaction: the body with its inputs, one recipient output holding the
+// tokens plus change, the key witnesses, the minting script and the
+// metadata (its JSON is longer than the CBOR that goes on chain).
+func estimateMintSize(inputs, tokens, witnesses int, metadata, scriptFile string) int {
+	size := 250 + 45*inputs + 2*70 + 50*tokens + 105*witnesses + len(metadata)
+	if info, err := os.Stat(scriptFile); err == nil {
+		size += int(info.Size())
+	}
+	return size
+}
+
+// estimateMintFee estimates the fee of a mint transaction under policy with
+// the client's fee parameters, falling back to mainnet's.
+func (e *Engine) estimateMintFee(policy mintPolicy, inputs, tokens int, metadata string) int64 {
+	params, err := e.cardano.FeeParams()
+	if err != nil {
+		e.log.Warn("failed to read fee parameters; using mainnet defaults", "error", err)
+		params = defaultFeeParams
+	}
+	return params.fee(estimateMintSize(inputs, tokens, e.witnessCount(policy), metadata, policy.Script))
+}
+
+// reportFee logs the fee of a built transaction and returns it, or 0 if it
+// cannot be read.
+func (e *Engine) reportFee(depositTx, txFile string, estimated int64) int64 {
+	fee, err := e.cardano.TxFee(txFile)
+	if err != nil {
+		e.log.Warn("failed to read transaction fee", "deposit_tx", depositTx, "error", err)
+		return 0
+	}
+	e.log.Info("transaction fee", "deposit_tx", depositTx, "fee", fee, "estimated_fee", estimated)
+	return fee
+}
+
+// formatADA renders lovelace as ADA, e.g. "0.180109 ADA".
+func formatADA(lovelace int64) string {
+	return strconv.FormatFloat(float64(lovelace)/1_000_000, 'f', -1, 64) + " ADA"
+}
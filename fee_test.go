@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// walletNode is a mockClient whose addresses hold fixed UTxOs instead of
+// fresh ones on every query.
+type walletNode struct {
+	*mockClient
+	mu      sync.Mutex
+	wallets map[string][]UTxO
+	queries map[string]int
+}
+
+// useWallets switches te to a walletNode holding wallets.
+func (te *testEngine) useWallets(wallets map[string][]UTxO) *walletNode {
+	node := &walletNode{mockClient: te.mock, wallets: wallets, queries: make(map[string]int)}
+	te.cardano = node
+	return node
+}
+
+func (n *walletNode) GetUTxOs(address string) ([]UTxO, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queries[address]++
+	if len(n.wallets[address]) == 0 {
+		return nil, errNoUTxOs
+	}
+	return append([]UTxO(nil), n.wallets[address]...), nil
+}
+
+// fund replaces the UTxOs held at address.
+func (n *walletNode) fund(address string, utxos ...UTxO) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.wallets[address] = utxos
+}
+
+// queried returns how often address was queried.
+func (n *walletNode) queried(address string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.queries[address]
+}
+
+func TestMintInputsCoverFeeAndBuffer(t *testing.T) {
+	const buffer = 2_000_000
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.feeBuffer = buffer
+	})
+	policy := te.policyFor(nil)
+	// The price plus half the buffer covers the price but not the fee.
+	short := UTxO{ID: testTxHash(0xa0) + "#0", Lovelace: testMintPrice + buffer/2}
+	topUp := UTxO{ID: testTxHash(0xa1) + "#0", Lovelace: 3_000_000}
+	te.useWallets(map[string][]UTxO{te.monitorAddr: {short, topUp}})
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: dep})
+
+	if est := te.estimateMintFee(policy, 2, 1, "{}"); est <= 0 || uint64(testMintPrice+est+buffer) <= short.Lovelace {
+		t.Fatalf("estimated fee %d leaves %d lovelace enough on its own", est, short.Lovelace)
+	}
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 {
+		t.Fatalf("got %d mints, want 1", len(mints))
+	}
+	if want := []string{short.ID, topUp.ID}; !reflect.DeepEqual(mints[0].Inputs, want) {
+		t.Errorf("mint inputs = %v, want the short UTxO topped up: %v", mints[0].Inputs, want)
+	}
+}
+
+func TestMintRefusesInputsShortOfFee(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.feeBuffer = 2_000_000
+	})
+	te.useWallets(map[string][]UTxO{te.monitorAddr: {{ID: testTxHash(0xa0) + "#0", Lovelace: testMintPrice + 100_000}}})
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: dep})
+
+	te.poll()
+	if n := len(te.submitted()); n != 0 {
+		t.Fatalf("%d transactions built on inputs that cannot pay the fee", n)
+	}
+	if te.state.IsProcessed(dep) || !te.audited(auditFailed, dep) {
+		t.Errorf("processed %v, failure audited %v; want false, true", te.state.IsProcessed(dep), te.audited(auditFailed, dep))
+	}
+}
+
+func TestMintNoticeReportsFee(t *testing.T) {
+	hook := newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, hook.URL)
+	te := newTestEngine(t, nil)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: testTxHash(1)})
+
+	te.poll()
+	posts := hook.received()
+	if len(posts) != 1 {
+		t.Fatalf("webhook got %d posts, want 1", len(posts))
+	}
+	embeds, _ := posts[0]["embeds"].([]any)
+	if len(embeds) != 1 {
+		t.Fatalf("webhook post = %v, want one mint embed", posts[0])
+	}
+	embed, _ := embeds[0].(map[string]any)
+	fields, _ := embed["fields"].([]any)
+	for _, f := range fields {
+		if field, _ := f.(map[string]any); field["name"] == "Fee" {
+			if v, _ := field["value"].(string); v == "" || v == formatADA(0) {
+				t.Errorf("fee field = %q, want the built transaction's fee", v)
+			}
+			return
+		}
+	}
+	t.Errorf("mint embed fields = %v, want a Fee field", fields)
+}
+
+func TestFormatADA(t *testing.T) {
+	for lovelace, want := range map[int64]string{180109: "0.180109 ADA", 2_000_000: "2 ADA", 1_500_000: "1.5 ADA"} {
+		if got := formatADA(lovelace); got != want {
+			t.Errorf("formatADA(%d) = %q, want %q", lovelace, got, want)
+		}
+	}
+}
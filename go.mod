@@ -2,9 +2,10 @@ module github.com/36thchambersoftware/flowmass
 
 go 1.21
 
+require github.com/bwmarrin/discordgo v0.29.0
+
 require (
 	filippo.io/edwards25519 v1.0.0 // indirect
-	github.com/bwmarrin/discordgo v0.29.0 // indirect
 	github.com/echovl/cardano-go v0.1.14 // indirect
 	github.com/echovl/ed25519 v0.2.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
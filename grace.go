@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// heldDeposits are off-price deposits from one sender kept back during the
+// grace window, in case the buyer tops up to a price. When they add up, one
+// mint spends all of their UTxOs as inputs.
+type heldDeposits struct {
+	firstSeen time.Time
+	parts     []Deposit
+}
+
+// total returns the lovelace held for the sender.
+func (h *heldDeposits) total() int64 {
+	var sum int64
+	for _, p := range h.parts {
+		sum += p.Amount
+	}
+	return sum
+}
+
+// holdDeposit adds an unmatched deposit to its sender's held set. Deposits
+// stay unprocessed while held, so they are re-fetched every poll; repeats
+// are ignored.
+func (e *Engine) holdDeposit(dep Deposit) {
+	e.heldMu.Lock()
+	defer e.heldMu.Unlock()
+
+	h, ok := e.held[dep.SenderAddr]
+	if !ok {
+		h = &heldDeposits{firstSeen: time.Now()}
+		e.held[dep.SenderAddr] = h
+	}
+	for _, p := range h.parts {
+		if p.TxHash == dep.TxHash {
+			return
+		}
+	}
+	h.parts = append(h.parts, dep)
+	e.log.Info("holding off-price deposit for top-up", "deposit_tx", dep.TxHash, "sender", dep.SenderAddr,
+		"lovelace", dep.Amount, "held_lovelace", h.total(), "grace", e.refundGrace)
+}
+
+// heldMatch reports whether a held total pays for a mint, returning the
+// matched tier (nil in single-price mode).
+func (e *Engine) heldMatch(total int64) (*Tier, bool) {
+	if len(e.tiers) > 0 {
+		tier := matchTier(e.tiers, total, e.priceTolerance)
+		return tier, tier != nil
+	}
+	return nil, withinTolerance(total, e.mintPrice, e.priceTolerance)
+}
+
+// settleHeldDeposits mints for senders whose held deposits now add up to a
+// price, and refunds (or, without -refund, keeps with an alert) senders whose
+// grace window has expired.
+func (e *Engine) settleHeldDeposits() {
+	e.heldMu.Lock()
+	var settle []*heldDeposits
+	for sender, h := range e.held {
+		if _, ok := e.heldMatch(h.total()); ok || time.Since(h.firstSeen) >= e.refundGrace {
+			settle = append(settle, h)
+			delete(e.held, sender)
+		}
+	}
+	e.heldMu.Unlock()
+
+	for _, h := range settle {
+		if tier, ok := e.heldMatch(h.total()); ok {
+			e.mintHeldDeposits(h, tier)
+			continue
+		}
+		if !e.refundUnmatched {
+			e.keepExpiredDeposits(h)
+			continue
+		}
+		for _, dep := range h.parts {
+			if err := e.refundDeposit(dep); err != nil {
+				e.log.Error("failed to refund deposit", "deposit_tx", dep.TxHash, "error", err)
+				e.auditFailure(dep, err)
+				continue
+			}
+			e.markProcessed(dep.TxHash)
+		}
+		if err := e.state.Save(); err != nil {
+			e.log.Warn("failed to save state", "error", err)
+		}
+	}
+}
+
+// keepExpiredDeposits settles a sender's held deposits whose grace window
+// expired without -refund: the lovelace stays at the monitor address, so
+// each is recorded as a failure in the audit trail and the operator is
+// alerted to refund the sender by hand.
+func (e *Engine) keepExpiredDeposits(h *heldDeposits) {
+	var txs []string
+	for _, dep := range h.parts {
+		e.log.Warn("grace window expired without a matching price; deposit kept, refund it manually", "deposit_tx", dep.TxHash, "sender", dep.SenderAddr, "lovelace", dep.Amount)
+		e.auditFailure(dep, fmt.Errorf("grace window expired without a matching price; deposit kept without -refund"))
+		e.markProcessed(dep.TxHash)
+		txs = append(txs, dep.TxHash)
+	}
+	if err := e.state.Save(); err != nil {
+		e.log.Warn("failed to save state", "error", err)
+	}
+	Notify(eventFailure, fmt.Sprintf("%s: grace window expired for %d deposit(s) from %s totalling %d lovelace that match no price; kept without -refund, refund them manually: %s",
+		e.displayName(), len(h.parts), h.parts[0].SenderAddr, h.total(), strings.Join(txs, ", ")))
+}
+
+// mintHeldDeposits mints one token (or the tier's bundle) for a sender's
+// combined deposits, spending every deposit UTxO. The mint is recorded
+// against the first deposit; the rest are marked processed. tier is nil in
+// single-price mode.
+func (e *Engine) mintHeldDeposits(h *heldDeposits, tier *Tier) {
+	first := h.parts[0]
+	combined := Deposit{
+		TxHash:      first.TxHash,
+		OutputIndex: first.OutputIndex,
+		SenderAddr:  first.SenderAddr,
+		Amount:      h.total(),
+		Tier:        tier,
+		Parts:       h.parts,
+	}
+	e.log.Info("combined deposits reach price; minting", "deposit_tx", combined.TxHash, "sender", combined.SenderAddr, "deposits", len(h.parts), "lovelace", combined.Amount)
+
+	if err := e.mintTierDeposit(combined); err != nil {
+		e.log.Error("failed to mint for combined deposit", "deposit_tx", combined.TxHash, "error", err)
+		e.auditFailure(combined, err)
+		if e.settlePermanentFailure(combined, err) {
+			return
+		}
+		// put them back so the next poll retries within the same window
+		e.heldMu.Lock()
+		e.held[combined.SenderAddr] = h
+		e.heldMu.Unlock()
+		return
+	}
+
+	for _, p := range h.parts {
+		e.markProcessed(p.TxHash)
+	}
+	if err := e.state.Save(); err != nil {
+		e.log.Warn("failed to save state", "error", err)
+	}
+	Notify(eventCombined, fmt.Sprintf("Combined %d deposits from %s into one mint", len(h.parts), combined.SenderAddr))
+}
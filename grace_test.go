@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// expireGrace backdates sender's held deposits past the grace window.
+func (te *testEngine) expireGrace(sender string) {
+	te.t.Helper()
+	te.heldMu.Lock()
+	defer te.heldMu.Unlock()
+	h, ok := te.held[sender]
+	if !ok {
+		te.t.Fatalf("no deposits held for %s", sender)
+	}
+	h.firstSeen = time.Now().Add(-2 * te.refundGrace)
+}
+
+func TestGraceRefundsAfterWindow(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.RefundGrace = time.Hour
+		cfg.RefundUnmatched = true
+	})
+	buyer := testBuyer(t, 1)
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: buyer, Amount: 10_000_000, TxHash: dep})
+
+	te.poll()
+	if len(te.submitted()) != 0 || te.state.IsProcessed(dep) {
+		t.Fatalf("the deposit was settled inside the grace window: %d txs, processed %v", len(te.submitted()), te.state.IsProcessed(dep))
+	}
+
+	te.expireGrace(buyer)
+	te.poll()
+	refunds := te.submittedKind("refund")
+	if len(refunds) != 1 {
+		t.Fatalf("got %d refunds after the window, want 1", len(refunds))
+	}
+	if !slices.Equal(refunds[0].Inputs, []string{dep + "#0"}) || !slices.Equal(refunds[0].Outputs, []string{buyer}) {
+		t.Errorf("refund spends %v to %v, want the deposit back to %s", refunds[0].Inputs, refunds[0].Outputs, buyer)
+	}
+	if len(te.submittedKind("mint")) != 0 {
+		t.Error("an off-price deposit was minted")
+	}
+	if !te.state.IsProcessed(dep) || !te.audited(auditRefunded, dep) {
+		t.Errorf("processed %v, audited refund %v; want both", te.state.IsProcessed(dep), te.audited(auditRefunded, dep))
+	}
+}
+
+func TestRefundReleasesReservation(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.RefundGrace = time.Hour
+		cfg.RefundUnmatched = true
+	})
+	buyer := testBuyer(t, 1)
+	dep := testTxHash(1)
+	// A reservation left by a failed combined mint is the newest id.
+	id, err := te.state.ReservePendingMint(dep, buyer, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te.setDeposits(mockDeposit{SenderAddr: buyer, Amount: 10_000_000, TxHash: dep})
+
+	te.poll()
+	te.expireGrace(buyer)
+	te.poll()
+	if len(te.submittedKind("refund")) != 1 {
+		t.Fatal("the deposit was not refunded")
+	}
+	if len(te.state.Pending()) != 0 || te.state.Counter() != id {
+		t.Errorf("after the refund pending = %v, counter = %d; want no reservation and id %d handed back", te.state.Pending(), te.state.Counter(), id)
+	}
+}
+
+func TestRefundOffPriceWithoutGrace(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.RefundUnmatched = true
+	})
+	buyer := testBuyer(t, 1)
+	paid, under, over := testTxHash(1), testTxHash(2), testTxHash(3)
+	te.setDeposits(
+		mockDeposit{SenderAddr: buyer, Amount: testMintPrice, TxHash: paid},
+		mockDeposit{SenderAddr: buyer, Amount: testMintPrice / 2, TxHash: under},
+		mockDeposit{SenderAddr: buyer, Amount: testMintPrice * 3 / 2, TxHash: over},
+	)
+
+	te.poll()
+	if n := len(te.submittedKind("mint")); n != 1 {
+		t.Errorf("%d mints, want 1 for the deposit paying the price", n)
+	}
+	refunds := te.submittedKind("refund")
+	if len(refunds) != 2 {
+		t.Fatalf("got %d refunds, want one per off-price deposit", len(refunds))
+	}
+	for _, dep := range []string{under, over} {
+		if !te.state.IsProcessed(dep) || !te.audited(auditRefunded, dep) {
+			t.Errorf("off-price deposit %s: processed %v, audited refund %v; want both", dep, te.state.IsProcessed(dep), te.audited(auditRefunded, dep))
+		}
+	}
+}
+
+func TestGraceKeepsWithoutRefund(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.RefundGrace = time.Hour
+	})
+	buyer := testBuyer(t, 1)
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: buyer, Amount: 10_000_000, TxHash: dep})
+
+	te.poll()
+	te.expireGrace(buyer)
+	te.poll()
+	if n := len(te.submitted()); n != 0 {
+		t.Fatalf("%d transactions submitted for a kept deposit, want none", n)
+	}
+	if !te.state.IsProcessed(dep) {
+		t.Error("the kept deposit is not processed, so it would be held again")
+	}
+	if !te.audited(auditFailed, dep) {
+		t.Error("no failure audit entry for the kept deposit")
+	}
+}
+
+func TestGraceCombinesPartialDeposits(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.RefundGrace = time.Hour
+	})
+	buyer := testBuyer(t, 1)
+	first, second := testTxHash(1), testTxHash(2)
+	te.setDeposits(mockDeposit{SenderAddr: buyer, Amount: testMintPrice / 2, TxHash: first})
+
+	te.poll()
+	if len(te.submitted()) != 0 {
+		t.Fatal("a half payment was settled")
+	}
+
+	te.setDeposits(
+		mockDeposit{SenderAddr: buyer, Amount: testMintPrice / 2, TxHash: first},
+		mockDeposit{SenderAddr: buyer, Amount: testMintPrice - testMintPrice/2, TxHash: second},
+	)
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 {
+		t.Fatalf("got %d mints for the combined deposits, want 1", len(mints))
+	}
+	for _, dep := range []string{first, second} {
+		if !slices.Contains(mints[0].Inputs, dep+"#0") {
+			t.Errorf("the mint does not spend deposit %s: inputs %v", dep, mints[0].Inputs)
+		}
+		if !te.state.IsProcessed(dep) {
+			t.Errorf("deposit %s not processed", dep)
+		}
+	}
+	if n := len(mints[0].Mint); n != 1 {
+		t.Errorf("the combined deposits minted %d tokens, want 1", n)
+	}
+}
+
+func TestCombinedDepositsSpendEveryPart(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.RefundGrace = time.Hour
+	})
+	buyer := testBuyer(t, 1)
+	parts := []string{testTxHash(1), testTxHash(2), testTxHash(3)}
+	te.setDeposits(
+		mockDeposit{SenderAddr: buyer, Amount: 9_000_000, TxHash: parts[0]},
+		mockDeposit{SenderAddr: buyer, Amount: 9_000_000, TxHash: parts[1], OutputIndex: 1},
+		mockDeposit{SenderAddr: buyer, Amount: 9_000_000, TxHash: parts[2], OutputIndex: 2},
+	)
+
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 {
+		t.Fatalf("got %d mints, want 1 for the combined deposits", len(mints))
+	}
+	for i, dep := range parts {
+		if in := fmt.Sprintf("%s#%d", dep, i); !slices.Contains(mints[0].Inputs, in) {
+			t.Errorf("the mint does not spend part %s: inputs %v", in, mints[0].Inputs)
+		}
+		if !te.state.IsProcessed(dep) {
+			t.Errorf("part %s not processed", dep)
+		}
+	}
+	if rec, ok := te.state.GetMintRecord(parts[0]); !ok || rec.Recipient != buyer {
+		t.Errorf("mint record for the first part = %+v, %v; want one paying %s", rec, ok, buyer)
+	}
+
+	// Processed parts are never held or minted again.
+	te.poll()
+	if n := len(te.submitted()); n != 1 {
+		t.Errorf("%d transactions after a second poll, want still 1", n)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ipfsCheck configures the optional pre-mint media check (-verify-ipfs).
+// When enabled, every ipfs:// URI in a token's metadata must be retrievable
+// through gateway before the mint is built. If pinToken is set, media that
+// cannot be fetched is re-pinned through the IPFS Pinning Service API at
+// pinEndpoint; the mint is still postponed until a later poll finds it.
+type ipfsCheck struct {
+	enabled     bool
+	gateway     string
+	pinEndpoint string
+	pinToken    string
+}
+
+var ipfsClient = &http.Client{Timeout: 20 * time.Second}
+
+// newIPFSCheck returns the media check, disabled unless enabled is set.
+func newIPFSCheck(enabled bool, gateway, pinEndpoint, pinToken string) (ipfsCheck, error) {
+	if !enabled {
+		return ipfsCheck{}, nil
+	}
+	if gateway == "" {
+		return ipfsCheck{}, fmt.Errorf("-verify-ipfs needs an -ipfs-gateway")
+	}
+	if pinToken != "" && pinEndpoint == "" {
+		return ipfsCheck{}, fmt.Errorf("a pinning token needs -ipfs-pin-endpoint")
+	}
+	return ipfsCheck{
+		enabled:     true,
+		gateway:     strings.TrimSuffix(gateway, "/") + "/",
+		pinEndpoint: strings.TrimSuffix(pinEndpoint, "/"),
+		pinToken:    pinToken,
+	}, nil
+}
+
+// metadataIPFSPaths returns the distinct IPFS paths (CID plus any sub-path)
+// referenced by the image and files[].src fields of CIP-25 metadata. Values
+// split into 64-byte chunks are joined first.
+func metadataIPFSPaths(metadata string) ([]string, error) {
+	var root struct {
+		CIP25 map[string]map[string]struct {
+			Image interface{} `json:"image"`
+			Files []struct {
+				Src interface{} `json:"src"`
+			} `json:"files"`
+		} `json:"721"`
+	}
+	if err := json.Unmarshal([]byte(metadata), &root); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	add := func(v interface{}) {
+		uri := joinMetadataString(v)
+		if !strings.HasPrefix(uri, "ipfs://") {
+			return
+		}
+		path := strings.TrimPrefix(strings.TrimPrefix(uri, "ipfs://"), "ipfs/")
+		if path != "" && !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for _, assets := range root.CIP25 {
+		for _, a := range assets {
+			add(a.Image)
+			for _, f := range a.Files {
+				add(f.Src)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// joinMetadataString turns a metadata string or array of chunks back into
+// one string.
+func joinMetadataString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []interface{}:
+		var b strings.Builder
+		for _, part := range t {
+			if s, ok := part.(string); ok {
+				b.WriteString(s)
+			}
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// verifyIPFSMedia checks that every IPFS object in metadata answers a HEAD
+// request through the gateway. Unreachable objects are re-pinned when a
+// pinning token is configured. The returned error is not permanent: the
+// mint is retried on a later poll, once the media is back.
+func (c ipfsCheck) verifyIPFSMedia(metadata string) error {
+	paths, err := metadataIPFSPaths(metadata)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		headErr := c.headIPFS(path)
+		if headErr == nil {
+			continue
+		}
+		if c.pinToken == "" {
+			return fmt.Errorf("ipfs media %s is unreachable: %v", path, headErr)
+		}
+		cid, _, _ := strings.Cut(path, "/")
+		if err := c.pinIPFS(cid); err != nil {
+			return fmt.Errorf("ipfs media %s is unreachable (%v) and re-pinning failed: %v", path, headErr, err)
+		}
+		return fmt.Errorf("ipfs media %s was unreachable (%v); re-pinned %s, mint postponed", path, headErr, cid)
+	}
+	return nil
+}
+
+// headIPFS requests path from the gateway without downloading it.
+func (c ipfsCheck) headIPFS(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.gateway+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ipfsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pinIPFS asks the pinning service to pin cid (POST /pins of the IPFS
+// Pinning Service API).
+func (c ipfsCheck) pinIPFS(cid string) error {
+	body, err := json.Marshal(map[string]string{"cid": cid})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.pinEndpoint+"/pins", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.pinToken)
+	resp, err := ipfsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pinning service returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Text envelope types cardano-cli accepts for --signing-key-file when
+// witnessing with a payment key.
+const (
+	keyTypePaymentSigning         = "PaymentSigningKeyShelley_ed25519"
+	keyTypePaymentExtendedSigning = "PaymentExtendedSigningKeyShelley_ed25519_bip32"
+)
+
+// keyEnvelope is the cardano-cli text envelope wrapping every key file.
+type keyEnvelope struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	CborHex     string `json:"cborHex"`
+}
+
+// ValidateSigningKey reads a cardano-cli key file and checks that it is a
+// payment signing key, either normal or extended. It returns the envelope
+// type on success and a precise error naming the expected formats otherwise.
+func ValidateSigningKey(filePath string) (string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read signing key %s: %w", filePath, err)
+	}
+
+	var env keyEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return "", fmt.Errorf("signing key %s is not a cardano-cli text envelope: %v", filePath, err)
+	}
+
+	// cborHex is a CBOR byte string header followed by the raw key bytes:
+	// 5820 + 32 bytes for a normal key, 5880 + 128 bytes for an extended key.
+	var wantCbor string
+	var wantLen int
+	switch env.Type {
+	case keyTypePaymentSigning:
+		wantCbor, wantLen = "5820", 4+32*2
+	case keyTypePaymentExtendedSigning:
+		wantCbor, wantLen = "5880", 4+128*2
+	case "":
+		return "", fmt.Errorf("signing key %s has no \"type\" field; expected %s or %s", filePath, keyTypePaymentSigning, keyTypePaymentExtendedSigning)
+	default:
+		hint := ""
+		switch {
+		case strings.Contains(env.Type, "VerificationKey"):
+			hint = " (this is a verification key; point -signing-key at the matching .skey)"
+		case strings.HasPrefix(env.Type, "Stake"):
+			hint = " (this is a stake key; minting requires the payment key)"
+		}
+		return "", fmt.Errorf("signing key %s has type %q%s; expected %s or %s", filePath, env.Type, hint, keyTypePaymentSigning, keyTypePaymentExtendedSigning)
+	}
+
+	if !strings.HasPrefix(env.CborHex, wantCbor) || len(env.CborHex) != wantLen {
+		return "", fmt.Errorf("signing key %s is declared as %s but its cborHex is malformed (want %d hex chars starting with %s, got %d)", filePath, env.Type, wantLen, wantCbor, len(env.CborHex))
+	}
+
+	return env.Type, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSigningKey(t *testing.T) {
+	tests := []struct {
+		file     string
+		wantType string
+		wantErr  string
+	}{
+		{file: "payment.skey", wantType: keyTypePaymentSigning},
+		{file: "payment-extended.skey", wantType: keyTypePaymentExtendedSigning},
+		{file: "payment.vkey", wantErr: "this is a verification key"},
+		{file: "stake.skey", wantErr: "this is a stake key"},
+		{file: "truncated.skey", wantErr: "cborHex is malformed"},
+		{file: "extended-as-normal.skey", wantErr: "cborHex is malformed"},
+		{file: "untyped.skey", wantErr: `has no "type" field`},
+		{file: "not-json.skey", wantErr: "not a cardano-cli text envelope"},
+		{file: "missing.skey", wantErr: "failed to read signing key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := ValidateSigningKey(filepath.Join("testdata", "keys", tt.file))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ValidateSigningKey() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateSigningKey() error = %v", err)
+			}
+			if got != tt.wantType {
+				t.Errorf("ValidateSigningKey() = %q, want %q", got, tt.wantType)
+			}
+		})
+	}
+}
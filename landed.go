@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// spentInputErrors are ledger rejections that also occur when the exact same
+// transaction was already accepted: its inputs are now spent.
+var spentInputErrors = []string{"BadInputsUTxO", "ValueNotConservedUTxO", "All inputs are spent"}
+
+// isSpentInputError reports whether a submit error says the inputs are gone.
+func isSpentInputError(err error) bool {
+	msg := err.Error()
+	for _, s := range spentInputErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmLanded handles a submit rejected for spent inputs: if the signed
+// transaction itself is on chain (a resubmit after a timeout), it returns
+// the tx hash and a nil error. Otherwise the original error is returned.
+func (e *Engine) confirmLanded(signedFile string, submitErr error) (string, error) {
+	if !isSpentInputError(submitErr) {
+		return "", submitErr
+	}
+	txHash, err := e.cardano.TxID(signedFile)
+	if err != nil {
+		return "", submitErr
+	}
+	landed, err := e.cardano.TxOnChain(txHash)
+	if err != nil {
+		e.log.Warn("could not check whether rejected tx is on chain", "tx_hash", txHash, "error", err)
+		return "", submitErr
+	}
+	if !landed {
+		return "", submitErr
+	}
+	e.log.Info("submit rejected but transaction is already on chain; treating as success", "tx_hash", txHash)
+	return txHash, nil
+}
+
+// TxOnChain reports whether txHash has been included in a block, asking
+// Blockfrost when a key is configured and the local node otherwise.
+func TxOnChain(bf *blockfrostBudget, txHash, network, testnetMagic, blockfrostKey string) (bool, error) {
+	if blockfrostKey != "" {
+		var tx struct {
+			Hash string `json:"hash"`
+		}
+		err := blockfrostGet(bf, blockfrostKey, fmt.Sprintf("%s/txs/%s", blockfrostBase(network), txHash), &tx)
+		if err != nil {
+			if isBlockfrostNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		return tx.Hash == txHash, nil
+	}
+
+	// Every transaction here pays its first output to a recipient, so an
+	// unspent txHash#0 proves inclusion. (A spent one gives a false
+	// negative, which only means the original error is reported.)
+	args := []string{"query", "utxo", "--tx-in", txHash + "#0", "--output-json"}
+	netArgsWithSocket, err := socketAndNetArgs(network, testnetMagic)
+	if err != nil {
+		return false, err
+	}
+	args = append(args, netArgsWithSocket...)
+	out, err := exec.Command("cardano-cli", args...).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("failed to query tx output: %w (output: %s)", err, strings.TrimSpace(string(out)))
+	}
+	return strings.Contains(string(out), txHash), nil
+}
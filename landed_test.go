@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfirmLanded(t *testing.T) {
+	te := newTestEngine(t, nil)
+	landed := filepath.Join(t.TempDir(), "landed.signed")
+	writeFile(t, landed, `{"kind": "mint", "inputs": ["a#0"]}`)
+	txHash, err := te.mock.SubmitTransaction(landed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := filepath.Join(t.TempDir(), "pending.signed")
+	writeFile(t, pending, `{"kind": "mint", "inputs": ["b#0"]}`)
+
+	spent := errors.New(`transaction submit error: ShelleyTxValidationError ShelleyBasedEraConway (ApplyTxError (ConwayUtxowFailure (UtxoFailure (BadInputsUTxO (fromList [TxIn (TxId {unTxId = SafeHash "aa"}) (TxIx 0)])))))`)
+	tests := []struct {
+		name      string
+		signed    string
+		submitErr error
+		wantHash  string
+	}{
+		{name: "already in ledger", signed: landed, submitErr: spent, wantHash: txHash},
+		{name: "all inputs spent", signed: landed, submitErr: errors.New("All inputs are spent. Transaction has probably already been included"), wantHash: txHash},
+		{name: "spent by another transaction", signed: pending, submitErr: spent},
+		{name: "other rejection", signed: landed, submitErr: errors.New("FeeTooSmallUTxO")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := te.confirmLanded(tt.signed, tt.submitErr)
+			if tt.wantHash != "" {
+				if err != nil || got != tt.wantHash {
+					t.Errorf("confirmLanded() = %q, %v; want success with %s", got, err, tt.wantHash)
+				}
+				return
+			}
+			if err != tt.submitErr || got != "" {
+				t.Errorf("confirmLanded() = %q, %v; want the original error", got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockFile falls back to an O_EXCL lockfile where flock is unavailable. A
+// stale lockfile left by a crash must be removed by hand.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("state file %s is locked by another process", path)
+		}
+		return nil, fmt.Errorf("failed to create state lock file: %w", err)
+	}
+	return f, nil
+}
+
+// unlockFile releases a lock taken by lockFile by removing the lockfile.
+func unlockFile(f *os.File) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Remove(f.Name())
+}
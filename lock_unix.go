@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive, non-blocking advisory lock on path+".lock".
+// The lock is held until the returned file is closed (or the process exits).
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state lock file: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("state file %s is locked by another process", path)
+		}
+		return nil, fmt.Errorf("failed to lock state file %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// unlockFile releases a lock taken by lockFile. The lockfile itself is left
+// in place: removing it would let a waiting process lock a different inode.
+func unlockFile(f *os.File) error {
+	return f.Close()
+}
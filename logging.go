@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Component loggers. They are rebuilt by setupLogging once the handler is
+// configured so every line carries a "component" field.
+var (
+	engineLog  = component("engine")
+	cardanoLog = component("cardano")
+	stateLog   = component("state")
+	mainLog    = component("main")    // startup, signals and shutdown
+	notifyLog  = component("notify")  // webhooks and the notification queue
+	apiLog     = component("api")     // the -http-addr server
+	cmdLog     = component("command") // one-off commands
+)
+
+// component returns the default logger tagged with a component name.
+func component(name string) *slog.Logger {
+	return slog.Default().With("component", name)
+}
+
+// fatal logs msg at error level and exits, for errors that stop the
+// process outside an engine.
+func fatal(msg string, args ...any) {
+	mainLog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// setupLogging installs the default slog handler for the given level
+// (debug, info, warn, error) and format (text, json). Plain log.Printf calls,
+// e.g. from libraries, are routed through the same handler at info level.
+func setupLogging(level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q (want debug, info, warn or error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	engineLog = component("engine")
+	cardanoLog = component("cardano")
+	stateLog = component("state")
+	mainLog = component("main")
+	notifyLog = component("notify")
+	apiLog = component("api")
+	cmdLog = component("command")
+	return nil
+}
@@ -2,53 +2,212 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
+	// The first argument may name a subcommand. One-off tools take their own
+	// flags; run (the default), status, mint-to and reprocess share the
+	// daemon's.
+	args := os.Args[1:]
+	mode := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		var run func([]string) error
+		switch args[0] {
+		case "reset-state":
+			run = runResetState
+		case "recover-counter":
+			run = runRecoverCounter
+		case "burn":
+			run = runBurn
+		case "royalty":
+			run = runRoyalty
+		case "refund":
+			run = runRefund
+		case "requeue":
+			run = runRequeue
+		case "preview-metadata":
+			run = runPreviewMetadata
+		case "run", "status", "mint-to", "reprocess":
+			mode = args[0]
+		case "mint-one":
+			mode = "mint-to"
+		default:
+			fatal("unknown command (want run, status, mint-to, reprocess, burn, refund, requeue, royalty, preview-metadata, reset-state or recover-counter)", "command", args[0])
+		}
+		if run != nil {
+			if err := run(args[1:]); err != nil {
+				fatal("command failed", "command", args[0], "error", err)
+			}
+			return
+		}
+		args = args[1:]
+	}
+
 	blockfrostKey := flag.String("blockfrost-key", os.Getenv("BLOCKFROST_API_KEY"), "Blockfrost API key for deposit tracking")
+	blockfrostRPS := flag.Float64("blockfrost-rps", defaultBlockfrostRPS, "Most Blockfrost requests per second, shared by every collection (0 = unlimited)")
+	blockfrostBurst := flag.Int("blockfrost-burst", defaultBlockfrostBurst, "Blockfrost requests allowed in a burst above -blockfrost-rps")
+	blockfrostDailyLimit := flag.Int("blockfrost-daily-limit", 0, "Blockfrost plan's daily request quota, for the remaining-budget metric and a warning at 90% (0 = unknown)")
 	monitorAddr := flag.String("monitor-address", os.Getenv("MONITOR_ADDRESS"), "Cardano address to monitor for deposits")
-	policyID := flag.String("policy-id", os.Getenv("POLICY_ID"), "NFT minting policy ID")
+	policyID := flag.String("policy-id", os.Getenv("POLICY_ID"), "NFT minting policy ID; checked against -script, or derived from it when empty")
 	scriptFile := flag.String("script", os.Getenv("SCRIPT_FILE"), "Path to minting script file (e.g., policy.script)")
 	// metadataFile := flag.String("metadata", os.Getenv("METADATA_FILE"), "Path to metadata template JSON")
 	stateFile := flag.String("state", os.Getenv("STATE_FILE"), "Path to state file (tracks mint counter and processed deposits)")
+	stateBackend := flag.String("state-backend", envOr("STATE_BACKEND", "json"), "State storage backend: json or sqlite")
+	maxProcessed := flag.Int("state-max-processed", defaultMaxProcessed, "Processed deposits kept in a json state file; older ones move to <state>.processed.log and are still never minted twice (0 = keep all)")
 	mintPrice := flag.Int64("mint-price", 32000000, "Mint price in lovelace (default: 32000000)")
-	signingKeyFile := flag.String("signing-key", os.Getenv("SIGNING_KEY_FILE"), "Path to signing key for transaction signing")
+	priceTolerance := flag.Int64("price-tolerance", 0, "Accept deposits overpaying the price (or a tier price) by up to this many lovelace; the excess is kept as a tip")
+	allowlistFile := flag.String("allowlist", os.Getenv("ALLOWLIST_FILE"), "File of sender addresses allowed to mint, one per line with an optional per-wallet cap; reloaded on SIGHUP")
+	maxPerWallet := flag.Int("max-per-wallet", 0, "Most tokens one sender may mint; further deposits are refunded with -refund, otherwise skipped (0 = no cap; an allowlist cap takes precedence)")
+	var signingKeyFiles stringList
+	flag.Var(&signingKeyFiles, "signing-key", "Path to signing key for transaction signing; repeat for each key a multisig policy requires (default: SIGNING_KEY_FILE)")
+	signingBackend := flag.String("signing-backend", envOr("SIGNING_BACKEND", "file"), "How -signing-key files sign: file (cardano-cli .skey) or hw (cardano-hw-cli .hwsfile on a Ledger/Trezor)")
+	hwDerivationPath := flag.String("hw-derivation-path", envOr("HW_DERIVATION_PATH", defaultHWDerivationPath), "Derivation path used to create a missing -signing-key .hwsfile with -signing-backend hw")
 	network := flag.String("network", os.Getenv("CARDANO_NETWORK"), "Cardano network: mainnet or preprod")
 	testnetMagic := flag.String("testnet-magic", os.Getenv("TESTNET_MAGIC"), "Testnet magic number for preprod (if needed)")
-	flag.Parse()
+	tiersFile := flag.String("tiers", os.Getenv("TIERS_FILE"), "Path to JSON tier config (price + metadata template per tier); overrides -mint-price")
+	refundUnmatched := flag.Bool("refund", os.Getenv("REFUND_UNMATCHED") == "true", "Refund deposits that pay no price or match no tier instead of ignoring them")
+	traitsFile := flag.String("traits", os.Getenv("TRAITS_FILE"), "Path to JSON trait supply shuffled across mint ids")
+	seed := flag.Int64("seed", 0, "Seed for trait shuffling; reuse it to reproduce an assignment (default: time-based)")
+	manifestFile := flag.String("manifest", os.Getenv("MANIFEST_FILE"), "Path to a JSON or CSV manifest giving each mint id its own name, image and traits")
+	matchPaymentCred := flag.Bool("match-payment-credential", os.Getenv("MATCH_PAYMENT_CREDENTIAL") == "true", "Monitor every address sharing the monitor address's payment credential")
+	refundGrace := flag.Duration("refund-grace", 0, "Hold off-price deposits this long so a sender's follow-up deposits can be combined into one mint before refunding (e.g. 10m)")
+	minConfirmations := flag.Int("min-confirmations", 0, "Wait until a deposit has this many confirmations before minting")
+	mockFile := flag.String("mock-deposits", os.Getenv("MOCK_DEPOSITS_FILE"), "Read deposits from this JSON file instead of Blockfrost (testing)")
+	mockCardano := flag.String("mock-cardano", os.Getenv("MOCK_CARDANO_DIR"), "Simulate cardano-cli: build, sign and submit write transaction JSON to this directory and return fake tx hashes; nothing is submitted (testing)")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", envOr("LOG_FORMAT", "text"), "Log format: text or json")
+	onPermanentFailure := flag.String("on-permanent-failure", envOr("ON_PERMANENT_FAILURE", "retry"), "What to do with a reserved mint id whose mint can never succeed (e.g. bad metadata): retry, reuse (release the id) or skip (leave a recorded gap)")
+	refundPendingOnStop := flag.Bool("refund-pending-on-stop", false, "On shutdown, refund every deposit still holding a mint id reservation to its sender, for closing a drop")
+	recipientGuard := flag.Bool("recipient-guard", false, "Before minting, check the recipient is a key-hash payment address on -network and not a burn pattern; dead-letter the deposit with an alert otherwise")
+	maxMintAttempts := flag.Int("max-mint-attempts", defaultMaxMintAttempts, "Failed mint attempts after which a deposit is dead-lettered: no longer retried until requeued, with a webhook alert (0 = retry forever)")
+	senderCacheTTL := flag.Duration("sender-cache-ttl", defaultSenderCacheTTL, "How long resolved deposit senders are cached in the state, saving a Blockfrost call per deposit on re-scans and restarts (0 = no cache)")
+	plutusRedeemer := flag.String("plutus-redeemer", os.Getenv("PLUTUS_REDEEMER_FILE"), "Redeemer JSON for a Plutus minting policy; with -collateral, -script is treated as a Plutus script")
+	collateral := flag.String("collateral", os.Getenv("COLLATERAL_UTXO"), "Lovelace-only UTxO (txhash#index) at the monitor address used as collateral for a Plutus minting policy")
+	feeBuffer := flag.Int64("fee-buffer", defaultFeeBuffer, "Lovelace mint inputs must hold beyond the price and the estimated fee, for the change output and slack")
+	maxPerPoll := flag.Int("max-per-poll", 0, "Maximum deposits to process per poll, oldest first; the rest wait for the next poll (0 = no limit)")
+	mintWorkers := flag.Int("mint-workers", 1, "Number of deposits to mint concurrently; each worker spends its own inputs")
+	changeAddress := flag.String("change-address", os.Getenv("CHANGE_ADDRESS"), "Address that receives mint change, e.g. a reserve wallet; inputs are still spent from the monitor address (default: the monitor address)")
+	maxMetadataBytes := flag.Int("max-metadata-bytes", defaultMaxMetadataBytes, "Largest serialized transaction metadata a mint may carry; larger metadata fails the mint with a clear error before building (0 = no limit)")
+	metadataStandard := flag.String("metadata-standard", envOr("METADATA_STANDARD", metadataCIP25), "How token metadata is stored: cip25 (label 721 transaction metadata) or cip68 (reference token with an inline datum, user token to the buyer)")
+	referenceAddress := flag.String("reference-address", os.Getenv("REFERENCE_ADDRESS"), "Address that holds CIP-68 reference tokens (required with cip68), ideally a script address nobody can spend from; not the monitor, change or funding address")
+	fundingAddress := flag.String("funding-address", os.Getenv("FUNDING_ADDRESS"), "Extra address whose lovelace-only UTxOs top up a mint the monitor address cannot cover; add its key with another -signing-key")
+	assetName := flag.String("asset-name", envOr("ASSET_NAME", defaultAssetName), "printf pattern naming each token from its mint id, e.g. \"Flowmass %d\" or \"FM%04d\"; hex-encoded on chain, the same name keys the CIP-25 metadata")
+	description := flag.String("description", os.Getenv("DESCRIPTION"), "CIP-25 description for every token (tiers and \"description\" traits override it); split into 64-byte chunks when longer")
+	collectionsFile := flag.String("collections", os.Getenv("COLLECTIONS_FILE"), "Path to JSON list of collections (monitor address, policy, script, price, state each) to run in one process; replaces the per-collection flags")
+	era := flag.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era for building and signing transactions: babbage or conway")
+	mintStartSlot := flag.Int64("mint-start-slot", 0, "Slot a timed drop opens at: mints wait for it and carry it as their invalid-before, like an after lock in the policy (0 = the policy's own)")
+	txTTLSlots := flag.Int64("tx-ttl-slots", defaultTTLSlots, "Slots past the current one a transaction stays valid (its invalid-hereafter); shorter frees the inputs of failed transactions sooner")
+	buildModeFlag := flag.String("build-mode", envOr("BUILD_MODE", buildAuto), "How transactions are balanced: auto (transaction build) or raw (build-raw with calculate-min-fee)")
+	protocolParamsFile := flag.String("protocol-params-file", os.Getenv("PROTOCOL_PARAMS_FILE"), "Protocol parameters JSON used by -build-mode raw and min-UTxO calculations instead of querying the node")
+	workDirFlag := flag.String("work-dir", envOr("WORK_DIR", os.TempDir()), "Directory for the transaction and metadata files passed to cardano-cli")
+	keepTempFlag := flag.Bool("keep-temp", false, "Keep transaction and metadata files after submitting (for debugging)")
+	submitAttempts := flag.Int("submit-attempts", defaultSubmitRetry.attempts, "Times to try submitting a transaction before giving up until the next poll")
+	submitBackoff := flag.Duration("submit-backoff", defaultSubmitRetry.backoff, "Wait before the first submit retry; doubled after each further failure")
+	breakerThreshold := flag.Int("breaker-threshold", defaultBreakerThreshold, "Consecutive failed deposit fetches (e.g. Blockfrost down) after which polling pauses for -breaker-cooldown and a webhook fires; earlier failures double the poll interval (0 = never pause)")
+	breakerCooldown := flag.Duration("breaker-cooldown", defaultBreakerCooldown, "How long deposit polling pauses once -breaker-threshold is reached; also the longest backoff")
+	backupDir := flag.String("backup-dir", os.Getenv("BACKUP_DIR"), "Directory for timestamped state backups, taken every -backup-interval and on SIGUSR1 (empty = no backups)")
+	backupInterval := flag.Duration("backup-interval", defaultBackupInterval, "How often the state is backed up to -backup-dir (0 = only on SIGUSR1)")
+	backupKeep := flag.Int("backup-keep", defaultBackupKeep, "Newest state backups kept per state file; older ones are deleted (0 = keep all)")
+	maxSyncLag := flag.Duration("max-sync-lag", defaultMaxSyncLag, "How far the chain tip may trail wall-clock time; until it is within this the engine waits (retrying with backoff) instead of minting (0 = no check)")
+	tipLagAlert := flag.Duration("tip-lag-alert", defaultTipLagAlert, "While running, alert when the chain tip trails wall-clock time by more than this; also shown on /status (0 = no monitoring)")
+	tipCheckInterval := flag.Duration("tip-check-interval", defaultTipCheckInterval, "How often -tip-lag-alert reads the chain tip")
+	httpAddr := flag.String("http-addr", os.Getenv("HTTP_ADDR"), "Serve the read-only API (GET /status, GET /mints) on this address, e.g. :8080")
+	corsOrigin := flag.String("cors-origin", os.Getenv("CORS_ORIGIN"), "Origin allowed to call the HTTP API from a browser (Access-Control-Allow-Origin), e.g. https://flowmass.io or *")
+	auditLogFile := flag.String("audit-log", os.Getenv("AUDIT_LOG"), "Append-only JSON lines file recording each deposit's lifecycle (seen, reserved, built, submitted, confirmed, refunded, failed), synced on every event")
+	var webhookURLs stringList
+	flag.Var(&webhookURLs, "webhook-url", "Discord or Slack webhook URL for notifications; repeat to notify several channels (default: DISCORD_WEBHOOK_URL)")
+	var discordURLs, slackURLs, telegramURLs stringList
+	flag.Var(&discordURLs, "notify-discord", "Discord webhook URL to notify; repeatable (NOTIFY_DISCORD)")
+	flag.Var(&slackURLs, "notify-slack", "Slack incoming webhook URL to notify; repeatable (NOTIFY_SLACK)")
+	flag.Var(&telegramURLs, "notify-telegram", "Telegram bot sendMessage URL with chat_id, e.g. https://api.telegram.org/bot<token>/sendMessage?chat_id=<id>; repeatable (NOTIFY_TELEGRAM)")
+	discordUsername := flag.String("discord-username", envOr("DISCORD_USERNAME", defaultDiscordUsername), "Name Discord shows on notification posts")
+	discordAvatarURL := flag.String("discord-avatar-url", os.Getenv("DISCORD_AVATAR_URL"), "Image URL Discord shows as the avatar on notification posts (empty = the webhook's own)")
+	verifyIPFS := flag.Bool("verify-ipfs", false, "Before each mint, check that the metadata's ipfs:// media is reachable through -ipfs-gateway; postpone the mint if not")
+	ipfsGateway := flag.String("ipfs-gateway", envOr("IPFS_GATEWAY", "https://ipfs.io/ipfs/"), "IPFS HTTP gateway used by -verify-ipfs")
+	ipfsPinEndpoint := flag.String("ipfs-pin-endpoint", os.Getenv("IPFS_PIN_ENDPOINT"), "IPFS Pinning Service API endpoint for re-pinning unreachable media (e.g. https://api.pinata.cloud/psa)")
+	ipfsPinToken := flag.String("ipfs-pin-token", os.Getenv("IPFS_PIN_TOKEN"), "Access token for -ipfs-pin-endpoint; enables re-pinning")
+	notifyQueueSize := flag.Int("notify-queue", 100, "Notifications buffered for the background sender")
+	notifyQueueFull := flag.String("notify-queue-full", "drop", "When the notification queue is full: drop (count and report later) or block")
+	drainTimeout := flag.Duration("drain-timeout", 25*time.Second, "On SIGTERM, how long to wait for mints in progress to finish and queued notifications to be sent before exiting; SIGINT exits without waiting")
+	notifyInterval := flag.Duration("notify-interval", 2*time.Second, "Minimum time between notification batches; notices arriving meanwhile are combined")
+	showVersion := flag.Bool("version", false, "Print version, commit and build date, then exit")
+	confirm := flag.Bool("yes", false, "Confirm reprocess; without it, only the deposit's current state is shown")
+	mintID := flag.Int("id", 0, "Mint id for mint-to; must be unused on chain and in state (0 = the next id)")
+	once := flag.Bool("once", false, "Process the deposits eligible now in a single poll and exit (non-zero if any failed), for cron or CI")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML or TOML file with the same settings as the flags; flags override it")
+	flag.CommandLine.Parse(args)
 
-	// Validate required configuration
-	if *monitorAddr == "" {
-		log.Fatal("monitor-address is required (use -monitor-address flag or MONITOR_ADDRESS env var)")
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	// Flags given on the command line win over the config file, also when
+	// it is re-read on SIGHUP.
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if *configFile != "" {
+		if err := loadConfigFile(flag.CommandLine, *configFile); err != nil {
+			fatal("startup failed", "error", err)
+		}
+	}
+
+	if err := setupLogging(*logLevel, *logFormat); err != nil {
+		fatal("startup failed", "error", err)
+	}
+	if len(signingKeyFiles) == 0 {
+		signingKeyFiles.Set(os.Getenv("SIGNING_KEY_FILE"))
+	}
+	if len(discordURLs) == 0 {
+		discordURLs.Set(os.Getenv("NOTIFY_DISCORD"))
+	}
+	if len(slackURLs) == 0 {
+		slackURLs.Set(os.Getenv("NOTIFY_SLACK"))
+	}
+	if len(telegramURLs) == 0 {
+		telegramURLs.Set(os.Getenv("NOTIFY_TELEGRAM"))
+	}
+	checkedEra, err := checkEra(*era)
+	if err != nil {
+		fatal("startup failed", "error", err)
 	}
-	if *policyID == "" {
-		log.Fatal("policy-id is required (use -policy-id flag or POLICY_ID env var)")
+	*era = checkedEra
+	if *buildModeFlag, err = checkBuildMode(*buildModeFlag); err != nil {
+		fatal("startup failed", "error", err)
 	}
-	if *scriptFile == "" {
-		log.Fatal("script is required (use -script flag or SCRIPT_FILE env var)")
+	blockfrost := newBlockfrostBudget(*blockfrostRPS, *blockfrostBurst, *blockfrostDailyLimit)
+	signer, err := newSigner(*signingBackend, *hwDerivationPath)
+	if err != nil {
+		fatal("startup failed", "error", err)
+	}
+	ipfs, err := newIPFSCheck(*verifyIPFS, *ipfsGateway, *ipfsPinEndpoint, *ipfsPinToken)
+	if err != nil {
+		fatal("startup failed", "error", err)
+	}
+
+	plutus, err := NewPlutusPolicy(*plutusRedeemer, *collateral)
+	if err != nil {
+		fatal("startup failed", "error", err)
 	}
-	// if *metadataFile == "" {
-	// 	log.Fatal("metadata is required (use -metadata flag or METADATA_FILE env var)")
-	// }
-	if *stateFile == "" {
-		*stateFile = "flowmass.state"
+	if plutus != nil && *buildModeFlag == buildRaw {
+		fatal("-build-mode raw does not support Plutus minting policies; use -build-mode auto")
 	}
 
-	log.Println("Flowmass NFT Minting Engine (Mainnet)")
-	log.Printf("Monitor Address: %s", *monitorAddr)
-	log.Printf("Mint Price: %d lovelace", *mintPrice)
-	log.Printf("Policy ID: %s", *policyID)
-	log.Printf("Script: %s", *scriptFile)
-	// log.Printf("Metadata: %s", *metadataFile)
-	log.Printf("State: %s", *stateFile)
-	log.Printf("Network: %s", *network)
-	log.Printf("Testnet Magic: %s", *testnetMagic)
+	var allowlist *Allowlist
+	if *allowlistFile != "" {
+		if allowlist, err = LoadAllowlist(*allowlistFile); err != nil {
+			fatal("startup failed", "error", err)
+		}
+		mainLog.Info("allowlist loaded", "file", *allowlistFile, "addresses", allowlist.Size())
+	}
 
-	// Initialize engine
 	if *network == "" {
 		*network = "mainnet"
 	}
@@ -59,33 +218,449 @@ func main() {
 		}
 	}
 
-	eng, err := NewEngine(
-		*monitorAddr,
-		*mintPrice,
-		*policyID,
-		*scriptFile,
-		// *metadataFile,
-		*stateFile,
-		*blockfrostKey,
-		*network,
-		*testnetMagic,
-		*signingKeyFile,
-	)
-	if err != nil {
-		log.Fatalf("Failed to initialize engine: %v", err)
+	// Without -collections the flags describe a single, unnamed collection.
+	var collections []Collection
+	if *collectionsFile != "" {
+		var err error
+		collections, err = LoadCollections(*collectionsFile)
+		if err != nil {
+			fatal("failed to load collections", "error", err)
+		}
+	} else {
+		// Validate required configuration
+		if *monitorAddr == "" {
+			fatal("monitor-address is required (use -monitor-address flag or MONITOR_ADDRESS env var)")
+		}
+		if *scriptFile == "" {
+			fatal("script is required (use -script flag or SCRIPT_FILE env var)")
+		}
+		// if *metadataFile == "" {
+		// 	fatal("metadata is required (use -metadata flag or METADATA_FILE env var)")
+		// }
+		if *stateFile == "" {
+			*stateFile = "flowmass.state"
+		}
+		// 0 is a valid seed, so only a -seed that was given is passed on.
+		var seedSet bool
+		flag.Visit(func(f *flag.Flag) { seedSet = seedSet || f.Name == "seed" })
+		var collectionSeed *int64
+		if seedSet {
+			collectionSeed = seed
+		}
+		collections = []Collection{{
+			MonitorAddress:   *monitorAddr,
+			PolicyID:         *policyID,
+			Script:           *scriptFile,
+			MintPrice:        *mintPrice,
+			State:            *stateFile,
+			Tiers:            *tiersFile,
+			Traits:           *traitsFile,
+			Seed:             collectionSeed,
+			Manifest:         *manifestFile,
+			Description:      *description,
+			AssetName:        *assetName,
+			ChangeAddress:    *changeAddress,
+			FundingAddress:   *fundingAddress,
+			MetadataStandard: *metadataStandard,
+			ReferenceAddress: *referenceAddress,
+			MockDeposits:     *mockFile,
+		}}
 	}
 
-	initWebhook()
+	if mode == "status" {
+		for _, c := range collections {
+			if err := printStatus(os.Stdout, c, *stateBackend); err != nil {
+				fatal("status failed", "error", err)
+			}
+		}
+		return
+	}
+	var recipient string
+	if mode == "mint-to" {
+		recipient = flag.Arg(0)
+		// Flags may also follow the recipient: mint-to <recipient> -id N.
+		if flag.NArg() > 1 {
+			flag.CommandLine.Parse(flag.Args()[1:])
+			if flag.NArg() > 0 {
+				recipient = ""
+			}
+		}
+		if recipient == "" || len(collections) != 1 {
+			fatal("usage: flowmass mint-to [flags] <recipient> [-id N] (with a single collection)")
+		}
+	}
+	var reprocessTx string
+	if mode == "reprocess" {
+		if flag.NArg() != 1 || len(collections) != 1 {
+			fatal("usage: flowmass reprocess [flags] -yes <txhash> (with a single collection)")
+		}
+		reprocessTx, _, _ = strings.Cut(flag.Arg(0), "#")
+	}
 
-	// Start engine
-	go eng.Start()
-	log.Println("Engine started. Press CTRL-C to exit.")
+	mainLog.Info("Flowmass NFT Minting Engine", "version", versionString(), "network", *network, "testnet_magic", *testnetMagic, "era", *era, "build_mode", *buildModeFlag)
 
-	// Wait for interrupt
+	if *blockfrostKey != "" {
+		if err := checkBlockfrostKey(blockfrost, *blockfrostKey, *network); err != nil {
+			fatal("startup failed", "error", err)
+		}
+	}
+
+	var trail *auditLog
+	if *auditLogFile != "" {
+		if trail, err = openAuditLog(*auditLogFile); err != nil {
+			fatal("startup failed", "error", err)
+		}
+	}
+
+	settings := engineSettings{
+		submit:              submitRetry{attempts: *submitAttempts, backoff: *submitBackoff},
+		ipfs:                ipfs,
+		maxProcessed:        *maxProcessed,
+		breakerThreshold:    *breakerThreshold,
+		breakerCooldown:     *breakerCooldown,
+		feeBuffer:           *feeBuffer,
+		maxMintAttempts:     *maxMintAttempts,
+		senderCacheTTL:      *senderCacheTTL,
+		maxSyncLag:          *maxSyncLag,
+		recipientGuard:      *recipientGuard,
+		refundPendingOnStop: *refundPendingOnStop,
+		mintStartSlot:       *mintStartSlot,
+		maxMetadataBytes:    *maxMetadataBytes,
+		backupDir:           *backupDir,
+		backupInterval:      *backupInterval,
+		backupKeep:          *backupKeep,
+		tipLagAlert:         *tipLagAlert,
+		tipCheckInterval:    *tipCheckInterval,
+		audit:               trail,
+		blockfrost:          blockfrost,
+	}
+
+	var engines []*Engine
+	for _, c := range collections {
+		clog := mainLog
+		if c.Name != "" {
+			clog = mainLog.With("collection", c.Name)
+		}
+		clog.Info("collection", "monitor_address", c.MonitorAddress, "mint_price", c.MintPrice, "script", c.Script, "state", c.State, "state_backend", *stateBackend)
+		if c.ChangeAddress != "" {
+			clog.Info("change address", "address", c.ChangeAddress)
+		}
+		if c.FundingAddress != "" {
+			clog.Info("funding address", "address", c.FundingAddress)
+		}
+		if c.MetadataStandard == metadataCIP68 {
+			clog.Info("metadata standard CIP-68", "reference_address", c.ReferenceAddress)
+		}
+		if c.PolicyID != "" {
+			clog.Info("policy id", "policy_id", c.PolicyID)
+		}
+		// clog.Info("metadata", "file", *metadataFile)
+
+		collectionEra := *era
+		if c.Era != "" {
+			collectionEra = c.Era
+			clog.Info("era", "era", c.Era)
+		}
+		collectionBuildMode := *buildModeFlag
+		if c.BuildMode != "" {
+			if plutus != nil && c.BuildMode == buildRaw {
+				fatal("build_mode raw does not support Plutus minting policies", "collection", c.Name)
+			}
+			collectionBuildMode = c.BuildMode
+			clog.Info("build mode", "build_mode", c.BuildMode)
+		}
+		collectionWorkDir := *workDirFlag
+		if c.WorkDir != "" {
+			collectionWorkDir = c.WorkDir
+			clog.Info("work dir", "dir", c.WorkDir)
+		}
+		work, err := newWorkDir(collectionWorkDir, *keepTempFlag)
+		if err != nil {
+			fatal("failed to initialize engine", "collection", c.Name, "error", err)
+		}
+		cli, err := newCardanoCLI(*network, *testnetMagic, collectionEra, collectionBuildMode, *protocolParamsFile, work, signer, settings.submit)
+		if err != nil {
+			fatal("failed to initialize engine", "collection", c.Name, "error", err)
+		}
+		cardano, err := newCardanoClient(cli, blockfrost, *blockfrostKey, *mockCardano)
+		if err != nil {
+			fatal("failed to initialize engine", "collection", c.Name, "error", err)
+		}
+		collectionSettings := settings
+		if c.MaxProcessed != nil {
+			collectionSettings.maxProcessed = *c.MaxProcessed
+		}
+		if c.FeeBuffer != nil {
+			collectionSettings.feeBuffer = *c.FeeBuffer
+		}
+		if c.MaxMintAttempts != nil {
+			collectionSettings.maxMintAttempts = *c.MaxMintAttempts
+		}
+		if c.MintStartSlot != nil {
+			collectionSettings.mintStartSlot = *c.MintStartSlot
+		}
+
+		var tiers []Tier
+		if c.Tiers != "" {
+			var err error
+			tiers, err = LoadTiers(c.Tiers)
+			if err != nil {
+				fatal("failed to load tiers", "collection", c.Name, "error", err)
+			}
+			for _, t := range tiers {
+				clog.Info("tier", "tier", t.Name, "price", t.Price, "quantity", t.Quantity, "template", t.MetadataTemplate)
+			}
+			clog.Info("refund unmatched deposits", "enabled", *refundUnmatched)
+		}
+
+		var traits *TraitPool
+		if c.Traits != "" {
+			seed := time.Now().UnixNano()
+			if c.Seed != nil {
+				seed = *c.Seed
+			}
+			var err error
+			traits, err = LoadTraitPool(c.Traits, seed)
+			if err != nil {
+				fatal("failed to load traits", "collection", c.Name, "error", err)
+			}
+			// Record the seed so the distribution can be audited and reproduced.
+			clog.Info("traits loaded", "file", c.Traits, "sets", traits.Size(), "seed", traits.Seed)
+		}
+
+		var manifest *Manifest
+		if c.Manifest != "" {
+			var err error
+			manifest, err = LoadManifest(c.Manifest)
+			if err != nil {
+				fatal("failed to load manifest", "collection", c.Name, "error", err)
+			}
+			clog.Info("manifest loaded", "file", c.Manifest, "tokens", manifest.Size())
+		}
+
+		eng, err := NewEngine(EngineConfig{
+			Name:               c.Name,
+			MonitorAddr:        c.MonitorAddress,
+			ChangeAddr:         c.ChangeAddress,
+			FundingAddr:        c.FundingAddress,
+			MintPrice:          c.MintPrice,
+			PriceTolerance:     *priceTolerance,
+			PolicyID:           c.PolicyID,
+			ScriptFile:         c.Script,
+			Plutus:             plutus,
+			StateFile:          c.State,
+			StateBackend:       *stateBackend,
+			BlockfrostKey:      *blockfrostKey,
+			Network:            *network,
+			TestnetMagic:       *testnetMagic,
+			MockFile:           c.MockDeposits,
+			SigningKeyFiles:    signingKeyFiles,
+			AssetName:          c.AssetName,
+			Description:        c.Description,
+			MetadataStandard:   c.MetadataStandard,
+			RefAddr:            c.ReferenceAddress,
+			Tiers:              tiers,
+			Traits:             traits,
+			Manifest:           manifest,
+			Allowlist:          allowlist,
+			RefundUnmatched:    *refundUnmatched,
+			MatchPaymentCred:   *matchPaymentCred,
+			RefundGrace:        *refundGrace,
+			MinConfirmations:   *minConfirmations,
+			OnPermanentFailure: *onPermanentFailure,
+			MaxPerWallet:       *maxPerWallet,
+			MintWorkers:        *mintWorkers,
+			MaxPerPoll:         *maxPerPoll,
+			TTLSlots:           *txTTLSlots,
+			Settings:           collectionSettings,
+		}, cardano)
+		if err != nil {
+			fatal("failed to initialize engine", "collection", c.Name, "error", err)
+		}
+		engines = append(engines, eng)
+	}
+
+	if err := initNotifiers(webhookURLs, discordURLs, slackURLs, telegramURLs, discordIdentity{Username: *discordUsername, AvatarURL: *discordAvatarURL}); err != nil {
+		fatal("startup failed", "error", err)
+	}
+	if err := startNotifyQueue(*notifyQueueSize, *notifyQueueFull, *notifyInterval); err != nil {
+		fatal("startup failed", "error", err)
+	}
+
+	if mode == "mint-to" {
+		txHash, err := engines[0].MintTo(recipient, *mintID)
+		engines[0].Stop()
+		flushNotifications(30 * time.Second)
+		if err != nil {
+			fatal("mint failed", "error", err)
+		}
+		fmt.Println(txHash)
+		return
+	}
+
+	if mode == "reprocess" {
+		err := engines[0].Reprocess(reprocessTx, operator(), *confirm)
+		engines[0].Stop()
+		flushNotifications(30 * time.Second)
+		if err != nil {
+			fatal("reprocess failed", "error", err)
+		}
+		return
+	}
+
+	if *once {
+		failed := false
+		for _, eng := range engines {
+			if err := eng.RunOnce(); err != nil {
+				eng.log.Error("poll failed", "error", err)
+				failed = true
+			}
+			eng.Stop()
+		}
+		flushNotifications(30 * time.Second)
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Start engines
+	for _, eng := range engines {
+		go eng.Start()
+	}
+	if *httpAddr != "" {
+		startHTTPServer(*httpAddr, engines, *corsOrigin)
+	}
+	mainLog.Info("engine started; press CTRL-C to exit")
+
+	for _, eng := range engines {
+		eng.startBackups()
+	}
+
+	// Wait for SIGINT or SIGTERM; SIGHUP reloads the allowlist, metadata
+	// templates, manifests and the safe settings, SIGUSR1 backs up the state.
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	<-sig
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+	if backupSignal != nil {
+		signals = append(signals, backupSignal)
+	}
+	signal.Notify(sig, signals...)
+	var stopSignal os.Signal
+	for s := range sig {
+		if s == backupSignal {
+			backupAll(engines)
+			continue
+		}
+		if s != syscall.SIGHUP {
+			stopSignal = s
+			break
+		}
+		reload(allowlist, engines, collections, *collectionsFile, *configFile, explicit, reloadSettings{
+			Description:    *description,
+			PriceTolerance: *priceTolerance,
+			MaxPerWallet:   *maxPerWallet,
+		})
+	}
 
-	log.Println("Shutting down engine...")
-	eng.Stop()
+	// A second signal during shutdown exits at once.
+	go func() {
+		for s := range sig {
+			if s == syscall.SIGINT || s == syscall.SIGTERM {
+				mainLog.Warn("exiting immediately", "signal", s.String())
+				os.Exit(1)
+			}
+		}
+	}()
+	shutdown(engines, stopSignal == syscall.SIGTERM, *drainTimeout)
+}
+
+// shutdown stops the engines. A graceful shutdown (SIGTERM, as sent by
+// container orchestrators) first lets the current mints finish, then
+// refunds pending deposits if asked to and flushes queued notifications,
+// all within drainTimeout. A fast one (SIGINT) stops right away and gives
+// refunds and notifications a few seconds.
+func shutdown(engines []*Engine, graceful bool, drainTimeout time.Duration) {
+	if !graceful {
+		mainLog.Info("shutting down (fast; send SIGTERM for a graceful drain)")
+		deadline := time.Now().Add(5 * time.Second)
+		for _, eng := range engines {
+			eng.StopBy(deadline)
+		}
+		flushNotifications(max(time.Until(deadline), time.Second))
+		return
+	}
+
+	mainLog.Info("shutting down (graceful; signal again to exit now)", "drain_timeout", drainTimeout)
+	deadline := time.Now().Add(drainTimeout)
+	var wg sync.WaitGroup
+	for _, eng := range engines {
+		wg.Add(1)
+		go func(eng *Engine) {
+			defer wg.Done()
+			if !eng.Drain(drainTimeout) {
+				eng.log.Warn("mints still in progress at the drain deadline; stopping anyway")
+			}
+		}(eng)
+	}
+	wg.Wait()
+	for _, eng := range engines {
+		eng.StopBy(deadline)
+	}
+	flushNotifications(max(time.Until(deadline), time.Second))
+}
+
+// reload handles SIGHUP. base holds the current safe flag values; the
+// -config and -collections files are re-read for newer ones. Each engine
+// keeps its settings if its reload fails.
+func reload(allowlist *Allowlist, engines []*Engine, collections []Collection, collectionsFile, configFile string, explicit map[string]bool, base reloadSettings) {
+	mainLog.Info("SIGHUP: reloading")
+	if allowlist != nil {
+		if err := allowlist.Reload(); err != nil {
+			mainLog.Error("allowlist reload failed; keeping the current list", "error", err)
+		} else {
+			mainLog.Info("reloaded allowlist", "addresses", allowlist.Size())
+		}
+	}
+	if configFile != "" {
+		var err error
+		if base, err = reloadConfigFile(configFile, explicit, base); err != nil {
+			mainLog.Error("config reload failed; keeping the current settings", "error", err)
+			return
+		}
+	}
+	if collectionsFile != "" {
+		reloaded, err := LoadCollections(collectionsFile)
+		if err != nil {
+			mainLog.Error("collections reload failed; keeping the current settings", "error", err)
+			return
+		}
+		byName := make(map[string]Collection, len(reloaded))
+		for _, c := range reloaded {
+			byName[c.Name] = c
+		}
+		for i := range collections {
+			if c, ok := byName[collections[i].Name]; ok {
+				collections[i].Tiers, collections[i].Manifest, collections[i].Description = c.Tiers, c.Manifest, c.Description
+			}
+		}
+	}
+
+	for i, eng := range engines {
+		s := base
+		s.Tiers, s.Manifest = collections[i].Tiers, collections[i].Manifest
+		if collectionsFile != "" {
+			s.Description = collections[i].Description
+		}
+		if err := eng.Reload(s); err != nil {
+			eng.log.Error("reload failed; keeping the current settings", "error", err)
+		}
+	}
+}
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestEntry is the metadata of one token in a manifest.
+type ManifestEntry struct {
+	Name        string            `json:"name"`
+	Image       string            `json:"image"`
+	MediaType   string            `json:"mediaType,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Traits      map[string]string `json:"traits,omitempty"`
+}
+
+// Manifest gives every mint id its own name, image and traits, replacing
+// the shared metadata template. It is read from JSON keyed by mint id:
+/*
+{
+	"1": {"name": "Flowmass 1", "image": "ipfs://bafy...", "mediaType": "image/png",
+	      "traits": {"type": "Shark", "background": "Reef"}}
+}
+*/
+// or from CSV with id, name and image columns, optional mediaType and
+// description columns, and one column per trait:
+/*
+id,name,image,mediaType,type,background
+1,Flowmass 1,ipfs://bafy...,image/png,Shark,Reef
+*/
+type Manifest struct {
+	entries map[int]ManifestEntry
+}
+
+// LoadManifest reads a manifest; files ending in .csv are parsed as CSV and
+// anything else as JSON. Every entry needs a name and an image.
+func LoadManifest(filePath string) (*Manifest, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var entries map[int]ManifestEntry
+	if strings.EqualFold(filepath.Ext(filePath), ".csv") {
+		entries, err = parseManifestCSV(data)
+	} else {
+		entries, err = parseManifestJSON(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", filePath, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("manifest %s defines no tokens", filePath)
+	}
+	for id, e := range entries {
+		if id < 1 {
+			return nil, fmt.Errorf("manifest %s: invalid mint id %d", filePath, id)
+		}
+		if e.Name == "" || e.Image == "" {
+			return nil, fmt.Errorf("manifest %s: id %d needs a name and an image", filePath, id)
+		}
+	}
+	return &Manifest{entries: entries}, nil
+}
+
+func parseManifestJSON(data []byte) (map[int]ManifestEntry, error) {
+	var raw map[string]ManifestEntry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	entries := make(map[int]ManifestEntry, len(raw))
+	for key, e := range raw {
+		id, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not a mint id", key)
+		}
+		entries[id] = e
+	}
+	return entries, nil
+}
+
+func parseManifestCSV(data []byte) (map[int]ManifestEntry, error) {
+	rows, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	if len(header) == 0 || header[0] != "id" {
+		return nil, fmt.Errorf("first column must be id")
+	}
+
+	entries := make(map[int]ManifestEntry, len(rows)-1)
+	for line, row := range rows[1:] {
+		id, err := strconv.Atoi(strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid id %q", line+2, row[0])
+		}
+		if _, dup := entries[id]; dup {
+			return nil, fmt.Errorf("line %d: id %d listed twice", line+2, id)
+		}
+		e := ManifestEntry{Traits: make(map[string]string)}
+		for i, col := range header[1:] {
+			value := row[i+1]
+			switch col {
+			case "name":
+				e.Name = value
+			case "image":
+				e.Image = value
+			case "mediaType", "media_type":
+				e.MediaType = value
+			case "description":
+				e.Description = value
+			default:
+				if value != "" {
+					e.Traits[col] = value
+				}
+			}
+		}
+		entries[id] = e
+	}
+	return entries, nil
+}
+
+// ForID returns the entry for mint id. Minting an id the manifest does not
+// cover is an error, so no token ever gets placeholder metadata.
+func (m *Manifest) ForID(id int) (ManifestEntry, error) {
+	e, ok := m.entries[id]
+	if !ok {
+		return ManifestEntry{}, fmt.Errorf("manifest has no entry for mint id %d", id)
+	}
+	return e, nil
+}
+
+// Size returns the number of tokens in the manifest.
+func (m *Manifest) Size() int {
+	return len(m.entries)
+}
+
+// Render returns CIP-25 metadata under policyID for the tokens ids, whose
+// on-chain names are names. An entry's own description wins over the
+// token's entry in descriptions. Strings longer than 64 bytes are split into
+// arrays.
+func (m *Manifest) Render(policyID string, ids []int, names, descriptions []string) (string, error) {
+	assets := make(map[string]interface{}, len(ids))
+	for i, id := range ids {
+		e, err := m.ForID(id)
+		if err != nil {
+			return "", err
+		}
+		asset := map[string]interface{}{
+			"name":  e.Name,
+			"image": metadataValue(e.Image),
+		}
+		if e.MediaType != "" {
+			asset["mediaType"] = e.MediaType
+		}
+		if e.Description != "" {
+			asset["description"] = metadataValue(e.Description)
+		} else if descriptions[i] != "" {
+			asset["description"] = metadataValue(descriptions[i])
+		}
+		for k, v := range e.Traits {
+			if _, reserved := asset[k]; !reserved {
+				asset[k] = metadataValue(v)
+			}
+		}
+		assets[names[i]] = asset
+	}
+
+	out, err := json.MarshalIndent(map[string]interface{}{
+		"721": map[string]interface{}{policyID: assets},
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // Metadata represents the NFT metadata structure.
@@ -34,8 +39,57 @@ import (
 }
 */
 
-// Unmarshal metadata from json string template
-func MetadataTemplate(hexName string) (string, error) {
+// errMetadataInvalid marks a build that cardano-cli rejected for its
+// metadata, a permanent mint failure.
+var errMetadataInvalid = errors.New("transaction metadata rejected")
+
+// metadataStringLimit is the ledger's maximum size of a metadata string, in bytes.
+const metadataStringLimit = 64
+
+// chunkMetadataString splits s into pieces of at most 64 bytes without
+// breaking a UTF-8 character, the CIP-25 convention for long strings.
+func chunkMetadataString(s string) []string {
+	var chunks []string
+	for len(s) > metadataStringLimit {
+		cut := metadataStringLimit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		chunks = append(chunks, s[:cut])
+		s = s[cut:]
+	}
+	return append(chunks, s)
+}
+
+// metadataStringJSON encodes s as a JSON metadata value: a plain string when
+// it fits in 64 bytes, otherwise an array of chunks.
+func metadataStringJSON(s string) string {
+	out, _ := json.Marshal(metadataValue(s))
+	return string(out)
+}
+
+// metadataValue returns s, or its 64-byte chunks when it is too long for a
+// single metadata string.
+func metadataValue(s string) interface{} {
+	if len(s) > metadataStringLimit {
+		return chunkMetadataString(s)
+	}
+	return s
+}
+
+// descriptionField returns the `"description": ...,` line for a template,
+// or nothing when description is empty.
+func descriptionField(description string) string {
+	if description == "" {
+		return ""
+	}
+	return fmt.Sprintf(`"description": %s,
+				`, metadataStringJSON(description))
+}
+
+// MetadataTemplate renders the default CIP-25 metadata for one token under
+// policyID, the minting policy of the transaction.
+func MetadataTemplate(policyID, hexName, description string) (string, error) {
 	name, err := hex.DecodeString(hexName)
 	if err != nil {
 		return "", err
@@ -43,10 +97,10 @@ func MetadataTemplate(hexName string) (string, error) {
 
 	template := fmt.Sprintf(`{
 	"721": {
-		"1d0cf168b30d27c6619e7ca7c18e02c8cebc011bf056216a1ea829ff": {
+		"%s": {
 			"%s": {
 				"name": "%s",
-				"image": ["ipfs://bafybeic24satynujphugtqvwea3222g363", "ipdavlv5vhncvn6zxffrxe3e"],
+				%s"image": ["ipfs://bafybeic24satynujphugtqvwea3222g363", "ipdavlv5vhncvn6zxffrxe3e"],
 				"mediaType": "image/png",
 				"files": [
 					{
@@ -63,15 +117,16 @@ func MetadataTemplate(hexName string) (string, error) {
 			}
 		}
 	}
-}`, name, name)
+}`, policyID, name, name, descriptionField(description))
 
 	return template, nil
 }
 
-// MetadatasTemplate generates metadata for multiple NFTs given a slice of hex names
-func MetadatasTemplate(hexNames []string) (string, error) {
+// MetadatasTemplate generates metadata for multiple NFTs given a slice of
+// hex names and one description per name
+func MetadatasTemplate(policyID string, hexNames, descriptions []string) (string, error) {
 	entries := ""
-	for _, hexName := range hexNames {
+	for i, hexName := range hexNames {
 		name, err := hex.DecodeString(hexName)
 		if err != nil {
 			return "", err
@@ -79,7 +134,7 @@ func MetadatasTemplate(hexNames []string) (string, error) {
 
 		entry := fmt.Sprintf(`"%s": {
 				"name": "%s",
-				"image": ["ipfs://bafybeic24satynujphugtqvwea3222g363", "ipdavlv5vhncvn6zxffrxe3e"],
+				%s"image": ["ipfs://bafybeic24satynujphugtqvwea3222g363", "ipdavlv5vhncvn6zxffrxe3e"],
 				"mediaType": "image/png",
 				"files": [
 					{
@@ -93,7 +148,7 @@ func MetadatasTemplate(hexNames []string) (string, error) {
 				"twitter": "https://x.com/PREEB_Pool",
 				"discord": "https://discord.gg/aHrZJuEKZG",
 				"type": "Shark"
-			}`, name, name)
+			}`, name, name, descriptionField(descriptions[i]))
 
 		if entries != "" {
 			entries += ",\n"
@@ -103,15 +158,171 @@ func MetadatasTemplate(hexNames []string) (string, error) {
 
 	template := fmt.Sprintf(`{
 	"721": {
-		"1d0cf168b30d27c6619e7ca7c18e02c8cebc011bf056216a1ea829ff": {
+		"%s": {
 			%s
 		}
 	}
-}`, entries)
+}`, policyID, entries)
 
 	return template, nil
 }
 
+// ValidateMetadata checks rendered CIP-25 metadata before it reaches
+// cardano-cli: the 721 object must hold only policyID, every token needs a
+// name and an image, and no string may exceed the ledger's 64-byte limit.
+// The node would reject the transaction for any of these, after fees and
+// retries had been spent on it.
+func ValidateMetadata(jsonStr, policyID string) error {
+	var root map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonStr), &root); err != nil {
+		return fmt.Errorf("metadata is not a JSON object: %w", err)
+	}
+	cip25, ok := root["721"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("metadata has no 721 object")
+	}
+	tokens := 0
+	for key, v := range cip25 {
+		if key == "version" {
+			continue
+		}
+		if key != policyID {
+			return fmt.Errorf("metadata policy %q does not match minting policy %q", key, policyID)
+		}
+		assets, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("metadata 721.%s is not an object", key)
+		}
+		for name, a := range assets {
+			if len(name) > metadataStringLimit {
+				return fmt.Errorf("metadata asset key %q is longer than %d bytes", name, metadataStringLimit)
+			}
+			asset, ok := a.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("metadata 721.%s.%s is not an object", key, name)
+			}
+			for _, field := range []string{"name", "image"} {
+				if _, ok := asset[field]; !ok {
+					return fmt.Errorf("metadata for %s is missing %q", name, field)
+				}
+			}
+			tokens++
+		}
+	}
+	if tokens == 0 {
+		return fmt.Errorf("metadata has no tokens under policy %s", policyID)
+	}
+	return checkMetadataStrings("721", cip25)
+}
+
+// checkMetadataStrings reports the first string (or map key) under v longer
+// than metadataStringLimit, naming its path.
+func checkMetadataStrings(path string, v interface{}) error {
+	switch t := v.(type) {
+	case string:
+		if len(t) > metadataStringLimit {
+			return fmt.Errorf("metadata %s is %d bytes; strings are limited to %d (split it into an array)", path, len(t), metadataStringLimit)
+		}
+	case []interface{}:
+		for i, item := range t {
+			if err := checkMetadataStrings(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for k, item := range t {
+			if len(k) > metadataStringLimit {
+				return fmt.Errorf("metadata key %s.%s is longer than %d bytes", path, k, metadataStringLimit)
+			}
+			if err := checkMetadataStrings(path+"."+k, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The protocol limits a whole transaction to maxTxBytes; metadata near that
+// fails the build with an opaque size error. txHeadroom is left for the
+// body (inputs, outputs, the mint and validity fields) and the script and
+// key witnesses, which a bundle mint with a multisig policy fills.
+const (
+	maxTxBytes = 16384
+	txHeadroom = 2048
+)
+
+// defaultMaxMetadataBytes is the default -max-metadata-bytes.
+const defaultMaxMetadataBytes = maxTxBytes - txHeadroom
+
+// checkMetadataSize returns the CBOR-serialized size of metadata and an
+// error naming the size and limit when it exceeds limit (0 = no limit).
+func checkMetadataSize(metadata string, limit int) (int, error) {
+	dec := json.NewDecoder(strings.NewReader(metadata))
+	dec.UseNumber()
+	var root map[string]interface{}
+	if err := dec.Decode(&root); err != nil {
+		return 0, fmt.Errorf("metadata is not a JSON object: %w", err)
+	}
+	size := metadataCBORSize(root, true)
+	if limit > 0 && size > limit {
+		return size, fmt.Errorf("metadata serializes to %d bytes, over the %d-byte limit (-max-metadata-bytes); trim traits, files or descriptions", size, limit)
+	}
+	return size, nil
+}
+
+// metadataCBORSize is the size of v in the CBOR encoding cardano-cli gives
+// JSON metadata: objects are maps, arrays arrays, integers ints and strings
+// text. The top level's keys are the integer metadata labels.
+func metadataCBORSize(v interface{}, labels bool) int {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		size := cborHeadSize(uint64(len(t)))
+		for k, item := range t {
+			if n, err := strconv.ParseUint(k, 10, 64); labels && err == nil {
+				size += cborHeadSize(n)
+			} else {
+				size += cborHeadSize(uint64(len(k))) + len(k)
+			}
+			size += metadataCBORSize(item, false)
+		}
+		return size
+	case []interface{}:
+		size := cborHeadSize(uint64(len(t)))
+		for _, item := range t {
+			size += metadataCBORSize(item, false)
+		}
+		return size
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			if n < 0 {
+				n = -1 - n
+			}
+			return cborHeadSize(uint64(n))
+		}
+		return cborHeadSize(uint64(len(t))) + len(t)
+	case string:
+		return cborHeadSize(uint64(len(t))) + len(t)
+	default:
+		return 1
+	}
+}
+
+// cborHeadSize is the size of a CBOR item head carrying n.
+func cborHeadSize(n uint64) int {
+	switch {
+	case n < 24:
+		return 1
+	case n < 1<<8:
+		return 2
+	case n < 1<<16:
+		return 3
+	case n < 1<<32:
+		return 5
+	default:
+		return 9
+	}
+}
+
 // Copy the state.go Save method to save metadata to a file to be used by cardano-cli
 func SaveMetadataToFile(metadata, filePath string) error {
 	return ioutil.WriteFile(filePath, []byte(metadata), 0o644)
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkMetadataString(t *testing.T) {
+	tests := []struct {
+		name   string
+		s      string
+		chunks int
+	}{
+		{name: "empty", s: "", chunks: 1},
+		{name: "short", s: "A shark from the reef.", chunks: 1},
+		{name: "exactly 64 bytes", s: strings.Repeat("a", 64), chunks: 1},
+		{name: "65 bytes", s: strings.Repeat("a", 65), chunks: 2},
+		{name: "200 bytes", s: strings.Repeat("abcd", 50), chunks: 4},
+		// 63 ASCII bytes then a 3-byte rune straddling the boundary
+		{name: "rune at boundary", s: strings.Repeat("a", 63) + "€" + "tail", chunks: 2},
+		{name: "emoji only", s: strings.Repeat("🦈", 40), chunks: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkMetadataString(tt.s)
+			if len(chunks) != tt.chunks {
+				t.Errorf("got %d chunks, want %d: %q", len(chunks), tt.chunks, chunks)
+			}
+			for i, c := range chunks {
+				if len(c) > metadataStringLimit {
+					t.Errorf("chunk %d is %d bytes, over the %d-byte limit", i, len(c), metadataStringLimit)
+				}
+				if !utf8.ValidString(c) {
+					t.Errorf("chunk %d splits a character: %q", i, c)
+				}
+			}
+			if joined := strings.Join(chunks, ""); joined != tt.s {
+				t.Errorf("chunks rejoin to %q, want %q", joined, tt.s)
+			}
+		})
+	}
+}
+
+// tokenMetadata decodes rendered CIP-25 metadata and returns the entry for
+// the token named name under policyID.
+func tokenMetadata(t *testing.T, metadata, policyID, name string) map[string]any {
+	t.Helper()
+	var doc struct {
+		CIP25 map[string]json.RawMessage `json:"721"`
+	}
+	if err := json.Unmarshal([]byte(metadata), &doc); err != nil {
+		t.Fatalf("metadata is not valid JSON: %v\n%s", err, metadata)
+	}
+	var entries map[string]map[string]any
+	json.Unmarshal(doc.CIP25[policyID], &entries)
+	entry, ok := entries[name]
+	if !ok {
+		t.Fatalf("no 721 entry for %s under %s in\n%s", name, policyID, metadata)
+	}
+	return entry
+}
+
+func TestMetadataDescription(t *testing.T) {
+	hexName := hex.EncodeToString([]byte("Flowmass1"))
+	long := "Flowmass is a collection of hand-drawn sharks swimming the Cardano reef, minted on demand."
+
+	short, err := MetadataTemplate(testPolicyID, hexName, "A shark.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tokenMetadata(t, short, testPolicyID, "Flowmass1")["description"]; got != "A shark." {
+		t.Errorf("short description = %v, want a plain string", got)
+	}
+
+	chunked, err := MetadataTemplate(testPolicyID, hexName, long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, ok := tokenMetadata(t, chunked, testPolicyID, "Flowmass1")["description"].([]any)
+	if !ok || len(parts) != 2 {
+		t.Fatalf("long description = %v, want two chunks", tokenMetadata(t, chunked, testPolicyID, "Flowmass1")["description"])
+	}
+	if parts[0].(string)+parts[1].(string) != long {
+		t.Errorf("chunks %q do not rejoin to the description", parts)
+	}
+
+	none, err := MetadataTemplate(testPolicyID, hexName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tokenMetadata(t, none, testPolicyID, "Flowmass1")["description"]; ok {
+		t.Error("an empty description was rendered")
+	}
+}
+
+func TestMetadataUsesConfiguredPolicy(t *testing.T) {
+	te := newTestEngine(t, nil)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: testTxHash(1)})
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 {
+		t.Fatalf("got %d mints, want 1", len(mints))
+	}
+	entry := tokenMetadata(t, string(mints[0].Metadata), testPolicyID, "Flowmass1")
+	if entry["name"] != "Flowmass1" {
+		t.Errorf("metadata name = %v, want Flowmass1", entry["name"])
+	}
+	if want := "1 " + testPolicyID + ".466c6f776d61737331"; !slices.Equal(mints[0].Mint, []string{want}) {
+		t.Errorf("mint = %v, want %s under the same policy", mints[0].Mint, want)
+	}
+}
+
+func TestValidateMetadataPolicy(t *testing.T) {
+	metadata, err := MetadataTemplate(testPolicyID, hex.EncodeToString([]byte("Flowmass1")), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateMetadata(metadata, testPolicyID); err != nil {
+		t.Errorf("ValidateMetadata() under its own policy: %v", err)
+	}
+	other := strings.Repeat("ab", 28)
+	if err := ValidateMetadata(metadata, other); err == nil || !strings.Contains(err.Error(), "does not match minting policy") {
+		t.Errorf("ValidateMetadata() under another policy: err = %v, want a policy mismatch", err)
+	}
+}
+
+func TestMetadataCBORSize(t *testing.T) {
+	tests := []struct {
+		metadata string
+		want     int
+	}{
+		// map(1), label 721, map(1), "a", "b"
+		{metadata: `{"721": {"a": "b"}}`, want: 1 + 3 + 1 + 2 + 2},
+		// map(1), label 674, map(1), "msg", array(2) of "hi" and "there"
+		{metadata: `{"674": {"msg": ["hi", "there"]}}`, want: 1 + 3 + 1 + 4 + 1 + 3 + 6},
+		// integers take their head's size; negative ones count from -1
+		{metadata: `{"1": [0, 23, 24, 255, 256, 65536, -1, -25]}`, want: 1 + 1 + 1 + 1 + 1 + 2 + 2 + 3 + 5 + 1 + 2},
+		// a 24-byte string needs a two-byte head
+		{metadata: `{"1": "` + strings.Repeat("x", 24) + `"}`, want: 1 + 1 + 2 + 24},
+	}
+	for _, tt := range tests {
+		if got, err := checkMetadataSize(tt.metadata, 0); err != nil || got != tt.want {
+			t.Errorf("checkMetadataSize(%s) = %d, %v; want %d", tt.metadata, got, err, tt.want)
+		}
+	}
+	if _, err := checkMetadataSize(`["721"]`, 0); err == nil {
+		t.Error("checkMetadataSize() accepted metadata that is not an object")
+	}
+}
+
+func TestCheckMetadataSizeLimit(t *testing.T) {
+	limit := defaultMaxMetadataBytes
+	withImage := func(n int) string {
+		return `{"721": {"` + testPolicyID + `": {"Flowmass1": {"name": "Flowmass1", "image": "` + strings.Repeat("x", n) + `"}}}}`
+	}
+	// The longest image that fits.
+	n := limit
+	for size, _ := checkMetadataSize(withImage(n), 0); size > limit; size, _ = checkMetadataSize(withImage(n), 0) {
+		n--
+	}
+	if size, err := checkMetadataSize(withImage(n), limit); err != nil || size != limit {
+		t.Fatalf("metadata of exactly %d bytes: size %d, err %v; want it accepted", limit, size, err)
+	}
+	size, err := checkMetadataSize(withImage(n+1), limit)
+	if err == nil || size != limit+1 {
+		t.Fatalf("metadata of %d bytes: size %d, err %v; want it rejected", limit+1, size, err)
+	}
+	if want := "serializes to 14337 bytes, over the 14336-byte limit"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want one containing %q", err, want)
+	}
+	if _, err := checkMetadataSize(withImage(maxTxBytes), 0); err != nil {
+		t.Errorf("a limit of 0 rejected metadata: %v", err)
+	}
+}
+
+func TestOversizedMetadataFailsBeforeBuild(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Description = strings.Repeat("A shark swimming the reef. ", 600)
+		cfg.Settings.maxMetadataBytes = defaultMaxMetadataBytes
+	})
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: dep})
+
+	te.poll()
+	if n := len(te.submitted()); n != 0 {
+		t.Fatalf("%d transactions built with oversized metadata", n)
+	}
+	for _, ev := range te.auditEvents() {
+		if ev.Event == auditFailed && ev.DepositTx == dep {
+			if !strings.Contains(ev.Error, "-max-metadata-bytes") {
+				t.Errorf("failure = %q, want the metadata size named", ev.Error)
+			}
+			return
+		}
+	}
+	t.Error("no failure audit entry")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Mint phases timed by mintTimer and exported as flowmass_mint_phase_seconds.
+// confirm runs from submit until a poll sees the transaction on chain, so
+// its resolution is the poll interval.
+const (
+	phaseSlot    = "slot"
+	phaseUTxO    = "utxo"
+	phaseBuild   = "build"
+	phaseSign    = "sign"
+	phaseSubmit  = "submit"
+	phaseConfirm = "confirm"
+)
+
+// phaseBuckets are the histogram's upper bounds, in seconds.
+var phaseBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// histogram is a Prometheus-style cumulative histogram.
+type histogram struct {
+	counts []uint64 // per bucket in phaseBuckets, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// phaseKey labels one histogram.
+type phaseKey struct {
+	collection, phase string
+}
+
+// mintPhaseSeconds holds a histogram per collection and phase.
+var mintPhaseSeconds = struct {
+	sync.Mutex
+	byKey map[phaseKey]*histogram
+}{byKey: make(map[phaseKey]*histogram)}
+
+// observeMintPhase records that phase of a mint in collection took d.
+func observeMintPhase(collection, phase string, d time.Duration) {
+	mintPhaseSeconds.Lock()
+	defer mintPhaseSeconds.Unlock()
+	key := phaseKey{collection, phase}
+	h := mintPhaseSeconds.byKey[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(phaseBuckets))}
+		mintPhaseSeconds.byKey[key] = h
+	}
+	s := d.Seconds()
+	for i, le := range phaseBuckets {
+		if s <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+// writeMetrics writes the metrics in the Prometheus text format, with the
+// Blockfrost budget bf.
+func writeMetrics(w io.Writer, bf *blockfrostBudget) {
+	writeBlockfrostMetrics(w, bf)
+	mintPhaseSeconds.Lock()
+	defer mintPhaseSeconds.Unlock()
+	keys := make([]phaseKey, 0, len(mintPhaseSeconds.byKey))
+	for k := range mintPhaseSeconds.byKey {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].collection != keys[j].collection {
+			return keys[i].collection < keys[j].collection
+		}
+		return keys[i].phase < keys[j].phase
+	})
+	fmt.Fprintln(w, "# HELP flowmass_mint_phase_seconds Time spent in each step of a mint.")
+	fmt.Fprintln(w, "# TYPE flowmass_mint_phase_seconds histogram")
+	for _, k := range keys {
+		h := mintPhaseSeconds.byKey[k]
+		labels := fmt.Sprintf("collection=%q,phase=%q", k.collection, k.phase)
+		var cumulative uint64
+		for i, le := range phaseBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "flowmass_mint_phase_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "flowmass_mint_phase_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "flowmass_mint_phase_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "flowmass_mint_phase_seconds_count{%s} %d\n", labels, h.count)
+	}
+}
+
+// mintTimer times the phases of one mint: each mark records the time since
+// the previous one. log writes the phases reached as one structured line.
+type mintTimer struct {
+	e       *Engine
+	depTx   string
+	started time.Time
+	last    time.Time
+	fields  []any
+}
+
+// startMintTimer starts timing the mint of deposit depTx.
+func (e *Engine) startMintTimer(depTx string) *mintTimer {
+	now := time.Now()
+	return &mintTimer{e: e, depTx: depTx, started: now, last: now}
+}
+
+// mark ends phase, feeding its duration to the histogram.
+func (t *mintTimer) mark(phase string) {
+	now := time.Now()
+	d := now.Sub(t.last)
+	t.last = now
+	observeMintPhase(t.e.name, phase, d)
+	t.fields = append(t.fields, phase+"_ms", d.Milliseconds())
+}
+
+// log writes the phase timings reached so far.
+func (t *mintTimer) log() {
+	args := append([]any{"deposit_tx", t.depTx}, t.fields...)
+	args = append(args, "total_ms", time.Since(t.started).Milliseconds())
+	t.e.log.Info("mint timing", args...)
+}
+
+// writeBlockfrostMetrics writes the Blockfrost request count and the
+// budget left under -blockfrost-rps/-blockfrost-burst and
+// -blockfrost-daily-limit.
+func writeBlockfrostMetrics(w io.Writer, b *blockfrostBudget) {
+	if b == nil {
+		return
+	}
+	today := b.calls.today()
+	fmt.Fprintln(w, "# HELP flowmass_blockfrost_requests_today Blockfrost requests made since midnight UTC.")
+	fmt.Fprintln(w, "# TYPE flowmass_blockfrost_requests_today gauge")
+	fmt.Fprintf(w, "flowmass_blockfrost_requests_today %d\n", today)
+	if b.limiter != nil {
+		fmt.Fprintln(w, "# HELP flowmass_blockfrost_burst_remaining Blockfrost requests that can be made now without waiting.")
+		fmt.Fprintln(w, "# TYPE flowmass_blockfrost_burst_remaining gauge")
+		fmt.Fprintf(w, "flowmass_blockfrost_burst_remaining %d\n", b.limiter.available())
+	}
+	if b.dailyLimit > 0 {
+		fmt.Fprintln(w, "# HELP flowmass_blockfrost_daily_remaining Blockfrost requests left in today's quota.")
+		fmt.Fprintln(w, "# TYPE flowmass_blockfrost_daily_remaining gauge")
+		fmt.Fprintf(w, "flowmass_blockfrost_daily_remaining %d\n", max(b.dailyLimit-today, 0))
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Queue-full policies for -notify-queue-full.
+const (
+	queueFullDrop  = "drop"  // discard the new notice and count it
+	queueFullBlock = "block" // wait for room, slowing the caller
+)
+
+// notice is one queued notification: plain text, or a mint.
+type notice struct {
+	event string
+	text  string
+	mint  *mintNotice
+}
+
+// notifyQ, when set by startNotifyQueue, decouples notifications from the
+// poll loop: Notify enqueues and one goroutine delivers.
+var notifyQ *notifyQueue
+
+// notifyQueue delivers notices from a buffered channel on a single
+// goroutine, at most one batch per interval. Text notices waiting together
+// are coalesced into one message, so a burst of events costs one request
+// per destination instead of one each.
+type notifyQueue struct {
+	ch       chan notice
+	block    bool
+	interval time.Duration
+	dropped  atomic.Int64
+	done     chan struct{}
+
+	mu     sync.RWMutex // guards closed against enqueues racing the flush
+	closed bool
+}
+
+// maxBatch bounds how many text notices are merged into one message.
+const maxBatch = 10
+
+// startNotifyQueue starts the background sender.
+func startNotifyQueue(size int, fullPolicy string, interval time.Duration) error {
+	if size < 1 {
+		return fmt.Errorf("notification queue size must be at least 1")
+	}
+	if fullPolicy != queueFullDrop && fullPolicy != queueFullBlock {
+		return fmt.Errorf("invalid -notify-queue-full %q (want %s or %s)", fullPolicy, queueFullDrop, queueFullBlock)
+	}
+	q := &notifyQueue{
+		ch:       make(chan notice, size),
+		block:    fullPolicy == queueFullBlock,
+		interval: interval,
+		done:     make(chan struct{}),
+	}
+	go q.run()
+	notifyQ = q
+	return nil
+}
+
+// enqueue adds n, dropping it when the queue is full unless blocking.
+// After the flush, notices are delivered directly.
+func (q *notifyQueue) enqueue(n notice) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		if n.mint != nil {
+			deliverMint(*n.mint)
+		} else {
+			deliverText(n.event, n.text)
+		}
+		return
+	}
+	if q.block {
+		q.ch <- n
+		return
+	}
+	select {
+	case q.ch <- n:
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+func (q *notifyQueue) run() {
+	defer close(q.done)
+	for n := range q.ch {
+		batch := []notice{n}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case next, ok := <-q.ch:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		q.deliver(batch)
+		time.Sleep(q.interval)
+	}
+	if n := q.dropped.Swap(0); n > 0 {
+		notifyLog.Warn("dropped notifications while the queue was full", "dropped", n)
+	}
+}
+
+// deliver sends mint notices individually and the text notices merged.
+func (q *notifyQueue) deliver(batch []notice) {
+	var texts []string
+	event := ""
+	for _, n := range batch {
+		if n.mint != nil {
+			deliverMint(*n.mint)
+			continue
+		}
+		if event == "" {
+			event = n.event
+		}
+		texts = append(texts, n.text)
+	}
+	if n := q.dropped.Swap(0); n > 0 {
+		texts = append(texts, fmt.Sprintf("(%d notification(s) dropped while the queue was full)", n))
+	}
+	if len(texts) > 0 {
+		deliverText(event, strings.Join(texts, "\n"))
+	}
+}
+
+// flushNotifications stops accepting notices and waits up to timeout for
+// the queued ones to be delivered.
+func flushNotifications(timeout time.Duration) {
+	q := notifyQ
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.ch)
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+	case <-time.After(timeout):
+		notifyLog.Warn("gave up flushing notifications", "timeout", timeout, "unsent", len(q.ch))
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// onChainMint is one token minted under the policy, as reconstructed from
+// Blockfrost: the mint transaction, its recipient and the monitor-address
+// inputs it spent (the deposits it paid for).
+type onChainMint struct {
+	Asset      string
+	TokenName  string
+	MintID     int // id in the token name (mintIDFromAsset); 0 if it has none
+	MintTxHash string
+	Recipient  string
+	DepositTxs []string
+}
+
+// mintIDFromAsset returns the mint id in a token name, or 0 if the name
+// is not one the engine mints. patterns are the printf patterns of the
+// engine's names: -asset-name and each tier's prefix (namePatterns). A name
+// formatted from one of them yields its id exactly (so "FM0012" yields 12
+// for "FM%04d"); otherwise a name made of a pattern's text before the id,
+// a space or "#", and a number yields that number ("Flowmass #12"). Other
+// assets under the policy carry no id.
+func mintIDFromAsset(name string, patterns []string) int {
+	for _, pattern := range patterns {
+		var id int
+		if _, err := fmt.Sscanf(name, pattern, &id); err == nil && fmt.Sprintf(pattern, id) == name {
+			return id
+		}
+	}
+	i := len(name)
+	for i > 0 && unicode.IsDigit(rune(name[i-1])) {
+		i--
+	}
+	id, err := strconv.Atoi(name[i:])
+	if err != nil {
+		return 0
+	}
+	stem := strings.TrimRight(name[:i], " #")
+	for _, pattern := range patterns {
+		if verb := strings.Index(pattern, "%"); verb >= 0 && stem == strings.TrimRight(pattern[:verb], " #") {
+			return id
+		}
+	}
+	return 0
+}
+
+// namePatterns returns the printf patterns the engine names tokens with:
+// assetName and the "<prefix>%d" of each tier with an asset_prefix.
+func namePatterns(assetName string, tiers []Tier) []string {
+	patterns := []string{assetName}
+	seen := map[string]bool{assetName: true}
+	for i := range tiers {
+		if p := tiers[i].namePattern(assetName); !seen[p] {
+			seen[p] = true
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// policyAsset is an asset under a policy: its full unit (policy id plus
+// hex name) and its name, decoded from hex where possible.
+type policyAsset struct {
+	Asset string
+	Name  string
+}
+
+// policyAssets lists every asset minted under policyID, paging through
+// Blockfrost's /assets/policy. A policy with nothing minted yet is a 404
+// and yields no assets.
+func policyAssets(bf *blockfrostBudget, policyID, blockfrostKey, network string) ([]policyAsset, error) {
+	base := blockfrostBase(network)
+	var assets []policyAsset
+	for page := 1; ; page++ {
+		var batch []struct {
+			Asset string `json:"asset"`
+		}
+		url := fmt.Sprintf("%s/assets/policy/%s?count=%d&page=%d", base, policyID, blockfrostPageSize, page)
+		if err := blockfrostGet(bf, blockfrostKey, url, &batch); isBlockfrostNotFound(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		for _, a := range batch {
+			name := strings.TrimPrefix(a.Asset, policyID)
+			// CIP-68 names carry a 4-byte label before the display name.
+			for _, label := range []string{cip68UserLabel, cip68RefLabel} {
+				if strings.HasPrefix(name, label) {
+					name = strings.TrimPrefix(name, label)
+					break
+				}
+			}
+			if b, err := hex.DecodeString(name); err == nil {
+				name = string(b)
+			}
+			assets = append(assets, policyAsset{Asset: a.Asset, Name: name})
+		}
+		if len(batch) < blockfrostPageSize {
+			break
+		}
+	}
+	return assets, nil
+}
+
+// assetMinted reports whether unit (policy id plus hex name) has ever been
+// minted. Blockfrost keeps burned assets, so a burned token still counts;
+// a 404 means the name is free.
+func assetMinted(bf *blockfrostBudget, unit, blockfrostKey, network string) (bool, error) {
+	var asset struct {
+		Asset string `json:"asset"`
+	}
+	err := blockfrostGet(bf, blockfrostKey, fmt.Sprintf("%s/assets/%s", blockfrostBase(network), unit), &asset)
+	if isBlockfrostNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// maxOnChainMintID returns the highest mint id among the assets under
+// policyID (see mintIDFromAsset), and the names that carry no id.
+func maxOnChainMintID(bf *blockfrostBudget, policyID string, patterns []string, blockfrostKey, network string) (int, []string, error) {
+	assets, err := policyAssets(bf, policyID, blockfrostKey, network)
+	if err != nil {
+		return 0, nil, err
+	}
+	maxID := 0
+	var unparsed []string
+	for _, a := range assets {
+		id := mintIDFromAsset(a.Name, patterns)
+		if id == 0 {
+			unparsed = append(unparsed, a.Name)
+		}
+		if id > maxID {
+			maxID = id
+		}
+	}
+	return maxID, unparsed, nil
+}
+
+// fetchOnChainMints lists every asset under policyID and resolves the
+// transaction that minted it. Deposits are taken to be the inputs of that
+// transaction that came from monitorAddr (or, when paymentCred is set, any
+// address sharing it), other than outputs of earlier mints.
+func fetchOnChainMints(bf *blockfrostBudget, policyID string, patterns []string, monitorAddr, paymentCred, blockfrostKey, network string) ([]onChainMint, error) {
+	base := blockfrostBase(network)
+	isMonitored := func(addr string) bool {
+		if addr == monitorAddr {
+			return true
+		}
+		if paymentCred == "" {
+			return false
+		}
+		cred, err := PaymentCredential(addr)
+		return err == nil && cred == paymentCred
+	}
+
+	assets, err := policyAssets(bf, policyID, blockfrostKey, network)
+	if err != nil {
+		return nil, err
+	}
+	var mints []onChainMint
+	for _, a := range assets {
+		mints = append(mints, onChainMint{Asset: a.Asset, TokenName: a.Name, MintID: mintIDFromAsset(a.Name, patterns)})
+	}
+
+	mintTxs := make(map[string]bool)
+	for i := range mints {
+		m := &mints[i]
+		var history []struct {
+			TxHash string `json:"tx_hash"`
+			Action string `json:"action"`
+		}
+		if err := blockfrostGet(bf, blockfrostKey, fmt.Sprintf("%s/assets/%s/history?order=asc", base, m.Asset), &history); err != nil {
+			return nil, err
+		}
+		for _, h := range history {
+			if h.Action == "minted" {
+				m.MintTxHash = h.TxHash
+				mintTxs[h.TxHash] = true
+				break
+			}
+		}
+	}
+
+	// Resolve each mint tx once; multi-mint transactions carry several assets.
+	type txInfo struct {
+		recipient string
+		deposits  []string
+	}
+	resolved := make(map[string]txInfo)
+	for tx := range mintTxs {
+		var utxos struct {
+			Inputs []struct {
+				Address string `json:"address"`
+				TxHash  string `json:"tx_hash"`
+			} `json:"inputs"`
+			Outputs []struct {
+				Address string `json:"address"`
+			} `json:"outputs"`
+		}
+		if err := blockfrostGet(bf, blockfrostKey, fmt.Sprintf("%s/txs/%s/utxos", base, tx), &utxos); err != nil {
+			return nil, err
+		}
+		var info txInfo
+		seen := make(map[string]bool)
+		for _, in := range utxos.Inputs {
+			if isMonitored(in.Address) && !mintTxs[in.TxHash] && !seen[in.TxHash] {
+				seen[in.TxHash] = true
+				info.deposits = append(info.deposits, in.TxHash)
+			}
+		}
+		for _, out := range utxos.Outputs {
+			if !isMonitored(out.Address) {
+				info.recipient = out.Address
+				break
+			}
+		}
+		resolved[tx] = info
+	}
+	for i := range mints {
+		info := resolved[mints[i].MintTxHash]
+		mints[i].Recipient = info.recipient
+		mints[i].DepositTxs = info.deposits
+	}
+
+	sort.Slice(mints, func(i, j int) bool { return mints[i].MintID < mints[j].MintID })
+	return mints, nil
+}
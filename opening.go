@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// slotTime returns when slot begins on network, estimated from now and the
+// current tip when the network's genesis timing is unknown.
+func slotTime(network string, slot, tip int64, now time.Time) time.Time {
+	if zero, ok := slotZero[network]; ok {
+		return time.Unix(zero+slot, 0).UTC()
+	}
+	return now.Add(time.Duration(slot-tip) * time.Second).UTC()
+}
+
+// dropOpen reports whether mints can be valid yet: the tip has reached the
+// opening slot of the engine's policy. Before that, reserving ids and
+// building transactions would only earn rejections from the node, so the
+// poll is skipped and deposits wait; the first skipped poll announces when
+// the drop opens. If the tip cannot be read, the poll goes ahead and each
+// mint checks its own validity interval.
+func (e *Engine) dropOpen() bool {
+	open := e.timeLock.after
+	if open == 0 {
+		return true
+	}
+	slot, err := e.currentSlot()
+	if err != nil || slot >= open {
+		return true
+	}
+	now := time.Now()
+	at := slotTime(e.network, open, slot, now)
+	e.log.Info("drop not open yet; deposits wait", "slot", slot, "opens_at", open, "opens_in", at.Sub(now).Round(time.Second))
+	if e.openingAnnounced.CompareAndSwap(false, true) {
+		Notify(eventDropOpens, fmt.Sprintf("%s opens at slot %d, around %s (in %s); deposits sent before then are minted when it opens.",
+			e.displayName(), open, at.Format("2006-01-02 15:04 MST"), at.Sub(now).Round(time.Minute)))
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package main
+
+import "fmt"
+
+// txPosition is where a transaction sits on chain: its block height and
+// its index within the block. Deposits are minted in this order, so a
+// replay of the same deposits onto fresh state assigns the same mint ids.
+type txPosition struct {
+	BlockHeight int64 `json:"block_height"`
+	Index       int   `json:"index"`
+}
+
+// txPosition returns the chain position of txHash from Blockfrost. A
+// transaction never moves once in a block, so positions are cached for the
+// life of the engine.
+func (e *Engine) txPosition(base, txHash string) (txPosition, error) {
+	if pos, ok := e.positions.Load(txHash); ok {
+		return pos.(txPosition), nil
+	}
+	var pos txPosition
+	if err := blockfrostGet(e.settings.blockfrost, e.blockfrostKey, fmt.Sprintf("%s/txs/%s", base, txHash), &pos); err != nil {
+		return txPosition{}, err
+	}
+	if pos.BlockHeight <= 0 {
+		return txPosition{}, fmt.Errorf("transaction %s has no block height yet", txHash)
+	}
+	e.positions.Store(txHash, pos)
+	return pos, nil
+}
+
+// depositBefore orders deposits by chain position (block height, then
+// index in block, then output index), falling back to Seq for deposits
+// without one, such as mock deposits.
+func depositBefore(a, b Deposit) bool {
+	if a.BlockHeight > 0 && b.BlockHeight > 0 {
+		if a.BlockHeight != b.BlockHeight {
+			return a.BlockHeight < b.BlockHeight
+		}
+		if a.TxIndex != b.TxIndex {
+			return a.TxIndex < b.TxIndex
+		}
+	} else if a.Seq != b.Seq {
+		return a.Seq < b.Seq
+	}
+	if a.TxHash != b.TxHash {
+		return a.TxHash < b.TxHash
+	}
+	return a.OutputIndex < b.OutputIndex
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PlutusPolicy switches minting from a native script to a Plutus minting
+// policy. The script file is still the collection's -script; Plutus needs a
+// redeemer for it and a collateral UTxO, forfeited only if the script fails
+// on chain.
+type PlutusPolicy struct {
+	Redeemer   string // redeemer JSON file (cardano-cli --mint-redeemer-file)
+	Collateral string // "txhash#index" of a lovelace-only UTxO
+}
+
+// plutusScriptType is the text envelope type prefix of a Plutus script.
+const plutusScriptType = "PlutusScript"
+
+// NewPlutusPolicy checks the redeemer file and collateral reference. It
+// returns nil when neither is set, i.e. native-script minting.
+func NewPlutusPolicy(redeemerFile, collateral string) (*PlutusPolicy, error) {
+	if redeemerFile == "" && collateral == "" {
+		return nil, nil
+	}
+	if redeemerFile == "" || collateral == "" {
+		return nil, fmt.Errorf("a Plutus minting policy needs both -plutus-redeemer and -collateral")
+	}
+	data, err := os.ReadFile(redeemerFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redeemer: %w", err)
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("redeemer %s is not valid JSON", redeemerFile)
+	}
+	if _, _, err := splitUTxORef(collateral); err != nil {
+		return nil, fmt.Errorf("collateral: %v", err)
+	}
+	return &PlutusPolicy{Redeemer: redeemerFile, Collateral: collateral}, nil
+}
+
+// splitUTxORef splits "txhash#index" and checks both parts.
+func splitUTxORef(ref string) (string, string, error) {
+	tx, idx, ok := strings.Cut(ref, "#")
+	if !ok || len(tx) != 64 || idx == "" {
+		return "", "", fmt.Errorf("%q is not a txhash#index reference", ref)
+	}
+	for _, c := range idx {
+		if c < '0' || c > '9' {
+			return "", "", fmt.Errorf("%q is not a txhash#index reference", ref)
+		}
+	}
+	return tx, idx, nil
+}
+
+// isPlutusScript reports whether filePath holds a Plutus script envelope.
+func isPlutusScript(filePath string) bool {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+	var env keyEnvelope
+	return json.Unmarshal(data, &env) == nil && strings.HasPrefix(env.Type, plutusScriptType)
+}
+
+// mintScriptArgs returns the cardano-cli flags witnessing a mint: the native
+// script, or the Plutus script with its redeemer and collateral.
+func mintScriptArgs(scriptFile string, plutus *PlutusPolicy) []string {
+	if plutus == nil {
+		return []string{"--minting-script-file", scriptFile}
+	}
+	return []string{
+		"--mint-script-file", scriptFile,
+		"--mint-redeemer-file", plutus.Redeemer,
+		"--tx-in-collateral", plutus.Collateral,
+	}
+}
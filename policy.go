@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// mintPolicy is a minting policy and the native script that witnesses it.
+type mintPolicy struct {
+	ID     string
+	Script string
+	lock   timeLock
+	// signers is how many key witnesses the native script needs (see
+	// requiredSigners); 0 for Plutus policies.
+	signers int
+}
+
+// loadTierPolicies checks the policies tiers mint under instead of the
+// engine's, e.g. characters and items sold from one monitor address at
+// different prices. Each such tier names a native script, whose signers and
+// derived policy id are checked as the engine's own are, and which opens
+// no earlier than startSlot. The result maps tier name to policy.
+func loadTierPolicies(tiers []Tier, cardano CardanoClient, signingKeyFiles []string, plutus *PlutusPolicy, startSlot int64, logger *slog.Logger) (map[string]mintPolicy, error) {
+	policies := make(map[string]mintPolicy)
+	for _, t := range tiers {
+		if t.Script == "" {
+			if t.PolicyID != "" {
+				return nil, fmt.Errorf("tier %q: policy_id needs a script", t.Name)
+			}
+			continue
+		}
+		if plutus != nil {
+			return nil, fmt.Errorf("tier %q: per-tier policies must be native scripts; drop -plutus-redeemer and -collateral", t.Name)
+		}
+		script, err := LoadNativeScript(t.Script)
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: %v", t.Name, err)
+		}
+		if len(signingKeyFiles) > 0 {
+			if err := cardano.CheckScriptSigners(script, t.Script, signingKeyFiles); err != nil {
+				return nil, fmt.Errorf("tier %q: %v", t.Name, err)
+			}
+		}
+		policy := mintPolicy{ID: t.PolicyID, Script: t.Script, lock: script.window(), signers: script.requiredSigners()}
+		policy.lock.after = max(policy.lock.after, startSlot)
+		derived, err := cardano.PolicyID(t.Script)
+		switch {
+		case err != nil && policy.ID == "":
+			return nil, fmt.Errorf("tier %q: no policy_id given, and it cannot be derived from %s: %v", t.Name, t.Script, err)
+		case err != nil:
+			logger.Warn("cannot derive policy id from tier script; not checked", "tier", t.Name, "script", t.Script, "error", err)
+		case policy.ID == "":
+			policy.ID = derived
+		case !strings.EqualFold(derived, policy.ID):
+			return nil, fmt.Errorf("tier %q: script %s has policy id %s, but the tier's policy_id is %s", t.Name, t.Script, derived, policy.ID)
+		default:
+			policy.ID = derived
+		}
+		logger.Info("tier mints under its own policy", "tier", t.Name, "script", t.Script, "policy_id", policy.ID)
+		policies[t.Name] = policy
+	}
+	return policies, nil
+}
+
+// tierPolicyIDs returns the ids of the policies tiers mint under other than
+// defaultID: each tier's policy_id, or the one derived from its script. The
+// chain scans of reset-state and recover-counter cover these too, since
+// tiers share the mint counter.
+func tierPolicyIDs(tiers []Tier, defaultID string, cli cardanoCLI) ([]string, error) {
+	seen := map[string]bool{strings.ToLower(defaultID): true}
+	var ids []string
+	for _, t := range tiers {
+		id, err := tierPolicyID(t, cli)
+		if err != nil {
+			return nil, err
+		}
+		if id == "" || seen[strings.ToLower(id)] {
+			continue
+		}
+		seen[strings.ToLower(id)] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// tierPolicyID returns the id of the policy tier t mints under: its
+// policy_id, or the one derived from its script. It is empty for a tier
+// minting under the engine's policy.
+func tierPolicyID(t Tier, cli cardanoCLI) (string, error) {
+	if t.PolicyID != "" || t.Script == "" {
+		return t.PolicyID, nil
+	}
+	id, err := cli.ScriptPolicyID(t.Script)
+	if err != nil {
+		return "", fmt.Errorf("tier %q: no policy_id given, and it cannot be derived from %s: %v", t.Name, t.Script, err)
+	}
+	return id, nil
+}
+
+// policyFor returns the policy a deposit for tier mints under: the tier's
+// own, or the engine's.
+func (e *Engine) policyFor(tier *Tier) mintPolicy {
+	if tier != nil {
+		if p, ok := e.tierPolicies[tier.Name]; ok {
+			return p
+		}
+	}
+	return mintPolicy{ID: e.policyID, Script: e.scriptFile, lock: e.timeLock, signers: e.scriptSigners}
+}
+
+// mintCandidate is a token a mint id may have produced: its name and the
+// policy it was minted under.
+type mintCandidate struct {
+	name   string
+	policy mintPolicy
+}
+
+// mintCandidates returns the tokens a mint for id may have produced: the
+// default name under the engine's policy and each tier's under its policy,
+// since the tier is not stored with the reservation.
+func (e *Engine) mintCandidates(id int) []mintCandidate {
+	def := e.policyFor(nil)
+	candidates := []mintCandidate{{e.tokenName(id), def}}
+	for i := range e.tiers {
+		c := mintCandidate{e.tiers[i].DisplayName(e.assetName, id), e.policyFor(&e.tiers[i])}
+		if c.name != candidates[0].name || c.policy.ID != def.ID {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
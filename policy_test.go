@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// derivingNode is a mockClient that derives policy ids as cardano-cli
+// would, returning id (or err) for every script.
+type derivingNode struct {
+	*mockClient
+	id  string
+	err error
+}
+
+func (n derivingNode) PolicyID(scriptFile string) (string, error) {
+	return n.id, n.err
+}
+
+func TestNewEngineChecksPolicyID(t *testing.T) {
+	other := strings.Repeat("ab", 28)
+	errNoCLI := errors.New("cardano-cli not found")
+	tests := []struct {
+		name       string
+		configured string
+		derived    string
+		deriveErr  error
+		want       string
+		wantErr    string
+	}{
+		{name: "matching", configured: testPolicyID, derived: testPolicyID, want: testPolicyID},
+		{name: "matching upper-case flag", configured: strings.ToUpper(testPolicyID), derived: testPolicyID, want: testPolicyID},
+		{name: "mismatch", configured: other, derived: testPolicyID, wantErr: "has policy id " + testPolicyID + ", but the configured policy id is " + other},
+		{name: "derived without the flag", derived: testPolicyID, want: testPolicyID},
+		{name: "flag without derivation", configured: testPolicyID, deriveErr: errNoCLI, want: testPolicyID},
+		{name: "neither", deriveErr: errNoCLI, wantErr: "no policy id given, and it cannot be derived"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, mock, _, _ := testEngineConfig(t)
+			cfg.PolicyID = tt.configured
+			e, err := NewEngine(cfg, derivingNode{mockClient: mock, id: tt.derived, err: tt.deriveErr})
+			if tt.wantErr != "" {
+				if err == nil {
+					e.Stop()
+					t.Fatalf("NewEngine() succeeded with policy id %s, want an error containing %q", e.policyID, tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewEngine() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			defer e.Stop()
+			if e.policyID != tt.want {
+				t.Errorf("policy id = %s, want %s", e.policyID, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptPolicyID(t *testing.T) {
+	tests := []struct {
+		name, cli, want, wantErr string
+	}{
+		{name: "era command", cli: `[ "$1" = conway ] && echo ` + testPolicyID + `; exit 0`, want: testPolicyID},
+		{name: "legacy command", cli: `[ "$1" = conway ] && exit 1; echo ` + testPolicyID, want: testPolicyID},
+		{name: "not a policy id", cli: `echo "Usage: cardano-cli transaction policyid"`, wantErr: "unexpected policyid output"},
+		{name: "short hash", cli: `echo 1d0cf168`, wantErr: "unexpected policyid output"},
+		{name: "failing", cli: `echo "Invalid script"; exit 1`, wantErr: "failed to derive policy id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCardanoCLI(t, tt.cli+"\n")
+			got, err := testCLI(t, buildAuto).ScriptPolicyID("policy.script")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ScriptPolicyID() = %q, %v; want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("ScriptPolicyID() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
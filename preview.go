@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runPreviewMetadata implements `flowmass preview-metadata <id>`: it renders
+// the metadata mint id would get, from the manifest, a tier's template or
+// the default template, under the policy the mint would use, and prints it
+// indented (the CIP-68 datum with -metadata-standard cip68). The document
+// goes through the checks a mint makes (ValidateMetadata, the 64-byte
+// string and transaction size limits, asset name keys), so template bugs
+// show up before launch. Nothing is reserved, built or submitted.
+func runPreviewMetadata(args []string) error {
+	fs := flag.NewFlagSet("preview-metadata", flag.ExitOnError)
+	policyID := fs.String("policy-id", os.Getenv("POLICY_ID"), "Policy ID substituted into the metadata (default: derived from -script)")
+	scriptFile := fs.String("script", os.Getenv("SCRIPT_FILE"), "Minting script the policy id is derived from and checked against")
+	era := fs.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era used to derive policy ids: babbage or conway")
+	metadataStandard := fs.String("metadata-standard", envOr("METADATA_STANDARD", metadataCIP25), "cip25 prints the label 721 metadata, cip68 the reference token's datum")
+	assetName := fs.String("asset-name", envOr("ASSET_NAME", defaultAssetName), "printf pattern naming each token from its mint id")
+	description := fs.String("description", os.Getenv("DESCRIPTION"), "CIP-25 description for every token")
+	tiersFile := fs.String("tiers", os.Getenv("TIERS_FILE"), "Path to JSON tier config")
+	tierName := fs.String("tier", "", "Tier whose template renders the token (default: the first tier, when -tiers is set)")
+	traitsFile := fs.String("traits", os.Getenv("TRAITS_FILE"), "Path to JSON trait supply shuffled across mint ids")
+	seed := fs.Int64("seed", 0, "Seed of the trait shuffle to preview; use the daemon's logged seed")
+	manifestFile := fs.String("manifest", os.Getenv("MANIFEST_FILE"), "Path to a JSON or CSV manifest")
+	maxMetadataBytes := fs.Int("max-metadata-bytes", defaultMaxMetadataBytes, "Largest serialized transaction metadata a mint may carry (0 = no limit)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flowmass preview-metadata [flags] <id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*policyID == "" && *scriptFile == "") {
+		fs.Usage()
+		return fmt.Errorf("preview-metadata requires a mint id and -policy-id or -script")
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || id < 1 {
+		return fmt.Errorf("invalid mint id %q", fs.Arg(0))
+	}
+	if err := validateAssetName(*assetName); err != nil {
+		return err
+	}
+	if err := validateMetadataStandard(*metadataStandard, *assetName); err != nil {
+		return err
+	}
+	cli, err := newCardanoCLI("", "", *era, buildAuto, "", workDir{}, fileSigner{}, defaultSubmitRetry)
+	if err != nil {
+		return err
+	}
+	// As the engine does: the script's id, which -policy-id must match.
+	if *scriptFile != "" {
+		derived, err := cli.ScriptPolicyID(*scriptFile)
+		switch {
+		case err != nil && *policyID == "":
+			return fmt.Errorf("no policy id given, and it cannot be derived from %s: %v", *scriptFile, err)
+		case err != nil:
+			cmdLog.Warn("cannot derive policy id from minting script; not checked", "script", *scriptFile, "error", err)
+		case *policyID != "" && !strings.EqualFold(derived, *policyID):
+			return fmt.Errorf("minting script %s has policy id %s, but the configured policy id is %s", *scriptFile, derived, *policyID)
+		default:
+			*policyID = derived
+		}
+	}
+
+	var tier *Tier
+	if *tiersFile != "" {
+		tiers, err := LoadTiers(*tiersFile)
+		if err != nil {
+			return err
+		}
+		for i := range tiers {
+			if *tierName == "" || tiers[i].Name == *tierName {
+				tier = &tiers[i]
+				break
+			}
+		}
+		if tier == nil {
+			return fmt.Errorf("no tier named %q in %s", *tierName, *tiersFile)
+		}
+	} else if *tierName != "" {
+		return fmt.Errorf("-tier needs -tiers")
+	}
+	if tier != nil {
+		tierID, err := tierPolicyID(*tier, cli)
+		if err != nil {
+			return err
+		}
+		if tierID != "" {
+			*policyID = tierID
+		}
+	}
+
+	displayName := fmt.Sprintf(*assetName, id)
+	if tier != nil {
+		displayName = tier.DisplayName(*assetName, id)
+	}
+	hexName := hex.EncodeToString([]byte(displayName))
+	if _, err := assetUnit(*policyID, hexName); err != nil {
+		return err
+	}
+
+	var traits map[string]string
+	if *traitsFile != "" {
+		pool, err := LoadTraitPool(*traitsFile, *seed)
+		if err != nil {
+			return err
+		}
+		if traits, err = pool.ForID(id); err != nil {
+			return err
+		}
+	}
+	var manifest *Manifest
+	if *manifestFile != "" {
+		if manifest, err = LoadManifest(*manifestFile); err != nil {
+			return err
+		}
+	}
+	metadata, err := renderMetadata(manifest, tier, []TierMetadata{{
+		ID:          id,
+		Name:        displayName,
+		HexName:     hexName,
+		PolicyID:    *policyID,
+		Traits:      traits,
+		Description: tokenDescription(tier, traits, *description),
+	}})
+	if err != nil {
+		return err
+	}
+
+	printed := metadata
+	if *metadataStandard == metadataCIP68 {
+		if printed, err = cip68Datum(metadata, *policyID, hexName); err != nil {
+			return fmt.Errorf("invalid metadata for %s: %v", displayName, err)
+		}
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, bytes.TrimSpace([]byte(printed)), "", "  "); err != nil {
+		return fmt.Errorf("rendered metadata is not valid JSON: %v", err)
+	}
+	fmt.Println(pretty.String())
+
+	if err := ValidateMetadata(metadata, *policyID); err != nil {
+		return fmt.Errorf("invalid metadata for %s: %v", displayName, err)
+	}
+	size, err := checkMetadataSize(metadata, *maxMetadataBytes)
+	if err != nil {
+		return fmt.Errorf("invalid metadata for %s: %v", displayName, err)
+	}
+	if err := checkMetadataNames(metadata, *policyID, []string{hexName}); err != nil {
+		return fmt.Errorf("invalid metadata for %s: %v", displayName, err)
+	}
+	cmdLog.Info("metadata is valid", "token_name", displayName, "hex_name", hexName, "bytes", size, "limit", *maxMetadataBytes)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Blockfrost limits each project to 10 requests per second with bursts of
+// up to 500, refilled at the same rate, and plans cap requests per day.
+const (
+	defaultBlockfrostRPS   = 10
+	defaultBlockfrostBurst = 500
+)
+
+// blockfrostBudget spaces out and counts the requests made with a
+// Blockfrost project. Every Blockfrost call throttles on it first, so a
+// busy poll (many deposits, each with sender and position lookups) is
+// spaced out instead of throttled. Set from -blockfrost-rps,
+// -blockfrost-burst and -blockfrost-daily-limit; the engines of one process
+// share it, as they share the project. A nil budget neither waits nor
+// counts.
+type blockfrostBudget struct {
+	limiter    *tokenBucket
+	dailyLimit int
+	calls      dailyCounter
+}
+
+// newBlockfrostBudget returns a budget of rps requests per second with
+// bursts of burst (rps 0 = unlimited) and dailyLimit requests per day
+// (0 = unknown).
+func newBlockfrostBudget(rps float64, burst, dailyLimit int) *blockfrostBudget {
+	return &blockfrostBudget{limiter: newTokenBucket(rps, burst), dailyLimit: dailyLimit}
+}
+
+// tokenBucket is a token-bucket rate limiter: it holds up to burst tokens,
+// refilled at rate per second, and each call takes one.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil (no limit) when rps is not
+// positive.
+func newTokenBucket(rps float64, burst int) *tokenBucket {
+	if rps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// refill adds the tokens earned since the last call. The caller holds mu.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// using it. The balance may go negative, which queues concurrent callers
+// one refill interval apart.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait blocks until the caller may make a request.
+func (b *tokenBucket) wait() {
+	if b == nil {
+		return
+	}
+	if d := b.reserve(time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// available returns the whole tokens left in the bucket: the requests that
+// can be made right now without waiting.
+func (b *tokenBucket) available() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 0 {
+		return 0
+	}
+	return int(b.tokens)
+}
+
+// dailyCounter counts events per UTC day, as Blockfrost's daily quota does.
+type dailyCounter struct {
+	mu    sync.Mutex
+	day   string
+	count int
+}
+
+// add counts one event and returns the day's total.
+func (c *dailyCounter) add() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll()
+	c.count++
+	return c.count
+}
+
+// today returns the day's total so far.
+func (c *dailyCounter) today() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roll()
+	return c.count
+}
+
+// roll resets the count when the UTC day changes. The caller holds mu.
+func (c *dailyCounter) roll() {
+	if day := time.Now().UTC().Format(time.DateOnly); day != c.day {
+		c.day, c.count = day, 0
+	}
+}
+
+// throttle waits for the rate limiter and counts the request. Call it
+// before every Blockfrost request.
+func (b *blockfrostBudget) throttle() {
+	if b == nil {
+		return
+	}
+	b.limiter.wait()
+	if n := b.calls.add(); b.dailyLimit > 0 && n == b.dailyLimit*9/10 {
+		engineLog.Warn("Blockfrost daily request budget 90% used", "requests", n, "limit", b.dailyLimit)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurstAndRefill(t *testing.T) {
+	b := newTokenBucket(10, 5)
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	b.last = now
+
+	// A full bucket serves the burst without waiting, then queues callers
+	// one refill interval apart.
+	for i := 0; i < 5; i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("request %d of the burst waits %s, want none", i+1, d)
+		}
+	}
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond} {
+		if d := b.reserve(now); d != want {
+			t.Errorf("request %d past the burst waits %s, want %s", i+1, d, want)
+		}
+	}
+
+	// The queue drains at the rate, then the bucket refills.
+	if d := b.reserve(now.Add(300 * time.Millisecond)); d != 100*time.Millisecond {
+		t.Errorf("after the queue drained: wait %s, want 100ms", d)
+	}
+	now = now.Add(time.Second)
+	if d := b.reserve(now); d != 0 {
+		t.Errorf("after refilling: wait %s, want none", d)
+	}
+
+	// A long idle refills no more than the burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 5; i++ {
+		if d := b.reserve(now); d != 0 {
+			t.Fatalf("request %d after an idle hour waits %s, want none", i+1, d)
+		}
+	}
+	if d := b.reserve(now); d != 100*time.Millisecond {
+		t.Errorf("request past a refilled burst waits %s, want 100ms", d)
+	}
+}
+
+func TestNewTokenBucket(t *testing.T) {
+	if b := newTokenBucket(0, 500); b != nil {
+		t.Errorf("newTokenBucket(0, 500) = %+v, want no limit", b)
+	}
+	var unlimited *tokenBucket
+	unlimited.wait() // must not block or panic
+
+	b := newTokenBucket(2, 0)
+	if b.burst != 1 || b.available() != 1 {
+		t.Errorf("burst %v with %d available, want a burst of 1", b.burst, b.available())
+	}
+	b.reserve(time.Now())
+	if n := b.available(); n != 0 {
+		t.Errorf("available() after taking the only token = %d, want 0", n)
+	}
+}
+
+func TestBlockfrostBudgetCounts(t *testing.T) {
+	bf := newBlockfrostBudget(0, 0, 1000)
+	for i := 0; i < 3; i++ {
+		bf.throttle()
+	}
+	if n := bf.calls.today(); n != 3 {
+		t.Errorf("today() = %d, want 3", n)
+	}
+	var none *blockfrostBudget
+	none.throttle() // a nil budget neither waits nor counts
+
+	// The count starts over on a new UTC day.
+	bf.calls.mu.Lock()
+	bf.calls.day = "2026-09-30"
+	bf.calls.mu.Unlock()
+	if n := bf.calls.today(); n != 0 {
+		t.Errorf("today() after the day rolled = %d, want 0", n)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// checkRecipient reports why addr should not receive a minted token: it is
+// not a Shelley payment address (a stake address has no payment
+// credential), it belongs to another network, its payment credential is a
+// script, or the credential is a burn pattern (one byte repeated, like the
+// all-zero hash of well-known burn addresses).
+func checkRecipient(addr, network string) error {
+	if err := ValidateAddress(addr, network); err != nil {
+		return err
+	}
+	hrp, data, err := bech32Decode(addr)
+	if err != nil {
+		return err
+	}
+	if hrp != "addr" && hrp != "addr_test" {
+		return fmt.Errorf("%s is a stake address with no payment credential", addr)
+	}
+	if len(data) < 29 {
+		return fmt.Errorf("address %s is too short", addr)
+	}
+	addrType, networkID := data[0]>>4, data[0]&0x0f
+	if addrType > 7 {
+		return fmt.Errorf("address %s (type %d) has no payment credential", addr, addrType)
+	}
+	wantID := byte(0)
+	if network == "mainnet" || network == "" {
+		wantID = 1
+	}
+	if networkID != wantID {
+		return fmt.Errorf("address %s has network id %d but network is %s", addr, networkID, network)
+	}
+	if addrType%2 == 1 {
+		return fmt.Errorf("address %s has a script payment credential", addr)
+	}
+	cred := data[1:29]
+	if bytes.Count(cred, cred[:1]) == len(cred) {
+		return fmt.Errorf("address %s has a burn-pattern payment credential (%x repeated)", addr, cred[0])
+	}
+	return nil
+}
+
+// admitRecipient applies -recipient-guard to a deposit. A rejected deposit
+// is dead-lettered at once, with an alert; the buyer's lovelace stays at
+// the monitor address until an operator refunds it to a good address. It
+// reports whether the deposit may mint.
+func (e *Engine) admitRecipient(dep Deposit) bool {
+	if !e.settings.recipientGuard {
+		return true
+	}
+	err := checkRecipient(dep.SenderAddr, e.network)
+	if err == nil {
+		return true
+	}
+	dl := DeadLetter{
+		DepositTx:   dep.TxHash,
+		OutputIndex: dep.OutputIndex,
+		Sender:      dep.SenderAddr,
+		Lovelace:    dep.Amount,
+		LastError:   "recipient rejected: " + strings.Join(strings.Fields(err.Error()), " "),
+		At:          time.Now().UTC(),
+	}
+	if derr := e.state.AddDeadLetter(dl); derr != nil {
+		e.log.Warn("failed to dead-letter deposit", "deposit_tx", dep.TxHash, "error", derr)
+		return false
+	}
+	e.log.Error("recipient rejected; deposit dead-lettered for review", "deposit_tx", dep.TxHash, "recipient", dep.SenderAddr, "reason", err)
+	Notify(eventRecipientRejected, fmt.Sprintf("%s refused to mint for deposit %s (%d lovelace): recipient %s rejected: %v. Review it and refund with `flowmass refund -to <address> %s`.",
+		e.displayName(), dep.TxHash, dep.Amount, dep.SenderAddr, err, dep.TxHash))
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// keyAddress builds a Shelley address under hrp whose 28-byte credentials
+// count up from first, so it does not look like a burn pattern.
+func keyAddress(t *testing.T, hrp string, header byte, first ...byte) string {
+	t.Helper()
+	data := []byte{header}
+	for _, b := range first {
+		for i := byte(0); i < 28; i++ {
+			data = append(data, b+i)
+		}
+	}
+	addr, err := bech32Encode(hrp, data)
+	if err != nil {
+		t.Fatalf("bech32Encode: %v", err)
+	}
+	return addr
+}
+
+func TestCheckRecipient(t *testing.T) {
+	tests := []struct {
+		name, addr, network, wantErr string
+	}{
+		{name: "base", addr: keyAddress(t, "addr", 0x01, 0x10, 0x40), network: "mainnet"},
+		{name: "enterprise", addr: keyAddress(t, "addr", 0x61, 0x10), network: "mainnet"},
+		{name: "preprod enterprise", addr: keyAddress(t, "addr_test", 0x60, 0x10), network: "preprod"},
+		{name: "stake only", addr: keyAddress(t, "stake", 0xe1, 0x10), network: "mainnet", wantErr: "is a stake address with no payment credential"},
+		{name: "testnet on mainnet", addr: keyAddress(t, "addr", 0x60, 0x10), network: "mainnet", wantErr: "has network id 0 but network is mainnet"},
+		{name: "mainnet on preprod", addr: keyAddress(t, "addr_test", 0x61, 0x10), network: "preprod", wantErr: "has network id 1 but network is preprod"},
+		{name: "script", addr: keyAddress(t, "addr", 0x71, 0x10), network: "mainnet", wantErr: "has a script payment credential"},
+		{name: "burn pattern", addr: testAddress(t, "addr", 0x61, 0x00), network: "mainnet", wantErr: "burn-pattern payment credential"},
+		{name: "not an address", addr: "addr1qqqqqq", network: "mainnet", wantErr: "addr1qqqqqq"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRecipient(tt.addr, tt.network)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkRecipient() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkRecipient() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecipientGuardDeadLettersDeposit(t *testing.T) {
+	hook := newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, hook.URL)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.recipientGuard = true
+	})
+	good := keyAddress(t, "addr", 0x61, 0x10)
+	stake := keyAddress(t, "stake", 0xe1, 0x10)
+	testnet := keyAddress(t, "addr", 0x60, 0x10)
+	te.setDeposits(
+		mockDeposit{SenderAddr: good, Amount: testMintPrice, TxHash: testTxHash(1)},
+		mockDeposit{SenderAddr: stake, Amount: testMintPrice, TxHash: testTxHash(2)},
+		mockDeposit{SenderAddr: testnet, Amount: testMintPrice, TxHash: testTxHash(3)},
+	)
+
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 || !te.state.IsProcessed(testTxHash(1)) {
+		t.Fatalf("got mints %+v, want one for the good recipient only", mints)
+	}
+	for _, dep := range []string{testTxHash(2), testTxHash(3)} {
+		if !te.state.IsDeadLettered(dep) {
+			t.Errorf("deposit %s was not dead-lettered", dep)
+		}
+	}
+	dead := te.state.DeadLetters()
+	if len(dead) != 2 {
+		t.Fatalf("got dead letters %+v, want 2", dead)
+	}
+	for _, dl := range dead {
+		if !strings.HasPrefix(dl.LastError, "recipient rejected: ") || dl.Lovelace != testMintPrice {
+			t.Errorf("dead letter %+v, want the refused deposit", dl)
+		}
+	}
+
+	var notices []string
+	for _, post := range hook.received() {
+		if content, _ := post["content"].(string); strings.Contains(content, "refused to mint") {
+			notices = append(notices, content)
+		}
+	}
+	if len(notices) != 2 {
+		t.Fatalf("webhook got refusal notices %q, want 2", notices)
+	}
+	for _, addr := range []string{stake, testnet} {
+		if !strings.Contains(strings.Join(notices, "\n"), addr) {
+			t.Errorf("no refusal notice names %s", addr)
+		}
+	}
+
+	// The next poll leaves the dead-lettered deposits alone.
+	te.poll()
+	if n := len(te.submittedKind("mint")); n != 1 {
+		t.Errorf("got %d mints after a second poll, want 1", n)
+	}
+}
+
+func TestMintToChecksRecipient(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.recipientGuard = true
+	})
+	stake := keyAddress(t, "stake", 0xe1, 0x10)
+	if _, err := te.MintTo(stake, 0); err == nil || !strings.Contains(err.Error(), "recipient rejected") {
+		t.Fatalf("MintTo(stake address) error = %v, want the recipient rejected", err)
+	}
+	if n := len(te.submittedKind("mint")); n != 0 {
+		t.Errorf("got %d mints for a rejected recipient", n)
+	}
+	if _, err := te.MintTo(keyAddress(t, "addr", 0x61, 0x10), 0); err != nil {
+		t.Errorf("MintTo(enterprise address) error = %v", err)
+	}
+}
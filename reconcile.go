@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// reconcilePending settles reservations left by a previous run that crashed
+// between submitting a mint and recording it. A deposit whose reserved
+// tokens are on chain is recorded, for the recipient it reserved them for,
+// and marked processed so it is not minted twice. The mint transaction
+// need not spend the deposit, since wallet inputs are picked by size.
+// Anything else is left for the normal retry. It needs Blockfrost and is
+// safe to run repeatedly.
+func (e *Engine) reconcilePending() {
+	e.reconcilePendingFrom(blockfrostBase(e.network))
+}
+
+// pendingMint is one reservation of a deposit being reconciled.
+type pendingMint struct {
+	key string
+	n   int
+	id  int
+}
+
+// reconcilePendingFrom is reconcilePending against the Blockfrost API at
+// base.
+func (e *Engine) reconcilePendingFrom(base string) {
+	e.reloadMu.RLock()
+	defer e.reloadMu.RUnlock()
+	pending := e.state.Pending()
+	if len(pending) == 0 {
+		return
+	}
+	if e.blockfrostKey == "" {
+		e.log.Info("no blockfrost key; skipping reconcile of pending reservations", "pending", len(pending))
+		return
+	}
+
+	// Multi-mint deposits hold one reservation per token and are settled
+	// together, as they were minted in one transaction.
+	deposits := make(map[string][]pendingMint)
+	for key, id := range pending {
+		if isManualKey(key) {
+			// No deposit stands behind a manual mint to record it under.
+			e.log.Info("pending manual mint cannot be reconciled; leaving it for review", "deposit_tx", key, "mint_id", id)
+			continue
+		}
+		depositTx, n := splitPendingKey(key)
+		deposits[depositTx] = append(deposits[depositTx], pendingMint{key, n, id})
+	}
+
+	settled := 0
+	for depositTx, mints := range deposits {
+		sort.Slice(mints, func(i, j int) bool { return mints[i].n < mints[j].n })
+		recipient := e.state.PendingRecipient(mints[0].key)
+		ids := make([]int, len(mints))
+		for i, m := range mints {
+			ids[i] = m.id
+		}
+		names, mintTx, err := e.findMints(base, ids)
+		if err != nil {
+			e.log.Warn("could not reconcile pending reservation; leaving it for retry", "deposit_tx", depositTx, "mint_ids", ids, "error", err)
+			continue
+		}
+		if mintTx == "" {
+			e.log.Info("pending reservation not minted on chain; leaving it for retry", "deposit_tx", depositTx, "mint_ids", ids)
+			continue
+		}
+
+		e.log.Info("pending reservation was minted before restart; marking processed", "deposit_tx", depositTx, "mint_ids", ids, "token_name", strings.Join(names, ","), "recipient", recipient, "tx_hash", mintTx)
+		rec := MintRecord{
+			DepositTx:  depositTx,
+			MintID:     ids[0],
+			TokenName:  strings.Join(names, ","),
+			Recipient:  recipient,
+			MintTxHash: mintTx,
+		}
+		if len(ids) > 1 {
+			rec.MintIDs = ids
+		}
+		if err := e.state.RecordMint(rec); err != nil {
+			e.log.Warn("failed to record reconciled mint", "deposit_tx", depositTx, "error", err)
+			continue
+		}
+		for _, m := range mints {
+			if err := e.state.ClearPending(m.key); err != nil {
+				e.log.Warn("failed to clear pending reservation", "deposit_tx", m.key, "error", err)
+			}
+		}
+		settled++
+	}
+	if settled > 0 {
+		if err := e.state.Save(); err != nil {
+			e.log.Warn("failed to save state after reconcile", "error", err)
+		}
+	}
+	e.log.Info("reconciled pending reservations", "pending", len(pending), "settled", settled)
+}
+
+// findMints looks for the tokens minted for ids, under their policies. It
+// returns their names and the tx that minted the first, or no tx if none
+// is on chain. A deposit's tokens are minted in one transaction, so only
+// some of them on chain is an error.
+func (e *Engine) findMints(base string, ids []int) ([]string, string, error) {
+	var names []string
+	mintTx := ""
+	for i, id := range ids {
+		name, tx, err := e.findMint(base, id)
+		if err != nil {
+			return nil, "", err
+		}
+		if (tx == "") != (mintTx == "") && i > 0 {
+			return nil, "", fmt.Errorf("only some of mint ids %v are on chain", ids)
+		}
+		if tx == "" {
+			continue
+		}
+		names = append(names, name)
+		if mintTx == "" {
+			mintTx = tx
+		}
+	}
+	return names, mintTx, nil
+}
+
+// findMint looks for the token minted for id among its candidate names. It
+// returns the token name and the tx that minted it, or empty strings if it
+// is not on chain. Ids are reserved once, so a token carrying id was minted
+// for its reservation, whatever inputs the mint spent.
+func (e *Engine) findMint(base string, id int) (string, string, error) {
+	for _, c := range e.mintCandidates(id) {
+		asset := c.policy.ID + e.buyerAssetHex(c.name)
+		var history []struct {
+			TxHash string `json:"tx_hash"`
+			Action string `json:"action"`
+		}
+		err := blockfrostGet(e.settings.blockfrost, e.blockfrostKey, fmt.Sprintf("%s/assets/%s/history?order=asc", base, asset), &history)
+		if err != nil {
+			if isBlockfrostNotFound(err) {
+				continue
+			}
+			return "", "", err
+		}
+		for _, h := range history {
+			if h.Action == "minted" {
+				return c.name, h.TxHash, nil
+			}
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReconcilePending(t *testing.T) {
+	for _, backend := range []string{"json", "sqlite"} {
+		t.Run(backend, func(t *testing.T) {
+			requireBackend(t, backend)
+			te := newTestEngine(t, func(cfg *EngineConfig) {
+				cfg.StateBackend = backend
+				cfg.StateFile = filepath.Join(t.TempDir(), "state."+backend)
+			})
+			te.useBlockfrost()
+			single, bundle, unminted, manual := testTxHash(1), testTxHash(2), testTxHash(3), manualDepositKey()
+			buyer, other := testBuyer(t, 1), testBuyer(t, 2)
+			singleID, err := te.state.ReservePendingMint(single, buyer, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bundleIDs, err := te.state.ReservePendingMints(bundle, other, 2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := te.state.ReservePendingMint(unminted, buyer, 0); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := te.state.ReservePendingMint(manual, buyer, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			// The mint txs spent wallet UTxOs, not the deposits, so no tx is
+			// looked up: the tokens being on chain settles them.
+			mintTx := map[int]string{singleID: testTxHash(10), bundleIDs[0]: testTxHash(11), bundleIDs[1]: testTxHash(11)}
+			units := make(map[string]string)
+			for id, tx := range mintTx {
+				c := te.mintCandidates(id)[0]
+				units[c.policy.ID+te.buyerAssetHex(c.name)] = tx
+			}
+			base := newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+				unit, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/assets/"), "/history")
+				if !ok {
+					t.Errorf("unexpected Blockfrost request %s", r.URL)
+				}
+				tx, ok := units[unit]
+				if !ok {
+					blockfrostNotFound(w)
+					return
+				}
+				json.NewEncoder(w).Encode([]map[string]string{{"tx_hash": tx, "action": "minted", "amount": "1"}})
+			})
+
+			te.reconcilePendingFrom(base)
+
+			rec, ok := te.state.GetMintRecord(single)
+			if !ok || rec.MintID != singleID || rec.Recipient != buyer || rec.MintTxHash != mintTx[singleID] || rec.TokenName != te.tokenName(singleID) || len(rec.MintIDs) != 0 {
+				t.Errorf("single deposit record = %+v, %v", rec, ok)
+			}
+			rec, ok = te.state.GetMintRecord(bundle)
+			if !ok || !slices.Equal(rec.ids(), bundleIDs) || rec.Recipient != other || rec.MintTxHash != mintTx[bundleIDs[0]] {
+				t.Errorf("bundle deposit record = %+v, %v", rec, ok)
+			}
+			if n, err := te.state.WalletMints(other); err != nil || n != 2 {
+				t.Errorf("WalletMints(bundle recipient) = %d, %v; want 2", n, err)
+			}
+			if n, err := te.state.WalletMints(buyer); err != nil || n != 1 {
+				t.Errorf("WalletMints(single recipient) = %d, %v; want 1", n, err)
+			}
+			pending := te.state.Pending()
+			if _, ok := pending[unminted]; !ok || len(pending) != 2 {
+				t.Errorf("pending after reconcile = %v, want the unminted and manual reservations", pending)
+			}
+			if te.state.IsProcessed(unminted) {
+				t.Error("a deposit whose token is not on chain was marked processed")
+			}
+		})
+	}
+}
+
+func TestReconcilePartialBundle(t *testing.T) {
+	te := newTestEngine(t, nil)
+	te.useBlockfrost()
+	dep := testTxHash(1)
+	ids, err := te.state.ReservePendingMints(dep, testBuyer(t, 1), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := te.mintCandidates(ids[0])[0]
+	minted := "/assets/" + c.policy.ID + te.buyerAssetHex(c.name) + "/history"
+	base := newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != minted {
+			blockfrostNotFound(w)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"tx_hash": testTxHash(10), "action": "minted", "amount": "1"}})
+	})
+
+	te.reconcilePendingFrom(base)
+
+	if te.state.IsProcessed(dep) || len(te.state.Pending()) != 2 {
+		t.Errorf("a bundle with only some tokens on chain was settled: processed %v, pending %v", te.state.IsProcessed(dep), te.state.Pending())
+	}
+}
+
+// blockfrostNotFound answers as Blockfrost does for an unknown asset.
+func blockfrostNotFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"status_code": 404, "error": "Not Found", "message": "The requested component has not been found."}`)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runRefund implements `flowmass refund <tx>`: it returns a deposit sitting
+// at the monitor address to its sender (or -to) and, with -state, marks it
+// processed so the engine does not mint for it later. Opening the state
+// takes its lock, so stop the engine first. Nothing is submitted without
+// -yes.
+func runRefund(args []string) error {
+	fs := flag.NewFlagSet("refund", flag.ExitOnError)
+	blockfrostKey := fs.String("blockfrost-key", os.Getenv("BLOCKFROST_API_KEY"), "Blockfrost API key, used to resolve the sender")
+	monitorAddr := fs.String("monitor-address", os.Getenv("MONITOR_ADDRESS"), "Cardano address holding the deposit")
+	to := fs.String("to", "", "Refund to this address instead of the deposit's sender")
+	stateFile := fs.String("state", os.Getenv("STATE_FILE"), "State file to mark the deposit processed in (optional)")
+	stateBackend := fs.String("state-backend", envOr("STATE_BACKEND", "json"), "State storage backend: json or sqlite")
+	var signingKeyFiles stringList
+	fs.Var(&signingKeyFiles, "signing-key", "Path to a signing key for the monitor address; repeat for multisig (default: SIGNING_KEY_FILE)")
+	network := fs.String("network", envOr("CARDANO_NETWORK", "mainnet"), "Cardano network: mainnet or preprod")
+	testnetMagic := fs.String("testnet-magic", envOr("TESTNET_MAGIC", "1"), "Testnet magic number for preprod")
+	era := fs.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era: babbage or conway")
+	workDirFlag := fs.String("work-dir", envOr("WORK_DIR", os.TempDir()), "Directory for the refund transaction files")
+	auditLogFile := fs.String("audit-log", os.Getenv("AUDIT_LOG"), "Audit trail to record the refund in (optional)")
+	confirm := fs.Bool("yes", false, "Confirm the refund")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flowmass refund [flags] <txhash[#index]>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	work, err := newWorkDir(*workDirFlag, false)
+	if err != nil {
+		return err
+	}
+	cli, err := newCardanoCLI(*network, *testnetMagic, *era, buildAuto, "", work, fileSigner{}, defaultSubmitRetry)
+	if err != nil {
+		return err
+	}
+	if len(signingKeyFiles) == 0 {
+		signingKeyFiles.Set(os.Getenv("SIGNING_KEY_FILE"))
+	}
+
+	if fs.NArg() != 1 || *monitorAddr == "" || len(signingKeyFiles) == 0 {
+		fs.Usage()
+		return fmt.Errorf("refund requires a deposit tx, -monitor-address and -signing-key")
+	}
+	depositTx, _, _ := strings.Cut(fs.Arg(0), "#")
+
+	utxos, err := cli.GetUTxOs(*monitorAddr)
+	if err != nil {
+		return err
+	}
+	var utxo *UTxO
+	for i, u := range utxos {
+		if u.ID == fs.Arg(0) || (!strings.Contains(fs.Arg(0), "#") && strings.HasPrefix(u.ID, depositTx+"#")) {
+			utxo = &utxos[i]
+			break
+		}
+	}
+	if utxo == nil {
+		return fmt.Errorf("%s is not an unspent output at %s", fs.Arg(0), *monitorAddr)
+	}
+	if len(utxo.Assets) > 0 {
+		return fmt.Errorf("%s carries native assets; refund it by hand", utxo.ID)
+	}
+
+	recipient := *to
+	if recipient == "" {
+		if *blockfrostKey == "" {
+			return fmt.Errorf("refund needs -blockfrost-key to resolve the sender, or -to")
+		}
+		var details struct {
+			Inputs []struct {
+				Address string `json:"address"`
+			} `json:"inputs"`
+		}
+		if err := blockfrostGet(newBlockfrostBudget(defaultBlockfrostRPS, defaultBlockfrostBurst, 0), *blockfrostKey, fmt.Sprintf("%s/txs/%s/utxos", blockfrostBase(*network), depositTx), &details); err != nil {
+			return fmt.Errorf("failed to resolve sender: %w", err)
+		}
+		if len(details.Inputs) == 0 {
+			return fmt.Errorf("deposit %s has no inputs to refund to", depositTx)
+		}
+		recipient = details.Inputs[0].Address
+	}
+	if err := ValidateAddress(recipient, *network); err != nil {
+		return fmt.Errorf("refund address: %v", err)
+	}
+	if !*confirm {
+		cmdLog.Info("would refund; re-run with -yes to submit", "utxo", utxo.ID, "lovelace", utxo.Lovelace, "recipient", recipient)
+		return nil
+	}
+
+	var state StateStore
+	if *stateFile != "" {
+		if state, err = OpenStateStore(*stateBackend, *stateFile, defaultMaxProcessed); err != nil {
+			return err
+		}
+		defer state.Close()
+	}
+	var trail *auditLog
+	if *auditLogFile != "" {
+		if trail, err = openAuditLog(*auditLogFile); err != nil {
+			return err
+		}
+		defer trail.Close()
+	}
+
+	slot, err := GetCurrentSlotNetwork(*network, *testnetMagic)
+	if err != nil {
+		return err
+	}
+	txFile, err := cli.BuildRefundTransaction(utxo.ID, recipient, slot+10000, len(signingKeyFiles))
+	if err != nil {
+		return err
+	}
+	defer cli.cleanupTemp(txFile)
+	signedFile, err := cli.SignTransaction(txFile, signingKeyFiles)
+	if err != nil {
+		return err
+	}
+	defer cli.cleanupTemp(signedFile)
+	txHash, err := cli.submit.retrySubmit(func() (string, error) {
+		return cli.SubmitTransaction(signedFile)
+	})
+	if err != nil {
+		return err
+	}
+	cmdLog.Info("refunded", "utxo", utxo.ID, "lovelace", utxo.Lovelace, "recipient", recipient, "tx_hash", txHash)
+	trail.record(auditEvent{Event: auditRefunded, DepositTx: depositTx, Lovelace: int64(utxo.Lovelace), Recipient: recipient, TxHash: txHash})
+
+	if state != nil {
+		if err := state.MarkProcessed(depositTx); err != nil {
+			return err
+		}
+		if err := state.ClearPending(depositTx); err != nil {
+			return err
+		}
+		return state.Save()
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// reloadSettings are the settings a running engine can take on SIGHUP. The
+// policy, network, addresses, prices and state stay as they were at start.
+type reloadSettings struct {
+	Tiers          string // tiers file: templates and descriptions are re-read
+	Manifest       string
+	Description    string
+	PriceTolerance int64
+	MaxPerWallet   int
+}
+
+// Reload re-reads the metadata templates and manifest and applies the safe
+// settings, swapping them in between polls. Nothing changes if any file
+// fails to load or a tier's name, price or asset prefix differs.
+func (e *Engine) Reload(s reloadSettings) error {
+	var tiers []Tier
+	if s.Tiers != "" {
+		var err error
+		if tiers, err = LoadTiers(s.Tiers); err != nil {
+			return err
+		}
+		if err := sameTierIdentity(e.tiers, tiers); err != nil {
+			return err
+		}
+	} else if len(e.tiers) > 0 {
+		return fmt.Errorf("tiers cannot be removed while running; restart to switch to a single price")
+	}
+	var manifest *Manifest
+	if s.Manifest != "" {
+		var err error
+		if manifest, err = LoadManifest(s.Manifest); err != nil {
+			return err
+		}
+	}
+	if s.PriceTolerance < 0 || (len(e.tiers) == 0 && s.PriceTolerance >= e.mintPrice) {
+		return fmt.Errorf("-price-tolerance must be between 0 and the mint price")
+	}
+
+	// Wait for a poll in progress so no deposit sees a mix of settings.
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+
+	var changed []string
+	if tiers != nil {
+		e.tiers = tiers
+		changed = append(changed, "tier templates")
+	}
+	if !reflect.DeepEqual(manifest, e.manifest) {
+		e.manifest = manifest
+		changed = append(changed, "manifest")
+	}
+	if s.Description != e.description {
+		e.description = s.Description
+		changed = append(changed, "description")
+	}
+	if s.PriceTolerance != e.priceTolerance {
+		e.priceTolerance = s.PriceTolerance
+		changed = append(changed, "price tolerance")
+	}
+	if s.MaxPerWallet != e.maxPerWallet {
+		e.maxPerWallet = s.MaxPerWallet
+		changed = append(changed, "max per wallet")
+	}
+	e.clearDepositCursor()
+	e.log.Info("reloaded settings", "changed", strings.Join(changed, ", "))
+	return nil
+}
+
+// sameTierIdentity checks that reloaded tiers only differ in what can change
+// mid-mint: templates and descriptions.
+func sameTierIdentity(current, reloaded []Tier) error {
+	if len(current) != len(reloaded) {
+		return fmt.Errorf("tiers file now defines %d tiers instead of %d; restart to change tiers", len(reloaded), len(current))
+	}
+	for i := range current {
+		c, r := current[i], reloaded[i]
+		if c.Name != r.Name || c.Price != r.Price || c.AssetPrefix != r.AssetPrefix || c.Quantity != r.Quantity || c.Script != r.Script || c.PolicyID != r.PolicyID {
+			return fmt.Errorf("tier %q changed its name, price, asset prefix, quantity or policy; restart to change tiers", c.Name)
+		}
+	}
+	return nil
+}
+
+// reloadConfigFile re-reads the safe settings (description, price-tolerance,
+// max-per-wallet) from a -config file over base. Settings given on the
+// command line (explicit) keep their values, as at startup.
+func reloadConfigFile(configFile string, explicit map[string]bool, base reloadSettings) (reloadSettings, error) {
+	values, err := parseConfigFile(configFile)
+	if err != nil {
+		return base, err
+	}
+	for name, vals := range values {
+		if explicit[name] {
+			continue
+		}
+		value := vals[len(vals)-1]
+		switch name {
+		case "description":
+			base.Description = value
+		case "price-tolerance":
+			if base.PriceTolerance, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return base, fmt.Errorf("config file %s: invalid value for %s: %v", configFile, name, err)
+			}
+		case "max-per-wallet":
+			if base.MaxPerWallet, err = strconv.Atoi(value); err != nil {
+				return base, fmt.Errorf("config file %s: invalid value for %s: %v", configFile, name, err)
+			}
+		}
+	}
+	return base, nil
+}
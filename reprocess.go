@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// Reprocess is `flowmass reprocess <tx>`: support's entry point for a
+// deposit that was mis-handled (e.g. recorded processed but its mint never
+// landed). It forgets everything the state holds for the deposit (processed
+// record, pending reservations, failure count, dead letter) and runs it
+// through the mint workflow once. The deposit must still be an eligible
+// output at the monitor address; if not, nothing is reset. Without confirm
+// it only reports what would be reset; the reset is recorded in the audit
+// trail with actor.
+func (e *Engine) Reprocess(depositTx, actor string, confirm bool) error {
+	e.reloadMu.RLock()
+	defer e.reloadMu.RUnlock()
+
+	rec, processed := e.state.GetMintRecord(depositTx)
+	var pending []string
+	for tx, id := range e.state.Pending() {
+		if tx == depositTx || strings.HasPrefix(tx, depositTx+"-") {
+			pending = append(pending, fmt.Sprintf("%d", id))
+		}
+	}
+	dead := e.state.IsDeadLettered(depositTx)
+	e.log.Info("deposit state before reprocess", "deposit_tx", depositTx, "processed", processed,
+		"token_name", rec.TokenName, "mint_tx", rec.MintTxHash, "pending_ids", strings.Join(pending, ","), "dead_lettered", dead)
+	if processed && rec.MintTxHash != "" {
+		e.log.Warn("deposit already minted; reprocessing mints again", "deposit_tx", depositTx, "token_name", rec.TokenName, "mint_tx", rec.MintTxHash)
+	}
+	if !confirm {
+		e.log.Info("dry run; re-run with -yes to reset the deposit and mint for it", "deposit_tx", depositTx)
+		return nil
+	}
+
+	// Check the deposit is still eligible before anything is reset, so a
+	// spent or underpaying deposit leaves its state as it was.
+	e.reprocessTx = depositTx
+	e.clearDepositCursor()
+	deposits, err := e.fetchDeposits()
+	e.reprocessTx = ""
+	if err != nil {
+		return fmt.Errorf("failed to fetch deposits: %w", err)
+	}
+	var dep *Deposit
+	for i := range deposits {
+		if deposits[i].TxHash == depositTx {
+			dep = &deposits[i]
+			break
+		}
+	}
+	if dep == nil {
+		return fmt.Errorf("%s is not an eligible deposit at the monitor address (spent, or it pays no price); its state was left as is", depositTx)
+	}
+
+	// The previous outcome goes into the audit trail before it is dropped.
+	e.audit(auditEvent{Event: auditReprocess, DepositTx: depositTx, MintID: rec.MintID, TokenName: rec.TokenName,
+		Recipient: rec.Recipient, TxHash: rec.MintTxHash, Actor: actor})
+	if err := e.state.ForgetDeposit(depositTx); err != nil {
+		return fmt.Errorf("failed to reset deposit %s: %w", depositTx, err)
+	}
+
+	e.expireLocks()
+	e.resetClaims(e.reservedDeposits([]Deposit{*dep}))
+	e.processDeposit(*dep)
+	if !e.state.IsProcessed(depositTx) {
+		return fmt.Errorf("reprocessing %s did not complete; see the log", depositTx)
+	}
+	return e.state.Save()
+}
+
+// operator names who is running a manual command, for the audit trail.
+func operator() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		name += "@" + host
+	}
+	return name
+}
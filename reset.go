@@ -0,0 +1,213 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runResetState implements `flowmass reset-state`: it rebuilds the state
+// file from what is actually on chain, so a redeploy cannot hand out an id
+// that was already minted. The next mint id becomes the highest on-chain id
+// plus one and every deposit spent by a mint transaction is recorded as
+// processed. With -tiers, the tiers' own policies are scanned as well.
+// Nothing is written without -yes.
+func runResetState(args []string) error {
+	fs := flag.NewFlagSet("reset-state", flag.ExitOnError)
+	blockfrostKey := fs.String("blockfrost-key", os.Getenv("BLOCKFROST_API_KEY"), "Blockfrost API key")
+	monitorAddr := fs.String("monitor-address", os.Getenv("MONITOR_ADDRESS"), "Cardano address deposits are paid to")
+	policyID := fs.String("policy-id", os.Getenv("POLICY_ID"), "NFT minting policy ID")
+	assetName := fs.String("asset-name", envOr("ASSET_NAME", defaultAssetName), "printf pattern the engine names tokens with, used to read ids back from names")
+	stateFile := fs.String("state", envOr("STATE_FILE", "flowmass.state"), "Path to state file to reset")
+	stateBackend := fs.String("state-backend", envOr("STATE_BACKEND", "json"), "State storage backend: json or sqlite")
+	network := fs.String("network", envOr("CARDANO_NETWORK", "mainnet"), "Cardano network: mainnet or preprod")
+	era := fs.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era used to derive tier policy ids: babbage or conway")
+	matchPaymentCred := fs.Bool("match-payment-credential", os.Getenv("MATCH_PAYMENT_CREDENTIAL") == "true", "Treat every address sharing the monitor address's payment credential as the monitor address")
+	tiersFile := fs.String("tiers", os.Getenv("TIERS_FILE"), "Path to JSON tier config; tiers with their own policy are scanned too")
+	confirm := fs.Bool("yes", false, "Write the reset state (without it, only print what would be written)")
+	fs.Parse(args)
+
+	if *blockfrostKey == "" || *monitorAddr == "" || *policyID == "" {
+		return fmt.Errorf("reset-state requires -blockfrost-key, -monitor-address and -policy-id")
+	}
+	if err := validateAssetName(*assetName); err != nil {
+		return err
+	}
+	if err := ValidateAddress(*monitorAddr, *network); err != nil {
+		return err
+	}
+	var paymentCred string
+	if *matchPaymentCred {
+		var err error
+		if paymentCred, err = PaymentCredential(*monitorAddr); err != nil {
+			return err
+		}
+	}
+
+	cli, err := newCardanoCLI(*network, "", *era, buildAuto, "", workDir{}, fileSigner{}, defaultSubmitRetry)
+	if err != nil {
+		return err
+	}
+	tiers, policies, err := scannedPolicies(*tiersFile, *policyID, cli)
+	if err != nil {
+		return err
+	}
+	patterns := namePatterns(*assetName, tiers)
+	var mints []onChainMint
+	bf := newBlockfrostBudget(defaultBlockfrostRPS, defaultBlockfrostBurst, 0)
+	for _, policy := range policies {
+		found, err := fetchOnChainMints(bf, policy, patterns, *monitorAddr, paymentCred, *blockfrostKey, *network)
+		if err != nil {
+			return fmt.Errorf("failed to read on-chain mints under policy %s: %w", policy, err)
+		}
+		cmdLog.Info("on-chain tokens", "policy_id", policy, "tokens", len(found))
+		mints = append(mints, found...)
+	}
+	next, records := resetStateFromMints(mints)
+
+	cmdLog.Info("reset would set the counter and record processed deposits", "next_mint_counter", next, "processed", len(records))
+	for _, m := range mints {
+		if len(m.DepositTxs) == 0 {
+			cmdLog.Warn("mint spent no monitor-address input; its deposit cannot be reconstructed", "token_name", m.TokenName, "mint_tx", m.MintTxHash)
+		}
+	}
+	if !*confirm {
+		cmdLog.Info("dry run; re-run with -yes to overwrite the state", "state", *stateFile)
+		return nil
+	}
+
+	state, err := OpenStateStore(*stateBackend, *stateFile, defaultMaxProcessed)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+	if pending := state.Pending(); len(pending) > 0 {
+		cmdLog.Info("dropping pending reservations", "pending", len(pending))
+	}
+	if err := state.Reset(next, records); err != nil {
+		return fmt.Errorf("failed to reset state: %w", err)
+	}
+	cmdLog.Info("state reset", "state", *stateFile, "next_mint_counter", next, "processed", len(records))
+	return nil
+}
+
+// runRecoverCounter implements `flowmass recover-counter`: after the state
+// file is lost it bootstraps a new one from the chain by setting
+// next_mint_counter to the highest id minted under the policy (and, with
+// -tiers, the tiers' own policies) plus one. Unlike reset-state it only
+// lists the policies' assets, so it is cheap and needs no monitor address,
+// but it records no processed deposits. The counter is never lowered.
+// Nothing is written without -yes.
+func runRecoverCounter(args []string) error {
+	fs := flag.NewFlagSet("recover-counter", flag.ExitOnError)
+	blockfrostKey := fs.String("blockfrost-key", os.Getenv("BLOCKFROST_API_KEY"), "Blockfrost API key")
+	policyID := fs.String("policy-id", os.Getenv("POLICY_ID"), "NFT minting policy ID")
+	assetName := fs.String("asset-name", envOr("ASSET_NAME", defaultAssetName), "printf pattern the engine names tokens with, used to read ids back from names")
+	stateFile := fs.String("state", envOr("STATE_FILE", "flowmass.state"), "Path to state file to recover (created if missing)")
+	stateBackend := fs.String("state-backend", envOr("STATE_BACKEND", "json"), "State storage backend: json or sqlite")
+	network := fs.String("network", envOr("CARDANO_NETWORK", "mainnet"), "Cardano network: mainnet or preprod")
+	era := fs.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era used to derive tier policy ids: babbage or conway")
+	tiersFile := fs.String("tiers", os.Getenv("TIERS_FILE"), "Path to JSON tier config; tiers with their own policy are scanned too")
+	confirm := fs.Bool("yes", false, "Write the recovered counter (without it, only print what would be written)")
+	fs.Parse(args)
+
+	if *blockfrostKey == "" || *policyID == "" {
+		return fmt.Errorf("recover-counter requires -blockfrost-key and -policy-id")
+	}
+	if err := validateAssetName(*assetName); err != nil {
+		return err
+	}
+
+	cli, err := newCardanoCLI(*network, "", *era, buildAuto, "", workDir{}, fileSigner{}, defaultSubmitRetry)
+	if err != nil {
+		return err
+	}
+	tiers, policies, err := scannedPolicies(*tiersFile, *policyID, cli)
+	if err != nil {
+		return err
+	}
+	patterns := namePatterns(*assetName, tiers)
+	maxID := 0
+	bf := newBlockfrostBudget(defaultBlockfrostRPS, defaultBlockfrostBurst, 0)
+	for _, policy := range policies {
+		id, unparsed, err := maxOnChainMintID(bf, policy, patterns, *blockfrostKey, *network)
+		if err != nil {
+			return fmt.Errorf("failed to list assets under policy %s: %w", policy, err)
+		}
+		for _, name := range unparsed {
+			cmdLog.Warn("asset carries no mint id; ignored", "asset", name, "policy_id", policy)
+		}
+		cmdLog.Info("highest on-chain mint id", "policy_id", policy, "mint_id", id)
+		maxID = max(maxID, id)
+	}
+	next := maxID + 1
+	cmdLog.Info("recovered counter", "next_mint_counter", next)
+	if !*confirm {
+		cmdLog.Info("dry run; re-run with -yes to write the state", "state", *stateFile)
+		return nil
+	}
+
+	state, err := OpenStateStore(*stateBackend, *stateFile, defaultMaxProcessed)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+	if current := state.Counter(); current >= next {
+		cmdLog.Info("state counter already there; leaving it", "state", *stateFile, "next_mint_counter", current)
+		return nil
+	}
+	if err := state.SetCounter(next); err != nil {
+		return fmt.Errorf("failed to set counter: %w", err)
+	}
+	cmdLog.Info("state recovered", "state", *stateFile, "next_mint_counter", next)
+	return nil
+}
+
+// scannedPolicies loads the tiers in tiersFile, if set, and returns them
+// with the policies to scan: policyID and every tier's own policy. Tier
+// policies given only as a script are derived with cli.
+func scannedPolicies(tiersFile, policyID string, cli cardanoCLI) ([]Tier, []string, error) {
+	if tiersFile == "" {
+		return nil, []string{policyID}, nil
+	}
+	tiers, err := LoadTiers(tiersFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := tierPolicyIDs(tiers, policyID, cli)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tiers, append([]string{policyID}, ids...), nil
+}
+
+// resetStateFromMints derives the next mint id (highest on-chain id + 1) and
+// one processed-deposit record per deposit spent by a mint transaction.
+func resetStateFromMints(mints []onChainMint) (int, []MintRecord) {
+	maxID := 0
+	byDeposit := make(map[string]int)
+	var records []MintRecord
+	for _, m := range mints {
+		if m.MintID > maxID {
+			maxID = m.MintID
+		}
+		for _, tx := range m.DepositTxs {
+			if i, ok := byDeposit[tx]; ok {
+				if !strings.Contains(","+records[i].TokenName+",", ","+m.TokenName+",") {
+					records[i].TokenName += "," + m.TokenName
+				}
+				continue
+			}
+			byDeposit[tx] = len(records)
+			records = append(records, MintRecord{
+				DepositTx:  tx,
+				MintID:     m.MintID,
+				TokenName:  m.TokenName,
+				Recipient:  m.Recipient,
+				MintTxHash: m.MintTxHash,
+			})
+		}
+	}
+	return maxID + 1, records
+}
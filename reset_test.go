@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResetStateFromMints(t *testing.T) {
+	mints := []onChainMint{
+		{TokenName: "Flowmass1", MintID: 1, MintTxHash: "mint-a", Recipient: "alice", DepositTxs: []string{"dep-a"}},
+		// one deposit minting a bundle in one transaction
+		{TokenName: "Flowmass2", MintID: 2, MintTxHash: "mint-b", Recipient: "bob", DepositTxs: []string{"dep-b"}},
+		{TokenName: "Flowmass3", MintID: 3, MintTxHash: "mint-b", Recipient: "bob", DepositTxs: []string{"dep-b"}},
+		// two combined deposits spent by one mint
+		{TokenName: "Flowmass7", MintID: 7, MintTxHash: "mint-c", Recipient: "carol", DepositTxs: []string{"dep-c1", "dep-c2"}},
+		// a mint that spent no monitor-address input
+		{TokenName: "Flowmass5", MintID: 5, MintTxHash: "mint-d", Recipient: "dave"},
+		// an asset under the policy the engine did not name
+		{TokenName: "Logo", MintTxHash: "mint-e", Recipient: "erin", DepositTxs: []string{"dep-e"}},
+	}
+	next, records := resetStateFromMints(mints)
+	if next != 8 {
+		t.Errorf("next mint id = %d, want 8", next)
+	}
+	want := []MintRecord{
+		{DepositTx: "dep-a", MintID: 1, TokenName: "Flowmass1", Recipient: "alice", MintTxHash: "mint-a"},
+		{DepositTx: "dep-b", MintID: 2, TokenName: "Flowmass2,Flowmass3", Recipient: "bob", MintTxHash: "mint-b"},
+		{DepositTx: "dep-c1", MintID: 7, TokenName: "Flowmass7", Recipient: "carol", MintTxHash: "mint-c"},
+		{DepositTx: "dep-c2", MintID: 7, TokenName: "Flowmass7", Recipient: "carol", MintTxHash: "mint-c"},
+		{DepositTx: "dep-e", TokenName: "Logo", Recipient: "erin", MintTxHash: "mint-e"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records =\n%+v\nwant\n%+v", records, want)
+	}
+
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			s, _ := openTestState(t, backend)
+			if _, err := s.ReservePendingMint("stale", "zed", 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Reset(next, records); err != nil {
+				t.Fatalf("Reset: %v", err)
+			}
+			if s.Counter() != 8 || len(s.Pending()) != 0 {
+				t.Errorf("counter %d, pending %v; want 8 and none", s.Counter(), s.Pending())
+			}
+			for _, r := range want {
+				if !s.IsProcessed(r.DepositTx) {
+					t.Errorf("%s not processed after the reset", r.DepositTx)
+				}
+			}
+			if n, _ := s.WalletMints("bob"); n != 2 {
+				t.Errorf("WalletMints(bob) = %d, want the bundle's 2", n)
+			}
+		})
+	}
+}
+
+func TestMintIDFromAsset(t *testing.T) {
+	patterns := namePatterns("FM%04d", []Tier{{Name: "gold", AssetPrefix: "Gold #"}})
+	tests := map[string]int{
+		"FM0012":      12,
+		"FM 12":       12,
+		"FM#3":        3,
+		"Gold #7":     7,
+		"Gold 8":      8,
+		"Silver #7":   0,
+		"FMlogo":      0,
+		"Flowmass 12": 0,
+	}
+	for name, want := range tests {
+		if got := mintIDFromAsset(name, patterns); got != want {
+			t.Errorf("mintIDFromAsset(%q) = %d, want %d", name, got, want)
+		}
+	}
+}
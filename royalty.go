@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// royaltyMetadata returns the CIP-27 transaction metadata: label 777 with
+// the royalty rate and the payment address, chunked to 64-byte strings.
+func royaltyMetadata(rate float64, addr string) (string, error) {
+	if rate < 0 || rate > 1 {
+		return "", fmt.Errorf("royalty rate must be between 0 and 1, got %v", rate)
+	}
+	out, err := json.Marshal(map[string]interface{}{
+		"777": map[string]interface{}{
+			"rate": strconv.FormatFloat(rate, 'f', -1, 64),
+			"addr": metadataValue(addr),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// MintRoyalty mints the CIP-27 royalty token, the empty-named asset of
+// policyID, with label 777 metadata naming rate and addr. It is spent from
+// and returned to the enterprise address of signingKeyFile, which must
+// also witness the policy script. Mint it once, before the collection's
+// first token, and then lock the policy.
+func (cli cardanoCLI) MintRoyalty(rate float64, addr, policyID, scriptFile, signingKeyFile string) (string, error) {
+	metadata, err := royaltyMetadata(rate, addr)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateAddress(addr, cli.network); err != nil {
+		return "", fmt.Errorf("royalty address: %w", err)
+	}
+
+	keyAddr, err := cli.KeyAddress(signingKeyFile)
+	if err != nil {
+		return "", err
+	}
+	utxos, err := cli.GetUTxOs(keyAddr)
+	if err != nil {
+		return "", err
+	}
+	var txIn string
+	for _, u := range utxos {
+		if len(u.Assets) == 0 && u.Lovelace >= 3000000 {
+			txIn = u.ID
+			break
+		}
+	}
+	if txIn == "" {
+		return "", fmt.Errorf("%s has no lovelace-only UTxO of at least 3 ADA to pay for the royalty mint", keyAddr)
+	}
+
+	slot, err := GetCurrentSlotNetwork(cli.network, cli.testnetMagic)
+	if err != nil {
+		return "", err
+	}
+	invalidBefore, invalidHereafter, err := scriptInterval(scriptFile, slot)
+	if err != nil {
+		return "", err
+	}
+
+	metadataFile, err := cli.tempPath("royalty-*.json")
+	if err != nil {
+		return "", err
+	}
+	txFile, err := cli.tempPath("royalty-*.raw")
+	if err != nil {
+		cli.cleanupTemp(metadataFile)
+		return "", err
+	}
+	var signedFile string
+	defer func() { cli.cleanupTemp(metadataFile, txFile, signedFile) }()
+	if err := SaveMetadataToFile(metadata, metadataFile); err != nil {
+		return "", fmt.Errorf("failed to write metadata file: %w", err)
+	}
+
+	// The royalty token has an empty asset name, so the unit is the bare
+	// policy id. It stays with the change at the key's address.
+	args := []string{
+		cli.era, "transaction", "build",
+		"--tx-in", txIn,
+		"--mint", fmt.Sprintf("1 %s", policyID),
+		"--minting-script-file", scriptFile,
+		"--metadata-json-file", metadataFile,
+		"--change-address", keyAddr,
+		"--out-file", txFile,
+	}
+	args = append(args, validityArgs(invalidBefore, invalidHereafter)...)
+	netArgsWithSocket, err := socketAndNetArgs(cli.network, cli.testnetMagic)
+	if err != nil {
+		return "", err
+	}
+	args = append(args, netArgsWithSocket...)
+	cardanoLog.Debug("building royalty transaction", "args", args)
+
+	if output, err := exec.Command("cardano-cli", args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to build royalty transaction: %w (output: %s)", err, string(output))
+	}
+
+	signedFile, err = cli.SignTransaction(txFile, []string{signingKeyFile})
+	if err != nil {
+		return "", err
+	}
+	return cli.submit.retrySubmit(func() (string, error) {
+		return cli.SubmitTransaction(signedFile)
+	})
+}
+
+// runRoyalty implements `flowmass royalty`: the one-time CIP-27 royalty
+// mint for a policy. Nothing is submitted without -yes.
+func runRoyalty(args []string) error {
+	fs := flag.NewFlagSet("royalty", flag.ExitOnError)
+	rate := fs.Float64("rate", -1, "Royalty rate between 0 and 1 (e.g. 0.05 for 5%)")
+	addr := fs.String("address", "", "Address royalties are paid to")
+	policyID := fs.String("policy-id", os.Getenv("POLICY_ID"), "NFT minting policy ID")
+	scriptFile := fs.String("script", os.Getenv("SCRIPT_FILE"), "Path to minting script file")
+	signingKeyFile := fs.String("signing-key", os.Getenv("SIGNING_KEY_FILE"), "Path to the signing key funding the mint and witnessing the policy")
+	network := fs.String("network", envOr("CARDANO_NETWORK", "mainnet"), "Cardano network: mainnet or preprod")
+	testnetMagic := fs.String("testnet-magic", envOr("TESTNET_MAGIC", "1"), "Testnet magic number for preprod")
+	era := fs.String("era", envOr("CARDANO_ERA", defaultEra), "cardano-cli era: babbage or conway")
+	workDirFlag := fs.String("work-dir", envOr("WORK_DIR", os.TempDir()), "Directory for the royalty transaction files")
+	confirm := fs.Bool("yes", false, "Confirm the mint (a policy should carry one royalty token)")
+	fs.Parse(args)
+	work, err := newWorkDir(*workDirFlag, false)
+	if err != nil {
+		return err
+	}
+	cli, err := newCardanoCLI(*network, *testnetMagic, *era, buildAuto, "", work, fileSigner{}, defaultSubmitRetry)
+	if err != nil {
+		return err
+	}
+
+	if *addr == "" || *policyID == "" || *scriptFile == "" || *signingKeyFile == "" {
+		return fmt.Errorf("royalty requires -rate, -address, -policy-id, -script and -signing-key")
+	}
+	metadata, err := royaltyMetadata(*rate, *addr)
+	if err != nil {
+		return err
+	}
+	if _, err := ValidateSigningKey(*signingKeyFile); err != nil {
+		return err
+	}
+	if !*confirm {
+		cmdLog.Info("would mint the royalty token; re-run with -yes to submit", "policy_id", *policyID, "metadata", metadata)
+		return nil
+	}
+
+	txHash, err := cli.MintRoyalty(*rate, *addr, *policyID, *scriptFile, *signingKeyFile)
+	if err != nil {
+		return err
+	}
+	cmdLog.Info("minted royalty token", "policy_id", *policyID, "tx_hash", txHash)
+	return nil
+}
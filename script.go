@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nativeScript is a cardano-cli simple (native) script: a key signature
+// ("sig"), a time lock ("before"/"after") or a combination of them
+// ("all", "any", "atLeast").
+type nativeScript struct {
+	Type     string         `json:"type"`
+	KeyHash  string         `json:"keyHash,omitempty"`
+	Slot     int64          `json:"slot,omitempty"`
+	Required int            `json:"required,omitempty"`
+	Scripts  []nativeScript `json:"scripts,omitempty"`
+}
+
+// LoadNativeScript reads a native script JSON file.
+func LoadNativeScript(filePath string) (*nativeScript, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script %s: %w", filePath, err)
+	}
+	var s nativeScript
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("script %s is not a native script: %v", filePath, err)
+	}
+	if strings.HasPrefix(s.Type, plutusScriptType) {
+		return nil, fmt.Errorf("script %s is a %s, not a native script; set -plutus-redeemer and -collateral to mint with it", filePath, s.Type)
+	}
+	if s.Type == "" {
+		return nil, fmt.Errorf("script %s has no \"type\" field", filePath)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("script %s is not a valid native script: %v", filePath, err)
+	}
+	return &s, nil
+}
+
+// validate checks every node of the script has a known type and the
+// fields that type needs.
+func (s *nativeScript) validate() error {
+	switch s.Type {
+	case "sig":
+		if b, err := hex.DecodeString(s.KeyHash); err != nil || len(b) != 28 {
+			return fmt.Errorf("\"sig\" needs a 56-hex-digit keyHash, got %q", s.KeyHash)
+		}
+	case "before", "after":
+		if s.Slot <= 0 {
+			return fmt.Errorf("%q needs a positive slot", s.Type)
+		}
+	case "all", "any", "atLeast":
+		if s.Type == "atLeast" && (s.Required <= 0 || s.Required > len(s.Scripts)) {
+			return fmt.Errorf("\"atLeast\" requires %d of %d scripts", s.Required, len(s.Scripts))
+		}
+		for i := range s.Scripts {
+			if err := s.Scripts[i].validate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown script type %q (want all, any, atLeast, sig, before or after)", s.Type)
+	}
+	return nil
+}
+
+// ScriptPolicyID derives the policy id of a minting script with
+// cardano-cli, trying the era-scoped command before the legacy one.
+func (cli cardanoCLI) ScriptPolicyID(scriptFile string) (string, error) {
+	out, err := exec.Command("cardano-cli", cli.era, "transaction", "policyid", "--script-file", scriptFile).CombinedOutput()
+	if err != nil {
+		if out, err = exec.Command("cardano-cli", "transaction", "policyid", "--script-file", scriptFile).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to derive policy id: %w (output: %s)", err, strings.TrimSpace(string(out)))
+		}
+	}
+	id := strings.TrimSpace(string(out))
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 28 {
+		return "", fmt.Errorf("unexpected policyid output: %s", id)
+	}
+	return id, nil
+}
+
+// keyHashes returns every key hash the script mentions, sorted.
+func (s *nativeScript) keyHashes() []string {
+	seen := make(map[string]bool)
+	var walk func(n *nativeScript)
+	walk = func(n *nativeScript) {
+		if n.Type == "sig" && n.KeyHash != "" {
+			seen[strings.ToLower(n.KeyHash)] = true
+		}
+		for i := range n.Scripts {
+			walk(&n.Scripts[i])
+		}
+	}
+	walk(s)
+	hashes := make([]string, 0, len(seen))
+	for h := range seen {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// signedBy reports whether witnesses from the given key hashes satisfy the
+// script's signature requirements. Time locks count as met; they are
+// checked against the validity interval instead.
+func (s *nativeScript) signedBy(have map[string]bool) bool {
+	switch s.Type {
+	case "sig":
+		return have[strings.ToLower(s.KeyHash)]
+	case "all":
+		for i := range s.Scripts {
+			if !s.Scripts[i].signedBy(have) {
+				return false
+			}
+		}
+		return true
+	case "any":
+		for i := range s.Scripts {
+			if s.Scripts[i].signedBy(have) {
+				return true
+			}
+		}
+		return len(s.Scripts) == 0
+	case "atLeast":
+		n := 0
+		for i := range s.Scripts {
+			if s.Scripts[i].signedBy(have) {
+				n++
+			}
+		}
+		return n >= s.Required
+	default: // "before", "after"
+		return true
+	}
+}
+
+// requiredSigners returns the fewest key signatures that satisfy the
+// script: one per "sig", all of an "all", the cheapest branch of an "any"
+// and the cheapest "required" branches of an "atLeast". Time locks need
+// none. A multisig mint carries this many policy witnesses however many
+// cosigners the script lists.
+func (s *nativeScript) requiredSigners() int {
+	switch s.Type {
+	case "sig":
+		return 1
+	case "all", "any", "atLeast":
+		counts := make([]int, len(s.Scripts))
+		for i := range s.Scripts {
+			counts[i] = s.Scripts[i].requiredSigners()
+		}
+		sort.Ints(counts)
+		need := len(counts)
+		switch s.Type {
+		case "any":
+			need = min(1, need)
+		case "atLeast":
+			need = s.Required
+		}
+		n := 0
+		for _, c := range counts[:need] {
+			n += c
+		}
+		return n
+	default: // "before", "after"
+		return 0
+	}
+}
+
+// KeyHash returns the hex verification key hash of a payment signing key.
+func (cli cardanoCLI) KeyHash(signingKeyFile string) (string, error) {
+	vkeyFile, err := cli.verificationKeyFile(signingKeyFile)
+	if err != nil {
+		return "", err
+	}
+	defer cli.cleanupTemp(vkeyFile)
+	out, err := exec.Command("cardano-cli", cli.era, "address", "key-hash",
+		"--payment-verification-key-file", vkeyFile).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to hash verification key: %w (output: %s)", err, string(out))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkScriptSigners fails when the signing keys cannot witness the minting
+// script, which would otherwise only surface as a script-witness error on
+// every submit.
+func (cli cardanoCLI) checkScriptSigners(script *nativeScript, scriptFile string, signingKeyFiles []string) error {
+	have := make(map[string]bool)
+	var hashes []string
+	for _, keyFile := range signingKeyFiles {
+		h, err := cli.KeyHash(keyFile)
+		if err != nil {
+			return err
+		}
+		have[strings.ToLower(h)] = true
+		hashes = append(hashes, fmt.Sprintf("%s (%s)", h, keyFile))
+	}
+	if !script.signedBy(have) {
+		return fmt.Errorf("signing keys %s do not satisfy minting script %s, which expects key hashes %s; check -signing-key",
+			strings.Join(hashes, ", "), scriptFile, strings.Join(script.keyHashes(), ", "))
+	}
+	return nil
+}
+
+// defaultTTLSlots is how far past the current slot a transaction stays valid
+// (about 2.7 hours) unless -tx-ttl-slots says otherwise.
+const defaultTTLSlots = 10000
+
+// timeLock is the validity window a native script imposes on every
+// transaction that uses it. before is the first slot at which the policy is
+// locked (the script's "before"), after the first slot it opens (its
+// "after"); 0 means unbounded.
+type timeLock struct {
+	before int64
+	after  int64
+}
+
+// window returns the time lock set by the script's mandatory branches:
+// the script itself and everything reachable through "all". Locks under
+// "any" or "atLeast" are optional and ignored.
+func (s *nativeScript) window() timeLock {
+	var tl timeLock
+	var walk func(n *nativeScript)
+	walk = func(n *nativeScript) {
+		switch n.Type {
+		case "before":
+			if tl.before == 0 || n.Slot < tl.before {
+				tl.before = n.Slot
+			}
+		case "after":
+			if n.Slot > tl.after {
+				tl.after = n.Slot
+			}
+		case "all":
+			for i := range n.Scripts {
+				walk(&n.Scripts[i])
+			}
+		}
+	}
+	walk(s)
+	return tl
+}
+
+// interval returns the --invalid-before (0 to omit) and --invalid-hereafter
+// slots for a transaction built at slot that stays valid for ttl slots.
+// invalid-hereafter is clamped to the policy's lock and invalid-before set
+// to its opening slot. It fails when the policy is not open at slot; once
+// locked, the failure is permanent.
+func (tl timeLock) interval(slot, ttl int64) (int64, int64, error) {
+	if tl.after > 0 && slot < tl.after {
+		return 0, 0, fmt.Errorf("minting opens at slot %d (current slot %d); not submitting early", tl.after, slot)
+	}
+	if tl.before > 0 && slot >= tl.before {
+		return 0, 0, permanent(fmt.Errorf("minting policy locked at slot %d (current slot %d)", tl.before, slot))
+	}
+	hereafter := slot + ttl
+	if tl.before > 0 && tl.before < hereafter {
+		hereafter = tl.before
+	}
+	return tl.after, hereafter, nil
+}
+
+// scriptInterval loads scriptFile and returns its validity interval at slot,
+// for the one-off commands that mint or burn without an engine.
+func scriptInterval(scriptFile string, slot int64) (int64, int64, error) {
+	script, err := LoadNativeScript(scriptFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	return script.window().interval(slot, defaultTTLSlots)
+}
+
+// validityArgs returns the cardano-cli validity interval flags.
+func validityArgs(invalidBefore, invalidHereafter int64) []string {
+	args := []string{"--invalid-hereafter", strconv.FormatInt(invalidHereafter, 10)}
+	if invalidBefore > 0 {
+		args = append(args, "--invalid-before", strconv.FormatInt(invalidBefore, 10))
+	}
+	return args
+}
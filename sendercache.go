@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultSenderCacheTTL is how long a resolved deposit sender is remembered
+// in the state unless -sender-cache-ttl says otherwise. Resolving one costs
+// a Blockfrost /txs/{hash}/utxos call, and an unprocessed deposit (waiting
+// for confirmations, a top-up or funds) is re-resolved on every poll and
+// after every restart otherwise. A transaction's inputs never change, so
+// the TTL only bounds how long entries for deposits that were never
+// processed linger. 0 disables the cache.
+const defaultSenderCacheTTL = 7 * 24 * time.Hour
+
+// cachedSender is a sender cache entry.
+type cachedSender struct {
+	Address string    `json:"address"`
+	At      time.Time `json:"at"`
+}
+
+// resolveSender returns the address that funded depositTx (the first input
+// of the transaction), from the sender cache or Blockfrost, or "unknown"
+// if it cannot be resolved. Senders looked up on Blockfrost are added to
+// resolved for cacheSenders.
+func (e *Engine) resolveSender(base, depositTx string, resolved map[string]string) string {
+	if ttl := e.settings.senderCacheTTL; ttl > 0 {
+		if sender, ok := e.state.CachedSender(depositTx, ttl); ok {
+			return sender
+		}
+	}
+
+	e.settings.blockfrost.throttle()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "curl", "-s",
+		"-H", fmt.Sprintf("project_id:%s", e.blockfrostKey),
+		fmt.Sprintf("%s/txs/%s/utxos", base, depositTx))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		e.log.Warn("failed to resolve tx sender", "deposit_tx", depositTx, "error", err, "output", strings.TrimSpace(string(out)))
+		return "unknown"
+	}
+	var txDetails struct {
+		Inputs []struct {
+			Address string `json:"address"`
+		} `json:"inputs"`
+	}
+	if err := json.Unmarshal(out, &txDetails); err != nil || len(txDetails.Inputs) == 0 {
+		return "unknown"
+	}
+	sender := txDetails.Inputs[0].Address
+	resolved[depositTx] = sender
+	return sender
+}
+
+// cacheSenders stores the senders resolved during a poll in one state
+// write.
+func (e *Engine) cacheSenders(resolved map[string]string) {
+	if e.settings.senderCacheTTL <= 0 || len(resolved) == 0 {
+		return
+	}
+	if err := e.state.CacheSenders(resolved, e.settings.senderCacheTTL); err != nil {
+		e.log.Warn("failed to cache tx senders", "senders", len(resolved), "error", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResolveSenderHitsCache(t *testing.T) {
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	buyer := testBuyer(t, 1)
+	base := newBlockfrostServer(t, func(w http.ResponseWriter, r *http.Request) {
+		tx := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/txs/"), "/utxos")
+		mu.Lock()
+		lookups[tx]++
+		mu.Unlock()
+		fmt.Fprintf(w, `{"hash": %q, "inputs": [{"address": %q}], "outputs": []}`, tx, buyer)
+	})
+	count := func(tx string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return lookups[tx]
+	}
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.senderCacheTTL = time.Hour
+	})
+	te.useBlockfrost()
+	dep := testTxHash(1)
+
+	resolved := make(map[string]string)
+	if got := te.resolveSender(base, dep, resolved); got != buyer {
+		t.Fatalf("resolveSender() = %q, want %q", got, buyer)
+	}
+	te.cacheSenders(resolved)
+	if got := te.resolveSender(base, dep, make(map[string]string)); got != buyer || count(dep) != 1 {
+		t.Errorf("second resolveSender() = %q after %d lookups, want %q from the cache", got, count(dep), buyer)
+	}
+
+	// Entries older than the TTL are looked up again.
+	te.settings.senderCacheTTL = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if got := te.resolveSender(base, dep, make(map[string]string)); got != buyer || count(dep) != 2 {
+		t.Errorf("resolveSender() past the TTL = %q after %d lookups, want a fresh lookup", got, count(dep))
+	}
+
+	// A TTL of 0 caches nothing.
+	te.settings.senderCacheTTL = 0
+	other := testTxHash(2)
+	resolved = make(map[string]string)
+	te.resolveSender(base, other, resolved)
+	te.cacheSenders(resolved)
+	te.resolveSender(base, other, make(map[string]string))
+	if n := count(other); n != 2 {
+		t.Errorf("uncached sender looked up %d times, want 2", n)
+	}
+}
+
+func TestSenderCachePersists(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			s, path := openTestState(t, backend)
+			if err := s.CacheSenders(map[string]string{"tx-a": "alice"}, time.Hour); err != nil {
+				t.Fatalf("CacheSenders: %v", err)
+			}
+			s.Close()
+
+			r, err := OpenStateStore(backend, path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer r.Close()
+			if sender, ok := r.CachedSender("tx-a", time.Hour); !ok || sender != "alice" {
+				t.Errorf("reopened CachedSender(tx-a) = %q, %v; want alice", sender, ok)
+			}
+			if _, ok := r.CachedSender("tx-b", time.Hour); ok {
+				t.Error("CachedSender found a tx that was never cached")
+			}
+		})
+	}
+}
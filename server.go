@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Limits on /mints?limit=N.
+const (
+	defaultMintsLimit = 20
+	maxMintsLimit     = 500
+)
+
+// engineStatus is one collection's entry on /status.
+type engineStatus struct {
+	Collection string        `json:"collection,omitempty"`
+	PolicyID   string        `json:"policy_id"`
+	NextMintID int           `json:"next_mint_id"`
+	Pending    int           `json:"pending"`
+	DeadLetter int           `json:"dead_letters"`
+	Polling    breakerStatus `json:"polling"`
+	// Tip is the latest background tip check; absent before the first.
+	Tip *tipStatus `json:"tip,omitempty"`
+}
+
+// status reports the engine's live state.
+func (e *Engine) status() engineStatus {
+	return engineStatus{
+		Collection: e.name,
+		PolicyID:   e.policyID,
+		NextMintID: e.state.Counter(),
+		Pending:    len(e.state.Pending()),
+		DeadLetter: len(e.state.DeadLetters()),
+		Polling:    e.breaker.status(),
+		Tip:        e.tip.status(),
+	}
+}
+
+// mintEntry is one mint on /mints.
+type mintEntry struct {
+	Collection string     `json:"collection,omitempty"`
+	MintID     int        `json:"id"`
+	TokenName  string     `json:"token_name"` // comma-separated for multi-mint deposits
+	Recipient  string     `json:"recipient"`
+	TxHash     string     `json:"tx_hash"`
+	MintedAt   *time.Time `json:"minted_at,omitempty"`
+}
+
+// recentMints returns the engine's last limit mints, newest first.
+func (e *Engine) recentMints(limit int) []mintEntry {
+	records := e.state.MintRecords()
+	var mints []mintEntry
+	for i := len(records) - 1; i >= 0 && len(mints) < limit; i-- {
+		rec := records[i]
+		if rec.MintTxHash == "" {
+			continue
+		}
+		mints = append(mints, mintEntry{
+			Collection: e.name,
+			MintID:     rec.MintID,
+			TokenName:  rec.TokenName,
+			Recipient:  rec.Recipient,
+			TxHash:     rec.MintTxHash,
+			MintedAt:   rec.MintedAt,
+		})
+	}
+	return mints
+}
+
+// startHTTPServer serves the read-only API on addr in the background:
+//
+//	GET /status         every engine's next mint id, pending reservations,
+//	                    dead-lettered deposits and deposit-polling breaker state
+//	GET /mints?limit=N  the last N mints across engines, newest first
+//	GET /metrics        mint phase timings and Blockfrost request budget in
+//	                    the Prometheus text format
+//
+// allowedOrigin, when set, is sent as Access-Control-Allow-Origin so a
+// website on that origin can call the API from the browser.
+func startHTTPServer(addr string, engines []*Engine, allowedOrigin string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r, allowedOrigin) {
+			return
+		}
+		statuses := make([]engineStatus, 0, len(engines))
+		for _, e := range engines {
+			statuses = append(statuses, e.status())
+		}
+		writeJSON(w, "status", statuses)
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r, allowedOrigin) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		// The engines share one Blockfrost budget.
+		var bf *blockfrostBudget
+		if len(engines) > 0 {
+			bf = engines[0].settings.blockfrost
+		}
+		writeMetrics(w, bf)
+	})
+	mux.HandleFunc("/mints", func(w http.ResponseWriter, r *http.Request) {
+		if !allowGet(w, r, allowedOrigin) {
+			return
+		}
+		limit := defaultMintsLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		if limit > maxMintsLimit {
+			limit = maxMintsLimit
+		}
+		mints := []mintEntry{}
+		for _, e := range engines {
+			mints = append(mints, e.recentMints(limit)...)
+		}
+		if len(engines) > 1 {
+			// Each engine's list is newest first; merge them by time.
+			sort.SliceStable(mints, func(i, j int) bool {
+				a, b := mints[i].MintedAt, mints[j].MintedAt
+				return a != nil && (b == nil || a.After(*b))
+			})
+		}
+		if len(mints) > limit {
+			mints = mints[:limit]
+		}
+		writeJSON(w, "mints", mints)
+	})
+	go func() {
+		apiLog.Info("status API listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			apiLog.Error("status API stopped", "error", err)
+		}
+	}()
+}
+
+// allowGet sets the CORS header and answers preflight requests. It reports
+// whether r is a GET to be served.
+func allowGet(w http.ResponseWriter, r *http.Request, allowedOrigin string) bool {
+	if allowedOrigin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
+		w.Header().Set("Vary", "Origin")
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return true
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+	return false
+}
+
+// writeJSON encodes v as the response.
+func writeJSON(w http.ResponseWriter, what string, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLog.Warn("failed to write response", "what", what, "error", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import "time"
+
+// engineSettings are the operational knobs each engine carries, set from
+// flags. Keeping them on the engine rather than in package variables lets
+// collections in one process differ.
+type engineSettings struct {
+	// submit is how rejected submissions are retried.
+	submit submitRetry
+	// ipfs is the pre-mint media check (-verify-ipfs).
+	ipfs ipfsCheck
+	// maxProcessed caps the processed deposits a JSON state file keeps
+	// (-state-max-processed, or the collection's state_max_processed).
+	maxProcessed int
+	// breakerThreshold and breakerCooldown configure the poll breaker
+	// (-breaker-threshold, -breaker-cooldown).
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	// feeBuffer is the lovelace mint inputs must hold beyond the price and
+	// the estimated fee (-fee-buffer, or the collection's fee_buffer).
+	feeBuffer int64
+	// maxMintAttempts is how many failed mint attempts dead-letter a
+	// deposit (-max-mint-attempts, or the collection's max_mint_attempts).
+	maxMintAttempts int
+	// senderCacheTTL is how long resolved deposit senders are cached in the
+	// state (-sender-cache-ttl); 0 disables the cache.
+	senderCacheTTL time.Duration
+	// maxSyncLag is how far the chain tip may trail wall-clock time before
+	// the engine waits instead of minting (-max-sync-lag); 0 disables the
+	// check.
+	maxSyncLag time.Duration
+	// recipientGuard checks every resolved recipient with checkRecipient
+	// before minting (-recipient-guard). It is a safety net for sender
+	// resolution: a rejected deposit is dead-lettered for manual review
+	// instead of minting to an address that cannot use the token.
+	recipientGuard bool
+	// refundPendingOnStop makes Stop refund every deposit still holding a
+	// mint id reservation (-refund-pending-on-stop), for closing a drop
+	// (supply exhausted, campaign over) without leaving buyers waiting on
+	// mints that will never come.
+	refundPendingOnStop bool
+	// mintStartSlot is the slot a timed drop opens at (-mint-start-slot, or
+	// the collection's mint_start_slot). It works like an "after" lock in
+	// the minting policy (the later of the two wins): mints carry it as
+	// --invalid-before, and until the tip reaches it polls leave deposits
+	// waiting. 0 means no start slot beyond the policy's own.
+	mintStartSlot int64
+	// maxMetadataBytes caps a mint transaction's serialized metadata
+	// (-max-metadata-bytes); 0 means no limit.
+	maxMetadataBytes int
+	// backupDir, backupInterval and backupKeep configure state backups
+	// (-backup-dir, -backup-interval, -backup-keep); an empty backupDir
+	// disables them.
+	backupDir      string
+	backupInterval time.Duration
+	backupKeep     int
+	// tipLagAlert and tipCheckInterval configure the running tip monitor
+	// (-tip-lag-alert, -tip-check-interval).
+	tipLagAlert      time.Duration
+	tipCheckInterval time.Duration
+	// audit is the -audit-log trail, shared by the process's engines; nil
+	// disables it.
+	audit *auditLog
+	// blockfrost throttles and counts Blockfrost requests; the engines of
+	// one process share it, as they share the Blockfrost project.
+	blockfrost *blockfrostBudget
+}
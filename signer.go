@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// txSigner witnesses transactions. Each -signing-key path is interpreted by
+// the backend: a cardano-cli .skey for the file backend, a cardano-hw-cli
+// hardware signing file (.hwsfile) for the hw backend.
+type txSigner interface {
+	// Sign witnesses txFile with every signing file and returns the path of
+	// the signed transaction.
+	Sign(cli cardanoCLI, txFile string, signingFiles []string) (string, error)
+	// VerificationKey writes the verification key of signingFile to vkeyFile.
+	VerificationKey(cli cardanoCLI, signingFile, vkeyFile string) error
+	// Validate checks a signing file before it is used and returns its type.
+	Validate(signingFile string) (string, error)
+}
+
+// defaultHWDerivationPath is the first payment key of the first account.
+const defaultHWDerivationPath = "1852H/1815H/0H/0/0"
+
+// newSigner returns the signing backend selected with -signing-backend:
+// "file" (default) or "hw". derivationPath is used by the hw backend to
+// create missing signing files.
+func newSigner(backend, derivationPath string) (txSigner, error) {
+	switch backend {
+	case "", "file":
+		return fileSigner{}, nil
+	case "hw":
+		if _, err := exec.LookPath("cardano-hw-cli"); err != nil {
+			return nil, fmt.Errorf("-signing-backend hw requires cardano-hw-cli in PATH: %v", err)
+		}
+		if derivationPath == "" {
+			derivationPath = defaultHWDerivationPath
+		}
+		return hwSigner{derivationPath: derivationPath}, nil
+	}
+	return nil, fmt.Errorf("unknown signing backend %q (want file or hw)", backend)
+}
+
+// signedPath is where a transaction's signed counterpart is written.
+func signedPath(txFile string) string {
+	return strings.TrimSuffix(txFile, filepath.Ext(txFile)) + ".signed"
+}
+
+// fileSigner signs with signing keys on disk through cardano-cli.
+type fileSigner struct{}
+
+func (fileSigner) Sign(cli cardanoCLI, txFile string, signingFiles []string) (string, error) {
+	signedFile := signedPath(txFile)
+
+	args := []string{
+		cli.era, "transaction", "sign",
+		"--tx-body-file", txFile,
+	}
+	for _, key := range signingFiles {
+		args = append(args, "--signing-key-file", key)
+	}
+	args = append(args, "--out-file", signedFile)
+
+	// append network args + socket
+	netArgs := netArgs(cli.network, cli.testnetMagic)
+	args = append(args, netArgs...)
+
+	cmd := exec.Command("cardano-cli", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to sign transaction: %w (output: %s)", err, string(output))
+	}
+
+	return signedFile, nil
+}
+
+func (fileSigner) VerificationKey(cli cardanoCLI, signingFile, vkeyFile string) error {
+	if out, err := exec.Command("cardano-cli", cli.era, "key", "verification-key",
+		"--signing-key-file", signingFile,
+		"--verification-key-file", vkeyFile).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to derive verification key: %w (output: %s)", err, string(out))
+	}
+	return nil
+}
+
+func (fileSigner) Validate(signingFile string) (string, error) {
+	return ValidateSigningKey(signingFile)
+}
+
+// hwSigner signs on a Ledger or Trezor through cardano-hw-cli: the body is
+// transformed to the canonical form the device signs, each signing file
+// produces a witness and cardano-cli assembles them. The device asks for
+// confirmation of every transaction.
+type hwSigner struct {
+	derivationPath string
+}
+
+// hwSigningFileTypes are the cardano-hw-cli signing file envelope types.
+var hwSigningFileTypes = []string{"PaymentHWSigningFileShelley_ed25519", "StakeHWSigningFileShelley_ed25519"}
+
+func (hwSigner) Sign(cli cardanoCLI, txFile string, signingFiles []string) (string, error) {
+	transformed := strings.TrimSuffix(txFile, filepath.Ext(txFile)) + ".transformed"
+	defer cli.cleanupTemp(transformed)
+	if out, err := exec.Command("cardano-hw-cli", "transaction", "transform",
+		"--tx-file", txFile, "--out-file", transformed).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to transform transaction for the hardware wallet: %w (output: %s)", err, string(out))
+	}
+
+	args := []string{"transaction", "witness", "--tx-file", transformed}
+	var witnesses []string
+	defer func() { cli.cleanupTemp(witnesses...) }()
+	for i, f := range signingFiles {
+		w := fmt.Sprintf("%s.witness%d", strings.TrimSuffix(txFile, filepath.Ext(txFile)), i)
+		witnesses = append(witnesses, w)
+		args = append(args, "--hw-signing-file", f, "--out-file", w)
+	}
+	args = append(args, netArgs(cli.network, cli.testnetMagic)...)
+	cardanoLog.Info("waiting for hardware wallet to confirm the transaction", "tx_file", txFile)
+	if out, err := exec.Command("cardano-hw-cli", args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("hardware wallet did not witness the transaction: %w (output: %s)", err, string(out))
+	}
+
+	signedFile := signedPath(txFile)
+	assemble := []string{cli.era, "transaction", "assemble", "--tx-body-file", transformed}
+	for _, w := range witnesses {
+		assemble = append(assemble, "--witness-file", w)
+	}
+	assemble = append(assemble, "--out-file", signedFile)
+	if out, err := exec.Command("cardano-cli", assemble...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to assemble witnesses: %w (output: %s)", err, string(out))
+	}
+	return signedFile, nil
+}
+
+func (hwSigner) VerificationKey(_ cardanoCLI, signingFile, vkeyFile string) error {
+	if out, err := exec.Command("cardano-hw-cli", "key", "verification-key",
+		"--hw-signing-file", signingFile,
+		"--verification-key-file", vkeyFile).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to derive verification key: %w (output: %s)", err, string(out))
+	}
+	return nil
+}
+
+// Validate checks a hardware signing file. A missing file is created from
+// the device at the configured derivation path.
+func (s hwSigner) Validate(signingFile string) (string, error) {
+	if _, err := os.Stat(signingFile); os.IsNotExist(err) {
+		vkeyFile := strings.TrimSuffix(signingFile, filepath.Ext(signingFile)) + ".vkey"
+		cardanoLog.Info("creating hardware signing file", "file", signingFile, "path", s.derivationPath)
+		if out, err := exec.Command("cardano-hw-cli", "address", "key-gen",
+			"--path", s.derivationPath,
+			"--verification-key-file", vkeyFile,
+			"--hw-signing-file", signingFile).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("failed to create hardware signing file %s: %w (output: %s)", signingFile, err, string(out))
+		}
+	}
+
+	data, err := os.ReadFile(signingFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read hardware signing file %s: %w", signingFile, err)
+	}
+	var env keyEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return "", fmt.Errorf("hardware signing file %s is not a text envelope: %v", signingFile, err)
+	}
+	for _, t := range hwSigningFileTypes {
+		if env.Type == t {
+			return env.Type, nil
+		}
+	}
+	return "", fmt.Errorf("%s has type %q; -signing-backend hw expects a cardano-hw-cli signing file (%s)", signingFile, env.Type, strings.Join(hwSigningFileTypes, " or "))
+}
@@ -2,40 +2,269 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
+// StateStore is the persistence backend for mint counters, reservations and
+// processed deposits. State (JSON file) and SQLiteState implement it.
+type StateStore interface {
+	IsProcessed(txHash string) bool
+	// MarkProcessed marks a deposit as processed. The mark holds in memory
+	// even when persisting it fails; the error lets the caller alert.
+	MarkProcessed(txHash string) error
+	// ReservePendingMint reserves the next mint id for a deposit paying
+	// recipient. With limit > 0 it is the recipient's per-wallet cap: the
+	// reservation fails with errWalletCap if the tokens minted to the
+	// recipient, plus those reserved for its other deposits, plus this one
+	// would exceed it. The check and the reservation are one step, so
+	// concurrent mints cannot both pass it. A reservation the deposit
+	// already holds is returned unchanged.
+	ReservePendingMint(depositTx, recipient string, limit int) (int, error)
+	// ReservePendingMints reserves n consecutive mint ids for a deposit in
+	// one step, keyed "<depositTx>-<i>" as multi-token deposits are, under
+	// the same per-wallet cap as ReservePendingMint. Reservations the
+	// deposit already holds are returned unchanged.
+	ReservePendingMints(depositTx, recipient string, n, limit int) ([]int, error)
+	// ReserveMintID reserves a chosen id for depositTx, failing if a
+	// reservation or processed deposit already holds it. An id at or past
+	// the counter moves the counter beyond it.
+	ReserveMintID(depositTx string, id int) error
+	ClearPending(depositTx string) error
+	// ReleaseMintID drops a deposit's reservation and, if it holds the
+	// newest id, hands that id back to the counter. It reports whether the
+	// id was returned.
+	ReleaseMintID(depositTx string) (bool, error)
+	NextMintID() int
+	ReserveNextMintID() (int, error)
+	// Counter returns the next mint id without reserving it.
+	Counter() int
+	// SetCounter moves the next mint id and persists it.
+	SetCounter(next int) error
+	// Pending returns a copy of the depositTx -> reserved id reservations.
+	Pending() map[string]int
+	// PendingRecipient returns who the reservation under key mints for,
+	// or "" if none was recorded.
+	PendingRecipient(key string) string
+	// RecordMint marks rec.DepositTx processed and stores what it minted.
+	RecordMint(rec MintRecord) error
+	// GetMintRecord returns the record stored for a processed deposit.
+	GetMintRecord(depositTx string) (MintRecord, bool)
+	// MintRecords returns every processed deposit's record, oldest first.
+	MintRecords() []MintRecord
+	// WalletMints returns how many tokens have been minted to addr.
+	WalletMints(addr string) (int, error)
+	// DepositCursor returns the monitor address's newest transaction
+	// ("height:index") as of the last deposit scan that left nothing
+	// outstanding, or "" if there is none.
+	DepositCursor() string
+	// SetDepositCursor stores the deposit cursor and persists it.
+	SetDepositCursor(cursor string) error
+	// RecordFailure counts a failed mint attempt for a deposit and returns
+	// its attempts so far. Processing the deposit clears the count.
+	RecordFailure(depositTx string) (int, error)
+	// AddDeadLetter moves a deposit to the dead-letter list: it is no
+	// longer retried until Requeue.
+	AddDeadLetter(dl DeadLetter) error
+	// DeadLetters returns the dead-lettered deposits, oldest first.
+	DeadLetters() []DeadLetter
+	IsDeadLettered(depositTx string) bool
+	// Requeue takes a deposit off the dead-letter list and clears its
+	// failure count. It reports whether the deposit was listed.
+	Requeue(depositTx string) (bool, error)
+	// ForgetDeposit drops everything recorded for a deposit (its processed
+	// record, pending reservations, failure count and dead letter) so the
+	// engine treats it as new.
+	ForgetDeposit(depositTx string) error
+	// CachedSender returns the cached sender of a deposit tx if it was
+	// cached within maxAge.
+	CachedSender(txHash string, maxAge time.Duration) (string, bool)
+	// CacheSenders caches the senders of deposit txs (tx -> sender) and
+	// persists them in one write, dropping entries older than maxAge.
+	CacheSenders(senders map[string]string, maxAge time.Duration) error
+	// MarkNotified records that event was announced for depositTx and
+	// persists it, dropping entries older than maxAge. It reports false if
+	// the event was already recorded, so the announcement is a duplicate.
+	MarkNotified(depositTx, event string, maxAge time.Duration) (bool, error)
+	// Reset replaces all state: the counter becomes next, processed
+	// deposits become records, and pending reservations, failure counts
+	// and dead letters are dropped.
+	Reset(next int, records []MintRecord) error
+	// Backup writes a consistent copy of the state to dest (and, for the
+	// JSON backend, its processed-deposit archive next to it).
+	Backup(dest string) error
+	Save() error
+	Close() error
+}
+
+// OpenStateStore opens the state backend selected by name ("json" or
+// "sqlite"). maxProcessed caps the processed deposits a JSON state file
+// keeps (see defaultMaxProcessed); SQLite keeps them all.
+func OpenStateStore(backend, filePath string, maxProcessed int) (StateStore, error) {
+	switch backend {
+	case "", "json":
+		return LoadState(filePath, maxProcessed)
+	case "sqlite":
+		return OpenSQLiteState(filePath)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q (want json or sqlite)", backend)
+	}
+}
+
+// ReadStateStore opens an existing state for inspection without taking the
+// lock, so it works while an engine is running. Do not write through it;
+// the SQLite backend is opened read-only and unmigrated, so writes fail.
+func ReadStateStore(backend, filePath string) (StateStore, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return nil, err
+	}
+	switch backend {
+	case "", "json":
+		return loadState(filePath)
+	case "sqlite":
+		return openSQLiteState(filePath, true)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q (want json or sqlite)", backend)
+	}
+}
+
+// MintRecord describes what a processed deposit produced. Deposits that were
+// processed without minting (e.g. refunds) only carry DepositTx.
+type MintRecord struct {
+	DepositTx string `json:"deposit_tx"`
+	MintID    int    `json:"mint_id,omitempty"`
+	// MintIDs lists every id a bundle or multi-mint deposit minted, MintID
+	// first; empty for single mints.
+	MintIDs    []int  `json:"mint_ids,omitempty"`
+	TokenName  string `json:"token_name,omitempty"` // comma-separated for multi-mint deposits
+	Recipient  string `json:"recipient,omitempty"`
+	MintTxHash string `json:"mint_tx_hash,omitempty"`
+	// MintedAt is when the mint was recorded; nil for records without a
+	// mint transaction and those written before it was kept.
+	MintedAt *time.Time `json:"minted_at,omitempty"`
+}
+
+// stamped returns rec with MintedAt set to now if it records a mint
+// transaction without a time.
+func (rec MintRecord) stamped() MintRecord {
+	if rec.MintTxHash != "" && rec.MintedAt == nil {
+		now := time.Now().UTC().Truncate(time.Second)
+		rec.MintedAt = &now
+	}
+	return rec
+}
+
+// ids returns the mint ids a record holds: MintIDs, or MintID alone.
+func (r MintRecord) ids() []int {
+	if len(r.MintIDs) > 0 {
+		return r.MintIDs
+	}
+	if r.MintID == 0 {
+		return nil
+	}
+	return []int{r.MintID}
+}
+
+// tokenCount is the number of tokens a record minted: its ids, or for
+// records written before MintIDs was kept, its comma-separated token names.
+func (r MintRecord) tokenCount() int {
+	if r.TokenName == "" {
+		return 0
+	}
+	if len(r.MintIDs) > 0 {
+		return len(r.MintIDs)
+	}
+	return strings.Count(r.TokenName, ",") + 1
+}
+
+// UnmarshalJSON accepts both the record object and the legacy bare tx hash
+// string, so state files written before mint records existed still load.
+func (r *MintRecord) UnmarshalJSON(data []byte) error {
+	var txHash string
+	if err := json.Unmarshal(data, &txHash); err == nil {
+		*r = MintRecord{DepositTx: txHash}
+		return nil
+	}
+	type record MintRecord
+	return json.Unmarshal(data, (*record)(r))
+}
+
 // State tracks mint counter and processed deposits.
 type State struct {
 	mu                sync.Mutex
 	filePath          string
-	NextMintCounter   int             `json:"next_mint_counter"`
-	ProcessedDeposits []string        `json:"processed_deposits"`
-	PendingDeposits   map[string]int  `json:"pending_deposits"`
-	processedSet      map[string]bool // in-memory cache
+	NextMintCounter   int            `json:"next_mint_counter"`
+	ProcessedDeposits []MintRecord   `json:"processed_deposits"`
+	PendingDeposits   map[string]int `json:"pending_deposits"`
+	// PendingRecipients records who each pending reservation mints for,
+	// so per-wallet caps count tokens not yet minted.
+	PendingRecipients map[string]string `json:"pending_recipients,omitempty"`
+	// WalletMintCounts counts the tokens minted to each recipient, for
+	// -max-per-wallet and allowlist caps.
+	WalletMintCounts map[string]int `json:"wallet_mints"`
+	// Cursor is the deposit cursor: a poll skips the deposit scan when no
+	// transaction touched the monitor address since it was taken.
+	Cursor string `json:"deposit_cursor,omitempty"`
+	// MintFailures counts failed mint attempts per unprocessed deposit.
+	MintFailures map[string]int `json:"mint_failures,omitempty"`
+	// DeadLettered lists the deposits that failed -max-mint-attempts times.
+	DeadLettered []DeadLetter `json:"dead_letter,omitempty"`
+	// Senders caches resolved deposit senders: deposit tx -> sender.
+	Senders map[string]cachedSender `json:"sender_cache,omitempty"`
+	// Notified records the deposit events already announced:
+	// "<deposit tx> <event>" -> when.
+	Notified     map[string]time.Time `json:"notified,omitempty"`
+	processedSet map[string]int       // in-memory cache: deposit tx -> index in ProcessedDeposits, or archivedIndex
+	archived     []MintRecord         // the archive's records, oldest first, read once at load
+	archivedAt   map[string]int       // deposit tx -> index of its last record in archived
+	lock         *os.File             // exclusive lock held until Close
+	maxProcessed int                  // records kept before archiving; 0 keeps all
 }
 
-// LoadState loads state from file or initializes new.
-func LoadState(filePath string) (*State, error) {
+// LoadState loads state from file or initializes new. It takes an exclusive
+// lock on the state file so a second engine pointed at the same file fails
+// to start instead of double-minting; Close releases it. maxProcessed caps
+// the processed deposits kept in the file; 0 keeps all.
+func LoadState(filePath string, maxProcessed int) (*State, error) {
+	lock, err := lockFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadState(filePath)
+	if err != nil {
+		unlockFile(lock)
+		return nil, err
+	}
+	state.lock = lock
+	state.maxProcessed = maxProcessed
+	return state, nil
+}
+
+func loadState(filePath string) (*State, error) {
 	state := &State{
 		filePath:          filePath,
 		NextMintCounter:   1,
-		ProcessedDeposits: []string{},
+		ProcessedDeposits: []MintRecord{},
 		PendingDeposits:   make(map[string]int),
-		processedSet:      make(map[string]bool),
+		processedSet:      make(map[string]int),
+		archivedAt:        make(map[string]int),
+		maxProcessed:      defaultMaxProcessed,
 	}
 
 	data, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// File doesn't exist; save initial state
+			state.WalletMintCounts = make(map[string]int)
 			if err := state.Save(); err != nil {
 				return nil, err
 			}
-			log.Printf("[state] initialized new state file: %s", filePath)
+			stateLog.Info("initialized new state file", "file", filePath)
 			return state, nil
 		}
 		return nil, err
@@ -45,15 +274,30 @@ func LoadState(filePath string) (*State, error) {
 		return nil, err
 	}
 
-	// Rebuild in-memory set
-	for _, tx := range state.ProcessedDeposits {
-		state.processedSet[tx] = true
+	// Rebuild in-memory set, archived deposits included
+	for i, rec := range state.ProcessedDeposits {
+		state.processedSet[rec.DepositTx] = i
+	}
+	archived, err := state.archivedRecords()
+	if err != nil {
+		return nil, err
+	}
+	state.indexArchived(archived)
+	for _, rec := range archived {
+		if _, ok := state.processedSet[rec.DepositTx]; !ok {
+			state.processedSet[rec.DepositTx] = archivedIndex
+		}
 	}
 	if state.PendingDeposits == nil {
 		state.PendingDeposits = make(map[string]int)
 	}
+	if state.WalletMintCounts == nil {
+		// State files written before wallet counts were tracked.
+		state.countWalletMints()
+		stateLog.Info("migrated state: counted mints per wallet", "file", filePath, "wallets", len(state.WalletMintCounts))
+	}
 
-	log.Printf("[state] loaded state: next_mint=%d, processed=%d deposits", state.NextMintCounter, len(state.ProcessedDeposits))
+	stateLog.Info("loaded state", "file", filePath, "next_mint", state.NextMintCounter, "processed", len(state.processedSet))
 	return state, nil
 }
 
@@ -61,23 +305,142 @@ func LoadState(filePath string) (*State, error) {
 func (s *State) IsProcessed(txHash string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.processedSet[txHash]
+	_, ok := s.processedSet[txHash]
+	return ok
+}
+
+// MarkProcessed marks a deposit as processed; Save persists it.
+func (s *State) MarkProcessed(txHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.processedSet[txHash]; !ok {
+		s.processedSet[txHash] = len(s.ProcessedDeposits)
+		s.ProcessedDeposits = append(s.ProcessedDeposits, MintRecord{DepositTx: txHash})
+	}
+	s.dropFailuresLocked(txHash)
+	return nil
+}
+
+// RecordMint marks the deposit processed, storing (or filling in) its mint
+// record, and persists the state.
+func (s *State) RecordMint(rec MintRecord) error {
+	rec = rec.stamped()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.processedSet[rec.DepositTx]; ok {
+		var old MintRecord
+		if i == archivedIndex {
+			old, _ = s.archivedRecord(rec.DepositTx)
+		} else {
+			old = s.ProcessedDeposits[i]
+		}
+		s.WalletMintCounts[old.Recipient] -= old.tokenCount()
+		if s.WalletMintCounts[old.Recipient] <= 0 {
+			delete(s.WalletMintCounts, old.Recipient)
+		}
+		if i == archivedIndex {
+			s.processedSet[rec.DepositTx] = len(s.ProcessedDeposits)
+			s.ProcessedDeposits = append(s.ProcessedDeposits, rec)
+		} else {
+			s.ProcessedDeposits[i] = rec
+		}
+	} else {
+		s.processedSet[rec.DepositTx] = len(s.ProcessedDeposits)
+		s.ProcessedDeposits = append(s.ProcessedDeposits, rec)
+	}
+	if n := rec.tokenCount(); n > 0 && rec.Recipient != "" {
+		s.WalletMintCounts[rec.Recipient] += n
+	}
+	s.dropFailuresLocked(rec.DepositTx)
+	return s.writeLocked()
 }
 
-// MarkProcessed marks a deposit as processed.
-func (s *State) MarkProcessed(txHash string) {
+// GetMintRecord returns the record for a processed deposit.
+func (s *State) GetMintRecord(depositTx string) (MintRecord, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if !s.processedSet[txHash] {
-		s.processedSet[txHash] = true
-		s.ProcessedDeposits = append(s.ProcessedDeposits, txHash)
+	i, ok := s.processedSet[depositTx]
+	if !ok {
+		return MintRecord{}, false
 	}
+	if i == archivedIndex {
+		return s.archivedRecord(depositTx)
+	}
+	return s.ProcessedDeposits[i], true
+}
+
+// MintRecords returns a copy of the processed deposit records, archived
+// ones first.
+func (s *State) MintRecords() []MintRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(s.archivedOnly(), s.ProcessedDeposits...)
+}
+
+// archivedOnly returns the archived records not superseded by one in the
+// state file or a later archived one. Callers hold s.mu or own s exclusively.
+func (s *State) archivedOnly() []MintRecord {
+	// A deposit archived twice (its record was filled in after archiving)
+	// keeps its last record.
+	var records []MintRecord
+	for i, rec := range s.archived {
+		if s.archivedAt[rec.DepositTx] == i && s.processedSet[rec.DepositTx] == archivedIndex {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
+
+// WalletMints returns the tokens minted to addr.
+func (s *State) WalletMints(addr string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.WalletMintCounts[addr], nil
+}
+
+// countWalletMints rebuilds the per-wallet counts from the processed
+// deposits. Callers hold s.mu or own s exclusively.
+func (s *State) countWalletMints() {
+	s.WalletMintCounts = make(map[string]int)
+	for _, rec := range append(s.archivedOnly(), s.ProcessedDeposits...) {
+		if n := rec.tokenCount(); n > 0 && rec.Recipient != "" {
+			s.WalletMintCounts[rec.Recipient] += n
+		}
+	}
+}
+
+// Reset replaces the counter, processed deposits and reservations, and
+// persists the state.
+func (s *State) Reset(next int, records []MintRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.archivePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove processed-deposit archive: %w", err)
+	}
+	s.indexArchived(nil)
+	s.NextMintCounter = next
+	s.ProcessedDeposits = make([]MintRecord, 0, len(records))
+	s.PendingDeposits = make(map[string]int)
+	s.PendingRecipients = nil
+	s.processedSet = make(map[string]int, len(records))
+	for _, rec := range records {
+		if _, ok := s.processedSet[rec.DepositTx]; ok {
+			continue
+		}
+		s.processedSet[rec.DepositTx] = len(s.ProcessedDeposits)
+		s.ProcessedDeposits = append(s.ProcessedDeposits, rec)
+	}
+	s.Cursor = ""
+	s.MintFailures = nil
+	s.DeadLettered = nil
+	s.countWalletMints()
+	return s.writeLocked()
 }
 
 // ReservePendingMint reserves the next mint id for a deposit and persists the state.
 // Returns the reserved id. The reservation is recorded as depositTx -> id
 // so that restarts won't reuse the id.
-func (s *State) ReservePendingMint(depositTx string) (int, error) {
+func (s *State) ReservePendingMint(depositTx, recipient string, limit int) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -88,25 +451,121 @@ func (s *State) ReservePendingMint(depositTx string) (int, error) {
 	if id, ok := s.PendingDeposits[depositTx]; ok {
 		return id, nil
 	}
+	if err := s.checkWalletCapLocked(depositTx, recipient, 1, limit); err != nil {
+		return 0, err
+	}
 
 	id := s.NextMintCounter
 	s.NextMintCounter++
 	s.PendingDeposits[depositTx] = id
+	s.setRecipientLocked(depositTx, recipient)
 
-	data, err := json.MarshalIndent(map[string]interface{}{
-		"next_mint_counter":  s.NextMintCounter,
-		"processed_deposits": s.ProcessedDeposits,
-		"pending_deposits":   s.PendingDeposits,
-	}, "", "  ")
-	if err != nil {
+	if err := s.writeLocked(); err != nil {
 		return 0, err
 	}
 
-	if err := ioutil.WriteFile(s.filePath, data, 0o600); err != nil {
-		return 0, err
+	return id, nil
+}
+
+// ReservePendingMints reserves n mint ids for a deposit under one lock, so
+// concurrent mints cannot interleave with the block, and persists the
+// state once.
+func (s *State) ReservePendingMints(depositTx, recipient string, n, limit int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.PendingDeposits == nil {
+		s.PendingDeposits = make(map[string]int)
+	}
+	missing := 0
+	for i := 0; i < n; i++ {
+		if _, ok := s.PendingDeposits[fmt.Sprintf("%s-%d", depositTx, i)]; !ok {
+			missing++
+		}
+	}
+	if missing > 0 {
+		if err := s.checkWalletCapLocked(depositTx, recipient, n, limit); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("%s-%d", depositTx, i)
+		id, ok := s.PendingDeposits[key]
+		if !ok {
+			id = s.NextMintCounter
+			s.NextMintCounter++
+			s.PendingDeposits[key] = id
+			s.setRecipientLocked(key, recipient)
+		}
+		ids = append(ids, id)
 	}
 
-	return id, nil
+	if err := s.writeLocked(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// checkWalletCapLocked returns errWalletCap if n more tokens for recipient
+// would take it past limit, counting its minted tokens and the ids reserved
+// for its deposits other than depositTx. Callers hold s.mu.
+func (s *State) checkWalletCapLocked(depositTx, recipient string, n, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	held := s.WalletMintCounts[recipient]
+	for key, to := range s.PendingRecipients {
+		if to == recipient && key != depositTx && !strings.HasPrefix(key, depositTx+"-") {
+			held++
+		}
+	}
+	if held+n > limit {
+		return fmt.Errorf("%w (%d of %d minted or reserved)", errWalletCap, held, limit)
+	}
+	return nil
+}
+
+// setRecipientLocked records who the reservation under key mints for.
+// Callers hold s.mu.
+func (s *State) setRecipientLocked(key, recipient string) {
+	if recipient == "" {
+		return
+	}
+	if s.PendingRecipients == nil {
+		s.PendingRecipients = make(map[string]string)
+	}
+	s.PendingRecipients[key] = recipient
+}
+
+// ReserveMintID reserves id for a deposit and persists the state. Ids held
+// by archived records are not checked; the on-chain check in MintTo covers
+// those.
+func (s *State) ReserveMintID(depositTx string, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 {
+		return fmt.Errorf("mint id must be positive, got %d", id)
+	}
+	for tx, pending := range s.PendingDeposits {
+		if pending == id {
+			return fmt.Errorf("mint id %d is already reserved for %s", id, tx)
+		}
+	}
+	for _, rec := range s.ProcessedDeposits {
+		if slices.Contains(rec.ids(), id) {
+			return fmt.Errorf("mint id %d was already minted for %s", id, rec.DepositTx)
+		}
+	}
+	if s.PendingDeposits == nil {
+		s.PendingDeposits = make(map[string]int)
+	}
+	s.PendingDeposits[depositTx] = id
+	if id >= s.NextMintCounter {
+		s.NextMintCounter = id + 1
+	}
+	return s.writeLocked()
 }
 
 // ClearPending removes a pending reservation for a deposit and persists state.
@@ -116,21 +575,32 @@ func (s *State) ClearPending(depositTx string) error {
 	if s.PendingDeposits != nil {
 		delete(s.PendingDeposits, depositTx)
 	}
+	delete(s.PendingRecipients, depositTx)
 
-	data, err := json.MarshalIndent(map[string]interface{}{
-		"next_mint_counter":  s.NextMintCounter,
-		"processed_deposits": s.ProcessedDeposits,
-		"pending_deposits":   s.PendingDeposits,
-	}, "", "  ")
-	if err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(s.filePath, data, 0o600); err != nil {
+	if err := s.writeLocked(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// ReleaseMintID clears the deposit's reservation and rewinds the counter if
+// the reservation holds the newest id, all under one lock.
+func (s *State) ReleaseMintID(depositTx string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.PendingDeposits[depositTx]
+	if !ok {
+		return false, nil
+	}
+	delete(s.PendingDeposits, depositTx)
+	delete(s.PendingRecipients, depositTx)
+	released := id == s.NextMintCounter-1
+	if released {
+		s.NextMintCounter = id
+	}
+	return released, s.writeLocked()
+}
+
 // NextMintID returns and increments the mint counter.
 func (s *State) NextMintID() int {
 	s.mu.Lock()
@@ -149,19 +619,222 @@ func (s *State) ReserveNextMintID() (int, error) {
 	id := s.NextMintCounter
 	s.NextMintCounter++
 
-	data, err := json.MarshalIndent(map[string]interface{}{
-		"next_mint_counter":  s.NextMintCounter,
-		"processed_deposits": s.ProcessedDeposits,
-	}, "", "  ")
-	if err != nil {
+	if err := s.writeLocked(); err != nil {
 		return 0, err
 	}
 
-	if err := ioutil.WriteFile(s.filePath, data, 0o600); err != nil {
-		return 0, err
+	return id, nil
+}
+
+// Counter returns the next mint id without reserving it.
+func (s *State) Counter() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.NextMintCounter
+}
+
+// SetCounter sets the next mint id and persists the state.
+func (s *State) SetCounter(next int) error {
+	s.mu.Lock()
+	s.NextMintCounter = next
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// DepositCursor returns the deposit cursor.
+func (s *State) DepositCursor() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Cursor
+}
+
+// SetDepositCursor stores the deposit cursor and persists the state.
+func (s *State) SetDepositCursor(cursor string) error {
+	s.mu.Lock()
+	s.Cursor = cursor
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// CachedSender returns a cached deposit sender younger than maxAge.
+func (s *State) CachedSender(txHash string, maxAge time.Duration) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.Senders[txHash]
+	if !ok || time.Since(c.At) > maxAge {
+		return "", false
 	}
+	return c.Address, true
+}
 
-	return id, nil
+// CacheSenders caches deposit senders, drops expired entries and persists
+// the state once.
+func (s *State) CacheSenders(senders map[string]string, maxAge time.Duration) error {
+	s.mu.Lock()
+	if s.Senders == nil {
+		s.Senders = make(map[string]cachedSender)
+	}
+	for tx, c := range s.Senders {
+		if time.Since(c.At) > maxAge {
+			delete(s.Senders, tx)
+		}
+	}
+	now := time.Now().UTC()
+	for txHash, sender := range senders {
+		s.Senders[txHash] = cachedSender{Address: sender, At: now}
+	}
+	s.mu.Unlock()
+	return s.Save()
+}
+
+// MarkNotified records a deposit event as announced, drops expired entries
+// and persists the state. It reports false if it was already recorded.
+func (s *State) MarkNotified(depositTx, event string, maxAge time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Notified == nil {
+		s.Notified = make(map[string]time.Time)
+	}
+	for key, at := range s.Notified {
+		if time.Since(at) > maxAge {
+			delete(s.Notified, key)
+		}
+	}
+	key := depositTx + " " + event
+	if _, ok := s.Notified[key]; ok {
+		return false, nil
+	}
+	s.Notified[key] = time.Now().UTC()
+	return true, s.writeLocked()
+}
+
+// ForgetDeposit drops a deposit's record, reservations (multi-mint ones
+// included), failure count, dead letter and announced events, and persists
+// the state.
+func (s *State) ForgetDeposit(depositTx string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.processedSet[depositTx]; ok {
+		if i == archivedIndex {
+			if err := s.forgetArchived(depositTx); err != nil {
+				return err
+			}
+		} else {
+			s.ProcessedDeposits = append(s.ProcessedDeposits[:i], s.ProcessedDeposits[i+1:]...)
+			for j := i; j < len(s.ProcessedDeposits); j++ {
+				s.processedSet[s.ProcessedDeposits[j].DepositTx] = j
+			}
+		}
+		delete(s.processedSet, depositTx)
+		s.countWalletMints()
+	}
+	for tx := range s.PendingDeposits {
+		if tx == depositTx || strings.HasPrefix(tx, depositTx+"-") {
+			delete(s.PendingDeposits, tx)
+			delete(s.PendingRecipients, tx)
+		}
+	}
+	for key := range s.Notified {
+		if strings.HasPrefix(key, depositTx+" ") {
+			delete(s.Notified, key)
+		}
+	}
+	s.dropFailuresLocked(depositTx)
+	return s.writeLocked()
+}
+
+// RecordFailure counts a failed mint attempt and persists the state.
+func (s *State) RecordFailure(depositTx string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.MintFailures == nil {
+		s.MintFailures = make(map[string]int)
+	}
+	s.MintFailures[depositTx]++
+	return s.MintFailures[depositTx], s.writeLocked()
+}
+
+// AddDeadLetter dead-letters a deposit and persists the state.
+func (s *State) AddDeadLetter(dl DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropFailuresLocked(dl.DepositTx)
+	s.DeadLettered = append(s.DeadLettered, dl)
+	return s.writeLocked()
+}
+
+// DeadLetters returns a copy of the dead-letter list.
+func (s *State) DeadLetters() []DeadLetter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]DeadLetter(nil), s.DeadLettered...)
+}
+
+// IsDeadLettered reports whether a deposit is on the dead-letter list.
+func (s *State) IsDeadLettered(depositTx string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dl := range s.DeadLettered {
+		if dl.DepositTx == depositTx {
+			return true
+		}
+	}
+	return false
+}
+
+// Requeue takes a deposit off the dead-letter list and persists the state.
+func (s *State) Requeue(depositTx string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.DeadLettered)
+	s.dropFailuresLocked(depositTx)
+	if len(s.DeadLettered) == n {
+		return false, nil
+	}
+	return true, s.writeLocked()
+}
+
+// dropFailuresLocked forgets a deposit's failure count and dead letter.
+// Callers hold s.mu.
+func (s *State) dropFailuresLocked(depositTx string) {
+	delete(s.MintFailures, depositTx)
+	kept := s.DeadLettered[:0]
+	for _, dl := range s.DeadLettered {
+		if dl.DepositTx != depositTx {
+			kept = append(kept, dl)
+		}
+	}
+	s.DeadLettered = kept
+}
+
+// Pending returns a copy of the pending reservations.
+func (s *State) Pending() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := make(map[string]int, len(s.PendingDeposits))
+	for tx, id := range s.PendingDeposits {
+		pending[tx] = id
+	}
+	return pending
+}
+
+// PendingRecipient returns the recipient recorded for a pending reservation.
+func (s *State) PendingRecipient(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.PendingRecipients[key]
+}
+
+// Close releases the state file lock; every mutation is already on disk.
+func (s *State) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lock == nil {
+		return nil
+	}
+	err := unlockFile(s.lock)
+	s.lock = nil
+	return err
 }
 
 // Save persists state to file.
@@ -169,17 +842,36 @@ func (s *State) Save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	data, err := json.MarshalIndent(map[string]interface{}{
-		"next_mint_counter":  s.NextMintCounter,
-		"processed_deposits": s.ProcessedDeposits,
-	}, "", "  ")
-	if err != nil {
+	if err := s.writeLocked(); err != nil {
 		return err
 	}
 
-	if err := ioutil.WriteFile(s.filePath, data, 0o600); err != nil {
+	return nil
+}
+
+// Backup writes the state, as last saved, to dest and copies the archive,
+// if any, to dest's archive path. Holding s.mu keeps writes out meanwhile.
+func (s *State) Backup(dest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := copyFile(s.filePath, dest); err != nil {
+		return err
+	}
+	if err := copyFile(s.archivePath(), dest+archiveSuffix); err != nil && !os.IsNotExist(err) {
 		return err
 	}
-
 	return nil
 }
+
+// writeLocked marshals the state and writes it to disk, archiving the
+// oldest processed deposits first if there are too many. Callers hold s.mu.
+func (s *State) writeLocked() error {
+	if err := s.archiveLocked(); err != nil {
+		stateLog.Warn("failed to archive processed deposits; keeping them in the state file", "error", err)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.filePath, data, 0o600)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// defaultMaxProcessed caps the processed deposits a JSON state file keeps
+// unless -state-max-processed says otherwise; older records move to an
+// append-only archive next to it (<state>.processed.log, one JSON record
+// per line) so each save stays small. Archived deposits are still loaded
+// into the dedupe set, so they are never minted twice.
+const defaultMaxProcessed = 10000
+
+// archivedIndex marks a processedSet entry whose record is in the archive.
+const archivedIndex = -1
+
+// archiveSuffix names the processed-deposit archive after its state file.
+const archiveSuffix = ".processed.log"
+
+// archivePath is the processed-deposit archive of the state file.
+func (s *State) archivePath() string {
+	return s.filePath + archiveSuffix
+}
+
+// archiveLocked moves the oldest records past s.maxProcessed to the archive.
+// The archive is appended (and synced) before the state file is rewritten,
+// so a crash in between only leaves a record in both. Callers hold s.mu.
+func (s *State) archiveLocked() error {
+	excess := len(s.ProcessedDeposits) - s.maxProcessed
+	if s.maxProcessed <= 0 || excess <= 0 {
+		return nil
+	}
+	f, err := os.OpenFile(s.archivePath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open processed-deposit archive: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range s.ProcessedDeposits[:excess] {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write processed-deposit archive: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync processed-deposit archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, rec := range s.ProcessedDeposits[:excess] {
+		s.processedSet[rec.DepositTx] = archivedIndex
+		s.archivedAt[rec.DepositTx] = len(s.archived)
+		s.archived = append(s.archived, rec)
+	}
+	s.ProcessedDeposits = append([]MintRecord(nil), s.ProcessedDeposits[excess:]...)
+	for i, rec := range s.ProcessedDeposits {
+		s.processedSet[rec.DepositTx] = i
+	}
+	stateLog.Debug("archived processed deposits", "file", s.archivePath(), "archived", excess)
+	return nil
+}
+
+// forgetArchived rewrites the archive without depositTx's records. Callers
+// hold s.mu.
+func (s *State) forgetArchived(depositTx string) error {
+	var records []MintRecord
+	for _, rec := range s.archived {
+		if rec.DepositTx != depositTx {
+			records = append(records, rec)
+		}
+	}
+	tmp := s.archivePath() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite processed-deposit archive: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rewrite processed-deposit archive: %w", err)
+	}
+	if err := os.Rename(tmp, s.archivePath()); err != nil {
+		return err
+	}
+	s.indexArchived(records)
+	return nil
+}
+
+// indexArchived replaces the in-memory copy of the archive with records,
+// oldest first. Callers hold s.mu or own s exclusively.
+func (s *State) indexArchived(records []MintRecord) {
+	s.archived = records
+	s.archivedAt = make(map[string]int, len(records))
+	for i, rec := range records {
+		s.archivedAt[rec.DepositTx] = i
+	}
+}
+
+// archivedRecords reads the archive from disk, oldest first. A missing
+// archive is empty.
+func (s *State) archivedRecords() ([]MintRecord, error) {
+	f, err := os.Open(s.archivePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var records []MintRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec MintRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("corrupt processed-deposit archive %s: %v", s.archivePath(), err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// archivedRecord returns the last archived record of depositTx from the
+// in-memory index.
+func (s *State) archivedRecord(depositTx string) (MintRecord, bool) {
+	i, ok := s.archivedAt[depositTx]
+	if !ok {
+		return MintRecord{}, false
+	}
+	return s.archived[i], true
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stateBackends are the StateStore backends every state test runs against.
+// SQLite needs the sqlite3 CLI and is skipped without it.
+var stateBackends = []string{"json", "sqlite"}
+
+// requireBackend skips the test when backend cannot run here.
+func requireBackend(t *testing.T, backend string) {
+	t.Helper()
+	if backend == "sqlite" {
+		if _, err := exec.LookPath("sqlite3"); err != nil {
+			t.Skip("sqlite3 not in PATH")
+		}
+	}
+}
+
+// openTestState opens a fresh store of backend in a temp dir, closed when
+// the test ends.
+func openTestState(t *testing.T, backend string) (StateStore, string) {
+	t.Helper()
+	requireBackend(t, backend)
+	path := filepath.Join(t.TempDir(), "state."+backend)
+	s, err := OpenStateStore(backend, path, 0)
+	if err != nil {
+		t.Fatalf("OpenStateStore(%s): %v", backend, err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestStateStore(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			s, _ := openTestState(t, backend)
+
+			// MarkProcessed
+			if s.IsProcessed("tx-a") {
+				t.Fatal("fresh state reports tx-a processed")
+			}
+			if err := s.MarkProcessed("tx-a"); err != nil {
+				t.Fatalf("MarkProcessed: %v", err)
+			}
+			if !s.IsProcessed("tx-a") {
+				t.Fatal("tx-a not processed after MarkProcessed")
+			}
+
+			// ReservePendingMint is idempotent per deposit.
+			id, err := s.ReservePendingMint("tx-b", "alice", 0)
+			if err != nil || id != 1 {
+				t.Fatalf("ReservePendingMint(tx-b) = %d, %v; want 1", id, err)
+			}
+			if id, err := s.ReservePendingMint("tx-b", "alice", 0); err != nil || id != 1 {
+				t.Fatalf("second ReservePendingMint(tx-b) = %d, %v; want 1 again", id, err)
+			}
+
+			// ReservePendingMints takes a consecutive block.
+			ids, err := s.ReservePendingMints("tx-c", "alice", 2, 0)
+			if err != nil || !reflect.DeepEqual(ids, []int{2, 3}) {
+				t.Fatalf("ReservePendingMints(tx-c) = %v, %v; want [2 3]", ids, err)
+			}
+			want := map[string]int{"tx-b": 1, "tx-c-0": 2, "tx-c-1": 3}
+			if got := s.Pending(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("Pending() = %v, want %v", got, want)
+			}
+			if got := s.Counter(); got != 4 {
+				t.Fatalf("Counter() = %d, want 4", got)
+			}
+
+			// A limit caps the recipient's minted and reserved tokens.
+			if _, err := s.ReservePendingMint("tx-d", "alice", 3); !errors.Is(err, errWalletCap) {
+				t.Fatalf("ReservePendingMint over alice's cap: err = %v, want errWalletCap", err)
+			}
+			id, err = s.ReservePendingMint("tx-d", "bob", 1)
+			if err != nil || id != 4 {
+				t.Fatalf("ReservePendingMint(tx-d) = %d, %v; want 4", id, err)
+			}
+
+			// RecordMint, MintRecords and WalletMints
+			rec := MintRecord{DepositTx: "tx-d", MintID: 4, TokenName: "Flowmass4", Recipient: "bob", MintTxHash: "mint-d"}
+			if err := s.RecordMint(rec); err != nil {
+				t.Fatalf("RecordMint: %v", err)
+			}
+			if err := s.ClearPending("tx-d"); err != nil {
+				t.Fatalf("ClearPending: %v", err)
+			}
+			if !s.IsProcessed("tx-d") {
+				t.Fatal("tx-d not processed after RecordMint")
+			}
+			got, ok := s.GetMintRecord("tx-d")
+			if !ok || got.MintID != 4 || got.TokenName != "Flowmass4" || got.Recipient != "bob" || got.MintTxHash != "mint-d" || got.MintedAt == nil {
+				t.Fatalf("GetMintRecord(tx-d) = %+v, %v", got, ok)
+			}
+			var minted []string
+			for _, r := range s.MintRecords() {
+				minted = append(minted, r.DepositTx)
+			}
+			if !reflect.DeepEqual(minted, []string{"tx-a", "tx-d"}) {
+				t.Fatalf("MintRecords() deposits = %v, want [tx-a tx-d]", minted)
+			}
+			if n, err := s.WalletMints("bob"); err != nil || n != 1 {
+				t.Fatalf("WalletMints(bob) = %d, %v; want 1", n, err)
+			}
+			if _, err := s.ReservePendingMint("tx-e", "bob", 1); !errors.Is(err, errWalletCap) {
+				t.Fatalf("ReservePendingMint past bob's cap: err = %v, want errWalletCap", err)
+			}
+
+			// Dead letters keep a multi-line error whole.
+			for want := 1; want <= 2; want++ {
+				if n, err := s.RecordFailure("tx-f"); err != nil || n != want {
+					t.Fatalf("RecordFailure(tx-f) = %d, %v; want %d", n, err, want)
+				}
+			}
+			dl := DeadLetter{
+				DepositTx:   "tx-f",
+				OutputIndex: 1,
+				Sender:      "carol",
+				Lovelace:    27_000_000,
+				Attempts:    2,
+				LastError:   "failed to build transaction: exit status 1\nCommand failed: transaction build | ValueNotConservedUTxO",
+				At:          time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+			}
+			if err := s.AddDeadLetter(dl); err != nil {
+				t.Fatalf("AddDeadLetter: %v", err)
+			}
+			if !s.IsDeadLettered("tx-f") {
+				t.Fatal("tx-f not dead-lettered")
+			}
+			dead := s.DeadLetters()
+			if len(dead) != 1 || dead[0] != dl {
+				t.Fatalf("DeadLetters() = %+v, want [%+v]", dead, dl)
+			}
+			if ok, err := s.Requeue("tx-f"); err != nil || !ok {
+				t.Fatalf("Requeue(tx-f) = %v, %v; want true", ok, err)
+			}
+			if s.IsDeadLettered("tx-f") || len(s.DeadLetters()) != 0 {
+				t.Fatal("tx-f still dead-lettered after Requeue")
+			}
+			if n, err := s.RecordFailure("tx-f"); err != nil || n != 1 {
+				t.Fatalf("RecordFailure after Requeue = %d, %v; want a fresh count of 1", n, err)
+			}
+
+			// Backup is a consistent copy that opens as a store.
+			if err := s.Save(); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			dest := filepath.Join(t.TempDir(), "backup."+backend)
+			if err := s.Backup(dest); err != nil {
+				t.Fatalf("Backup: %v", err)
+			}
+			b, err := OpenStateStore(backend, dest, 0)
+			if err != nil {
+				t.Fatalf("open backup: %v", err)
+			}
+			if !b.IsProcessed("tx-a") || !b.IsProcessed("tx-d") || b.Counter() != 5 {
+				t.Errorf("backup: processed tx-a=%v tx-d=%v, counter %d; want true, true, 5", b.IsProcessed("tx-a"), b.IsProcessed("tx-d"), b.Counter())
+			}
+			if got := b.Pending(); !reflect.DeepEqual(got, want) {
+				t.Errorf("backup Pending() = %v, want %v", got, want)
+			}
+			b.Close()
+
+			// Reset replaces everything.
+			seed := []MintRecord{{DepositTx: "tx-z", MintID: 9, TokenName: "Flowmass9", Recipient: "dave", MintTxHash: "mint-z"}}
+			if err := s.Reset(10, seed); err != nil {
+				t.Fatalf("Reset: %v", err)
+			}
+			if s.Counter() != 10 {
+				t.Errorf("Counter() after Reset = %d, want 10", s.Counter())
+			}
+			if len(s.Pending()) != 0 {
+				t.Errorf("Pending() after Reset = %v, want none", s.Pending())
+			}
+			if s.IsProcessed("tx-a") || !s.IsProcessed("tx-z") {
+				t.Errorf("after Reset processed tx-a=%v tx-z=%v; want false, true", s.IsProcessed("tx-a"), s.IsProcessed("tx-z"))
+			}
+			if n, _ := s.WalletMints("dave"); n != 1 {
+				t.Errorf("WalletMints(dave) after Reset = %d, want 1", n)
+			}
+			if n, _ := s.WalletMints("bob"); n != 0 {
+				t.Errorf("WalletMints(bob) after Reset = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestStateStoreReopen(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			s, path := openTestState(t, backend)
+			if _, err := s.ReservePendingMints("tx-a", "alice", 3, 0); err != nil {
+				t.Fatalf("ReservePendingMints: %v", err)
+			}
+			if err := s.MarkProcessed("tx-b"); err != nil {
+				t.Fatalf("MarkProcessed: %v", err)
+			}
+			if err := s.Save(); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			s.Close()
+
+			r, err := OpenStateStore(backend, path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer r.Close()
+			var keys []string
+			for key := range r.Pending() {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, []string{"tx-a-0", "tx-a-1", "tx-a-2"}) || r.Counter() != 4 || !r.IsProcessed("tx-b") {
+				t.Errorf("reopened: pending %v, counter %d, tx-b processed %v", keys, r.Counter(), r.IsProcessed("tx-b"))
+			}
+		})
+	}
+}
+
+func TestStateStoreLock(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			first, path := openTestState(t, backend)
+			if second, err := OpenStateStore(backend, path, 0); err == nil {
+				second.Close()
+				t.Fatal("second open succeeded while the first holds the lock")
+			} else if !strings.Contains(err.Error(), "locked by another process") {
+				t.Fatalf("second open: err = %v, want a lock error", err)
+			}
+
+			if err := first.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			third, err := OpenStateStore(backend, path, 0)
+			if err != nil {
+				t.Fatalf("open after Close: %v", err)
+			}
+			third.Close()
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// statusRecentMints is how many of the latest mints `flowmass status` lists.
+const statusRecentMints = 5
+
+// printStatus writes a summary of a collection's state: the next mint id,
+// processed deposits, pending reservations and the latest mints. It opens
+// the state without its lock, so it can run next to the engine.
+func printStatus(w io.Writer, c Collection, backend string) error {
+	state, err := ReadStateStore(backend, c.State)
+	if err != nil {
+		return fmt.Errorf("failed to read state %s: %w", c.State, err)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = c.PolicyID
+	}
+	if name == "" {
+		name = c.State
+	}
+	records := state.MintRecords()
+	var mints []MintRecord
+	for _, rec := range records {
+		if rec.MintTxHash != "" {
+			mints = append(mints, rec)
+		}
+	}
+
+	fmt.Fprintf(w, "%s (%s)\n", name, c.State)
+	fmt.Fprintf(w, "  next mint id:       %d\n", state.Counter())
+	fmt.Fprintf(w, "  processed deposits: %d\n", len(records))
+	fmt.Fprintf(w, "  minted tokens:      %d\n", len(mints))
+	if cursor := state.DepositCursor(); cursor != "" {
+		fmt.Fprintf(w, "  deposit cursor:     %s\n", cursor)
+	}
+
+	pending := state.Pending()
+	fmt.Fprintf(w, "  pending:            %d\n", len(pending))
+	txs := make([]string, 0, len(pending))
+	for tx := range pending {
+		txs = append(txs, tx)
+	}
+	sort.Slice(txs, func(i, j int) bool { return pending[txs[i]] < pending[txs[j]] })
+	for _, tx := range txs {
+		fmt.Fprintf(w, "    id %d  deposit %s\n", pending[tx], tx)
+	}
+
+	if dead := state.DeadLetters(); len(dead) > 0 {
+		fmt.Fprintf(w, "  dead-lettered:      %d\n", len(dead))
+		for _, dl := range dead {
+			fmt.Fprintf(w, "    deposit %s  %d attempts: %s\n", dl.DepositTx, dl.Attempts, dl.LastError)
+		}
+	}
+
+	if len(mints) > statusRecentMints {
+		mints = mints[len(mints)-statusRecentMints:]
+	}
+	if len(mints) > 0 {
+		fmt.Fprintln(w, "  recent mints:")
+	}
+	for _, rec := range mints {
+		fmt.Fprintf(w, "    %s -> %s (tx %s)\n", rec.TokenName, truncateAddress(rec.Recipient), rec.MintTxHash)
+	}
+	return nil
+}
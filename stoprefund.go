@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Bounds on the refunds Stop submits besides the shutdown deadline, so a
+// long backlog or a slow chain cannot hold up shutdown indefinitely.
+// Deposits left over stay pending and are reported.
+const (
+	stopRefundWait = 30 * time.Second // for the current poll to finish
+	stopRefundMax  = 100
+)
+
+// refundPending refunds the deposits behind the pending reservations,
+// oldest first, once no poll is running, until deadline. A deposit whose
+// mint is already submitted is left to confirm; one carrying native
+// assets, or whose sender cannot be resolved, is left for an operator.
+func (e *Engine) refundPending(deadline time.Time) {
+	pending := e.pendingDepositTxs()
+	if len(pending) == 0 {
+		return
+	}
+	if !e.drained.Load() && !e.Drain(min(stopRefundWait, time.Until(deadline))) {
+		e.log.Warn("poll still running; not refunding pending deposits on stop", "pending", len(pending))
+		return
+	}
+
+	e.clearDepositCursor()
+	deposits, err := e.fetchDeposits()
+	if err != nil {
+		e.log.Error("failed to fetch deposits; not refunding pending deposits on stop", "error", err)
+		return
+	}
+	sort.SliceStable(deposits, func(i, j int) bool { return depositBefore(deposits[i], deposits[j]) })
+	e.expireLocks()
+	e.log.Info("refunding pending deposits on stop", "pending", len(pending))
+
+	refunded, left := 0, 0
+	for _, dep := range deposits {
+		if !pending[dep.TxHash] {
+			continue
+		}
+		delete(pending, dep.TxHash)
+		switch {
+		case refunded >= stopRefundMax || time.Now().After(deadline):
+			left++
+			continue
+		case e.inFlight(dep.TxHash):
+			e.log.Info("mint already submitted; not refunding", "deposit_tx", dep.TxHash)
+			continue
+		case len(dep.Assets) > 0:
+			e.log.Warn("pending deposit carries native assets; refund it by hand", "deposit_tx", dep.TxHash)
+			left++
+			continue
+		case dep.SenderAddr == "unknown":
+			e.log.Warn("cannot resolve sender of pending deposit; refund it by hand", "deposit_tx", dep.TxHash)
+			left++
+			continue
+		}
+		if err := e.refundDeposit(dep); err != nil {
+			e.log.Error("failed to refund pending deposit", "deposit_tx", dep.TxHash, "error", err)
+			left++
+			continue
+		}
+		e.settleStopRefund(dep)
+		refunded++
+	}
+	// Reservations whose deposit is no longer at the monitor address were
+	// spent elsewhere; reconciliation settles them on the next start.
+	for tx := range pending {
+		e.log.Warn("pending deposit not found at the monitor address; not refunded", "deposit_tx", tx)
+		left++
+	}
+	e.log.Info("refunded pending deposits on stop", "refunded", refunded, "left", left)
+	if left > 0 {
+		Notify(eventFailure, fmt.Sprintf("%s refunded %d pending deposit(s) on stop; %d left pending for review", e.displayName(), refunded, left))
+	}
+}
+
+// pendingDepositTxs returns the deposit transactions with a pending
+// reservation (see splitPendingKey). Reservations of manual mints have no
+// deposit.
+func (e *Engine) pendingDepositTxs() map[string]bool {
+	txs := make(map[string]bool)
+	for key := range e.state.Pending() {
+		if isManualKey(key) {
+			continue
+		}
+		tx, _ := splitPendingKey(key)
+		txs[tx] = true
+	}
+	return txs
+}
+
+// inFlight reports whether a transaction for depositTx is submitted and
+// not yet confirmed or expired.
+func (e *Engine) inFlight(depositTx string) bool {
+	e.claimMu.Lock()
+	defer e.claimMu.Unlock()
+	_, ok := e.locks[depositTx]
+	return ok
+}
+
+// settleStopRefund marks a refunded deposit processed; refundDeposit has
+// already released its reservations.
+func (e *Engine) settleStopRefund(dep Deposit) {
+	e.markProcessed(dep.TxHash)
+	if err := e.state.Save(); err != nil {
+		e.log.Warn("failed to save state", "error", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// submitRetry controls how often a rejected submission is retried: attempt
+// n waits backoff * 2^(n-1) first. Set from -submit-attempts and
+// -submit-backoff.
+type submitRetry struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// defaultSubmitRetry is used by the one-off commands and as the flags'
+// defaults.
+var defaultSubmitRetry = submitRetry{attempts: 4, backoff: 2 * time.Second}
+
+// submitSleep is time.Sleep, replaceable so the backoff can be skipped.
+var submitSleep = time.Sleep
+
+// permanentSubmitErrors are ledger rejections that resubmitting the same
+// transaction can never fix. Everything else (socket busy, mempool full,
+// HTTP 5xx) is treated as transient.
+var permanentSubmitErrors = []string{
+	"MissingScriptWitnesses",
+	"ScriptWitnessNotValidating",
+	"MissingVKeyWitnesses",
+	"InvalidWitnesses",
+	"OutsideValidityIntervalUTxO",
+	"FeeTooSmallUTxO",
+	"OutputTooSmallUTxO",
+	"DeserialiseFailure",
+}
+
+// isRetryableSubmitError reports whether a submit error may go away on its
+// own. Spent inputs are not retryable: confirmLanded decides those.
+func isRetryableSubmitError(err error) bool {
+	if isSpentInputError(err) || isPermanent(err) {
+		return false
+	}
+	msg := err.Error()
+	for _, s := range permanentSubmitErrors {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// retrySubmit calls submit until it succeeds, fails permanently or the
+// attempts are exhausted, and returns the last error.
+func (r submitRetry) retrySubmit(submit func() (string, error)) (string, error) {
+	attempts := r.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var txHash string
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		txHash, err = submit()
+		if err == nil || !isRetryableSubmitError(err) || attempt == attempts {
+			break
+		}
+		wait := r.backoff << (attempt - 1)
+		cardanoLog.Warn("submit failed; retrying", "attempt", attempt, "of", attempts, "wait", wait, "error", err)
+		submitSleep(wait)
+	}
+	return txHash, err
+}
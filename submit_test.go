@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recordSleeps replaces submitSleep for one test and returns the waits it
+// was asked for.
+func recordSleeps(t *testing.T) *[]time.Duration {
+	t.Helper()
+	var waits []time.Duration
+	saved := submitSleep
+	submitSleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { submitSleep = saved })
+	return &waits
+}
+
+// scriptedSubmit returns a submit func failing with errs in turn, then
+// succeeding, and a pointer to its call count.
+func scriptedSubmit(errs ...error) (func() (string, error), *int) {
+	calls := 0
+	return func() (string, error) {
+		calls++
+		if calls <= len(errs) {
+			return "", errs[calls-1]
+		}
+		return testTxID, nil
+	}, &calls
+}
+
+func TestRetrySubmitBacksOff(t *testing.T) {
+	waits := recordSleeps(t)
+	busy := errors.New("failed to submit transaction: exit status 1 (output: resource vanished (Broken pipe))")
+	submit, calls := scriptedSubmit(busy, busy, busy)
+
+	r := submitRetry{attempts: 4, backoff: time.Second}
+	txHash, err := r.retrySubmit(submit)
+	if err != nil || txHash != testTxID {
+		t.Fatalf("retrySubmit() = %q, %v; want success on the fourth attempt", txHash, err)
+	}
+	if *calls != 4 {
+		t.Errorf("submit called %d times, want 4", *calls)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}; !slices.Equal(*waits, want) {
+		t.Errorf("waits = %v, want doubling %v", *waits, want)
+	}
+}
+
+func TestRetrySubmitGivesUp(t *testing.T) {
+	waits := recordSleeps(t)
+	busy := errors.New("mempool is full")
+	submit, calls := scriptedSubmit(busy, busy, busy, busy)
+
+	_, err := submitRetry{attempts: 3, backoff: time.Second}.retrySubmit(submit)
+	if err != busy {
+		t.Errorf("retrySubmit() error = %v, want the last error", err)
+	}
+	if *calls != 3 || len(*waits) != 2 {
+		t.Errorf("%d calls and %d waits, want 3 and 2 (no wait after the last)", *calls, len(*waits))
+	}
+}
+
+func TestRetrySubmitStopsOnNonRetryable(t *testing.T) {
+	for name, err := range map[string]error{
+		"ledger rejection": errors.New("ApplyTxError [UtxowFailure (MissingVKeyWitnessesUTXOW ...)] MissingVKeyWitnesses"),
+		"expired":          errors.New("OutsideValidityIntervalUTxO (ValidityInterval {invalidBefore = SNothing, invalidHereafter = SJust (SlotNo 139483917)})"),
+		"spent inputs":     errors.New("BadInputsUTxO (fromList [...])"),
+		"permanent":        permanent(errors.New("metadata too large")),
+	} {
+		t.Run(name, func(t *testing.T) {
+			waits := recordSleeps(t)
+			submit, calls := scriptedSubmit(err)
+			if _, got := (submitRetry{attempts: 4, backoff: time.Second}).retrySubmit(submit); got != err {
+				t.Errorf("retrySubmit() error = %v, want %v", got, err)
+			}
+			if *calls != 1 || len(*waits) != 0 {
+				t.Errorf("%d calls and %d waits, want a single attempt", *calls, len(*waits))
+			}
+		})
+	}
+}
+
+func TestRetrySubmitZeroAttempts(t *testing.T) {
+	recordSleeps(t)
+	submit, calls := scriptedSubmit(errors.New("busy"))
+	if _, err := (submitRetry{}).retrySubmit(submit); err == nil || *calls != 1 {
+		t.Errorf("zero attempts: %d calls, err %v; want one failing attempt", *calls, err)
+	}
+}
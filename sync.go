@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxSyncLag is how far the chain tip (from the node, or Blockfrost
+// in Blockfrost-only mode) may trail wall-clock time before the engine
+// refuses to mint, unless -max-sync-lag says otherwise: a node still
+// syncing reports an old slot, and transactions built from it carry an
+// invalid-hereafter that has already passed.
+const defaultMaxSyncLag = 10 * time.Minute
+
+// syncRetryMax caps the wait between sync checks at startup.
+const syncRetryMax = 5 * time.Minute
+
+// slotZero is, per network, the Unix time slot 0 would have had at one
+// slot per second (the Shelley-era slot length): the expected slot at time
+// t is t minus it.
+var slotZero = map[string]int64{
+	"mainnet": 1591566291,
+	"preprod": 1655683200,
+	"preview": 1666656000,
+}
+
+// expectedSlot returns the slot the network should be at, at now. ok is
+// false for networks without known genesis timing.
+func expectedSlot(network string, now time.Time) (int64, bool) {
+	zero, ok := slotZero[network]
+	if !ok {
+		return 0, false
+	}
+	return now.Unix() - zero, true
+}
+
+// syncLag returns how far tip trails the slot expected at now, or 0 if it
+// is not behind (or the network's timing is unknown).
+func syncLag(network string, tip int64, now time.Time) time.Duration {
+	want, ok := expectedSlot(network, now)
+	if !ok || tip >= want {
+		return 0
+	}
+	return time.Duration(want-tip) * time.Second
+}
+
+// checkSync returns an error when the chain tip is more than the engine's
+// maxSyncLag behind wall-clock time or cannot be read.
+func (e *Engine) checkSync() error {
+	maxSyncLag := e.settings.maxSyncLag
+	if maxSyncLag <= 0 {
+		return nil
+	}
+	tip, lag, err := e.cardano.SyncLag()
+	if err != nil {
+		return fmt.Errorf("failed to read chain tip: %w", err)
+	}
+	if lag > maxSyncLag {
+		return fmt.Errorf("chain tip at slot %d is %s behind wall-clock time (limit %s)", tip, lag.Round(time.Second), maxSyncLag)
+	}
+	return nil
+}
+
+// waitForSync blocks until checkSync passes, retrying with backoff up to
+// syncRetryMax. A notification is sent when the wait begins and when it
+// ends. It returns false if the engine is stopped while waiting.
+func (e *Engine) waitForSync() bool {
+	wait := 10 * time.Second
+	waited := false
+	for {
+		err := e.checkSync()
+		if err == nil {
+			if waited {
+				e.log.Info("chain is in sync; starting")
+				Notify(eventResumed, fmt.Sprintf("%s: the chain is in sync; minting starts", e.displayName()))
+			}
+			return true
+		}
+		e.log.Warn("chain not in sync; not minting yet", "error", err, "retry_in", wait)
+		if !waited {
+			waited = true
+			Notify(eventSyncWait, fmt.Sprintf("%s is waiting for the chain to sync before minting: %v", e.displayName(), err))
+		}
+		select {
+		case <-time.After(wait):
+		case <-e.quit:
+			return false
+		}
+		if wait *= 2; wait > syncRetryMax {
+			wait = syncRetryMax
+		}
+	}
+}
+
+// Defaults of -tip-lag-alert and -tip-check-interval (see monitorTip).
+const (
+	defaultTipLagAlert      = 2 * time.Minute
+	defaultTipCheckInterval = time.Minute
+)
+
+// tipStatus is the latest tip check, reported on /status.
+type tipStatus struct {
+	Slot         int64     `json:"slot,omitempty"`
+	ExpectedSlot int64     `json:"expected_slot,omitempty"`
+	LagSeconds   int64     `json:"lag_seconds"`
+	Lagging      bool      `json:"lagging"`
+	Error        string    `json:"error,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// tipMonitor holds the latest tip check.
+type tipMonitor struct {
+	mu   sync.Mutex
+	last *tipStatus
+}
+
+// status returns the latest check, or nil before the first.
+func (m *tipMonitor) status() *tipStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.last == nil {
+		return nil
+	}
+	st := *m.last
+	return &st
+}
+
+// monitorTip checks the tip every tipCheckInterval until the engine stops.
+// When the tip trails wall-clock time by more than tipLagAlert, a webhook
+// alert fires once, and again when it catches up: a stuck node or a stale
+// Blockfrost view otherwise only shows up as mints failing on an
+// invalid-hereafter already past. 0 disables the monitor.
+func (e *Engine) monitorTip() {
+	if e.settings.tipLagAlert <= 0 || e.settings.tipCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(e.settings.tipCheckInterval)
+	defer ticker.Stop()
+	for {
+		e.checkTip(time.Now())
+		select {
+		case <-ticker.C:
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+// checkTip reads the tip, records the check and alerts when the tip starts
+// or stops lagging. A tip that cannot be read counts as lagging.
+func (e *Engine) checkTip(now time.Time) {
+	st := &tipStatus{CheckedAt: now.UTC()}
+	tip, err := e.currentSlot()
+	if err != nil {
+		st.Error = err.Error()
+		st.Lagging = true
+	} else {
+		st.Slot = tip
+		st.ExpectedSlot, _ = expectedSlot(e.network, now)
+		lag := syncLag(e.network, tip, now)
+		st.LagSeconds = int64(lag / time.Second)
+		st.Lagging = lag > e.settings.tipLagAlert
+	}
+
+	e.tip.mu.Lock()
+	was := e.tip.last != nil && e.tip.last.Lagging
+	e.tip.last = st
+	e.tip.mu.Unlock()
+
+	switch {
+	case st.Lagging && !was && err != nil:
+		e.log.Warn("cannot read chain tip", "error", err)
+		Notify(eventTipLag, fmt.Sprintf("%s cannot read the chain tip: %v", e.displayName(), err))
+	case st.Lagging && !was:
+		e.log.Warn("chain tip is lagging", "slot", st.Slot, "expected_slot", st.ExpectedSlot, "lag", time.Duration(st.LagSeconds)*time.Second, "limit", e.settings.tipLagAlert)
+		Notify(eventTipLag, fmt.Sprintf("%s: chain tip at slot %d is %s behind wall-clock time (limit %s); check the node or Blockfrost",
+			e.displayName(), st.Slot, time.Duration(st.LagSeconds)*time.Second, e.settings.tipLagAlert))
+	case !st.Lagging && was:
+		e.log.Info("chain tip caught up", "slot", st.Slot, "lag", time.Duration(st.LagSeconds)*time.Second)
+		Notify(eventTipLag, fmt.Sprintf("%s: chain tip caught up (slot %d, %s behind)", e.displayName(), st.Slot, time.Duration(st.LagSeconds)*time.Second))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncLag(t *testing.T) {
+	now := time.Unix(1_760_000_000, 0)
+	mainnetSlot := now.Unix() - slotZero["mainnet"]
+	tests := []struct {
+		name, network string
+		tip           int64
+		want          time.Duration
+	}{
+		{name: "at the tip", network: "mainnet", tip: mainnetSlot},
+		{name: "ahead of the clock", network: "mainnet", tip: mainnetSlot + 30},
+		{name: "behind", network: "mainnet", tip: mainnetSlot - 600, want: 10 * time.Minute},
+		{name: "preprod behind", network: "preprod", tip: now.Unix() - slotZero["preprod"] - 90, want: 90 * time.Second},
+		{name: "unknown network", network: "sanchonet", tip: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := syncLag(tt.network, tt.tip, now); got != tt.want {
+				t.Errorf("syncLag() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// laggingNode is a mockClient whose tip trails wall-clock time by lag.
+type laggingNode struct {
+	*mockClient
+	lag atomic.Int64
+	err error
+}
+
+func (n *laggingNode) SyncLag() (int64, time.Duration, error) {
+	if n.err != nil {
+		return 0, 0, n.err
+	}
+	return 1000, time.Duration(n.lag.Load()), nil
+}
+
+func TestCheckSyncThreshold(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.maxSyncLag = 5 * time.Minute
+	})
+	node := &laggingNode{mockClient: te.mock}
+	te.cardano = node
+
+	for lag, wantErr := range map[time.Duration]bool{0: false, 5 * time.Minute: false, 5*time.Minute + time.Second: true, time.Hour: true} {
+		node.lag.Store(int64(lag))
+		if err := te.checkSync(); (err != nil) != wantErr {
+			t.Errorf("checkSync() at a lag of %s = %v, want error %v", lag, err, wantErr)
+		}
+	}
+
+	node.err = errors.New("connection refused")
+	if err := te.checkSync(); err == nil || !strings.Contains(err.Error(), "failed to read chain tip") {
+		t.Errorf("checkSync() without a tip = %v, want a tip error", err)
+	}
+
+	// -max-sync-lag 0 turns the check off.
+	te.settings.maxSyncLag = 0
+	if err := te.checkSync(); err != nil {
+		t.Errorf("checkSync() with the check off = %v", err)
+	}
+}
+
+func TestWaitForSyncNotifiesWhileWaiting(t *testing.T) {
+	hook := newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, hook.URL)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Settings.maxSyncLag = time.Minute
+	})
+	node := &laggingNode{mockClient: te.mock}
+	node.lag.Store(int64(time.Hour))
+	te.cardano = node
+
+	done := make(chan bool)
+	go func() { done <- te.waitForSync() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hook.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	te.halt.Do(func() { close(te.quit) })
+	if <-done {
+		t.Error("waitForSync() reported the chain in sync at an hour's lag")
+	}
+	posts := hook.received()
+	if len(posts) != 1 {
+		t.Fatalf("webhook got %d posts, want 1", len(posts))
+	}
+	if content, _ := posts[0]["content"].(string); !strings.Contains(content, "waiting for the chain to sync") {
+		t.Errorf("webhook content = %q, want the sync wait", content)
+	}
+
+	// In sync from the start: no wait, no notice.
+	node.lag.Store(0)
+	if !te.waitForSync() {
+		t.Error("waitForSync() = false for a chain in sync")
+	}
+	if n := len(hook.received()); n != 1 {
+		t.Errorf("webhook got %d posts in total, want no more for a chain in sync", n)
+	}
+}
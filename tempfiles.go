@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// workDir holds the transaction, metadata and query files handed to
+// cardano-cli. Every file gets a unique name, so concurrent mints and
+// several instances sharing the directory never overwrite each other. The
+// zero value uses the system temp directory.
+type workDir struct {
+	dir string
+	// keep leaves those files in place for debugging instead of removing
+	// them once the transaction has been submitted.
+	keep bool
+}
+
+// newWorkDir selects (and creates) the directory for temporary files.
+func newWorkDir(dir string, keep bool) (workDir, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return workDir{}, fmt.Errorf("failed to create work dir: %w", err)
+	}
+	return workDir{dir: dir, keep: keep}, nil
+}
+
+// tempPath creates an empty, uniquely named file in the work dir matching
+// pattern (see os.CreateTemp) and returns its path.
+func (w workDir) tempPath(pattern string) (string, error) {
+	f, err := os.CreateTemp(w.dir, pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// removeOnError removes path if *err is set when the caller returns, so a
+// failed build does not leave its output file behind. Defer it with the
+// caller's named error result.
+func (w workDir) removeOnError(path string, err *error) {
+	if *err != nil {
+		w.cleanupTemp(path)
+	}
+}
+
+// cleanupTemp removes temporary files unless -keep-temp is set. Empty paths
+// are ignored, so it can be deferred before the files exist.
+func (w workDir) cleanupTemp(paths ...string) {
+	if w.keep {
+		return
+	}
+	for _, p := range paths {
+		if p != "" {
+			os.Remove(p)
+		}
+	}
+}
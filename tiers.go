@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Tier is a price point sold from the monitor address. Each tier carries its
+// own metadata template and an optional asset name prefix so that e.g. a
+// "standard" and a "rare" drop can share one address and one counter.
+//
+// Tiers are loaded from a JSON file:
+/*
+[
+	{"name": "standard", "price": 27000000, "metadata_template": "standard.json"},
+	{"name": "rare", "price": 50000000, "metadata_template": "rare.json", "asset_prefix": "FlowmassRare"},
+	{"name": "3-pack", "price": 70000000, "metadata_template": "standard.json", "quantity": 3},
+	{"name": "item", "price": 10000000, "metadata_template": "item.json", "asset_prefix": "FlowmassItem", "script": "items.script"}
+]
+*/
+type Tier struct {
+	Name             string `json:"name"`
+	Price            int64  `json:"price"`
+	MetadataTemplate string `json:"metadata_template"`
+	AssetPrefix      string `json:"asset_prefix,omitempty"`
+	// Description overrides the collection-wide -description for this tier.
+	Description string `json:"description,omitempty"`
+	// Quantity is how many tokens a deposit buys: a bundle, minted as
+	// distinct tokens with consecutive ids in one transaction. Default 1.
+	Quantity int `json:"quantity,omitempty"`
+	// Script and PolicyID mint the tier under its own policy instead of the
+	// engine's; the policy id is derived from the script when empty.
+	Script   string `json:"script,omitempty"`
+	PolicyID string `json:"policy_id,omitempty"`
+
+	tmpl *template.Template
+}
+
+// TierMetadata is the data made available to a tier's metadata template.
+type TierMetadata struct {
+	ID       int
+	Name     string // display name, e.g. "Flowmass 1"
+	HexName  string // hex-encoded on-chain asset name
+	PolicyID string
+	Tier     string
+	Traits   map[string]string // seeded trait assignment, when -traits is set
+	// Description is the token's description text; render it with
+	// {{metastring .Description}} so strings over 64 bytes become chunk arrays.
+	Description string
+}
+
+// LoadTiers reads the tier list from a JSON file and parses each tier's
+// metadata template. Template and script paths are resolved relative to the
+// tiers file.
+func LoadTiers(filePath string) ([]Tier, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tiers file: %w", err)
+	}
+
+	var tiers []Tier
+	if err := json.Unmarshal(data, &tiers); err != nil {
+		return nil, fmt.Errorf("failed to parse tiers file: %w", err)
+	}
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("tiers file %s defines no tiers", filePath)
+	}
+
+	seen := make(map[int64]string)
+	for i := range tiers {
+		t := &tiers[i]
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("tier%d", i+1)
+		}
+		if t.Price <= 0 {
+			return nil, fmt.Errorf("tier %q: price must be positive", t.Name)
+		}
+		if other, ok := seen[t.Price]; ok {
+			return nil, fmt.Errorf("tier %q: price %d already used by tier %q", t.Name, t.Price, other)
+		}
+		seen[t.Price] = t.Name
+		if t.Quantity < 0 {
+			return nil, fmt.Errorf("tier %q: quantity must be positive", t.Name)
+		}
+		if t.Quantity == 0 {
+			t.Quantity = 1
+		}
+
+		if t.MetadataTemplate == "" {
+			return nil, fmt.Errorf("tier %q: metadata_template is required", t.Name)
+		}
+		tmplPath := t.MetadataTemplate
+		if !filepath.IsAbs(tmplPath) {
+			tmplPath = filepath.Join(filepath.Dir(filePath), tmplPath)
+		}
+		raw, err := os.ReadFile(tmplPath)
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: failed to read metadata template: %w", t.Name, err)
+		}
+		tmpl, err := template.New(t.Name).Funcs(metadataFuncs).Parse(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("tier %q: failed to parse metadata template: %w", t.Name, err)
+		}
+		t.tmpl = tmpl
+		if t.Script != "" && !filepath.IsAbs(t.Script) {
+			t.Script = filepath.Join(filepath.Dir(filePath), t.Script)
+		}
+	}
+
+	return tiers, nil
+}
+
+// metadataFuncs are available to metadata templates. metastring renders a
+// string as a CIP-25 value, chunking it into an array past 64 bytes.
+var metadataFuncs = template.FuncMap{
+	"metastring": metadataStringJSON,
+}
+
+// matchTier returns the tier a deposit of lovelace pays for, or nil. A
+// deposit matches a tier priced up to tolerance below it, the excess being
+// a tip; when several do, the highest price wins.
+func matchTier(tiers []Tier, lovelace, tolerance int64) *Tier {
+	var best *Tier
+	for i := range tiers {
+		if withinTolerance(lovelace, tiers[i].Price, tolerance) && (best == nil || tiers[i].Price > best.Price) {
+			best = &tiers[i]
+		}
+	}
+	return best
+}
+
+// withinTolerance reports whether lovelace pays price, overpaying by at most
+// tolerance.
+func withinTolerance(lovelace, price, tolerance int64) bool {
+	return lovelace >= price && lovelace-price <= tolerance
+}
+
+// namePattern is the printf pattern of the tier's token names: its
+// asset_prefix followed by the id, or assetName (-asset-name) when it has
+// no prefix.
+func (t *Tier) namePattern(assetName string) string {
+	if t.AssetPrefix == "" {
+		return assetName
+	}
+	return strings.ReplaceAll(t.AssetPrefix, "%", "%%") + "%d"
+}
+
+// DisplayName returns the token display name for the given mint id, with
+// assetName naming tokens of a tier without a prefix.
+func (t *Tier) DisplayName(assetName string, id int) string {
+	return fmt.Sprintf(t.namePattern(assetName), id)
+}
+
+// RenderMetadata executes the tier's template and checks the result is valid JSON.
+func (t *Tier) RenderMetadata(data TierMetadata) (string, error) {
+	if t.tmpl == nil {
+		return "", fmt.Errorf("tier %q has no metadata template loaded", t.Name)
+	}
+	data.Tier = t.Name
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("tier %q: failed to render metadata: %w", t.Name, err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return "", fmt.Errorf("tier %q: rendered metadata is not valid JSON", t.Name)
+	}
+	return buf.String(), nil
+}
+
+// RenderMetadatas renders the template once per token of a bundle and merges
+// the results into one document holding every token under label 721 and
+// the policy. Other keys keep their value from the first token.
+func (t *Tier) RenderMetadatas(tokens []TierMetadata) (string, error) {
+	if len(tokens) == 0 {
+		return "", fmt.Errorf("tier %q: no tokens to render", t.Name)
+	}
+	labels := make(map[string]json.RawMessage)
+	cip25 := make(map[string]json.RawMessage)
+	assets := make(map[string]json.RawMessage)
+	for i, data := range tokens {
+		rendered, err := t.RenderMetadata(data)
+		if err != nil {
+			return "", err
+		}
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(rendered), &doc); err != nil {
+			return "", fmt.Errorf("tier %q: rendered metadata is not an object: %w", t.Name, err)
+		}
+		var label map[string]json.RawMessage
+		if err := json.Unmarshal(doc["721"], &label); err != nil {
+			return "", fmt.Errorf("tier %q: rendered metadata has no 721 object: %w", t.Name, err)
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(label[data.PolicyID], &entries); err != nil {
+			return "", fmt.Errorf("tier %q: rendered metadata has no entries under policy %s: %w", t.Name, data.PolicyID, err)
+		}
+		for name, entry := range entries {
+			assets[name] = entry
+		}
+		if i > 0 {
+			continue
+		}
+		for k, v := range doc {
+			labels[k] = v
+		}
+		for k, v := range label {
+			cip25[k] = v
+		}
+	}
+	var err error
+	if cip25[tokens[0].PolicyID], err = json.Marshal(assets); err != nil {
+		return "", err
+	}
+	if labels["721"], err = json.Marshal(cip25); err != nil {
+		return "", err
+	}
+	out, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
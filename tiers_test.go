@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWithinTolerance(t *testing.T) {
+	const price = 27_000_000
+	tests := []struct {
+		name      string
+		lovelace  int64
+		tolerance int64
+		want      bool
+	}{
+		{name: "exact", lovelace: price, want: true},
+		{name: "exact with tolerance", lovelace: price, tolerance: 500_000, want: true},
+		{name: "tip within tolerance", lovelace: price + 300_000, tolerance: 500_000, want: true},
+		{name: "tip at tolerance", lovelace: price + 500_000, tolerance: 500_000, want: true},
+		{name: "over tolerance", lovelace: price + 500_001, tolerance: 500_000},
+		{name: "tip without tolerance", lovelace: price + 1},
+		{name: "underpay", lovelace: price - 1, tolerance: 500_000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withinTolerance(tt.lovelace, price, tt.tolerance); got != tt.want {
+				t.Errorf("withinTolerance(%d, %d, %d) = %v, want %v", tt.lovelace, price, tt.tolerance, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchTier(t *testing.T) {
+	tiers := []Tier{
+		{Name: "common", Price: 25_000_000},
+		{Name: "rare", Price: 26_000_000},
+		{Name: "legendary", Price: 100_000_000},
+	}
+	tests := []struct {
+		name      string
+		lovelace  int64
+		tolerance int64
+		want      string // "" for no match
+	}{
+		{name: "exact common", lovelace: 25_000_000, want: "common"},
+		{name: "exact rare", lovelace: 26_000_000, want: "rare"},
+		{name: "tip within tolerance", lovelace: 25_400_000, tolerance: 500_000, want: "common"},
+		{name: "over tolerance", lovelace: 26_600_000, tolerance: 500_000},
+		{name: "underpay", lovelace: 24_999_999, tolerance: 2_000_000},
+		{name: "between tiers without tolerance", lovelace: 25_500_000},
+		// 26.5 ADA pays common (+1.5) and rare (+0.5) within 2 ADA.
+		{name: "overlapping tiers pick the highest price", lovelace: 26_500_000, tolerance: 2_000_000, want: "rare"},
+		{name: "exact price beats a lower tier's tolerance", lovelace: 26_000_000, tolerance: 2_000_000, want: "rare"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchTier(tiers, tt.lovelace, tt.tolerance)
+			switch {
+			case tt.want == "" && got != nil:
+				t.Errorf("matchTier(%d, %d) = %s, want no tier", tt.lovelace, tt.tolerance, got.Name)
+			case tt.want != "" && (got == nil || got.Name != tt.want):
+				t.Errorf("matchTier(%d, %d) = %v, want %s", tt.lovelace, tt.tolerance, got, tt.want)
+			}
+		})
+	}
+}
+
+// testTierTemplate is a CIP-25 tier template with a second label, which
+// bundles keep from their first token.
+const testTierTemplate = `{
+	"721": {"{{.PolicyID}}": {"{{.Name}}": {"name": "{{.Name}}", "id": {{.ID}}, "tier": "{{.Tier}}", "image": "ipfs://shark"}}, "version": "1.0"},
+	"674": {"msg": ["Flowmass"]}
+}`
+
+// writeTiers writes tiersJSON beside testTierTemplate (as tier.json) and
+// loads it.
+func writeTiers(t *testing.T, tiersJSON string) []Tier {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tier.json"), testTierTemplate)
+	writeFile(t, filepath.Join(dir, "tiers.json"), tiersJSON)
+	tiers, err := LoadTiers(filepath.Join(dir, "tiers.json"))
+	if err != nil {
+		t.Fatalf("LoadTiers: %v", err)
+	}
+	return tiers
+}
+
+func TestRenderMetadatasMergesBundle(t *testing.T) {
+	tier := &writeTiers(t, `[{"name": "3-pack", "price": 70000000, "metadata_template": "tier.json", "quantity": 3}]`)[0]
+	var tokens []TierMetadata
+	for id := 4; id <= 6; id++ {
+		name := fmt.Sprintf("Flowmass%d", id)
+		tokens = append(tokens, TierMetadata{ID: id, Name: name, HexName: hex.EncodeToString([]byte(name)), PolicyID: testPolicyID})
+	}
+
+	metadata, err := tier.RenderMetadatas(tokens)
+	if err != nil {
+		t.Fatalf("RenderMetadatas: %v", err)
+	}
+	var doc struct {
+		CIP25 map[string]json.RawMessage `json:"721"`
+		Msg   map[string][]string        `json:"674"`
+	}
+	if err := json.Unmarshal([]byte(metadata), &doc); err != nil {
+		t.Fatalf("merged metadata is not JSON: %v\n%s", err, metadata)
+	}
+	if string(doc.CIP25["version"]) != `"1.0"` || len(doc.Msg["msg"]) != 1 {
+		t.Errorf("merged metadata lost the first token's other keys: %s", metadata)
+	}
+	for _, tok := range tokens {
+		entry := tokenMetadata(t, metadata, testPolicyID, tok.Name)
+		if entry["id"] != float64(tok.ID) || entry["tier"] != "3-pack" {
+			t.Errorf("entry for %s = %v, want id %d of the 3-pack", tok.Name, entry, tok.ID)
+		}
+	}
+
+	if _, err := tier.RenderMetadatas(nil); err == nil {
+		t.Error("RenderMetadatas() rendered an empty bundle")
+	}
+}
+
+func TestBundleMintsDistinctTokensInOneTx(t *testing.T) {
+	tiers := writeTiers(t, `[
+		{"name": "single", "price": 27000000, "metadata_template": "tier.json"},
+		{"name": "3-pack", "price": 70000000, "metadata_template": "tier.json", "quantity": 3}
+	]`)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Tiers = tiers
+	})
+	pack, single := testTxHash(1), testTxHash(2)
+	te.setDeposits(
+		mockDeposit{SenderAddr: testBuyer(t, 1), Amount: 70_000_000, TxHash: pack},
+		mockDeposit{SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: single},
+	)
+
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 2 {
+		t.Fatalf("got %d mint transactions, want one per deposit", len(mints))
+	}
+	var bundle mockTx
+	for _, tx := range mints {
+		if len(tx.Mint) > 1 {
+			bundle = tx
+		}
+	}
+	var want []string
+	for id := 1; id <= 3; id++ {
+		want = append(want, "1 "+testPolicyID+"."+hex.EncodeToString([]byte(fmt.Sprintf("Flowmass%d", id))))
+	}
+	if !reflect.DeepEqual(bundle.Mint, want) {
+		t.Fatalf("bundle minted %v, want three consecutive tokens %v", bundle.Mint, want)
+	}
+	for id := 1; id <= 3; id++ {
+		tokenMetadata(t, string(bundle.Metadata), testPolicyID, fmt.Sprintf("Flowmass%d", id))
+	}
+	if rec, ok := te.state.GetMintRecord(single); !ok || rec.MintID != 4 {
+		t.Errorf("single after the bundle: record %+v, %v; want id 4", rec, ok)
+	}
+	if n := te.state.Counter(); n != 5 {
+		t.Errorf("Counter() = %d, want 5 after four tokens", n)
+	}
+}
+
+func TestBundleMustFitTraitSupply(t *testing.T) {
+	tiers := writeTiers(t, `[{"name": "3-pack", "price": 70000000, "metadata_template": "tier.json", "quantity": 3}]`)
+	pool, err := LoadTraitPool(writeTraits(t, 2), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Tiers = tiers
+		cfg.Traits = pool
+	})
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: 70_000_000, TxHash: dep})
+
+	te.poll()
+	if n := len(te.submitted()); n != 0 {
+		t.Fatalf("%d transactions submitted for a bundle past a supply of 2", n)
+	}
+	if te.state.IsProcessed(dep) || !te.audited(auditFailed, dep) {
+		t.Errorf("processed %v, failure audited %v; want false, true", te.state.IsProcessed(dep), te.audited(auditFailed, dep))
+	}
+}
+
+// itemsPolicyID is the policy of the items tier in the multi-policy tests.
+const itemsPolicyID = "2f3c4a5b6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9012345678"
+
+// writeItemsTiers writes a character tier on the engine's policy and an
+// items tier with its own script, pinned to policyID if it is set.
+func writeItemsTiers(t *testing.T, policyID string) ([]Tier, string) {
+	t.Helper()
+	script := filepath.Join(t.TempDir(), "items.script")
+	writeFile(t, script, `{"type": "sig", "keyHash": "`+testKeyHash+`"}`)
+	items := fmt.Sprintf(`{"name": "item", "price": 10000000, "metadata_template": "tier.json", "script": %q`, script)
+	if policyID != "" {
+		items += fmt.Sprintf(`, "policy_id": %q`, policyID)
+	}
+	return writeTiers(t, `[
+		{"name": "character", "price": 27000000, "metadata_template": "tier.json"},
+		`+items+`}
+	]`), script
+}
+
+func TestTiersMintUnderTheirOwnPolicies(t *testing.T) {
+	tiers, itemsScript := writeItemsTiers(t, itemsPolicyID)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.Tiers = tiers
+	})
+	character, item := testTxHash(1), testTxHash(2)
+	te.setDeposits(
+		mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: character},
+		mockDeposit{SenderAddr: testBuyer(t, 2), Amount: 10_000_000, TxHash: item},
+	)
+
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 2 {
+		t.Fatalf("got %d mint transactions, want one per deposit", len(mints))
+	}
+	want := map[string]struct{ policy, script string }{
+		testPolicyID:  {testPolicyID, te.scriptFile},
+		itemsPolicyID: {itemsPolicyID, itemsScript},
+	}
+	for _, tx := range mints {
+		if len(tx.Mint) != 1 {
+			t.Fatalf("mint %+v, want one token", tx)
+		}
+		unit := strings.TrimPrefix(tx.Mint[0], "1 ")
+		policy, hexName, _ := strings.Cut(unit, ".")
+		w, ok := want[policy]
+		if !ok {
+			t.Fatalf("minted %s under an unknown policy", unit)
+		}
+		delete(want, policy)
+		if tx.Script != w.script {
+			t.Errorf("token under %s minted with script %s, want %s", policy, tx.Script, w.script)
+		}
+		name, err := hex.DecodeString(hexName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokenMetadata(t, string(tx.Metadata), w.policy, string(name))
+	}
+	if len(want) != 0 {
+		t.Errorf("no token minted under %v", want)
+	}
+	if !te.state.IsProcessed(character) || !te.state.IsProcessed(item) {
+		t.Error("a deposit was not processed")
+	}
+}
+
+// scriptPolicies is a mockClient that derives the policy id of the scripts
+// in ids and of no others.
+type scriptPolicies struct {
+	*mockClient
+	ids map[string]string
+}
+
+func (n scriptPolicies) PolicyID(scriptFile string) (string, error) {
+	if id, ok := n.ids[scriptFile]; ok {
+		return id, nil
+	}
+	return "", errors.New("cardano-cli not found")
+}
+
+func TestTierPolicyChecked(t *testing.T) {
+	tests := []struct {
+		name, policyID, derived, wantErr string
+	}{
+		{name: "derived", derived: itemsPolicyID},
+		{name: "matching upper-case policy_id", policyID: strings.ToUpper(itemsPolicyID), derived: itemsPolicyID},
+		{name: "mismatch", policyID: itemsPolicyID, derived: strings.Repeat("ab", 28), wantErr: "but the tier's policy_id is " + itemsPolicyID},
+		{name: "underivable", wantErr: `tier "item": no policy_id given`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, script := writeItemsTiers(t, tt.policyID)
+			cfg, mock, _, _ := testEngineConfig(t)
+			cfg.Tiers = tiers
+			node := scriptPolicies{mockClient: mock, ids: map[string]string{}}
+			if tt.derived != "" {
+				node.ids[script] = tt.derived
+			}
+			e, err := NewEngine(cfg, node)
+			if tt.wantErr != "" {
+				if err == nil {
+					e.Stop()
+					t.Fatalf("NewEngine() succeeded, want an error containing %q", tt.wantErr)
+				}
+				if !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewEngine() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			defer e.Stop()
+			if got := e.policyFor(&tiers[1]).ID; got != itemsPolicyID {
+				t.Errorf("item tier policy = %s, want %s", got, itemsPolicyID)
+			}
+		})
+	}
+
+	tiers := writeTiers(t, `[{"name": "item", "price": 10000000, "metadata_template": "tier.json", "policy_id": "`+itemsPolicyID+`"}]`)
+	cfg, mock, _, _ := testEngineConfig(t)
+	cfg.Tiers = tiers
+	if e, err := NewEngine(cfg, mock); err == nil || !strings.Contains(err.Error(), "policy_id needs a script") {
+		if err == nil {
+			e.Stop()
+		}
+		t.Errorf("NewEngine() with a tier policy_id but no script: error = %v", err)
+	}
+}
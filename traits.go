@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+)
+
+// TraitPool assigns a fixed supply of trait sets to mint ids. The supply is
+// shuffled once with a seeded PRNG, so the same file and seed always yield the
+// same id -> traits mapping and the distribution can be audited after the drop.
+//
+// The traits file is a JSON array with one entry per token in the supply:
+/*
+[
+	{"type": "Shark", "background": "Reef"},
+	{"type": "Whale", "background": "Abyss"}
+]
+*/
+type TraitPool struct {
+	Seed        int64
+	assignments []map[string]string
+}
+
+// LoadTraitPool reads the trait supply from filePath and shuffles it with seed.
+func LoadTraitPool(filePath string, seed int64) (*TraitPool, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read traits file: %w", err)
+	}
+
+	var supply []map[string]string
+	if err := json.Unmarshal(data, &supply); err != nil {
+		return nil, fmt.Errorf("failed to parse traits file: %w", err)
+	}
+	if len(supply) == 0 {
+		return nil, fmt.Errorf("traits file %s defines no trait sets", filePath)
+	}
+
+	return &TraitPool{
+		Seed:        seed,
+		assignments: shuffleTraits(supply, seed),
+	}, nil
+}
+
+// shuffleTraits returns a copy of supply permuted by a PRNG seeded with seed.
+func shuffleTraits(supply []map[string]string, seed int64) []map[string]string {
+	shuffled := make([]map[string]string, len(supply))
+	copy(shuffled, supply)
+	rng := rand.New(rand.NewSource(seed))
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// ForID returns the traits assigned to mint id (1-based).
+func (p *TraitPool) ForID(id int) (map[string]string, error) {
+	if id < 1 || id > len(p.assignments) {
+		return nil, fmt.Errorf("mint id %d is outside the trait supply (1..%d)", id, len(p.assignments))
+	}
+	return p.assignments[id-1], nil
+}
+
+// Size returns the number of trait sets in the supply.
+func (p *TraitPool) Size() int {
+	return len(p.assignments)
+}
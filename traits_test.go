@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTraits writes a supply of n distinct trait sets and returns its path.
+func writeTraits(t *testing.T, n int) string {
+	t.Helper()
+	supply := make([]map[string]string, n)
+	for i := range supply {
+		supply[i] = map[string]string{"type": fmt.Sprintf("Shark %d", i), "background": fmt.Sprintf("Reef %d", i%3)}
+	}
+	data, err := json.Marshal(supply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "traits.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// assignments returns the traits of every id in the pool.
+func assignments(t *testing.T, p *TraitPool) []map[string]string {
+	t.Helper()
+	var out []map[string]string
+	for id := 1; id <= p.Size(); id++ {
+		traits, err := p.ForID(id)
+		if err != nil {
+			t.Fatalf("ForID(%d): %v", id, err)
+		}
+		out = append(out, traits)
+	}
+	return out
+}
+
+func TestTraitPoolSeed(t *testing.T) {
+	path := writeTraits(t, 50)
+
+	a, err := LoadTraitPool(path, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadTraitPool(path, 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(assignments(t, a), assignments(t, b)) {
+		t.Error("the same seed assigned different traits")
+	}
+
+	c, err := LoadTraitPool(path, 43)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(assignments(t, a), assignments(t, c)) {
+		t.Error("seeds 42 and 43 assigned identical traits")
+	}
+
+	// Seed 0 is a seed like any other, not "unseeded".
+	z1, err := LoadTraitPool(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z2, err := LoadTraitPool(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(assignments(t, z1), assignments(t, z2)) {
+		t.Error("seed 0 assigned different traits across loads")
+	}
+}
+
+func TestTraitPoolForIDRange(t *testing.T) {
+	p, err := LoadTraitPool(writeTraits(t, 3), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{0, 4} {
+		if _, err := p.ForID(id); err == nil {
+			t.Errorf("ForID(%d) succeeded outside a supply of 3", id)
+		}
+	}
+}
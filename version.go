@@ -0,0 +1,17 @@
+package main
+
+import "fmt"
+
+// Build information, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// versionString describes the running build.
+func versionString() string {
+	return fmt.Sprintf("flowmass %s (commit %s, built %s)", version, commit, buildDate)
+}
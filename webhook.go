@@ -3,70 +3,429 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-var DISCORD_WEBHOOK_URL string
+// Notification events. Every message carries one so notifiers can format
+// or route it.
+const (
+	eventMinted     = "minted"
+	eventRefunded   = "refunded"
+	eventFailure    = "failure"
+	eventCombined   = "combined"
+	eventStarted    = "started"
+	eventStopped    = "stopped"
+	eventPaused     = "paused"
+	eventResumed    = "resumed"
+	eventDeadLetter = "dead_letter"
+	eventSyncWait   = "sync_wait"
 
-func initWebhook() {
-	webhook, ok := os.LookupEnv("DISCORD_WEBHOOK_URL")
-	if !ok {
-		log.Printf("Could not get DISCORD_WEBHOOK_URL. Notifications disabled.")
+	eventRecipientRejected = "recipient_rejected"
+	eventTipLag            = "tip_lag"
+	eventDropOpens         = "drop_opens"
+)
+
+// mintNotice describes a successful mint for notifiers that can render it
+// richer than plain text.
+type mintNotice struct {
+	TokenName string
+	Recipient string
+	TxHash    string
+	TxURL     string // explorer link for TxHash
+	Fee       int64  // lovelace; 0 if unknown
+}
+
+// text is the plain-text form of the notice, with the explorer link.
+func (m mintNotice) text() string {
+	if m.Fee > 0 {
+		return fmt.Sprintf("Minted %s to %s (fee %s)\n%s", m.TokenName, truncateAddress(m.Recipient), formatADA(m.Fee), m.TxURL)
+	}
+	return fmt.Sprintf("Minted %s to %s\n%s", m.TokenName, truncateAddress(m.Recipient), m.TxURL)
+}
+
+// mintNotifier is implemented by notifiers with a dedicated mint format.
+type mintNotifier interface {
+	NotifyMint(m mintNotice) error
+}
+
+// explorerTxURL links txHash on Cardanoscan for network.
+func explorerTxURL(network, txHash string) string {
+	host := "cardanoscan.io"
+	if network != "mainnet" {
+		host = network + ".cardanoscan.io"
+	}
+	return fmt.Sprintf("https://%s/transaction/%s", host, txHash)
+}
+
+// truncateAddress shortens a bech32 address to its head and tail.
+func truncateAddress(addr string) string {
+	if len(addr) <= 24 {
+		return addr
+	}
+	return addr[:14] + "…" + addr[len(addr)-8:]
+}
+
+// Notifier delivers one event message to a chat platform.
+type Notifier interface {
+	Notify(event, message string) error
+	// String names the destination for logs without exposing its secret.
+	String() string
+}
+
+// notifiers are the destinations set up by initNotifiers.
+var notifiers []Notifier
+
+// webhookTarget is the HTTP delivery shared by every notifier: it posts
+// JSON to one URL, keeping its own rate-limit state so a slow or throttled
+// channel does not hold up the others.
+type webhookTarget struct {
+	url string
+
+	mu       sync.Mutex // serializes sends to this target
+	lastSent time.Time
+}
+
+const (
+	webhookMinInterval = time.Second // at most one message per second per target
+	webhookAttempts    = 3
+)
+
+// defaultDiscordUsername is the name Discord shows on posts when
+// -discord-username is not set.
+const defaultDiscordUsername = "Flowmass Mint Bot"
+
+// discordIdentity is the name and avatar Discord shows on a webhook's
+// posts. An empty AvatarURL keeps the webhook's own avatar.
+type discordIdentity struct {
+	Username  string
+	AvatarURL string
+}
+
+// discordNotifier posts to a Discord incoming webhook.
+type discordNotifier struct {
+	webhookTarget
+	identity discordIdentity
+}
+
+func (n *discordNotifier) Notify(event, message string) error {
+	return n.send(discordgo.WebhookParams{Content: message, Username: n.identity.Username, AvatarURL: n.identity.AvatarURL})
+}
+
+// NotifyMint posts the mint as an embed linking the transaction.
+func (n *discordNotifier) NotifyMint(m mintNotice) error {
+	fields := []*discordgo.MessageEmbedField{
+		{Name: "Recipient", Value: truncateAddress(m.Recipient), Inline: true},
+		{Name: "Transaction", Value: fmt.Sprintf("[%s…](%s)", m.TxHash[:min(len(m.TxHash), 16)], m.TxURL), Inline: true},
+	}
+	if m.Fee > 0 {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: "Fee", Value: formatADA(m.Fee), Inline: true})
+	}
+	return n.send(discordgo.WebhookParams{
+		Username:  n.identity.Username,
+		AvatarURL: n.identity.AvatarURL,
+		Embeds: []*discordgo.MessageEmbed{{
+			Title:  "Minted " + m.TokenName,
+			URL:    m.TxURL,
+			Fields: fields,
+		}},
+	})
+}
+
+func (n *discordNotifier) String() string { return "discord " + redactWebhookURL(n.url) }
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct{ webhookTarget }
+
+func (n *slackNotifier) Notify(event, message string) error {
+	return n.send(map[string]string{"text": message})
+}
+
+func (n *slackNotifier) String() string { return "slack " + redactWebhookURL(n.url) }
+
+// telegramNotifier calls a bot's sendMessage method for one chat.
+type telegramNotifier struct {
+	webhookTarget
+	chatID string
+}
+
+func (n *telegramNotifier) Notify(event, message string) error {
+	return n.send(map[string]string{"chat_id": n.chatID, "text": message})
+}
+
+func (n *telegramNotifier) String() string { return "telegram " + redactWebhookURL(n.url) }
+
+// newTelegramNotifier parses a sendMessage URL carrying the chat, e.g.
+// https://api.telegram.org/bot<token>/sendMessage?chat_id=-100123.
+func newTelegramNotifier(raw string) (*telegramNotifier, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid telegram url: %v", err)
+	}
+	chatID := u.Query().Get("chat_id")
+	if chatID == "" {
+		return nil, fmt.Errorf("telegram url needs a chat_id query parameter")
+	}
+	u.RawQuery = ""
+	return &telegramNotifier{webhookTarget: webhookTarget{url: u.String()}, chatID: chatID}, nil
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// flag. Comma-separated values are split, so env vars and config files can
+// list several entries in one string.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+// initNotifiers sets up every configured destination. -webhook-url entries
+// are Discord webhooks unless the host is Slack's. With no destination at
+// all it falls back to DISCORD_WEBHOOK_URL. Discord posts carry identity,
+// with the default username when it has none.
+func initNotifiers(webhookURLs, discordURLs, slackURLs, telegramURLs []string, identity discordIdentity) error {
+	if identity.Username == "" {
+		identity.Username = defaultDiscordUsername
+	}
+	if len(webhookURLs)+len(discordURLs)+len(slackURLs)+len(telegramURLs) == 0 {
+		webhook, ok := os.LookupEnv("DISCORD_WEBHOOK_URL")
+		if !ok || webhook == "" {
+			notifyLog.Info("no DISCORD_WEBHOOK_URL; notifications disabled")
+			return nil
+		}
+		discordURLs = []string{webhook}
+	}
+
+	parse := func(raw string) (string, bool, error) {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return "", false, fmt.Errorf("invalid webhook url %q: %v", redactWebhookURL(raw), err)
+		}
+		return u.String(), strings.HasSuffix(u.Host, "slack.com"), nil
+	}
+	for _, raw := range webhookURLs {
+		u, slack, err := parse(raw)
+		if err != nil {
+			return err
+		}
+		if slack {
+			slackURLs = append(slackURLs, u)
+		} else {
+			discordURLs = append(discordURLs, u)
+		}
+	}
+	for _, raw := range discordURLs {
+		u, _, err := parse(raw)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, &discordNotifier{webhookTarget: webhookTarget{url: u}, identity: identity})
+	}
+	for _, raw := range slackURLs {
+		u, _, err := parse(raw)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, &slackNotifier{webhookTarget{url: u}})
+	}
+	for _, raw := range telegramURLs {
+		n, err := newTelegramNotifier(raw)
+		if err != nil {
+			return err
+		}
+		notifiers = append(notifiers, n)
+	}
+	notifyLog.Info("notifications enabled", "destinations", len(notifiers))
+	return nil
+}
+
+// Notify sends an event message to every configured notifier. With the
+// queue running it only enqueues; delivery errors are logged.
+func Notify(event, message string) {
+	if notifyQ != nil {
+		notifyQ.enqueue(notice{event: event, text: message})
+		return
+	}
+	deliverText(event, message)
+}
+
+// NotifyMinted announces a mint. Notifiers with a mint format (Discord
+// embeds) use it; the rest get the text form.
+func NotifyMinted(m mintNotice) {
+	if notifyQ != nil {
+		notifyQ.enqueue(notice{event: eventMinted, mint: &m})
+		return
 	}
+	deliverMint(m)
+}
+
+// notifiedTTL is how long announced deposit events are remembered by
+// notifyOnce; long enough to cover any retry or reconcile of a deposit.
+const notifiedTTL = 30 * 24 * time.Hour
+
+// notifyOnce reports whether event should be announced for depositTx. The
+// deposit tx and event are an idempotency key recorded in the state, so a
+// mint or refund retried after a crash or found again by a reconcile pass
+// is announced once. Recording happens before sending: a crash in between
+// loses the announcement rather than repeating it. If the state cannot
+// record the key, the event is announced anyway.
+func (e *Engine) notifyOnce(depositTx, event string) bool {
+	fresh, err := e.state.MarkNotified(depositTx, event, notifiedTTL)
+	if err != nil {
+		e.log.Warn("failed to record notification; sending it anyway", "deposit_tx", depositTx, "event", event, "error", err)
+		return true
+	}
+	if !fresh {
+		e.log.Info("event already announced for deposit; not repeating it", "deposit_tx", depositTx, "event", event)
+	}
+	return fresh
+}
+
+func deliverText(event, message string) {
+	if err := notifyAll(notifiers, event, message); err != nil {
+		notifyLog.Warn("failed to send notification", "event", event, "error", err)
+	}
+}
 
-	webhookURL, err := url.Parse(webhook)
+func deliverMint(m mintNotice) {
+	err := fanOut(notifiers, func(n Notifier) error {
+		if mn, ok := n.(mintNotifier); ok {
+			return mn.NotifyMint(m)
+		}
+		return n.Notify(eventMinted, m.text())
+	})
 	if err != nil {
-		log.Fatalf("Invalid webhook url %v", err)
+		notifyLog.Warn("failed to send notification", "event", eventMinted, "error", err)
 	}
+}
+
+// notifyAll fans the message out to all notifiers concurrently and returns
+// an error naming each destination that failed.
+func notifyAll(targets []Notifier, event, message string) error {
+	return fanOut(targets, func(n Notifier) error { return n.Notify(event, message) })
+}
 
-	DISCORD_WEBHOOK_URL = webhookURL.String()
+// fanOut calls send for every target concurrently and returns an error
+// naming each destination that failed.
+func fanOut(targets []Notifier, send func(Notifier) error) error {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t Notifier) {
+			defer wg.Done()
+			errs[i] = send(t)
+		}(i, t)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", targets[i], err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d destinations failed: %s", len(failed), len(targets), strings.Join(failed, "; "))
+	}
+	return nil
 }
 
-func Webhook(message string) {
+// send posts payload as JSON to the target, spacing messages by
+// webhookMinInterval and retrying on network errors, 429s and 5xx responses.
+func (t *webhookTarget) send(payload interface{}) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	params, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("could not marshal content: %v", err)
+	}
+
 	client := &http.Client{
 		Timeout: 10 * time.Second,
 	}
 
-	data := discordgo.WebhookParams{Content: message, Username: "Flowmass Mint Bot"}
-	params, err := json.Marshal(data)
-	if err != nil {
-		log.Panicf("could not marshal content: %v", err)
+	var lastErr error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if wait := webhookMinInterval - time.Since(t.lastSent); wait > 0 {
+			time.Sleep(wait)
+		}
+		t.lastSent = time.Now()
+
+		retryAfter, err := t.post(client, params)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if retryAfter < 0 {
+			break // permanent failure
+		}
+		if retryAfter == 0 {
+			retryAfter = time.Duration(attempt) * time.Second
+		}
+		time.Sleep(retryAfter)
 	}
+	return lastErr
+}
 
-	// Create a new request
-	request, err := http.NewRequest(http.MethodPost, DISCORD_WEBHOOK_URL, bytes.NewBuffer(params))
+// post makes one delivery attempt. On failure it returns how long to wait
+// before retrying (0 for the default backoff), or -1 if retrying is pointless.
+func (t *webhookTarget) post(client *http.Client, params []byte) (time.Duration, error) {
+	request, err := http.NewRequest(http.MethodPost, t.url, bytes.NewBuffer(params))
 	if err != nil {
-		log.Printf("request error: %v", err)
-		return
+		return -1, fmt.Errorf("request error: %v", err)
 	}
-
 	request.Header.Set("Content-Type", "application/json")
+
 	response, err := client.Do(request)
 	if err != nil {
-		log.Printf("response error: %v", err)
-		return
+		return 0, fmt.Errorf("response error: %v", err)
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode == http.StatusNoContent {
-		log.Printf("You are not waiting for a response. Add ?wait=true to webhook url")
-	}
-
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Printf("error reading body: %v %v", err, body)
-		return
+		return 0, fmt.Errorf("error reading body: %v", err)
 	}
 
-	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
-		log.Printf("status: %d, error: %s", response.StatusCode, string(body))
-		return
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return 0, nil
+	case response.StatusCode == http.StatusTooManyRequests:
+		wait := time.Duration(0)
+		if secs, err := strconv.ParseFloat(response.Header.Get("Retry-After"), 64); err == nil {
+			wait = time.Duration(secs * float64(time.Second))
+		}
+		return wait, fmt.Errorf("rate limited (status 429)")
+	case response.StatusCode >= 500:
+		return 0, fmt.Errorf("status: %d, error: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	default:
+		return -1, fmt.Errorf("status: %d, error: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+}
+
+// redactWebhookURL drops the path, which carries the webhook token, for logging.
+func redactWebhookURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "webhook"
 	}
+	return u.Scheme + "://" + u.Host + "/..."
 }
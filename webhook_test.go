@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a webhook endpoint that records the posted payloads
+// and answers with status.
+type webhookRecorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []map[string]any
+}
+
+func newWebhookRecorder(t *testing.T, status int) *webhookRecorder {
+	t.Helper()
+	rec := &webhookRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("webhook body is not JSON: %v", err)
+		}
+		rec.mu.Lock()
+		rec.payloads = append(rec.payloads, payload)
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *webhookRecorder) received() []map[string]any {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.payloads
+}
+
+// useNotifiers sets up the -webhook-url destinations for one test.
+func useNotifiers(t *testing.T, webhookURLs ...string) {
+	t.Helper()
+	saved := notifiers
+	notifiers = nil
+	t.Cleanup(func() { notifiers = saved })
+	if err := initNotifiers(webhookURLs, nil, nil, nil, discordIdentity{}); err != nil {
+		t.Fatalf("initNotifiers: %v", err)
+	}
+}
+
+func TestNotifyFansOutToEveryWebhook(t *testing.T) {
+	first, second := newWebhookRecorder(t, http.StatusNoContent), newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, first.URL, second.URL)
+	if len(notifiers) != 2 {
+		t.Fatalf("got %d notifiers, want one per -webhook-url", len(notifiers))
+	}
+
+	Notify(eventStarted, "Flowmass started")
+	for name, rec := range map[string]*webhookRecorder{"first": first, "second": second} {
+		got := rec.received()
+		if len(got) != 1 {
+			t.Errorf("%s webhook got %d posts, want 1", name, len(got))
+			continue
+		}
+		if got[0]["content"] != "Flowmass started" || got[0]["username"] != defaultDiscordUsername {
+			t.Errorf("%s webhook got %v, want the message under the default username", name, got[0])
+		}
+	}
+}
+
+func TestNotifyFailingWebhookDoesNotBlockOthers(t *testing.T) {
+	broken, healthy := newWebhookRecorder(t, http.StatusNotFound), newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, broken.URL, healthy.URL)
+
+	err := notifyAll(notifiers, eventFailure, "mint failed")
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 destinations failed") || !strings.Contains(err.Error(), "status: 404") {
+		t.Errorf("notifyAll() error = %v, want the 404 destination named", err)
+	}
+	if n := len(broken.received()); n != 1 {
+		t.Errorf("a 404 was retried: %d posts, want 1", n)
+	}
+	if n := len(healthy.received()); n != 1 {
+		t.Errorf("healthy webhook got %d posts, want 1", n)
+	}
+}
+
+func TestInitNotifiersRejectsBadURL(t *testing.T) {
+	saved := notifiers
+	t.Cleanup(func() { notifiers = saved })
+	if err := initNotifiers([]string{"not a url"}, nil, nil, nil, discordIdentity{}); err == nil {
+		t.Error("initNotifiers accepted a webhook url without a host")
+	}
+}
+
+func TestMarkNotifiedOnce(t *testing.T) {
+	for _, backend := range stateBackends {
+		t.Run(backend, func(t *testing.T) {
+			s, path := openTestState(t, backend)
+			for i, want := range []bool{true, false} {
+				if fresh, err := s.MarkNotified("tx-a", eventMinted, time.Hour); err != nil || fresh != want {
+					t.Fatalf("MarkNotified(tx-a, minted) #%d = %v, %v; want %v", i+1, fresh, err, want)
+				}
+			}
+			if fresh, _ := s.MarkNotified("tx-a", eventRefunded, time.Hour); !fresh {
+				t.Error("another event of the same deposit was taken as announced")
+			}
+			s.Close()
+
+			// The key outlives a restart, so a retried mint reuses it.
+			r, err := OpenStateStore(backend, path, 0)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			defer r.Close()
+			if fresh, _ := r.MarkNotified("tx-a", eventMinted, time.Hour); fresh {
+				t.Error("reopened state forgot an announced event")
+			}
+		})
+	}
+}
+
+func TestMintAnnouncedOnce(t *testing.T) {
+	hook := newWebhookRecorder(t, http.StatusNoContent)
+	useNotifiers(t, hook.URL)
+	te := newTestEngine(t, nil)
+	dep := Deposit{TxHash: testTxHash(1), SenderAddr: testBuyer(t, 1), Amount: testMintPrice}
+
+	// A retry of the same deposit, as after a crash or a reconcile pass,
+	// goes through the same announcement.
+	for i := 0; i < 2; i++ {
+		if err := te.mintNFTsForDeposit(dep); err != nil {
+			t.Fatalf("mint #%d: %v", i+1, err)
+		}
+	}
+	other := Deposit{TxHash: testTxHash(2), SenderAddr: testBuyer(t, 2), Amount: testMintPrice}
+	if err := te.mintNFTsForDeposit(other); err != nil {
+		t.Fatalf("mint of another deposit: %v", err)
+	}
+
+	var titles []string
+	for _, post := range hook.received() {
+		embeds, _ := post["embeds"].([]any)
+		for _, e := range embeds {
+			if embed, ok := e.(map[string]any); ok {
+				title, _ := embed["title"].(string)
+				titles = append(titles, title)
+			}
+		}
+	}
+	if want := []string{"Minted Flowmass1", "Minted Flowmass3"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("webhook announced %q, want %q", titles, want)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runWorkers processes deposits on up to e.mintWorkers goroutines. With one
+// worker deposits are handled in order, exactly as before the pool existed.
+func (e *Engine) runWorkers(deposits []Deposit) {
+	workers := e.mintWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(deposits) {
+		workers = len(deposits)
+	}
+
+	jobs := make(chan Deposit)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dep := range jobs {
+				e.processDeposit(dep)
+			}
+		}()
+	}
+	// Once the engine is told to quit, deposits not yet handed to a worker
+	// are left for the next run.
+feed:
+	for i, dep := range deposits {
+		select {
+		case jobs <- dep:
+		case <-e.quit:
+			e.log.Info("shutting down; leaving deposits for the next run", "remaining", len(deposits)-i)
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// claimSpent marks an input that a submitted (or in-flight) transaction
+// spends. Other claim values name the deposit a UTxO is reserved for.
+const claimSpent = "*"
+
+// resetClaims starts a poll cycle's claim set and drops the last cycle's
+// UTxO snapshot. Deposit UTxOs that are not minted straight away (held for
+// top-up or due for refund) are reserved for their own deposit so no mint
+// picks them as a funding input.
+func (e *Engine) resetClaims(reserved []Deposit) {
+	e.utxoMu.Lock()
+	e.utxos, e.utxosOK = nil, false
+	e.fundingUtxos, e.fundingUtxosOK = nil, false
+	e.utxoMu.Unlock()
+
+	e.claimMu.Lock()
+	defer e.claimMu.Unlock()
+	e.claimed = make(map[string]string)
+	if e.plutus != nil {
+		e.claimed[e.plutus.Collateral] = claimSpent
+	}
+	for _, lock := range e.locks {
+		for _, in := range lock.inputs {
+			e.claimed[in] = claimSpent
+		}
+	}
+	for _, dep := range reserved {
+		e.claimed[fmt.Sprintf("%s#%d", dep.TxHash, dep.OutputIndex)] = dep.TxHash
+	}
+}
+
+// errWalletEmpty means the monitor address has no lovelace to fund a mint
+// right now: it holds no UTxOs, or the ones that would cover the mint are
+// inputs of transactions still in flight. It is transient; the deposit is
+// deferred to the next poll rather than counted as a failed mint.
+var errWalletEmpty = errors.New("monitor wallet has no spendable UTxO")
+
+// claimInputs selects lovelace-only UTxOs covering required, starting with
+// the forced inputs (a deposit's own UTxOs), and claims them for the rest of
+// the poll cycle. The node keeps reporting a spent UTxO until the spending
+// transaction is in a block, so without the claim the next deposit in the
+// same cycle would try to spend it again. When the monitor address falls
+// short, -funding-address UTxOs make up the rest. Call release if the
+// transaction is not submitted, to hand the inputs back.
+func (e *Engine) claimInputs(utxos []UTxO, forced []string, forcedSum, required uint64) ([]string, uint64, func(), error) {
+	// collect strict lovelace-only candidates (no non-lovelace assets at all)
+	var candidates []UTxO
+	for _, u := range utxos {
+		if len(u.Assets) == 0 && u.Lovelace > 0 {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 && len(forced) == 0 && e.fundingAddr == "" {
+		return nil, 0, nil, fmt.Errorf("%w: no lovelace-only UTxO at monitor address", errWalletEmpty)
+	}
+
+	// sort descending by lovelace to minimize inputs
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Lovelace > candidates[j].Lovelace })
+
+	e.claimMu.Lock()
+	defer e.claimMu.Unlock()
+
+	selectedIns := append([]string(nil), forced...)
+	sum := forcedSum
+	var inFlight uint64 // claimed by transactions submitted or being built
+	selected := make(map[string]bool)
+	for _, in := range forced {
+		// a deposit's UTxO may be spent by the deposit's own transaction
+		if owner := e.claimed[in]; owner != "" && !strings.HasPrefix(in, owner+"#") {
+			return nil, 0, nil, fmt.Errorf("input %s is already spent by another transaction this cycle", in)
+		}
+		selected[in] = true
+	}
+	for _, c := range candidates {
+		if sum >= required {
+			break
+		}
+		if selected[c.ID] {
+			continue
+		}
+		if e.claimed[c.ID] != "" {
+			inFlight += c.Lovelace
+			continue
+		}
+		selectedIns = append(selectedIns, c.ID)
+		sum += c.Lovelace
+	}
+	if sum < required && e.fundingAddr != "" {
+		// top up from the funding address, largest first
+		funding, err := e.fundingUTxOs()
+		if err != nil {
+			e.log.Warn("failed to query funding address", "address", e.fundingAddr, "error", err)
+		}
+		sort.Slice(funding, func(i, j int) bool { return funding[i].Lovelace > funding[j].Lovelace })
+		for _, c := range funding {
+			if sum >= required {
+				break
+			}
+			if e.claimed[c.ID] != "" {
+				inFlight += c.Lovelace
+				continue
+			}
+			selectedIns = append(selectedIns, c.ID)
+			sum += c.Lovelace
+		}
+	}
+	if sum < required && sum+inFlight >= required {
+		return nil, 0, nil, fmt.Errorf("%w: %d of the required %d lovelace is in inputs still in flight", errWalletEmpty, inFlight, required)
+	}
+	if sum < required {
+		return nil, 0, nil, fmt.Errorf("insufficient lovelace in unclaimed lovelace-only UTxOs: have=%d required=%d", sum, required)
+	}
+
+	previous := make(map[string]string, len(selectedIns))
+	for _, in := range selectedIns {
+		previous[in] = e.claimed[in]
+		e.claimed[in] = claimSpent
+	}
+	release := func() {
+		e.claimMu.Lock()
+		defer e.claimMu.Unlock()
+		for in, prev := range previous {
+			if prev == "" {
+				delete(e.claimed, in)
+			} else {
+				e.claimed[in] = prev
+			}
+		}
+	}
+	return selectedIns, sum, release, nil
+}
+
+// inputLock records the inputs a submitted transaction spends, so they are
+// not selected again while it waits in the mempool.
+type inputLock struct {
+	txHash           string
+	inputs           []string
+	invalidHereafter int64
+	// parts are the deposits a combined deposit's transaction spends,
+	// its own included; nil for a single deposit.
+	parts []string
+	// submittedAt times a mint's confirm phase; zero for refunds.
+	submittedAt time.Time
+}
+
+// lockInputs keeps inputs claimed across poll cycles until txHash is in a
+// block, its validity interval has passed, or the deposit's reservation is
+// dropped with unlockInputs. The lock is held under dep.TxHash. mint marks
+// a mint transaction, whose time to confirm is recorded.
+func (e *Engine) lockInputs(dep Deposit, txHash string, invalidHereafter int64, inputs []string, mint bool) {
+	e.claimMu.Lock()
+	defer e.claimMu.Unlock()
+	lock := inputLock{txHash: txHash, inputs: inputs, invalidHereafter: invalidHereafter}
+	for _, p := range dep.Parts {
+		lock.parts = append(lock.parts, p.TxHash)
+	}
+	if mint {
+		lock.submittedAt = time.Now()
+	}
+	e.locks[dep.TxHash] = lock
+	e.consumeUTxOs(inputs)
+}
+
+// monitorUTxOs returns the poll cycle's snapshot of the monitor address's
+// UTxOs, querying the node only the first time in a cycle: one query per
+// poll instead of one per deposit, and every mint sees the same view.
+func (e *Engine) monitorUTxOs() ([]UTxO, error) {
+	e.utxoMu.Lock()
+	defer e.utxoMu.Unlock()
+	if !e.utxosOK {
+		utxos, err := e.cardano.GetUTxOs(e.monitorAddr)
+		if err != nil && !errors.Is(err, errNoUTxOs) {
+			return nil, err
+		}
+		e.utxos, e.utxosOK = utxos, true
+	}
+	return append([]UTxO(nil), e.utxos...), nil
+}
+
+// fundingUTxOs returns the poll cycle's snapshot of the funding address's
+// lovelace-only UTxOs, querying the node only the first time in a cycle.
+// UTxOs carrying tokens are left out so a mint never spends them.
+func (e *Engine) fundingUTxOs() ([]UTxO, error) {
+	e.utxoMu.Lock()
+	defer e.utxoMu.Unlock()
+	if !e.fundingUtxosOK {
+		utxos, err := e.cardano.GetUTxOs(e.fundingAddr)
+		if err != nil && !errors.Is(err, errNoUTxOs) {
+			return nil, err
+		}
+		e.fundingUtxos = e.fundingUtxos[:0]
+		for _, u := range utxos {
+			if len(u.Assets) == 0 && u.Lovelace > 0 {
+				e.fundingUtxos = append(e.fundingUtxos, u)
+			}
+		}
+		e.fundingUtxosOK = true
+	}
+	return append([]UTxO(nil), e.fundingUtxos...), nil
+}
+
+// consumeUTxOs removes a submitted transaction's inputs from the snapshot.
+// Its change is not added: the node only lets a transaction spend outputs
+// already in a block, so change is picked up by the next cycle's query.
+func (e *Engine) consumeUTxOs(inputs []string) {
+	spent := make(map[string]bool, len(inputs))
+	for _, in := range inputs {
+		spent[in] = true
+	}
+	e.utxoMu.Lock()
+	defer e.utxoMu.Unlock()
+	e.utxos = withoutSpent(e.utxos, spent)
+	e.fundingUtxos = withoutSpent(e.fundingUtxos, spent)
+}
+
+// withoutSpent filters utxos in place, dropping those in spent.
+func withoutSpent(utxos []UTxO, spent map[string]bool) []UTxO {
+	kept := utxos[:0]
+	for _, u := range utxos {
+		if !spent[u.ID] {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
+
+// unlockInputs drops the lock held for a deposit's transaction.
+func (e *Engine) unlockInputs(depositTx string) {
+	e.claimMu.Lock()
+	defer e.claimMu.Unlock()
+	delete(e.locks, depositTx)
+}
+
+// expireLocks releases locks whose transaction is on chain (its inputs no
+// longer show up in queries) or can no longer get there because the tip
+// passed its invalid-hereafter slot. Locks are kept when either check fails.
+// The deposits of an expired transaction are still unspent, so they are
+// requeued rather than left recorded as processed.
+func (e *Engine) expireLocks() {
+	e.claimMu.Lock()
+	locks := make(map[string]inputLock, len(e.locks))
+	for dep, lock := range e.locks {
+		locks[dep] = lock
+	}
+	e.claimMu.Unlock()
+	if len(locks) == 0 {
+		return
+	}
+
+	slot, slotErr := e.currentSlot()
+	for dep, lock := range locks {
+		landed, err := e.cardano.TxOnChain(lock.txHash)
+		switch {
+		case err == nil && landed:
+			e.log.Debug("transaction confirmed; releasing its inputs", "deposit_tx", dep, "tx_hash", lock.txHash)
+			e.audit(auditEvent{Event: auditConfirmed, DepositTx: dep, TxHash: lock.txHash})
+			if !lock.submittedAt.IsZero() {
+				observeMintPhase(e.name, phaseConfirm, time.Since(lock.submittedAt))
+			}
+		case err == nil && slotErr == nil && slot > lock.invalidHereafter:
+			e.log.Warn("transaction expired without confirming; releasing its inputs", "deposit_tx", dep, "tx_hash", lock.txHash)
+			e.audit(auditEvent{Event: auditFailed, DepositTx: dep, TxHash: lock.txHash, Error: "transaction expired without confirming"})
+			e.requeueExpired(dep, lock)
+		default:
+			continue
+		}
+		e.unlockInputs(dep)
+	}
+}
+
+// requeueExpired forgets what the state recorded for the deposits whose
+// transaction expired, every part of a combined deposit included, so the
+// next poll mints or refunds them again, and alerts the operator.
+func (e *Engine) requeueExpired(depositTx string, lock inputLock) {
+	deposits := lock.parts
+	if len(deposits) == 0 {
+		deposits = []string{depositTx}
+	}
+	requeued := 0
+	for _, dep := range deposits {
+		if err := e.state.ForgetDeposit(dep); err != nil {
+			e.log.Error("failed to requeue deposit of expired transaction", "deposit_tx", dep, "tx_hash", lock.txHash, "error", err)
+			Notify(eventFailure, fmt.Sprintf("%s: transaction %s for deposit %s expired without confirming and the deposit could not be requeued (%v); handle it by hand",
+				e.displayName(), lock.txHash, dep, err))
+			continue
+		}
+		requeued++
+	}
+	if requeued == 0 {
+		return
+	}
+	e.clearDepositCursor()
+	Notify(eventFailure, fmt.Sprintf("%s: transaction %s for deposit %s expired without confirming; %d deposit(s) requeued",
+		e.displayName(), lock.txHash, depositTx, requeued))
+}
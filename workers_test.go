@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWorkersMintEachDepositOnce(t *testing.T) {
+	const deposits = 50
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.MintWorkers = 4
+	})
+	var deps []mockDeposit
+	for i := 0; i < deposits; i++ {
+		deps = append(deps, mockDeposit{SenderAddr: testBuyer(t, byte(i%8)), Amount: testMintPrice, TxHash: testTxHash(i + 1)})
+	}
+	te.setDeposits(deps...)
+
+	// The mock reports four fresh UTxOs per poll, so each poll funds at
+	// most four mints and defers the rest.
+	for poll := 0; poll < 20 && len(te.submitted()) < deposits; poll++ {
+		te.poll()
+	}
+
+	mints := te.submittedKind("mint")
+	if len(mints) != deposits {
+		t.Fatalf("got %d mints for %d deposits", len(mints), deposits)
+	}
+	spentBy := make(map[string]int)
+	for i, tx := range mints {
+		for _, in := range tx.Inputs {
+			if prev, ok := spentBy[in]; ok {
+				t.Errorf("input %s spent by mints %d and %d", in, prev, i)
+			}
+			spentBy[in] = i
+		}
+	}
+	ids := make(map[int]string)
+	for _, dep := range deps {
+		rec, ok := te.state.GetMintRecord(dep.TxHash)
+		if !ok {
+			t.Errorf("deposit %s has no mint record", dep.TxHash)
+			continue
+		}
+		if other, dup := ids[rec.MintID]; dup {
+			t.Errorf("mint id %d given to %s and %s", rec.MintID, other, dep.TxHash)
+		}
+		ids[rec.MintID] = dep.TxHash
+	}
+	for id := 1; id <= deposits; id++ {
+		if _, ok := ids[id]; !ok {
+			t.Errorf("mint id %d was not used; ids are not consecutive", id)
+		}
+	}
+	if len(te.state.Pending()) != 0 {
+		t.Errorf("pending %v after every deposit minted", te.state.Pending())
+	}
+}
+
+func TestClaimInputsDisjoint(t *testing.T) {
+	te := newTestEngine(t, nil)
+	utxos := []UTxO{
+		{ID: "dep#0", Lovelace: 27_000_000},
+		{ID: "a#0", Lovelace: 5_000_000},
+		{ID: "b#0", Lovelace: 3_000_000},
+		{ID: "c#0", Lovelace: 2_000_000},
+		{ID: "nft#0", Lovelace: 50_000_000, Assets: map[string]uint64{testPolicyID + ".466c6f776d61737331": 1}},
+	}
+	// dep#0 is a held deposit, reserved for its own refund or mint.
+	te.resetClaims([]Deposit{{TxHash: "dep", OutputIndex: 0}})
+
+	first, _, release, err := te.claimInputs(utxos, nil, 0, 4_000_000)
+	if err != nil || !slices.Equal(first, []string{"a#0"}) {
+		t.Fatalf("first claim = %v, %v; want [a#0]", first, err)
+	}
+	second, sum, _, err := te.claimInputs(utxos, nil, 0, 4_000_000)
+	if err != nil || !slices.Equal(second, []string{"b#0", "c#0"}) || sum != 5_000_000 {
+		t.Fatalf("second claim = %v (%d), %v; want [b#0 c#0] without a#0", second, sum, err)
+	}
+	if _, _, _, err := te.claimInputs(utxos, nil, 0, 1_000_000); !errors.Is(err, errWalletEmpty) {
+		t.Fatalf("third claim: err = %v, want errWalletEmpty while the rest is in flight", err)
+	}
+
+	// A mint that is not submitted hands its inputs back.
+	release()
+	third, _, _, err := te.claimInputs(utxos, nil, 0, 1_000_000)
+	if err != nil || !slices.Equal(third, []string{"a#0"}) {
+		t.Fatalf("claim after release = %v, %v; want a#0 again", third, err)
+	}
+
+	// The held deposit's UTxO is only spent by its own transaction.
+	if _, _, _, err := te.claimInputs(nil, []string{"dep#0"}, 27_000_000, 0); err != nil {
+		t.Errorf("the deposit's own claim failed: %v", err)
+	}
+	if _, _, _, err := te.claimInputs(nil, []string{"dep#0"}, 27_000_000, 0); err == nil {
+		t.Error("a spent deposit UTxO was claimed twice")
+	}
+}
+
+func TestInputLockSkippedUntilExpiry(t *testing.T) {
+	te := newTestEngine(t, nil)
+	utxos := []UTxO{{ID: "a#0", Lovelace: 5_000_000}, {ID: "b#0", Lovelace: 5_000_000}}
+	slot, err := te.mock.GetCurrentSlot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep := testTxHash(1)
+	if err := te.state.MarkProcessed(dep); err != nil {
+		t.Fatal(err)
+	}
+	te.lockInputs(Deposit{TxHash: dep}, "unconfirmed-tx", slot+100, []string{"a#0"}, true)
+
+	// While the transaction may still land, later cycles skip its input.
+	te.expireLocks()
+	te.resetClaims(nil)
+	ins, _, _, err := te.claimInputs(utxos, nil, 0, 1_000_000)
+	if err != nil || !slices.Equal(ins, []string{"b#0"}) {
+		t.Fatalf("claim while locked = %v, %v; want b#0 only", ins, err)
+	}
+
+	// Past its invalid-hereafter slot the lock goes and the deposit is
+	// requeued, since its UTxO was never spent.
+	te.claimMu.Lock()
+	lock := te.locks[dep]
+	lock.invalidHereafter = slot - 1
+	te.locks[dep] = lock
+	te.claimMu.Unlock()
+	te.expireLocks()
+	if _, ok := te.locks[dep]; ok {
+		t.Fatal("the expired lock is still held")
+	}
+	if te.state.IsProcessed(dep) {
+		t.Error("the deposit of the expired transaction was not requeued")
+	}
+	if !te.audited(auditFailed, dep) {
+		t.Error("no failure audit entry for the expired transaction")
+	}
+	te.resetClaims(nil)
+	ins, _, _, err = te.claimInputs(utxos, nil, 0, 6_000_000)
+	if err != nil || len(ins) != 2 {
+		t.Errorf("claim after expiry = %v, %v; want both inputs free", ins, err)
+	}
+}
+
+func TestExpiredCombinedDepositRequeuesEveryPart(t *testing.T) {
+	te := newTestEngine(t, nil)
+	slot, err := te.mock.GetCurrentSlot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := testTxHash(1), testTxHash(2)
+	combined := Deposit{TxHash: first, Parts: []Deposit{{TxHash: first}, {TxHash: second}}}
+	for _, p := range combined.Parts {
+		if err := te.state.MarkProcessed(p.TxHash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	te.lockInputs(combined, "unconfirmed-tx", slot-1, []string{first + "#0", second + "#0"}, true)
+
+	te.expireLocks()
+	for _, p := range combined.Parts {
+		if te.state.IsProcessed(p.TxHash) {
+			t.Errorf("part %s of the expired combined deposit was not requeued", p.TxHash)
+		}
+	}
+}
+
+func TestInputLockReleasedOnConfirmation(t *testing.T) {
+	te := newTestEngine(t, nil)
+	signed := filepath.Join(t.TempDir(), "mint.signed")
+	writeFile(t, signed, `{"kind": "mint", "inputs": ["a#0"]}`)
+	txHash, err := te.mock.SubmitTransaction(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep := testTxHash(1)
+	if err := te.state.MarkProcessed(dep); err != nil {
+		t.Fatal(err)
+	}
+	slot, _ := te.mock.GetCurrentSlot()
+	te.lockInputs(Deposit{TxHash: dep}, txHash, slot+100, []string{"a#0"}, true)
+
+	te.expireLocks()
+	if _, ok := te.locks[dep]; ok {
+		t.Error("the lock of a confirmed transaction is still held")
+	}
+	if !te.state.IsProcessed(dep) {
+		t.Error("the deposit of a confirmed transaction was requeued")
+	}
+	if !te.audited(auditConfirmed, dep) {
+		t.Error("no confirmed audit entry")
+	}
+}
+
+func TestUTxOsQueriedOncePerPoll(t *testing.T) {
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.MintWorkers = 2
+	})
+	var wallet []UTxO
+	for i := 0; i < 6; i++ {
+		wallet = append(wallet, UTxO{ID: testTxHash(0xa0+i) + "#0", Lovelace: 100_000_000})
+	}
+	node := te.useWallets(map[string][]UTxO{te.monitorAddr: wallet})
+
+	var deps []mockDeposit
+	for i := 1; i <= 3; i++ {
+		deps = append(deps, mockDeposit{SenderAddr: testBuyer(t, byte(i)), Amount: testMintPrice, TxHash: testTxHash(i)})
+	}
+	te.setDeposits(deps...)
+	te.poll()
+	if n := len(te.submittedKind("mint")); n != 3 {
+		t.Fatalf("got %d mints, want 3", n)
+	}
+	if n := node.queried(te.monitorAddr); n != 1 {
+		t.Errorf("3 mints in one poll queried the monitor address %d times, want 1", n)
+	}
+	// Spent inputs leave the snapshot.
+	snapshot, err := te.monitorUTxOs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot) != 3 {
+		t.Errorf("snapshot after 3 single-input mints holds %d UTxOs, want 3", len(snapshot))
+	}
+
+	// A poll with nothing to mint does not query; the next one with a
+	// deposit queries afresh.
+	te.setDeposits()
+	te.poll()
+	if n := node.queried(te.monitorAddr); n != 1 {
+		t.Errorf("a poll without deposits queried UTxOs: %d queries, want 1", n)
+	}
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 4), Amount: testMintPrice, TxHash: testTxHash(4)})
+	te.poll()
+	if n := node.queried(te.monitorAddr); n != 2 {
+		t.Errorf("the next poll with a deposit made %d queries in total, want 2", n)
+	}
+}
+
+func TestFundingAddressTopsUpMint(t *testing.T) {
+	funding := testAddress(t, "addr", 0x61, 0x02)
+	te := newTestEngine(t, func(cfg *EngineConfig) {
+		cfg.FundingAddr = funding
+		cfg.Settings.feeBuffer = 2_000_000
+	})
+	short := UTxO{ID: testTxHash(0xa0) + "#0", Lovelace: testMintPrice + 500_000}
+	topUp := UTxO{ID: testTxHash(0xb0) + "#0", Lovelace: 10_000_000}
+	node := te.useWallets(map[string][]UTxO{te.monitorAddr: {short}, funding: {topUp}})
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: testTxHash(1)})
+
+	te.poll()
+	mints := te.submittedKind("mint")
+	if len(mints) != 1 {
+		t.Fatalf("got %d mints, want 1", len(mints))
+	}
+	if want := []string{short.ID, topUp.ID}; !slices.Equal(mints[0].Inputs, want) {
+		t.Errorf("mint inputs = %v, want the monitor UTxO topped up from the funding address: %v", mints[0].Inputs, want)
+	}
+	if mints[0].ChangeAddress != te.changeAddr {
+		t.Errorf("change to %s, want %s", mints[0].ChangeAddress, te.changeAddr)
+	}
+
+	// With the monitor address empty, the funding address pays for it all.
+	node.fund(te.monitorAddr)
+	node.fund(funding, UTxO{ID: testTxHash(0xb1) + "#0", Lovelace: 50_000_000})
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 2), Amount: testMintPrice, TxHash: testTxHash(2)})
+	te.poll()
+	if !te.state.IsProcessed(testTxHash(2)) {
+		t.Fatal("a mint the funding address covers was not made")
+	}
+	if n := node.queried(funding); n != 2 {
+		t.Errorf("funding address queried %d times over two polls, want 2", n)
+	}
+}
+
+func TestEmptyWalletDefersDeposit(t *testing.T) {
+	te := newTestEngine(t, nil)
+	node := te.useWallets(map[string][]UTxO{})
+	dep := testTxHash(1)
+	te.setDeposits(mockDeposit{SenderAddr: testBuyer(t, 1), Amount: testMintPrice, TxHash: dep})
+
+	te.poll()
+	if n := len(te.submitted()); n != 0 {
+		t.Fatalf("%d transactions built from an empty wallet", n)
+	}
+	if te.state.IsProcessed(dep) || te.audited(auditFailed, dep) || te.failures.Load() != 0 {
+		t.Errorf("deferred deposit: processed %v, failure audited %v, failures %d; want none", te.state.IsProcessed(dep), te.audited(auditFailed, dep), te.failures.Load())
+	}
+	if id, ok := te.state.Pending()[dep]; !ok || id != 1 {
+		t.Errorf("pending reservation = %d, %v; want id 1 kept for the next poll", id, ok)
+	}
+
+	node.fund(te.monitorAddr, UTxO{ID: testTxHash(0xa0) + "#0", Lovelace: 100_000_000})
+	te.poll()
+	if rec, ok := te.state.GetMintRecord(dep); !ok || rec.MintID != 1 {
+		t.Errorf("after funding: mint record %+v, %v; want id 1", rec, ok)
+	}
+}